@@ -23,6 +23,7 @@ import (
 
 	"github.com/prometheus/common/log"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/dynamic"
@@ -31,6 +32,7 @@ import (
 	"k8s.io/client-go/rest"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
@@ -82,8 +84,9 @@ func main() {
 	var enableLeaderElection bool
 
 	operatorOptions := controllers.OperatorOptions{
-		IsOpenShift: false,
-		Development: false,
+		IsOpenShift:             false,
+		Development:             false,
+		MaxConcurrentReconciles: 1,
 	}
 
 	// metrics-addr default is 0 - so it is disable, if we want to re-enable it, we should set the default to :8080
@@ -91,6 +94,7 @@ func main() {
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false, "Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
 	flag.BoolVar(&operatorOptions.IsOpenShift, "openshift", false, "Set this flag if you are running on an openshift cluster")
 	flag.StringVar(&operatorOptions.DefaultCoreImageTag, "core-image-tag", "tag-not-set", "The tag to use for the nvmesh core and utils images e.g. 0.7.0-4")
+	flag.IntVar(&operatorOptions.MaxConcurrentReconciles, "max-concurrent-reconciles", 1, "The maximum number of NVMesh clusters that can be reconciled concurrently")
 
 	// Development - Use this when developing locally and when you have access to the api-server but not internal ClusterIPs
 	flag.BoolVar(&operatorOptions.Development, "development", false, "Used for development only")
@@ -102,6 +106,7 @@ func main() {
 	addToScheme(scheme)
 
 	setupLog.Info(fmt.Sprintf("operatorOptions.IsOpenShift: %t", operatorOptions.IsOpenShift))
+	setupLog.Info(fmt.Sprintf("operatorOptions.MaxConcurrentReconciles: %d", operatorOptions.MaxConcurrentReconciles))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
@@ -109,6 +114,10 @@ func main() {
 		Port:               9443,
 		LeaderElection:     enableLeaderElection,
 		LeaderElectionID:   leaderElectionConfigMapName,
+		// Secrets are cached only if they are labelled with the cluster name, other Secrets are read directly from the api-server
+		NewCache: cache.BuilderWithOptions(cache.Options{SelectorsByObject: cache.SelectorsByObject{
+			&corev1.Secret{}: {Label: controllers.SecretsCacheSelector()},
+		}}),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -121,15 +130,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	dynamicClient := GetDynamicClientOrDie(mgr.GetConfig())
+
 	nvmeshReconciler := &controllers.NVMeshReconciler{
 		NVMeshBaseReconciler: controllers.NVMeshBaseReconciler{
-			Client:        mgr.GetClient(),
-			Log:           ctrl.Log.WithName("controllers").WithName("NVMesh"),
-			Scheme:        mgr.GetScheme(),
-			DynamicClient: GetDynamicClientOrDie(mgr.GetConfig()),
-			Manager:       mgr,
-			EventManager:  eventManager,
-			Options:       operatorOptions,
+			Client:         mgr.GetClient(),
+			Log:            ctrl.Log.WithName("controllers").WithName("NVMesh"),
+			Scheme:         mgr.GetScheme(),
+			DynamicClient:  dynamicClient,
+			Manager:        mgr,
+			EventManager:   eventManager,
+			Options:        operatorOptions,
+			DynamicWatches: controllers.NewDynamicWatchRegistry(dynamicClient, ctrl.Log.WithName("controllers").WithName("DynamicWatches")),
 		},
 	}
 
@@ -138,9 +150,20 @@ func main() {
 		os.Exit(1)
 	}
 
-	_, err = nvmeshReconciler.ListenToNodeLabels()
-	if err != nil {
-		setupLog.Error(err, "unable to create node listener", "controller", "NVMesh")
+	nvmeshActionReconciler := &controllers.NVMeshActionReconciler{
+		NVMeshBaseReconciler: controllers.NVMeshBaseReconciler{
+			Client:        mgr.GetClient(),
+			Log:           ctrl.Log.WithName("controllers").WithName("NVMeshAction"),
+			Scheme:        mgr.GetScheme(),
+			DynamicClient: dynamicClient,
+			Manager:       mgr,
+			EventManager:  eventManager,
+			Options:       operatorOptions,
+		},
+	}
+
+	if err = nvmeshActionReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "NVMeshAction")
 		os.Exit(1)
 	}
 
@@ -19,6 +19,8 @@ operator_hub_dir = "../operator-hub"
 bundle_dir = path.join(operator_hub_dir,"catalog_bundle")
 
 crd_base = path.join(bases, "crd/nvmesh.crd.yaml")
+node_crd_base = path.join(bases, "crd/nvmeshnode.crd.yaml")
+action_crd_base = path.join(bases, "crd/nvmeshaction.crd.yaml")
 csv_base = path.join(bases, "csv/csv.yaml")
 role_file = path.join(bases, "rbac/role.yaml")
 operator_dep_file = path.join(bases, "operator/deployment.yaml")
@@ -105,9 +107,10 @@ def build_csv():
     print("ClusterServiceVersion file generated at %s" % output_file)
 
 def copy_and_format_crd():
-    crd = load_yaml_file(crd_base)
-    crd['metadata'].pop('creationTimestamp', None)
-    write_yaml_file(crd, crd_base)
+    for crd_file in [crd_base, node_crd_base, action_crd_base]:
+        crd = load_yaml_file(crd_file)
+        crd['metadata'].pop('creationTimestamp', None)
+        write_yaml_file(crd, crd_file)
 
 def get_operator_image(repo=None):
     ver_info_copy = version_info.copy()
@@ -134,6 +137,8 @@ def get_deployment_for_kubernetes():
 
 def build_deploy_dir():
     copyfile(crd_base, path.join(deploy, "010_nvmesh_crd.yaml"))
+    copyfile(node_crd_base, path.join(deploy, "011_nvmeshnode_crd.yaml"))
+    copyfile(action_crd_base, path.join(deploy, "012_nvmeshaction_crd.yaml"))
     copyfile(path.join(bases, "extra/service_account.yaml"), path.join(deploy, "020_service_account.yaml"))
     copyfile(path.join(bases, "rbac/role.yaml"), path.join(deploy, "030_role.yaml"))
     copyfile(path.join(bases, "rbac/role_binding.yaml"), path.join(deploy, "040_role_binding.yaml"))
@@ -143,6 +148,8 @@ def build_deploy_dir():
 
     files_to_join = [
         "010_nvmesh_crd.yaml",
+        "011_nvmeshnode_crd.yaml",
+        "012_nvmeshaction_crd.yaml",
         "020_service_account.yaml",
         "030_role.yaml",
         "040_role_binding.yaml",
@@ -165,6 +172,8 @@ def build_deploy_dir():
 def build_bundle_dir():
     build_csv()
     copyfile(path.join(bases, "crd/nvmesh.crd.yaml"), path.join(bundle_dir,"manifests", "nvmesh_crd.yaml"))
+    copyfile(path.join(bases, "crd/nvmeshnode.crd.yaml"), path.join(bundle_dir,"manifests", "nvmeshnode_crd.yaml"))
+    copyfile(path.join(bases, "crd/nvmeshaction.crd.yaml"), path.join(bundle_dir,"manifests", "nvmeshaction_crd.yaml"))
 
 def update_catalog_source():
     catalog_source_file = path.join(operator_hub_dir, "dev/catalog_source.yaml")
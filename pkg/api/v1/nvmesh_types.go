@@ -64,7 +64,7 @@ type NVMeshCore struct {
 	// +optional
 	Disabled bool `json:"disabled,omitempty"`
 
-	// ConfiguredNICs - a comma seperated list of nics to use with NVMesh
+	// ConfiguredNICs - a comma seperated list of nics to use with NVMesh. When set to "auto" the operator discovers the NICs of each node and chooses the interfaces to use, nodes without an RDMA capable NIC fall back to TCP
 	// +optional
 	ConfiguredNICs string `json:"configuredNICs,omitempty"`
 
@@ -80,7 +80,126 @@ type NVMeshCore struct {
 	// +optional
 	ExcludeDrives *ExcludeNVMeDrivesSpec `json:"excludeDrives,omitempty"`
 
+	// ModuleParams - modprobe.d options lines written to /etc/modprobe.d on each node, i.e. "options nvmeibc nr_max_channels_per_disk=32". other modprobe.d commands are not allowed
+	// +optional
 	ModuleParams string `json:"moduleParams,omitempty"`
+
+	// Modules - kernel module parameters by module name and parameter name, i.e. {"nvmeibc": {"nr_max_channels_per_disk": "32"}}. rendered as options lines after moduleParams. a change reloads the drivers of each node whose parameters changed, one node at a time
+	// +optional
+	Modules map[string]map[string]string `json:"modules,omitempty"`
+
+	// ExtraConfig - additional nvmesh.conf keys and values. values are quoted by the operator, keys that are removed from this field are removed from nvmesh.conf
+	// +optional
+	ExtraConfig map[string]string `json:"extraConfig,omitempty"`
+
+	// ClientNodeSelector - if set, the operator will label all nodes matching this selector as NVMesh clients and will remove the client label from all other nodes. An empty selector matches all nodes
+	// +optional
+	ClientNodeSelector *metav1.LabelSelector `json:"clientNodeSelector,omitempty"`
+
+	// TargetNodeSelector - if set, the operator will label all nodes matching this selector as NVMesh targets and will remove the target label from all other nodes. An empty selector matches all nodes
+	// +optional
+	TargetNodeSelector *metav1.LabelSelector `json:"targetNodeSelector,omitempty"`
+
+	// Preflight - checks that run on each node before NVMesh Core is first deployed and before each upgrade
+	// +optional
+	Preflight PreflightSpec `json:"preflight,omitempty"`
+
+	// NodeOverrides - per-node changes to the NVMesh Core configuration. Overrides are applied in order, when a node matches more than one override the later one wins
+	// +optional
+	NodeOverrides []NodeConfigOverride `json:"nodeOverrides,omitempty"`
+
+	// DisableDrainGuard - if true the operator does not block drains of target nodes that hold the last healthy copy of a volume.
+	// Volumes without redundancy (concatenated and RAID-0) can not be rebuilt elsewhere, so they never block a drain
+	// +optional
+	DisableDrainGuard bool `json:"disableDrainGuard,omitempty"`
+
+	// Probes - timings of the readiness probe that checks the NVMesh kernel module is loaded. a liveness probe is not used since restarting the driver container unloads the modules
+	// +optional
+	Probes ProbesSpec `json:"probes,omitempty"`
+}
+
+// ProbesSpec - the readiness and liveness probes injected by the operator. zero values use the component defaults
+type ProbesSpec struct {
+	// Disabled - if true the operator does not add probes to the component
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Readiness - timings of the readiness probe
+	// +optional
+	Readiness ProbeTimings `json:"readiness,omitempty"`
+
+	// Liveness - timings of the liveness probe
+	// +optional
+	Liveness ProbeTimings `json:"liveness,omitempty"`
+}
+
+// ProbeTimings - overrides the timings of a probe, a field that is not set uses the component default
+type ProbeTimings struct {
+	// InitialDelaySeconds - seconds after the container started before the first probe
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+
+	// PeriodSeconds - seconds between probes
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+
+	// TimeoutSeconds - seconds after which a probe times out
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// FailureThreshold - consecutive failures after which the probe is considered failed
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// PreflightSpec - options for the preflight checks. a node that fails the checks blocks the first deployment of NVMesh Core, or its upgrade to spec.core.version, until the checks pass
+type PreflightSpec struct {
+	// Skip - if true the preflight checks do not run automatically and do not block deployments and upgrades. the preflight action can still be used
+	// +optional
+	Skip bool `json:"skip,omitempty"`
+
+	// MinAvailableMemoryMiB - the minimum available memory on each node in MiB. Defaults to 1024
+	// +optional
+	MinAvailableMemoryMiB int `json:"minAvailableMemoryMiB,omitempty"`
+
+	// MinFreeHugePages - the minimum number of free hugepages on each node
+	// +optional
+	MinFreeHugePages int `json:"minFreeHugePages,omitempty"`
+}
+
+// NodeConfigOverride - configuration that replaces the cluster wide Core configuration on the matching nodes
+type NodeConfigOverride struct {
+	// NodeName - the name of the node this override applies to. exactly one of nodeName or nodeSelector should be set
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+
+	// NodeSelector - select the nodes this override applies to by their labels. exactly one of nodeName or nodeSelector should be set
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// ConfiguredNICs - a comma seperated list of nics to use with NVMesh on the matching nodes, or "auto"
+	// +optional
+	ConfiguredNICs string `json:"configuredNICs,omitempty"`
+
+	// TCPOnly - overrides spec.core.tcpOnly on the matching nodes, allows a cluster that mixes TCP and RDMA nodes
+	// +optional
+	TCPOnly *bool `json:"tcpOnly,omitempty"`
+
+	// Exclude NVMe Drives - Define which NVMe drives should not be used by NVMesh on the matching nodes
+	// +optional
+	ExcludeDrives *ExcludeNVMeDrivesSpec `json:"excludeDrives,omitempty"`
+
+	// ModuleParams - additional module parameters for the matching nodes, appended after spec.core.moduleParams
+	// +optional
+	ModuleParams string `json:"moduleParams,omitempty"`
+
+	// ExtraConfig - additional nvmesh.conf keys and values for the matching nodes
+	// +optional
+	ExtraConfig map[string]string `json:"extraConfig,omitempty"`
 }
 
 type ExcludeNVMeDrivesSpec struct {
@@ -102,9 +221,57 @@ type MongoDBCluster struct {
 	// +optional
 	Address string `json:"address,omitempty"`
 
+	// ConnectionString - a full mongodb:// or mongodb+srv:// URI of an external MongoDB, including hosts, replica set, options and credentials.
+	// i.e "mongodb://mongo-0.db:27017,mongo-1.db:27017/?replicaSet=rs0". Used instead of address by the operator, its jobs and NVMesh Management
+	// +optional
+	ConnectionString string `json:"connectionString,omitempty"`
+
+	// ConnectionStringSecret - a reference to a Secret key holding the connection string, for a connection string with credentials
+	// +optional
+	ConnectionStringSecret *v1.SecretKeySelector `json:"connectionStringSecret,omitempty"`
+
 	//Overrides fields in the MongoDB data PVC
 	// +optional
 	DataVolumeClaim v1.PersistentVolumeClaimSpec `json:"dataVolumeClaim,omitempty"`
+
+	// Auth - the credentials used to connect to MongoDB. When MongoDB is deployed by the operator, authorization is enabled and the user is created on first start.
+	// The Secret is mounted in the pods that connect to MongoDB, including NVMesh Management
+	// +optional
+	Auth *MongoDBAuthSpec `json:"auth,omitempty"`
+
+	// TLS - connect to MongoDB using TLS. When MongoDB is deployed by the operator, it only accepts TLS connections.
+	// The certificates are mounted in the pods that connect to MongoDB, including NVMesh Management
+	// +optional
+	TLS *MongoDBTLSSpec `json:"tls,omitempty"`
+
+	// Probes - timings of the ping probes of the MongoDB deployed by the operator
+	// +optional
+	Probes ProbesSpec `json:"probes,omitempty"`
+}
+
+type MongoDBAuthSpec struct {
+	// The name of a Secret with the keys username and password.
+	// The credentials are also used in connection strings, so the password should not contain characters that need URL encoding
+	// +required
+	SecretName string `json:"secretName"`
+
+	// The database that holds the user, defaults to admin
+	// +optional
+	AuthSource string `json:"authSource,omitempty"`
+}
+
+type MongoDBTLSSpec struct {
+	// The name of a Secret with the CA certificate in the key ca.crt, used to verify the MongoDB server certificate
+	// +required
+	CASecretName string `json:"caSecretName"`
+
+	// The name of a kubernetes.io/tls Secret with the client certificate and key, for a MongoDB that requires client certificates
+	// +optional
+	ClientCertSecretName string `json:"clientCertSecretName,omitempty"`
+
+	// The name of a kubernetes.io/tls Secret with the server certificate and key of the MongoDB deployed by the operator, required unless external is true
+	// +optional
+	ServerCertSecretName string `json:"serverCertSecretName,omitempty"`
 }
 
 type NVMeshManagement struct {
@@ -147,6 +314,38 @@ type NVMeshManagement struct {
 	// Disable Auto-Evict Missing NVMe drives - This enables NVMesh to auto-rebuild volumes when drives were replaced (for example on the cloud after a machine was restarted)
 	// +optional
 	DisableAutoEvictMissingDrives bool `json:"disableAutoEvictMissingDrives,omitempty"`
+
+	// NodeSelector - if set, the operator will label all nodes matching this selector as NVMesh management nodes and will remove the management label from all other nodes. An empty selector matches all nodes
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// Upgrade - options for upgrading NVMesh Management when the version is changed
+	// +optional
+	Upgrade ManagementUpgradeSpec `json:"upgrade,omitempty"`
+
+	// Probes - timings of the Management probes, readiness checks the GUI over HTTP(S) and liveness checks the websocket port
+	// +optional
+	Probes ProbesSpec `json:"probes,omitempty"`
+}
+
+// ManagementUpgradeSpec - a Management upgrade backs up the management database, rolls the Management pods one at a time and verifies that the MCS agents reconnect. on failure Management is reverted to the previous version
+type ManagementUpgradeSpec struct {
+	// SkipBackup - if true the management database is not backed up before the upgrade
+	// +optional
+	SkipBackup bool `json:"skipBackup,omitempty"`
+
+	// RestoreBackupOnFailure - if true the backup taken before the upgrade is restored when the upgrade fails, Management is scaled down while it is restored. changes made to the database during the upgrade are lost
+	// +optional
+	RestoreBackupOnFailure bool `json:"restoreBackupOnFailure,omitempty"`
+
+	// TimeoutSeconds - how long to wait for the Management pods to become ready and for the MCS agents to reconnect before the upgrade is considered failed. Defaults to 600
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// Overrides fields in the PVC that stores the database backups
+	// +optional
+	BackupVolumeClaim v1.PersistentVolumeClaimSpec `json:"backupVolumeClaim,omitempty"`
 }
 
 type NVMeshCSI struct {
@@ -166,6 +365,10 @@ type NVMeshCSI struct {
 	//If true NVMesh CSI Driver will not be deployed
 	// +optional
 	Disabled bool `json:"disabled,omitempty"`
+
+	// Probes - timings of the probes that call the CSI Probe over the gRPC socket of the controller and the node driver
+	// +optional
+	Probes ProbesSpec `json:"probes,omitempty"`
 }
 
 type NVMeshOperatorSpec struct {
@@ -180,6 +383,36 @@ type NVMeshOperatorSpec struct {
 
 	// Override the default file server for compiled binaries
 	FileServer *OperatorFileServerSpec `json:"fileServer,omitempty"`
+
+	// Names of the Secrets used by the objects the operator deploys
+	// +optional
+	Secrets OperatorSecretsSpec `json:"secrets,omitempty"`
+}
+
+// OperatorSecretsSpec - references to Secrets in the namespace of the cluster. Each reference is propagated to every DaemonSet, StatefulSet, Job and ServiceAccount the operator creates
+type OperatorSecretsSpec struct {
+	// Image pull secrets for the NVMesh images, defaults to excelero-registry-cred
+	// +optional
+	ImagePullSecrets []v1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// The Secret with the username and password of the binaries file server, defaults to nvmesh-file-server-cred
+	// +optional
+	FileServer string `json:"fileServer,omitempty"`
+
+	// The Secret with the username and password the CSI driver uses to connect to NVMesh Management.
+	// When not set the operator creates a dedicated Management user with a generated password and stores it in the nvmesh-csi-credentials Secret,
+	// unless Management is disabled, then the nvmesh-csi-credentials Secret must be created by the user
+	// +optional
+	CSIManagement string `json:"csiManagement,omitempty"`
+
+	// The Secret with the username and password of a Management admin, used by the operator to create the CSI Management user.
+	// Defaults to nvmesh-mgmt-admin-cred, the CSI Management user is not created until it exists, and Management upgrades are completed without verifying that the MCS agents reconnected
+	// +optional
+	ManagementAdmin string `json:"managementAdmin,omitempty"`
+
+	// The Secret with the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY used to upload to S3, defaults to s3-bucket-secrets
+	// +optional
+	S3 string `json:"s3,omitempty"`
 }
 
 type OperatorFileServerSpec struct {
@@ -190,16 +423,30 @@ type OperatorFileServerSpec struct {
 	SkipCheckCertificate bool `json:"skipCheckCertificate,omitempty"`
 }
 
+// ActionName - the type of an action, used by spec.actions, spec.schedules and NVMeshActions
+// +kubebuilder:validation:Enum=collect-logs;discover-nodes;preflight;rotate-credentials;node-maintenance;format-drive;evict-drive;reinclude-drive
+type ActionName string
+
 type ClusterAction struct {
-	// The type of action to perform
-	// +kubebuilder:validation:Enum=collect-logs
+	// The type of action to perform. node-maintenance expects the args node and mode (enter or exit).
+	// format-drive, evict-drive and reinclude-drive expect the args node and one of serialNumber or devicePath
 	// +kubebuilder:validation:Required
 	// +required
-	Name string `json:"name"`
+	Name ActionName `json:"name"`
+
+	// A unique ID of the action, generated by the operator when it is not set.
+	// The same action can be submitted more than once with different IDs
+	// +optional
+	ID string `json:"id,omitempty"`
 
 	// Arguments for the Action
 	// +optional
 	Args map[string]string `json:"args,omitempty"`
+
+	// The action fails if it does not finish in this time, defaults to a timeout set for each type of action
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
 }
 
 // NVMeshDebugOptions - Operator Debug Options
@@ -242,10 +489,116 @@ type NVMeshSpec struct {
 
 	// Initiate actions such as collecting logs
 	Actions []ClusterAction `json:"actions,omitempty"`
+
+	// Run actions on a recurring schedule, each run creates an NVMeshAction
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Schedules []ActionSchedule `json:"schedules,omitempty"`
+}
+
+// ActionSchedule - runs an action on a cron schedule, missed runs are handled like a Kubernetes CronJob
+type ActionSchedule struct {
+	// A unique name of the schedule, used in the names of the NVMeshActions it creates
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=52
+	Name string `json:"name"`
+
+	// The schedule in Cron format evaluated in UTC, i.e. "0 2 * * *" for every night at 02:00.
+	// Also accepts @hourly, @daily, @weekly, @monthly, @yearly, @every <duration> and a CRON_TZ=<time zone> prefix
+	Schedule string `json:"schedule"`
+
+	// The action to run, the id of the action is ignored and each run gets its own id
+	Action ClusterAction `json:"action"`
+
+	// Allow (default) runs the action even if the previous run is still running, Forbid skips the run and Replace cancels the previous run.
+	// Replace is only valid for actions that can be stopped at any stage: collect-logs, discover-nodes and preflight
+	// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+	// +optional
+	ConcurrencyPolicy ScheduleConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+
+	// A run that is missed by more than this number of seconds, i.e. while the operator was down, is skipped. If not set missed runs are never skipped
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+
+	// Stops creating new runs, runs that already started are not affected
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// The NVMeshAction of each run is deleted this number of seconds after it finished, defaults to one day
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+}
+
+// ScheduleConcurrencyPolicy - how to treat a run of a schedule while the previous run is still running
+type ScheduleConcurrencyPolicy string
+
+// These are valid concurrency policies of an ActionSchedule
+const (
+	AllowConcurrent   ScheduleConcurrencyPolicy = "Allow"
+	ForbidConcurrent  ScheduleConcurrencyPolicy = "Forbid"
+	ReplaceConcurrent ScheduleConcurrencyPolicy = "Replace"
+)
+
+// ActionScheduleStatus - the runs of a schedule
+type ActionScheduleStatus struct {
+	// The name of the schedule
+	Name string `json:"name"`
+
+	// The time the last run was scheduled for
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// The time of the next run
+	// +optional
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+
+	// The NVMeshAction of the last run
+	// +optional
+	LastAction string `json:"lastAction,omitempty"`
+
+	// The NVMeshActions of the schedule that did not finish
+	// +optional
+	Active []string `json:"active,omitempty"`
 }
 
 type ActionStatus map[string]string
 
+// ActionHistoryEntry - the result of a finished action
+type ActionHistoryEntry struct {
+	// The unique ID of the action
+	ID string `json:"id"`
+
+	// The type of the action
+	Name string `json:"name"`
+
+	// The arguments the action was started with
+	// +optional
+	Args map[string]string `json:"args,omitempty"`
+
+	// Succeeded, Failed or TimedOut
+	Result string `json:"result"`
+
+	// The reason the action failed
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// +optional
+	StartTime metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	EndTime metav1.Time `json:"endTime,omitempty"`
+}
+
+// These are valid results of actions
+const (
+	ActionSucceeded = "Succeeded"
+	ActionFailed    = "Failed"
+	ActionTimedOut  = "TimedOut"
+)
+
 // NVMeshStatus defines the observed state of NVMesh
 type NVMeshStatus struct {
 	// define observed state of cluster
@@ -257,6 +610,18 @@ type NVMeshStatus struct {
 	// Represents the Status of actions
 	ActionsStatus map[string]ActionStatus `json:"actionsStatus,omitempty"`
 
+	// The results of the last finished actions, oldest first
+	// +optional
+	ActionHistory []ActionHistoryEntry `json:"actionHistory,omitempty"`
+
+	// The last and next runs of each schedule in spec.schedules
+	// +optional
+	Schedules []ActionScheduleStatus `json:"schedules,omitempty"`
+
+	// Represents the state of each node that participates in the cluster, keyed by node name
+	// +optional
+	Nodes map[string]NodeStatus `json:"nodes,omitempty"`
+
 	// Represents the latest available observations of a NVMesh's current state.
 	// +optional
 	// +patchMergeKey=type
@@ -264,12 +629,63 @@ type NVMeshStatus struct {
 	Conditions []ClusterCondition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,10,rep,name=conditions"`
 }
 
+// NodeState - the lifecycle state of a node in the NVMesh cluster
+type NodeState string
+
+// These are valid states of nodes in the NVMesh cluster
+const (
+	// NodeActive - the node has one of the NVMesh role labels
+	NodeActive NodeState = "Active"
+	// NodeUninstallPending - the NVMesh role labels were removed and the node is waiting to be uninstalled
+	NodeUninstallPending NodeState = "UninstallPending"
+	// NodeUninstalling - an uninstall job is running on the node
+	NodeUninstalling NodeState = "Uninstalling"
+	// NodeUninstalled - the uninstall job finished successfully
+	NodeUninstalled NodeState = "Uninstalled"
+	// NodeUninstallFailed - the uninstall job failed and no more attempts will be made
+	NodeUninstallFailed NodeState = "UninstallFailed"
+)
+
+// NodeStatus - the observed state of a single node in the NVMesh cluster
+type NodeStatus struct {
+	// The NVMesh roles of the node (client, target)
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// The lifecycle state of the node
+	State NodeState `json:"state,omitempty"`
+
+	// The number of uninstall jobs that were started on the node
+	// +optional
+	UninstallAttempts int `json:"uninstallAttempts,omitempty"`
+
+	// A human readable message indicating details about the last transition
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Last time the state of the node changed
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
 type ClusterConditionType string
 
 // These are valid conditions of NVMesh.
 const (
 	Ready        ClusterConditionType = "Ready"
 	Uninstalling ClusterConditionType = "Uninstalling"
+
+	// NodeRolesOverlap - some nodes are matched by more than one of the node role selectors
+	NodeRolesOverlap ClusterConditionType = "NodeRolesOverlap"
+
+	// InvalidNodeRoles - some nodes have a combination of role labels that cannot run, i.e. the target role without the client role
+	InvalidNodeRoles ClusterConditionType = "InvalidNodeRoles"
+
+	// PreflightFailed - some nodes failed the preflight checks, NVMesh Core will not be deployed or upgraded to spec.core.version until the checks pass
+	PreflightFailed ClusterConditionType = "PreflightFailed"
+
+	// SecretsMissing - Secrets needed by the cluster are missing or invalid
+	SecretsMissing ClusterConditionType = "SecretsMissing"
 )
 
 // These are valid condition statuses. "ConditionTrue" means a resource is in the condition;
@@ -0,0 +1,115 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.cluster`
+// +kubebuilder:printcolumn:name="Action",type=string,JSONPath=`.spec.name`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.reason`,priority=10
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// Represents an action on an NVMesh Cluster. An alternative to spec.actions that does not change the spec of the NVMesh object
+type NVMeshAction struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NVMeshActionSpec   `json:"spec,omitempty"`
+	Status NVMeshActionStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NVMeshActionList contains a list of NVMeshAction
+type NVMeshActionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NVMeshAction `json:"items"`
+}
+
+// NVMeshActionSpec - the action and the NVMesh cluster it runs on
+type NVMeshActionSpec struct {
+	// The name of the NVMesh cluster in the namespace of the action
+	Cluster string `json:"cluster"`
+
+	// The type of action to perform, accepts the same actions and arguments as spec.actions of the NVMesh
+	Name ActionName `json:"name"`
+
+	// Arguments for the Action
+	// +optional
+	Args map[string]string `json:"args,omitempty"`
+
+	// The action fails if it does not finish in this time, defaults to a timeout set for each type of action
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// The NVMeshAction is deleted this number of seconds after it finished. If not set the NVMeshAction is kept
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+}
+
+// NVMeshActionStatus - the progress and result of the action
+type NVMeshActionStatus struct {
+	// Pending, Running, Succeeded, Failed or TimedOut
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// The reason the action is pending or failed
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// The status of the stages of the action
+	// +optional
+	Stages ActionStatus `json:"stages,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// The Complete or Failed condition of the action, set when it finished
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions []ClusterCondition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// These are valid phases of an NVMeshAction, a finished action has the phase of its result (Succeeded, Failed or TimedOut)
+const (
+	ActionPending = "Pending"
+	ActionRunning = "Running"
+)
+
+// These are valid conditions of an NVMeshAction
+const (
+	// ActionCompleteCondition - the action succeeded
+	ActionCompleteCondition ClusterConditionType = "Complete"
+
+	// ActionFailedCondition - the action failed or timed out
+	ActionFailedCondition ClusterConditionType = "Failed"
+)
+
+func init() {
+	SchemeBuilder.Register(&NVMeshAction{}, &NVMeshActionList{})
+}
@@ -0,0 +1,282 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.cluster`
+// +kubebuilder:printcolumn:name="Roles",type=string,JSONPath=`.status.roles`
+// +kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+// +kubebuilder:printcolumn:name="Driver",type=string,JSONPath=`.status.driverVersion`
+// +kubebuilder:printcolumn:name="MCS",type=string,JSONPath=`.status.mcsState`
+// +kubebuilder:printcolumn:name="Agent",type=string,JSONPath=`.status.agentState`
+// +kubebuilder:printcolumn:name="NICs",type=string,JSONPath=`.status.nics[*].name`,priority=10
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// Represents a node that participates in an NVMesh Cluster. NVMeshNodes are created and updated by the operator
+type NVMeshNode struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NVMeshNodeSpec   `json:"spec,omitempty"`
+	Status NVMeshNodeStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NVMeshNodeList contains a list of NVMeshNode
+type NVMeshNodeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NVMeshNode `json:"items"`
+}
+
+// NVMeshNodeSpec - identifies the k8s node and the NVMesh cluster
+type NVMeshNodeSpec struct {
+	// The name of the k8s node
+	NodeName string `json:"nodeName"`
+
+	// The name of the NVMesh cluster this node belongs to
+	Cluster string `json:"cluster"`
+}
+
+// NVMeshNodeNIC - a network interface used by NVMesh on the node
+type NVMeshNodeNIC struct {
+	// The name of the network interface. i.e. ib0
+	Name string `json:"name"`
+
+	// The protocol used on this interface (tcp, rdma)
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// NVMeshNodeDrive - an NVMe drive found on the node
+type NVMeshNodeDrive struct {
+	// The serial number of the drive
+	// +optional
+	SerialNumber string `json:"serialNumber,omitempty"`
+
+	// The device path of the drive. i.e. /dev/nvme0n1
+	// +optional
+	DevicePath string `json:"devicePath,omitempty"`
+
+	// Excluded - true if the drive is excluded from being used by NVMesh
+	// +optional
+	Excluded bool `json:"excluded,omitempty"`
+}
+
+// NodeTaskResult - the result of the last time a task (i.e. uninstall or collect-logs) ran on the node
+type NodeTaskResult struct {
+	// Succeeded or Failed
+	Result string `json:"result"`
+
+	// A human readable message describing the result
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// The time the task finished
+	// +optional
+	Time metav1.Time `json:"time,omitempty"`
+
+	// The number of consecutive times the task failed, failed tasks that run automatically (i.e. discovery) are retried with a growing delay
+	// +optional
+	Failures int `json:"failures,omitempty"`
+}
+
+// NodeDiscovery - the hardware of the node as reported by the discovery job
+type NodeDiscovery struct {
+	// The kernel version of the node
+	// +optional
+	KernelVersion string `json:"kernelVersion,omitempty"`
+
+	// The boot ID of the node, the node is discovered again after it is rebooted
+	// +optional
+	BootID string `json:"bootID,omitempty"`
+
+	// The network interfaces found on the node
+	// +optional
+	NICs []DiscoveredNIC `json:"nics,omitempty"`
+
+	// The NVMe drives found on the node
+	// +optional
+	Drives []DiscoveredDrive `json:"drives,omitempty"`
+
+	// The time the discovery ran
+	// +optional
+	Time metav1.Time `json:"time,omitempty"`
+}
+
+// DiscoveredDrive - an NVMe drive found on the node by the discovery job
+type DiscoveredDrive struct {
+	// The device path of the drive. i.e. /dev/nvme0n1
+	DevicePath string `json:"devicePath"`
+
+	// The serial number of the drive
+	// +optional
+	SerialNumber string `json:"serialNumber,omitempty"`
+}
+
+// DiscoveredNIC - a network interface found on the node by the discovery job
+type DiscoveredNIC struct {
+	// The name of the network interface. i.e. ens1f0
+	Name string `json:"name"`
+
+	// The link speed in Mb/s, 0 if unknown or the link is down
+	// +optional
+	SpeedMbps int `json:"speedMbps,omitempty"`
+
+	// The MTU of the interface
+	// +optional
+	MTU int `json:"mtu,omitempty"`
+
+	// The operational state of the interface (up, down, unknown)
+	// +optional
+	State string `json:"state,omitempty"`
+
+	// Virtual - true for software interfaces such as bridges, veth pairs and tunnels
+	// +optional
+	Virtual bool `json:"virtual,omitempty"`
+
+	// The RDMA device bound to the interface. i.e. mlx5_0. Empty if the interface is not RDMA capable
+	// +optional
+	RDMADevice string `json:"rdmaDevice,omitempty"`
+
+	// The link layer of the RDMA device (InfiniBand, Ethernet)
+	// +optional
+	LinkLayer string `json:"linkLayer,omitempty"`
+}
+
+// NodePreflightResult - the result of the preflight checks on the node
+type NodePreflightResult struct {
+	// Succeeded or Failed
+	Result string `json:"result"`
+
+	// A human readable message describing the result
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// The time the checks finished
+	// +optional
+	Time metav1.Time `json:"time,omitempty"`
+
+	// The NVMesh version the node was checked for
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// The number of consecutive times the node failed the checks for this version, failed nodes are checked again with a growing delay
+	// +optional
+	Failures int `json:"failures,omitempty"`
+
+	// The result of each check
+	// +optional
+	Checks []PreflightCheck `json:"checks,omitempty"`
+}
+
+// PreflightCheck - the result of a single preflight check
+type PreflightCheck struct {
+	// The name of the check. i.e. kernel, file-server, memory
+	Name string `json:"name"`
+
+	// Passed, Warning or Failed
+	Result string `json:"result"`
+
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// These are valid results of preflight checks
+const (
+	PreflightCheckPassed  = "Passed"
+	PreflightCheckWarning = "Warning"
+	PreflightCheckFailed  = "Failed"
+)
+
+// These are valid results of node tasks
+const (
+	NodeTaskSucceeded = "Succeeded"
+	NodeTaskFailed    = "Failed"
+)
+
+// These are valid states of the MCS and agent on the node
+const (
+	ComponentConnected    = "Connected"
+	ComponentNotReady     = "NotReady"
+	ComponentNotRunning   = "NotRunning"
+	ComponentNotInstalled = "NotInstalled"
+)
+
+// NVMeshNodeStatus - the observed state of the node
+type NVMeshNodeStatus struct {
+	// The NVMesh roles of the node (client, target, management)
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// The lifecycle state of the node
+	// +optional
+	State NodeState `json:"state,omitempty"`
+
+	// The version of the NVMesh driver loaded on the node
+	// +optional
+	DriverVersion string `json:"driverVersion,omitempty"`
+
+	// The network interfaces used by NVMesh on the node
+	// +optional
+	NICs []NVMeshNodeNIC `json:"nics,omitempty"`
+
+	// The NVMe drives discovered on the node
+	// +optional
+	Drives []NVMeshNodeDrive `json:"drives,omitempty"`
+
+	// Drive serial numbers and device paths that are excluded from being used by NVMesh
+	// +optional
+	ExcludedDrives []string `json:"excludedDrives,omitempty"`
+
+	// The state of the MCS on the node
+	// +optional
+	MCSState string `json:"mcsState,omitempty"`
+
+	// The state of the management agent on the node
+	// +optional
+	AgentState string `json:"agentState,omitempty"`
+
+	// The result of the last uninstall on this node
+	// +optional
+	LastUninstall *NodeTaskResult `json:"lastUninstall,omitempty"`
+
+	// The result of the last collect-logs action on this node
+	// +optional
+	LastCollectLogs *NodeTaskResult `json:"lastCollectLogs,omitempty"`
+
+	// The NICs and kernel version found by the last successful discovery
+	// +optional
+	Discovery *NodeDiscovery `json:"discovery,omitempty"`
+
+	// The result of the last discovery on this node
+	// +optional
+	LastDiscovery *NodeTaskResult `json:"lastDiscovery,omitempty"`
+
+	// The result of the last preflight checks on this node
+	// +optional
+	LastPreflight *NodePreflightResult `json:"lastPreflight,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NVMeshNode{}, &NVMeshNodeList{})
+}
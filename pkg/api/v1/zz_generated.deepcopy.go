@@ -21,9 +21,89 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActionHistoryEntry) DeepCopyInto(out *ActionHistoryEntry) {
+	*out = *in
+	if in.Args != nil {
+		in, out := &in.Args, &out.Args
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	in.EndTime.DeepCopyInto(&out.EndTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActionHistoryEntry.
+func (in *ActionHistoryEntry) DeepCopy() *ActionHistoryEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(ActionHistoryEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActionSchedule) DeepCopyInto(out *ActionSchedule) {
+	*out = *in
+	in.Action.DeepCopyInto(&out.Action)
+	if in.StartingDeadlineSeconds != nil {
+		in, out := &in.StartingDeadlineSeconds, &out.StartingDeadlineSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.TTLSecondsAfterFinished != nil {
+		in, out := &in.TTLSecondsAfterFinished, &out.TTLSecondsAfterFinished
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActionSchedule.
+func (in *ActionSchedule) DeepCopy() *ActionSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(ActionSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActionScheduleStatus) DeepCopyInto(out *ActionScheduleStatus) {
+	*out = *in
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextScheduleTime != nil {
+		in, out := &in.NextScheduleTime, &out.NextScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Active != nil {
+		in, out := &in.Active, &out.Active
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActionScheduleStatus.
+func (in *ActionScheduleStatus) DeepCopy() *ActionScheduleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ActionScheduleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in ActionStatus) DeepCopyInto(out *ActionStatus) {
 	{
@@ -98,6 +178,36 @@ func (in *DebugOptions) DeepCopy() *DebugOptions {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscoveredDrive) DeepCopyInto(out *DiscoveredDrive) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscoveredDrive.
+func (in *DiscoveredDrive) DeepCopy() *DiscoveredDrive {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscoveredDrive)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiscoveredNIC) DeepCopyInto(out *DiscoveredNIC) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiscoveredNIC.
+func (in *DiscoveredNIC) DeepCopy() *DiscoveredNIC {
+	if in == nil {
+		return nil
+	}
+	out := new(DiscoveredNIC)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ExcludeNVMeDrivesSpec) DeepCopyInto(out *ExcludeNVMeDrivesSpec) {
 	*out = *in
@@ -123,10 +233,57 @@ func (in *ExcludeNVMeDrivesSpec) DeepCopy() *ExcludeNVMeDrivesSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagementUpgradeSpec) DeepCopyInto(out *ManagementUpgradeSpec) {
+	*out = *in
+	in.BackupVolumeClaim.DeepCopyInto(&out.BackupVolumeClaim)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagementUpgradeSpec.
+func (in *ManagementUpgradeSpec) DeepCopy() *ManagementUpgradeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagementUpgradeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MongoDBAuthSpec) DeepCopyInto(out *MongoDBAuthSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MongoDBAuthSpec.
+func (in *MongoDBAuthSpec) DeepCopy() *MongoDBAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MongoDBAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MongoDBCluster) DeepCopyInto(out *MongoDBCluster) {
 	*out = *in
+	if in.ConnectionStringSecret != nil {
+		in, out := &in.ConnectionStringSecret, &out.ConnectionStringSecret
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
 	in.DataVolumeClaim.DeepCopyInto(&out.DataVolumeClaim)
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		*out = new(MongoDBAuthSpec)
+		**out = **in
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(MongoDBTLSSpec)
+		**out = **in
+	}
+	out.Probes = in.Probes
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MongoDBCluster.
@@ -139,6 +296,21 @@ func (in *MongoDBCluster) DeepCopy() *MongoDBCluster {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MongoDBTLSSpec) DeepCopyInto(out *MongoDBTLSSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MongoDBTLSSpec.
+func (in *MongoDBTLSSpec) DeepCopy() *MongoDBTLSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MongoDBTLSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NVMesh) DeepCopyInto(out *NVMesh) {
 	*out = *in
@@ -166,9 +338,133 @@ func (in *NVMesh) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshAction) DeepCopyInto(out *NVMeshAction) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshAction.
+func (in *NVMeshAction) DeepCopy() *NVMeshAction {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshAction)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NVMeshAction) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshActionList) DeepCopyInto(out *NVMeshActionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NVMeshAction, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshActionList.
+func (in *NVMeshActionList) DeepCopy() *NVMeshActionList {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshActionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NVMeshActionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshActionSpec) DeepCopyInto(out *NVMeshActionSpec) {
+	*out = *in
+	if in.Args != nil {
+		in, out := &in.Args, &out.Args
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.TTLSecondsAfterFinished != nil {
+		in, out := &in.TTLSecondsAfterFinished, &out.TTLSecondsAfterFinished
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshActionSpec.
+func (in *NVMeshActionSpec) DeepCopy() *NVMeshActionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshActionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshActionStatus) DeepCopyInto(out *NVMeshActionStatus) {
+	*out = *in
+	if in.Stages != nil {
+		in, out := &in.Stages, &out.Stages
+		*out = make(ActionStatus, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]ClusterCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshActionStatus.
+func (in *NVMeshActionStatus) DeepCopy() *NVMeshActionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshActionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NVMeshCSI) DeepCopyInto(out *NVMeshCSI) {
 	*out = *in
+	out.Probes = in.Probes
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshCSI.
@@ -189,6 +485,49 @@ func (in *NVMeshCore) DeepCopyInto(out *NVMeshCore) {
 		*out = new(ExcludeNVMeDrivesSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Modules != nil {
+		in, out := &in.Modules, &out.Modules
+		*out = make(map[string]map[string]string, len(*in))
+		for key, val := range *in {
+			var outVal map[string]string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make(map[string]string, len(*in))
+				for key, val := range *in {
+					(*out)[key] = val
+				}
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.ExtraConfig != nil {
+		in, out := &in.ExtraConfig, &out.ExtraConfig
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ClientNodeSelector != nil {
+		in, out := &in.ClientNodeSelector, &out.ClientNodeSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TargetNodeSelector != nil {
+		in, out := &in.TargetNodeSelector, &out.TargetNodeSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Preflight = in.Preflight
+	if in.NodeOverrides != nil {
+		in, out := &in.NodeOverrides, &out.NodeOverrides
+		*out = make([]NodeConfigOverride, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	out.Probes = in.Probes
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshCore.
@@ -243,6 +582,13 @@ func (in *NVMeshManagement) DeepCopyInto(out *NVMeshManagement) {
 		copy(*out, *in)
 	}
 	in.BackupsVolumeClaim.DeepCopyInto(&out.BackupsVolumeClaim)
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Upgrade.DeepCopyInto(&out.Upgrade)
+	out.Probes = in.Probes
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshManagement.
@@ -255,6 +601,170 @@ func (in *NVMeshManagement) DeepCopy() *NVMeshManagement {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshNode) DeepCopyInto(out *NVMeshNode) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshNode.
+func (in *NVMeshNode) DeepCopy() *NVMeshNode {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NVMeshNode) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshNodeDrive) DeepCopyInto(out *NVMeshNodeDrive) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshNodeDrive.
+func (in *NVMeshNodeDrive) DeepCopy() *NVMeshNodeDrive {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshNodeDrive)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshNodeList) DeepCopyInto(out *NVMeshNodeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]NVMeshNode, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshNodeList.
+func (in *NVMeshNodeList) DeepCopy() *NVMeshNodeList {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshNodeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *NVMeshNodeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshNodeNIC) DeepCopyInto(out *NVMeshNodeNIC) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshNodeNIC.
+func (in *NVMeshNodeNIC) DeepCopy() *NVMeshNodeNIC {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshNodeNIC)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshNodeSpec) DeepCopyInto(out *NVMeshNodeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshNodeSpec.
+func (in *NVMeshNodeSpec) DeepCopy() *NVMeshNodeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshNodeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NVMeshNodeStatus) DeepCopyInto(out *NVMeshNodeStatus) {
+	*out = *in
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NICs != nil {
+		in, out := &in.NICs, &out.NICs
+		*out = make([]NVMeshNodeNIC, len(*in))
+		copy(*out, *in)
+	}
+	if in.Drives != nil {
+		in, out := &in.Drives, &out.Drives
+		*out = make([]NVMeshNodeDrive, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludedDrives != nil {
+		in, out := &in.ExcludedDrives, &out.ExcludedDrives
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastUninstall != nil {
+		in, out := &in.LastUninstall, &out.LastUninstall
+		*out = new(NodeTaskResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastCollectLogs != nil {
+		in, out := &in.LastCollectLogs, &out.LastCollectLogs
+		*out = new(NodeTaskResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Discovery != nil {
+		in, out := &in.Discovery, &out.Discovery
+		*out = new(NodeDiscovery)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastDiscovery != nil {
+		in, out := &in.LastDiscovery, &out.LastDiscovery
+		*out = new(NodeTaskResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastPreflight != nil {
+		in, out := &in.LastPreflight, &out.LastPreflight
+		*out = new(NodePreflightResult)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshNodeStatus.
+func (in *NVMeshNodeStatus) DeepCopy() *NVMeshNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NVMeshNodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NVMeshOperatorSpec) DeepCopyInto(out *NVMeshOperatorSpec) {
 	*out = *in
@@ -263,6 +773,7 @@ func (in *NVMeshOperatorSpec) DeepCopyInto(out *NVMeshOperatorSpec) {
 		*out = new(OperatorFileServerSpec)
 		**out = **in
 	}
+	in.Secrets.DeepCopyInto(&out.Secrets)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshOperatorSpec.
@@ -290,6 +801,13 @@ func (in *NVMeshSpec) DeepCopyInto(out *NVMeshSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Schedules != nil {
+		in, out := &in.Schedules, &out.Schedules
+		*out = make([]ActionSchedule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVMeshSpec.
@@ -322,6 +840,27 @@ func (in *NVMeshStatus) DeepCopyInto(out *NVMeshStatus) {
 			(*out)[key] = outVal
 		}
 	}
+	if in.ActionHistory != nil {
+		in, out := &in.ActionHistory, &out.ActionHistory
+		*out = make([]ActionHistoryEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Schedules != nil {
+		in, out := &in.Schedules, &out.Schedules
+		*out = make([]ActionScheduleStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make(map[string]NodeStatus, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]ClusterCondition, len(*in))
@@ -341,6 +880,127 @@ func (in *NVMeshStatus) DeepCopy() *NVMeshStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeConfigOverride) DeepCopyInto(out *NodeConfigOverride) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TCPOnly != nil {
+		in, out := &in.TCPOnly, &out.TCPOnly
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ExcludeDrives != nil {
+		in, out := &in.ExcludeDrives, &out.ExcludeDrives
+		*out = new(ExcludeNVMeDrivesSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExtraConfig != nil {
+		in, out := &in.ExtraConfig, &out.ExtraConfig
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeConfigOverride.
+func (in *NodeConfigOverride) DeepCopy() *NodeConfigOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeConfigOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeDiscovery) DeepCopyInto(out *NodeDiscovery) {
+	*out = *in
+	if in.NICs != nil {
+		in, out := &in.NICs, &out.NICs
+		*out = make([]DiscoveredNIC, len(*in))
+		copy(*out, *in)
+	}
+	if in.Drives != nil {
+		in, out := &in.Drives, &out.Drives
+		*out = make([]DiscoveredDrive, len(*in))
+		copy(*out, *in)
+	}
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeDiscovery.
+func (in *NodeDiscovery) DeepCopy() *NodeDiscovery {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeDiscovery)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePreflightResult) DeepCopyInto(out *NodePreflightResult) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	if in.Checks != nil {
+		in, out := &in.Checks, &out.Checks
+		*out = make([]PreflightCheck, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePreflightResult.
+func (in *NodePreflightResult) DeepCopy() *NodePreflightResult {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePreflightResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeStatus) DeepCopyInto(out *NodeStatus) {
+	*out = *in
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStatus.
+func (in *NodeStatus) DeepCopy() *NodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeTaskResult) DeepCopyInto(out *NodeTaskResult) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeTaskResult.
+func (in *NodeTaskResult) DeepCopy() *NodeTaskResult {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeTaskResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OperatorFileServerSpec) DeepCopyInto(out *OperatorFileServerSpec) {
 	*out = *in
@@ -355,3 +1015,85 @@ func (in *OperatorFileServerSpec) DeepCopy() *OperatorFileServerSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OperatorSecretsSpec) DeepCopyInto(out *OperatorSecretsSpec) {
+	*out = *in
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]corev1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OperatorSecretsSpec.
+func (in *OperatorSecretsSpec) DeepCopy() *OperatorSecretsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OperatorSecretsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreflightCheck) DeepCopyInto(out *PreflightCheck) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreflightCheck.
+func (in *PreflightCheck) DeepCopy() *PreflightCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(PreflightCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreflightSpec) DeepCopyInto(out *PreflightSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreflightSpec.
+func (in *PreflightSpec) DeepCopy() *PreflightSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PreflightSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProbeTimings) DeepCopyInto(out *ProbeTimings) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProbeTimings.
+func (in *ProbeTimings) DeepCopy() *ProbeTimings {
+	if in == nil {
+		return nil
+	}
+	out := new(ProbeTimings)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProbesSpec) DeepCopyInto(out *ProbesSpec) {
+	*out = *in
+	out.Readiness = in.Readiness
+	out.Liveness = in.Liveness
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProbesSpec.
+func (in *ProbesSpec) DeepCopy() *ProbesSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ProbesSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -58,7 +58,7 @@ func SetStatusCondition(conditions *[]nvmeshv1.ClusterCondition, newCondition *n
 // RemoveStatusCondition removes the corresponding conditionType from conditions.
 // conditions must be non-nil.
 func RemoveStatusCondition(conditions *[]nvmeshv1.ClusterCondition, conditionType nvmeshv1.ClusterConditionType) {
-	if conditions == nil {
+	if conditions == nil || len(*conditions) == 0 {
 		return
 	}
 	newConditions := make([]nvmeshv1.ClusterCondition, 0, len(*conditions)-1)
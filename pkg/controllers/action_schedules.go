@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	cron "github.com/robfig/cron/v3"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	nvmeshScheduleLabelKey = "nvmesh.excelero.com/schedule"
+
+	// defaultScheduledActionTTL - the NVMeshActions of scheduled runs are deleted one day after they finished
+	defaultScheduledActionTTL = int32(24 * 60 * 60)
+
+	// maxMissedSchedules - like a CronJob, a warning is emitted when more runs than this were missed
+	maxMissedSchedules = 100
+)
+
+// parseSchedule - parses a standard cron expression, evaluated in UTC unless it starts with CRON_TZ=<time zone>
+func parseSchedule(schedule string) (cron.Schedule, error) {
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	// the parser uses the time zone of the operator by default
+	trimmed := strings.TrimSpace(schedule)
+	if spec, ok := sched.(*cron.SpecSchedule); ok && !strings.HasPrefix(trimmed, "CRON_TZ=") && !strings.HasPrefix(trimmed, "TZ=") {
+		spec.Location = time.UTC
+	}
+
+	return sched, nil
+}
+
+func validateSchedules(cr *nvmeshv1.NVMesh) error {
+	names := make(map[string]bool)
+	for i, schedule := range cr.Spec.Schedules {
+		field := fmt.Sprintf("spec.schedules[%d]", i)
+		if names[schedule.Name] {
+			return validationError(cr, fmt.Sprintf("Invalid schedule in %s.", field), fmt.Sprintf("The name %s is used by more than one schedule.", schedule.Name))
+		}
+
+		names[schedule.Name] = true
+		if _, err := parseSchedule(schedule.Schedule); err != nil {
+			return validationError(cr, fmt.Sprintf("Invalid schedule in %s.schedule.", field), err.Error())
+		}
+
+		// a replaced run is deleted in the middle of its stages, which only an idempotent action can recover from
+		if schedule.ConcurrencyPolicy == nvmeshv1.ReplaceConcurrent && !isIdempotentAction(schedule.Action.Name) {
+			return validationError(cr, fmt.Sprintf("Invalid concurrencyPolicy in %s.concurrencyPolicy.", field), fmt.Sprintf("Action %s cannot be stopped in the middle of its stages, use Forbid or Allow instead of Replace.", schedule.Action.Name))
+		}
+	}
+
+	return nil
+}
+
+// +kubebuilder:rbac:groups=nvmesh.excelero.com,resources=nvmeshactions,verbs=create
+
+// reconcileSchedules - creates an NVMeshAction for each schedule that is due and keeps the last and next run times in status.schedules
+func (r *NVMeshReconciler) reconcileSchedules(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	now := time.Now()
+	collector := newErrorCollector()
+	statuses := make([]nvmeshv1.ActionScheduleStatus, 0, len(cr.Spec.Schedules))
+	for _, schedule := range cr.Spec.Schedules {
+		status := nvmeshv1.ActionScheduleStatus{Name: schedule.Name}
+		for _, s := range cr.Status.Schedules {
+			if s.Name == schedule.Name {
+				status = *s.DeepCopy()
+			}
+		}
+
+		collector.add(r.reconcileSchedule(cr, schedule, &status, now))
+		statuses = append(statuses, status)
+	}
+
+	cr.Status.Schedules = statuses
+	if len(statuses) == 0 {
+		cr.Status.Schedules = nil
+	}
+
+	return collector.Result()
+}
+
+func (r *NVMeshReconciler) reconcileSchedule(cr *nvmeshv1.NVMesh, schedule nvmeshv1.ActionSchedule, status *nvmeshv1.ActionScheduleStatus, now time.Time) (ctrl.Result, error) {
+	sched, err := parseSchedule(schedule.Schedule)
+	if err != nil {
+		return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Invalid schedule %s", schedule.Name))
+	}
+
+	active, err := r.getActiveScheduledActions(cr, schedule.Name)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	status.Active = make([]string, 0, len(active))
+	for _, action := range active {
+		status.Active = append(status.Active, action.GetName())
+	}
+
+	if schedule.Suspend {
+		status.NextScheduleTime = nil
+		return DoNotRequeue(), nil
+	}
+
+	// runs are counted from the last run, a new schedule starts counting from the first time it was seen
+	var earliest time.Time
+	if status.LastScheduleTime != nil {
+		earliest = status.LastScheduleTime.Time
+	} else if status.NextScheduleTime != nil {
+		earliest = status.NextScheduleTime.Add(-time.Second)
+	} else {
+		return r.setNextScheduleTime(sched, status, now), nil
+	}
+
+	if schedule.StartingDeadlineSeconds != nil {
+		deadline := now.Add(-time.Duration(*schedule.StartingDeadlineSeconds) * time.Second)
+		if deadline.After(earliest) {
+			earliest = deadline
+		}
+	}
+
+	scheduledTime, missed := getMostRecentScheduleTime(sched, earliest, now)
+	if scheduledTime.IsZero() {
+		return r.setNextScheduleTime(sched, status, now), nil
+	}
+
+	if missed > maxMissedSchedules {
+		r.EventManager.Warning(cr, "TooManyMissedTimes", fmt.Sprintf("Schedule %s missed %d runs, only the last run is started. Set startingDeadlineSeconds to skip missed runs", schedule.Name, missed))
+	}
+
+	if len(active) > 0 {
+		switch schedule.ConcurrencyPolicy {
+		case nvmeshv1.ForbidConcurrent:
+			// the run stays due and starts if the previous run finishes before the starting deadline
+			r.Log.Info(fmt.Sprintf("Schedule %s is skipping a run because %s is still running", schedule.Name, status.Active[0]))
+			return r.setNextScheduleTime(sched, status, now), nil
+		case nvmeshv1.ReplaceConcurrent:
+			if !isIdempotentAction(schedule.Action.Name) {
+				return DoNotRequeue(), errors.New(fmt.Sprintf("Schedule %s cannot replace %s, action %s is not idempotent", schedule.Name, status.Active[0], schedule.Action.Name))
+			}
+
+			for i := range active {
+				if err := r.Client.Delete(context.TODO(), &active[i]); err != nil && !k8serrors.IsNotFound(err) {
+					return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to delete NVMeshAction %s", active[i].GetName()))
+				}
+			}
+
+			status.Active = nil
+		}
+	}
+
+	action, err := r.createScheduledAction(cr, schedule, scheduledTime)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	r.EventManager.Normal(cr, "ActionScheduled", fmt.Sprintf("Schedule %s created NVMeshAction %s", schedule.Name, action.GetName()))
+	lastScheduleTime := metav1.NewTime(scheduledTime)
+	status.LastScheduleTime = &lastScheduleTime
+	status.LastAction = action.GetName()
+	status.Active = append(status.Active, action.GetName())
+	return r.setNextScheduleTime(sched, status, now), nil
+}
+
+// getMostRecentScheduleTime - returns the last time the schedule was due after earliest and until now, and the number of runs that were due
+func getMostRecentScheduleTime(sched cron.Schedule, earliest time.Time, now time.Time) (time.Time, int) {
+	var mostRecent time.Time
+	missed := 0
+	for t := sched.Next(earliest); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+		mostRecent = t
+		missed++
+	}
+
+	return mostRecent, missed
+}
+
+// setNextScheduleTime - keeps the time of the next run and requeues for it
+func (r *NVMeshReconciler) setNextScheduleTime(sched cron.Schedule, status *nvmeshv1.ActionScheduleStatus, now time.Time) ctrl.Result {
+	next := sched.Next(now)
+	if next.IsZero() {
+		status.NextScheduleTime = nil
+		return DoNotRequeue()
+	}
+
+	nextScheduleTime := metav1.NewTime(next)
+	status.NextScheduleTime = &nextScheduleTime
+	return Requeue(next.Sub(now))
+}
+
+// getScheduledActionName - the name is derived from the scheduled time so a run is not created twice
+func getScheduledActionName(cr *nvmeshv1.NVMesh, scheduleName string, scheduledTime time.Time) string {
+	return fmt.Sprintf("%s-%s-%d", cr.GetName(), scheduleName, scheduledTime.Unix()/60)
+}
+
+func (r *NVMeshReconciler) createScheduledAction(cr *nvmeshv1.NVMesh, schedule nvmeshv1.ActionSchedule, scheduledTime time.Time) (*nvmeshv1.NVMeshAction, error) {
+	ttl := defaultScheduledActionTTL
+	if schedule.TTLSecondsAfterFinished != nil {
+		ttl = *schedule.TTLSecondsAfterFinished
+	}
+
+	action := &nvmeshv1.NVMeshAction{}
+	action.SetName(getScheduledActionName(cr, schedule.Name, scheduledTime))
+	action.SetNamespace(cr.GetNamespace())
+	action.SetLabels(map[string]string{
+		nvmeshClusterNameLabelKey: cr.GetName(),
+		nvmeshScheduleLabelKey:    schedule.Name,
+	})
+	action.Spec = nvmeshv1.NVMeshActionSpec{
+		Cluster:                 cr.GetName(),
+		Name:                    schedule.Action.Name,
+		Args:                    schedule.Action.Args,
+		TimeoutSeconds:          schedule.Action.TimeoutSeconds,
+		TTLSecondsAfterFinished: &ttl,
+	}
+
+	if err := controllerutil.SetControllerReference(cr, action, r.Scheme); err != nil {
+		return nil, errors.Wrap(err, "Failed to set the owner of the NVMeshAction")
+	}
+
+	err := r.Client.Create(context.TODO(), action)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to create NVMeshAction for schedule %s", schedule.Name))
+	}
+
+	return action, nil
+}
+
+// getActiveScheduledActions - returns the NVMeshActions created by the schedule that did not finish
+func (r *NVMeshReconciler) getActiveScheduledActions(cr *nvmeshv1.NVMesh, scheduleName string) ([]nvmeshv1.NVMeshAction, error) {
+	actionList := &nvmeshv1.NVMeshActionList{}
+	err := r.Client.List(context.TODO(), actionList, client.InNamespace(cr.GetNamespace()), client.MatchingLabels{nvmeshClusterNameLabelKey: cr.GetName(), nvmeshScheduleLabelKey: scheduleName})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list NVMeshActions")
+	}
+
+	active := make([]nvmeshv1.NVMeshAction, 0)
+	for _, action := range actionList.Items {
+		if !isNVMeshActionFinished(&action) {
+			active = append(active, action)
+		}
+	}
+
+	return active, nil
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Action schedules", func() {
+	var (
+		ctx      = context.TODO()
+		cr       *nvmeshv1.NVMesh
+		r        *NVMeshReconciler
+		recorder *record.FakeRecorder
+		schedule nvmeshv1.ActionSchedule
+		status   *nvmeshv1.ActionScheduleStatus
+	)
+
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2021, time.March, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	reconcileAt := func(now time.Time) ctrl.Result {
+		result, err := r.reconcileSchedule(cr, schedule, status, now)
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	listActions := func() []nvmeshv1.NVMeshAction {
+		actionList := &nvmeshv1.NVMeshActionList{}
+		Expect(r.Client.List(ctx, actionList, client.MatchingLabels{nvmeshScheduleLabelKey: schedule.Name})).To(Succeed())
+		return actionList.Items
+	}
+
+	finish := func(action nvmeshv1.NVMeshAction) {
+		action.Status.Phase = nvmeshv1.ActionSucceeded
+		Expect(r.Client.Status().Update(ctx, &action)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		cr = newTestCluster()
+
+		schedule = nvmeshv1.ActionSchedule{
+			Name:              "hourly",
+			Schedule:          "0 * * * *",
+			Action:            nvmeshv1.ClusterAction{Name: preflightAction},
+			ConcurrencyPolicy: nvmeshv1.ForbidConcurrent,
+		}
+		cr.Spec.Schedules = []nvmeshv1.ActionSchedule{schedule}
+		status = &nvmeshv1.ActionScheduleStatus{Name: schedule.Name}
+
+		r = newFakeReconciler(cr)
+		recorder = r.EventManager.recorder.(*record.FakeRecorder)
+	})
+
+	It("waits for the first scheduled time of a new schedule", func() {
+		result := reconcileAt(at(3, 10, 17))
+		Expect(result.RequeueAfter).To(Equal(43 * time.Minute))
+		Expect(status.NextScheduleTime.Time).To(Equal(at(3, 11, 0)))
+		Expect(listActions()).To(BeEmpty())
+	})
+
+	It("creates an NVMeshAction once when the schedule is due", func() {
+		reconcileAt(at(3, 10, 17))
+
+		reconcileAt(at(3, 11, 0).Add(5 * time.Second))
+		actions := listActions()
+		Expect(actions).To(HaveLen(1))
+		Expect(actions[0].Spec.Cluster).To(Equal("cluster1"))
+		Expect(actions[0].Spec.Name).To(Equal(nvmeshv1.ActionName(preflightAction)))
+		Expect(*actions[0].Spec.TTLSecondsAfterFinished).To(Equal(defaultScheduledActionTTL))
+		Expect(status.LastScheduleTime.Time).To(Equal(at(3, 11, 0)))
+		Expect(status.LastAction).To(Equal(actions[0].GetName()))
+		Expect(status.Active).To(Equal([]string{actions[0].GetName()}))
+		Expect(status.NextScheduleTime.Time).To(Equal(at(3, 12, 0)))
+		Expect(recorder.Events).To(Receive(ContainSubstring("ActionScheduled")))
+
+		By("not creating the same run twice")
+		reconcileAt(at(3, 11, 30))
+		Expect(listActions()).To(HaveLen(1))
+	})
+
+	It("holds a run while the previous run is active with the Forbid policy", func() {
+		reconcileAt(at(3, 10, 17))
+		reconcileAt(at(3, 11, 0))
+
+		reconcileAt(at(3, 12, 0).Add(10 * time.Second))
+		actions := listActions()
+		Expect(actions).To(HaveLen(1))
+		Expect(status.LastScheduleTime.Time).To(Equal(at(3, 11, 0)))
+
+		By("starting the held run once the previous run finished")
+		finish(actions[0])
+		reconcileAt(at(3, 12, 30))
+		Expect(listActions()).To(HaveLen(2))
+		Expect(status.LastScheduleTime.Time).To(Equal(at(3, 12, 0)))
+		Expect(status.Active).To(Equal([]string{getScheduledActionName(cr, schedule.Name, at(3, 12, 0))}))
+	})
+
+	It("replaces the active run of an idempotent action with the Replace policy", func() {
+		schedule.ConcurrencyPolicy = nvmeshv1.ReplaceConcurrent
+		reconcileAt(at(3, 10, 17))
+		reconcileAt(at(3, 11, 0))
+
+		reconcileAt(at(3, 12, 0))
+		actions := listActions()
+		Expect(actions).To(HaveLen(1))
+		Expect(actions[0].GetName()).To(Equal(getScheduledActionName(cr, schedule.Name, at(3, 12, 0))))
+		Expect(status.Active).To(Equal([]string{actions[0].GetName()}))
+	})
+
+	It("does not replace a run of an action that is not idempotent", func() {
+		schedule.Action.Name = nodeMaintenanceAction
+		schedule.ConcurrencyPolicy = nvmeshv1.ReplaceConcurrent
+		cr.Spec.Schedules = []nvmeshv1.ActionSchedule{schedule}
+		Expect(validateSchedules(cr)).To(MatchError(ContainSubstring("spec.schedules[0].concurrencyPolicy")))
+
+		reconcileAt(at(3, 10, 17))
+		reconcileAt(at(3, 11, 0))
+		_, err := r.reconcileSchedule(cr, schedule, status, at(3, 12, 0))
+		Expect(err).To(MatchError(ContainSubstring("is not idempotent")))
+		Expect(listActions()).To(HaveLen(1))
+	})
+
+	It("starts only the last missed run within the starting deadline", func() {
+		reconcileAt(at(3, 10, 17))
+		reconcileAt(at(3, 11, 0))
+		finish(listActions()[0])
+
+		By("skipping runs that were missed by more than the starting deadline")
+		deadline := int64(600)
+		schedule.StartingDeadlineSeconds = &deadline
+		result := reconcileAt(at(4, 15, 20))
+		Expect(listActions()).To(HaveLen(1))
+		Expect(status.NextScheduleTime.Time).To(Equal(at(4, 16, 0)))
+		Expect(result.RequeueAfter).To(Equal(40 * time.Minute))
+
+		deadline = 3600
+		reconcileAt(at(4, 15, 20))
+		Expect(listActions()).To(HaveLen(2))
+		Expect(status.LastScheduleTime.Time).To(Equal(at(4, 15, 0)))
+		Expect(status.Active).To(Equal([]string{getScheduledActionName(cr, schedule.Name, at(4, 15, 0))}))
+	})
+
+	It("evaluates a schedule in UTC and accepts @every", func() {
+		sched, err := parseSchedule("0 2 * * *")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sched.Next(at(3, 10, 0))).To(Equal(at(4, 2, 0)))
+
+		sched, err = parseSchedule("@every 90m")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sched.Next(at(3, 10, 0))).To(Equal(at(3, 11, 30)))
+	})
+
+	It("does not create runs of a suspended schedule", func() {
+		reconcileAt(at(3, 10, 17))
+
+		schedule.Suspend = true
+		result := reconcileAt(at(3, 11, 0))
+		Expect(result.Requeue).To(BeFalse())
+		Expect(status.NextScheduleTime).To(BeNil())
+		Expect(listActions()).To(BeEmpty())
+	})
+
+	It("rejects an invalid cron expression", func() {
+		cr.Spec.Schedules[0].Schedule = "0 25 * * *"
+		Expect(validateSchedules(cr)).To(MatchError(ContainSubstring("spec.schedules[0].schedule")))
+	})
+})
@@ -2,7 +2,6 @@ package controllers
 
 import (
 	"context"
-	"time"
 
 	errors "github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
@@ -15,6 +14,8 @@ import (
 )
 
 const (
+	collectLogsAction = "collect-logs"
+
 	collectLogsImageName     = "nvmesh-logs-collector"
 	collectDbJobName         = "collect-db"
 	collectConfigMapsJobName = "collect-config-maps"
@@ -30,41 +31,70 @@ const (
 	nvmeshConfigMapName = "nvmesh-core-config"
 	csiConfigMapName    = "nvmesh-csi-config"
 
+	// default secret name, can be changed in spec.operator.secrets
 	s3bucketSecretName = "s3-bucket-secrets"
 	logsSavePath       = "/opt/nvmesh-operator/logs"
 )
 
-func (r *NVMeshReconciler) handleCollectLogs(cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) (bool, ctrl.Result, error) {
-	// run db dump job
-	if !r.isTaskFinished(cr, a, collectDBStage) {
-		r.setTaskStarted(cr, a, collectDBStage)
-		err := r.runCollectDBJob(cr, a)
-		if err != nil {
-			return false, DoNotRequeue(), err
-		}
-		r.setTaskFinished(cr, a, collectDBStage)
-	}
+// collectLogs - collects the Management database, the ConfigMaps and the logs of all nodes
+type collectLogs struct {
+	actionOptions
+}
 
-	// run collect config-maps job
-	if !r.isTaskFinished(cr, a, collectConfigMapsStage) {
-		r.setTaskStarted(cr, a, collectConfigMapsStage)
-		err := r.runCollectConfigMapsJob(cr, a)
-		if err != nil {
-			return false, DoNotRequeue(), err
-		}
-		r.setTaskFinished(cr, a, collectConfigMapsStage)
+func (collectLogs) Stages(r *NVMeshReconciler, a nvmeshv1.ClusterAction) []Task {
+	return []Task{
+		// run db dump job
+		{collectDBStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return DoNotRequeue(), r.runCollectDBJob(cr, a)
+		}},
+		// run collect config-maps job
+		{collectConfigMapsStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return DoNotRequeue(), r.runCollectConfigMapsJob(cr, a)
+		}},
+		// create logs collector jobs
+		{collectLogsStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			nodeList, err := r.getCollectLogsNodes(cr)
+			if err != nil {
+				return DoNotRequeue(), err
+			}
+
+			for _, node := range nodeList {
+				if err := r.createCollectLogsJob(cr, a, node.GetName()); err != nil {
+					return DoNotRequeue(), err
+				}
+			}
+
+			return DoNotRequeue(), nil
+		}},
+		{waitForJobsToFinish, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return r.waitForCollectLogsJobs(cr)
+		}},
+		{deleteJobsStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			nodeList, err := r.getCollectLogsNodes(cr)
+			if err != nil {
+				return DoNotRequeue(), err
+			}
+
+			err = r.deleteCollectLogJobs(cr, nodeList)
+			if err != nil && !k8serrors.IsNotFound(err) {
+				return DoNotRequeue(), err
+			}
+
+			return DoNotRequeue(), nil
+		}},
 	}
+}
 
-	// get all Cluster Nodes (including mgmt labelled nodes) for logs collection
+// getCollectLogsNodes - returns all Cluster Nodes, including mgmt labelled nodes on which management pods might have been running
+func (r *NVMeshReconciler) getCollectLogsNodes(cr *nvmeshv1.NVMesh) ([]corev1.Node, error) {
 	nodeSet, err := r.getAllNVMeshClusterNodes(cr)
 	if err != nil {
-		return false, DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to list all of the nodes in NVMesh Cluster %s", cr.GetName()))
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to list all of the nodes in NVMesh Cluster %s", cr.GetName()))
 	}
 
-	// get all management labelled nodes, on these nodes it is possible that management pods were running.
 	mgmtLabeledNodes, err := r.getAllMgmtLabelledNodes(cr)
 	if err != nil {
-		return false, DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to list nodes with label %s", nvmeshMgmtLabelKey))
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to list nodes with label %s", nvmeshMgmtLabelKey))
 	}
 
 	for _, n := range mgmtLabeledNodes.Items {
@@ -74,72 +104,51 @@ func (r *NVMeshReconciler) handleCollectLogs(cr *nvmeshv1.NVMesh, a nvmeshv1.Clu
 		}
 	}
 
-	nodeList := r.nodeSetToList(nodeSet)
-
-	// create logs collector jobs
-	if !r.isTaskFinished(cr, a, collectLogsStage) {
-		r.setTaskStarted(cr, a, collectLogsStage)
-
-		for _, node := range nodeList {
-			err := r.createCollectLogsJob(cr, a, node.GetName())
-			if err != nil {
-				return false, DoNotRequeue(), err
-			}
-		}
+	return r.nodeSetToList(nodeSet), nil
+}
 
-		r.setTaskFinished(cr, a, collectLogsStage)
+func (r *NVMeshReconciler) waitForCollectLogsJobs(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	// wait for db dump job to finish
+	res, err := r.waitForJobToFinish(cr, collectDbJobName)
+	if err != nil || res.Requeue {
+		return res, err
 	}
 
-	if !r.isTaskFinished(cr, a, waitForJobsToFinish) {
-		r.setTaskStarted(cr, a, waitForJobsToFinish)
-
-		// wait for db dump job to finish
-		res, err := r.waitForJobToFinish(cr, collectDbJobName)
-		if err != nil {
-			return false, res, err
-		}
+	// wait for collect config-maps job to finish
+	res, err = r.waitForJobToFinish(cr, collectConfigMapsJobName)
+	if err != nil || res.Requeue {
+		return res, err
+	}
 
-		if res.Requeue {
-			res.RequeueAfter = time.Second * 3
-			return false, res, err
-		}
+	nodeList, err := r.getCollectLogsNodes(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
 
-		// wait for collect config-maps job to finish
-		res, err = r.waitForJobToFinish(cr, collectConfigMapsJobName)
+	// wait for logs collector jobs to finish
+	for _, node := range nodeList {
+		jobName := collectLogsJobName + "-" + sanitizeString(node.GetName())
+		res, err = r.waitForJobToFinish(cr, jobName)
 		if err != nil {
-			return false, res, err
-		}
-
-		if res.Requeue {
-			res.RequeueAfter = time.Second * 3
-			return false, res, err
+			r.recordCollectLogsResult(cr, node.GetName(), err)
 		}
 
-		// wait for logs collector jobs to finish
-		for _, node := range nodeList {
-			jobName := collectLogsJobName + "-" + sanitizeString(node.GetName())
-			res, err = r.waitForJobToFinish(cr, jobName)
-			if res.Requeue || err != nil {
-				res.RequeueAfter = time.Second * 3
-				return false, res, err
-			}
+		if res.Requeue || err != nil {
+			return res, err
 		}
+	}
 
-		r.setTaskFinished(cr, a, waitForJobsToFinish)
+	for _, node := range nodeList {
+		r.recordCollectLogsResult(cr, node.GetName(), nil)
 	}
 
-	if !r.isTaskFinished(cr, a, deleteJobsStage) {
-		r.setTaskStarted(cr, a, deleteJobsStage)
-		err := r.deleteCollectLogJobs(cr, nodeList)
-		if err != nil && !k8serrors.IsNotFound(err) {
-			return false, Requeue(time.Second), err
-		}
+	return DoNotRequeue(), nil
+}
 
-		r.setTaskFinished(cr, a, deleteJobsStage)
+func (r *NVMeshReconciler) recordCollectLogsResult(cr *nvmeshv1.NVMesh, nodeName string, jobErr error) {
+	if _, ok := cr.Status.Nodes[nodeName]; ok {
+		r.recordNodeTaskResult(cr, nodeName, setLastCollectLogs, jobErr)
 	}
-
-	r.setActionComplete(cr, a)
-	return true, DoNotRequeue(), nil
 }
 
 func (r *NVMeshReconciler) deleteCollectLogJobs(cr *nvmeshv1.NVMesh, nodeList []corev1.Node) error {
@@ -182,7 +191,7 @@ func (r *NVMeshReconciler) addClusterNameEnvVar(container *corev1.Container, cr
 	container.Env = append(container.Env, clusterNameVar)
 }
 
-func (r *NVMeshReconciler) addS3CredentialsEnvVar(container *corev1.Container, bucketName string) {
+func (r *NVMeshReconciler) addS3CredentialsEnvVar(cr *nvmeshv1.NVMesh, container *corev1.Container, bucketName string) {
 	if container.Env == nil {
 		container.Env = []corev1.EnvVar{}
 	}
@@ -193,7 +202,7 @@ func (r *NVMeshReconciler) addS3CredentialsEnvVar(container *corev1.Container, b
 			ValueFrom: &corev1.EnvVarSource{
 				SecretKeyRef: &corev1.SecretKeySelector{
 					LocalObjectReference: corev1.LocalObjectReference{
-						Name: s3bucketSecretName,
+						Name: getS3SecretName(cr),
 					},
 					Key: "AWS_ACCESS_KEY_ID",
 				},
@@ -204,7 +213,7 @@ func (r *NVMeshReconciler) addS3CredentialsEnvVar(container *corev1.Container, b
 			ValueFrom: &corev1.EnvVarSource{
 				SecretKeyRef: &corev1.SecretKeySelector{
 					LocalObjectReference: corev1.LocalObjectReference{
-						Name: s3bucketSecretName,
+						Name: getS3SecretName(cr),
 					},
 					Key: "AWS_SECRET_ACCESS_KEY",
 				},
@@ -229,6 +238,10 @@ func setContainerAsPrivileged(container *corev1.Container) {
 }
 
 func (r *NVMeshReconciler) runCollectDBJob(cr *nvmeshv1.NVMesh, action nvmeshv1.ClusterAction) error {
+	if _, err := r.reconcileMongoConnection(cr); err != nil {
+		return err
+	}
+
 	job := r.getNewJob(cr, collectDbJobName, r.getCollectJogImageName(cr))
 	grace := int64(1)
 	podSpec := &job.Spec.Template.Spec
@@ -237,12 +250,8 @@ func (r *NVMeshReconciler) runCollectDBJob(cr *nvmeshv1.NVMesh, action nvmeshv1.
 	container := &podSpec.Containers[0]
 	container.Command = []string{"sudo"}
 	container.Args = []string{"-E", "/init.sh", "--db-dump"}
-	container.Env = []corev1.EnvVar{
-		{
-			Name:  "MONGO_URI",
-			Value: "mongodb://" + getMongoConnectionString(cr) + "/management",
-		},
-	}
+	addMongoURIEnvVar(container)
+	addMongoClientVolumes(cr, podSpec, container)
 
 	podSpec.ServiceAccountName = r.getClusterServiceAccountName(cr)
 	setContainerAsPrivileged(container)
@@ -251,7 +260,7 @@ func (r *NVMeshReconciler) runCollectDBJob(cr *nvmeshv1.NVMesh, action nvmeshv1.
 
 	if bucketName, ok := getActionArg(action, "upload-to-s3"); ok {
 		container.Args = append(container.Args, "--upload-to-s3")
-		r.addS3CredentialsEnvVar(container, bucketName)
+		r.addS3CredentialsEnvVar(cr, container, bucketName)
 	}
 
 	if cr.Spec.Debug.CollectLogsJobsRunForever {
@@ -260,6 +269,10 @@ func (r *NVMeshReconciler) runCollectDBJob(cr *nvmeshv1.NVMesh, action nvmeshv1.
 
 	r.addHostPathMount(podSpec, 0, logsSavePath)
 
+	if tlsSpec := cr.Spec.Management.MongoDB.TLS; tlsSpec != nil && tlsSpec.ClientCertSecretName != "" {
+		wrapWithPEMFile(container, mongoClientCertMountPath, mongoClientPEMPath)
+	}
+
 	err := r.Client.Create(context.TODO(), job)
 	if err != nil && !k8serrors.IsAlreadyExists(err) {
 		return errors.Wrap(err, fmt.Sprintf("Failed to create %s", job.GetName()))
@@ -285,7 +298,7 @@ func (r *NVMeshReconciler) runCollectConfigMapsJob(cr *nvmeshv1.NVMesh, action n
 
 	if bucketName, ok := getActionArg(action, "upload-to-s3"); ok {
 		container.Args = append(container.Args, "--upload-to-s3")
-		r.addS3CredentialsEnvVar(container, bucketName)
+		r.addS3CredentialsEnvVar(cr, container, bucketName)
 	}
 
 	if cr.Spec.Debug.CollectLogsJobsRunForever {
@@ -329,7 +342,7 @@ func (r *NVMeshReconciler) createCollectLogsJob(cr *nvmeshv1.NVMesh, action nvme
 
 	if bucketName, ok := getActionArg(action, "upload-to-s3"); ok {
 		container.Args = append(container.Args, "--upload-to-s3")
-		r.addS3CredentialsEnvVar(container, bucketName)
+		r.addS3CredentialsEnvVar(cr, container, bucketName)
 	}
 
 	if cr.Spec.Debug.CollectLogsJobsRunForever {
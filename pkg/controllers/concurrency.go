@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"sync"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// maxConcurrentComponents - the maximum number of components (Management, Core, CSI) that are reconciled at the same time for a single cluster
+	maxConcurrentComponents = 3
+)
+
+// errorCollector - collects errors and requeue requests from goroutines that run concurrently
+type errorCollector struct {
+	mutex  sync.Mutex
+	errors []error
+	result ctrl.Result
+}
+
+func newErrorCollector() *errorCollector {
+	return &errorCollector{
+		errors: make([]error, 0),
+		result: DoNotRequeue(),
+	}
+}
+
+// add - keeps the error (if any) and merges the result so that the shortest requeue requested is kept
+func (c *errorCollector) add(result ctrl.Result, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err != nil {
+		c.errors = append(c.errors, err)
+	}
+
+	if result.Requeue {
+		if !c.result.Requeue || result.RequeueAfter < c.result.RequeueAfter {
+			c.result.RequeueAfter = result.RequeueAfter
+		}
+
+		c.result.Requeue = true
+	}
+}
+
+// Errors - returns all collected errors
+func (c *errorCollector) Errors() []error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.errors
+}
+
+// Result - returns the merged result and the first error collected
+func (c *errorCollector) Result() (ctrl.Result, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.errors) > 0 {
+		return c.result, c.errors[0]
+	}
+
+	return c.result, nil
+}
+
+// runConcurrently - runs all funcs with at most limit funcs running at the same time and waits for all of them to finish
+func runConcurrently(limit int, funcs []func()) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, limit)
+
+	for _, f := range funcs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(run func()) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			run()
+		}(f)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	goerrors "errors"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Concurrent reconcile", func() {
+	It("keeps the shortest requeue and the first error", func() {
+		collector := newErrorCollector()
+		collector.add(DoNotRequeue(), nil)
+		collector.add(Requeue(time.Second*5), goerrors.New("first"))
+		collector.add(Requeue(time.Second), goerrors.New("second"))
+
+		result, err := collector.Result()
+		Expect(result.Requeue).To(BeTrue())
+		Expect(result.RequeueAfter).To(Equal(time.Second))
+		Expect(err).To(MatchError("first"))
+		Expect(len(collector.Errors())).To(Equal(2))
+	})
+
+	It("runs at most the given number of funcs at a time", func() {
+		var running int32
+		var maxRunning int32
+		var finished int32
+
+		funcs := make([]func(), 0)
+		for i := 0; i < 10; i++ {
+			funcs = append(funcs, func() {
+				current := atomic.AddInt32(&running, 1)
+				for {
+					max := atomic.LoadInt32(&maxRunning)
+					if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+						break
+					}
+				}
+
+				time.Sleep(time.Millisecond * 10)
+				atomic.AddInt32(&running, -1)
+				atomic.AddInt32(&finished, 1)
+			})
+		}
+
+		runConcurrently(2, funcs)
+
+		Expect(finished).To(Equal(int32(10)))
+		Expect(maxRunning <= 2).To(BeTrue())
+	})
+})
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"testing"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+func TestControllers(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Controllers Suite")
+}
+
+// newFakeReconciler - returns a reconciler backed by a fake client holding the given objects
+func newFakeReconciler(objects ...client.Object) *NVMeshReconciler {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = nvmeshv1.AddToScheme(s)
+
+	return &NVMeshReconciler{
+		NVMeshBaseReconciler: NVMeshBaseReconciler{
+			Client:       fake.NewClientBuilder().WithScheme(s).WithObjects(objects...).Build(),
+			Scheme:       s,
+			Log:          logf.Log,
+			EventManager: &EventManager{recorder: record.NewFakeRecorder(100)},
+		},
+	}
+}
+
+func newTestCluster() *nvmeshv1.NVMesh {
+	cr := &nvmeshv1.NVMesh{}
+	cr.SetName("cluster1")
+	cr.SetNamespace(TestingNamespace)
+	return cr
+}
+
+// newTestNode - returns a node with the given labels, the hostname label defaults to the node name
+func newTestNode(name string, labels map[string]string) *corev1.Node {
+	node := &corev1.Node{}
+	node.SetName(name)
+	node.SetLabels(map[string]string{corev1.LabelHostname: name})
+	for k, v := range labels {
+		node.Labels[k] = v
+	}
+
+	return node
+}
+
+// newTestPod - returns a ready pod scheduled on the given node
+func newTestPod(name string, nodeName string, labels map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{}
+	pod.SetName(name)
+	pod.SetNamespace(TestingNamespace)
+	pod.SetLabels(labels)
+	pod.Spec.NodeName = nodeName
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	return pod
+}
+
+func newTestSecret(name string, data map[string]string) *corev1.Secret {
+	secret := &corev1.Secret{Type: corev1.SecretTypeOpaque, Data: make(map[string][]byte)}
+	secret.SetName(name)
+	secret.SetNamespace(TestingNamespace)
+	for k, v := range data {
+		secret.Data[k] = []byte(v)
+	}
+
+	return secret
+}
+
+func newMgmtAdminSecret() *corev1.Secret {
+	return newTestSecret(mgmtAdminSecretName, map[string]string{"username": "root@company.com", "password": "secret"})
+}
+
+func newTestPDB(name string) *policyv1.PodDisruptionBudget {
+	pdb := &policyv1.PodDisruptionBudget{}
+	pdb.SetName(name)
+	return pdb
+}
+
+func newTestTargetDaemonSet(withMaintenanceAffinity bool) *appsv1.DaemonSet {
+	ds := &appsv1.DaemonSet{}
+	ds.SetName(targetDriverDaemonSetName)
+	ds.SetNamespace(TestingNamespace)
+	if withMaintenanceAffinity {
+		ds.Spec.Template.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{Key: nvmeshMaintenanceLabelKey, Operator: corev1.NodeSelectorOpDoesNotExist}},
+			}}},
+		}}
+	}
+
+	return ds
+}
+
+func newTestTargetPod(nodeName string) *corev1.Pod {
+	return newTestPod("nvmesh-target-"+nodeName, nodeName, map[string]string{"app": targetDriverDaemonSetName})
+}
+
+func newTestNVMeshAction(name string, actionName nvmeshv1.ActionName) *nvmeshv1.NVMeshAction {
+	action := &nvmeshv1.NVMeshAction{}
+	action.SetName(name)
+	action.SetNamespace(TestingNamespace)
+	action.Spec = nvmeshv1.NVMeshActionSpec{Cluster: "cluster1", Name: actionName}
+	return action
+}
+
+// completeJobWithReport - marks a job as succeeded and creates its pod with the report in the termination message
+func completeJobWithReport(r *NVMeshReconciler, jobName string, report string) error {
+	ctx := context.TODO()
+	job := &batchv1.Job{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: jobName, Namespace: TestingNamespace}, job); err != nil {
+		return err
+	}
+
+	job.Status.Succeeded = 1
+	if err := r.Client.Status().Update(ctx, job); err != nil {
+		return err
+	}
+
+	pod := &corev1.Pod{}
+	pod.SetName(jobName + "-abcde")
+	pod.SetNamespace(TestingNamespace)
+	pod.SetLabels(map[string]string{"job-name": jobName})
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  jobName,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: report}},
+	}}
+	return r.Client.Create(ctx, pod)
+}
+
+func newTestSegment(nodeID string, health string) managementDiskSegment {
+	return managementDiskSegment{NodeID: nodeID, Type: "data", Health: health}
+}
+
+func newTestVolume(name string, segments ...managementDiskSegment) managementVolume {
+	return managementVolume{Name: name, Chunks: []managementVolumeChunk{{Praids: []managementPraid{{DiskSegments: segments}}}}}
+}
+
+// fakeManagementAPI - records the calls made to Management and returns the configured servers and volumes
+type fakeManagementAPI struct {
+	available bool
+	logins    []string
+	users     []managementUser
+	servers   []managementServer
+	volumes   []managementVolume
+	drives    []string
+}
+
+// useFakeManagementAPI - makes the reconcilers connect to the given fake, the returned func restores the real client
+func useFakeManagementAPI(api *fakeManagementAPI) func() {
+	origNewManagementAPI := newManagementAPI
+	newManagementAPI = func(address string) (managementAPI, error) {
+		return api, nil
+	}
+
+	return func() { newManagementAPI = origNewManagementAPI }
+}
+
+func (f *fakeManagementAPI) Login(username string, password string) error {
+	if !f.available {
+		return goerrors.New("connection refused")
+	}
+
+	f.logins = append(f.logins, username+":"+password)
+	return nil
+}
+
+func (f *fakeManagementAPI) SaveUser(user managementUser) error {
+	f.users = append(f.users, user)
+	return nil
+}
+
+func (f *fakeManagementAPI) GetServers() ([]managementServer, error) {
+	return f.servers, nil
+}
+
+func (f *fakeManagementAPI) GetVolumes() ([]managementVolume, error) {
+	return f.volumes, nil
+}
+
+func (f *fakeManagementAPI) FormatDrive(diskID string) error {
+	f.drives = append(f.drives, "format:"+diskID)
+	return nil
+}
+
+func (f *fakeManagementAPI) EvictDrive(diskID string) error {
+	f.drives = append(f.drives, "evict:"+diskID)
+	f.setDiskStatus(diskID, mgmtDiskStatusEvicted)
+	return nil
+}
+
+func (f *fakeManagementAPI) ReincludeDrive(diskID string) error {
+	f.drives = append(f.drives, "reinclude:"+diskID)
+	f.setDiskStatus(diskID, "Ok")
+	return nil
+}
+
+// setDiskStatus - Management reports the new status of an evicted or reincluded drive
+func (f *fakeManagementAPI) setDiskStatus(diskID string, status string) {
+	for i := range f.servers {
+		for j := range f.servers[i].Disks {
+			if f.servers[i].Disks[j].DiskID == diskID {
+				f.servers[i].Disks[j].Status = status
+			}
+		}
+	}
+}
@@ -2,14 +2,18 @@ package controllers
 
 import (
 	"fmt"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 
 	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	nvmeshconf "excelero.com/nvmesh-k8s-operator/pkg/nvmeshconf"
+	errors "github.com/pkg/errors"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -32,14 +36,50 @@ type NVMeshCoreReconciler struct {
 //Reconcile NVMesh Core Component
 func (r *NVMeshCoreReconciler) Reconcile(cr *nvmeshv1.NVMesh, nvmeshr *NVMeshReconciler) (ctrl.Result, error) {
 	if !cr.Spec.Core.Disabled {
-		return DoNotRequeue(), r.deployCore(cr, nvmeshr)
+		// the preflight checks run in reconcileNodes, Core is first deployed or upgraded to spec.core.version only after all nodes passed them
+		version, deploy, err := r.getCoreVersionToDeploy(cr)
+		if err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if !deploy {
+			r.Log.Info(fmt.Sprintf("Waiting for the preflight checks of NVMesh %s to pass before deploying NVMesh Core", cr.Spec.Core.Version))
+			return Requeue(jobProgressSafetyRequeue), nil
+		}
+
+		upgradeBlocked := version != cr.Spec.Core.Version
+		if upgradeBlocked {
+			// other changes are applied to the deployed version. cr is the copy of this component so the version is not changed in the spec of the cluster
+			r.Log.Info(fmt.Sprintf("Waiting for the preflight checks of NVMesh %s to pass before upgrading NVMesh Core from %s", cr.Spec.Core.Version, version))
+			cr.Spec.Core.Version = version
+		}
+
+		if err := r.deployCore(cr, nvmeshr); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if err := r.reconcileNodeConfigMaps(cr); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		result, err := r.restartOutdatedCorePods(cr)
+		if upgradeBlocked && err == nil && !result.Requeue {
+			// the results of the checks are recorded on the NVMeshNodes, which do not trigger a reconcile of the cluster
+			result = Requeue(jobProgressSafetyRequeue)
+		}
+
+		return result, err
 	}
 
 	return DoNotRequeue(), r.removeCore(cr, nvmeshr)
 }
 
 func (r *NVMeshCoreReconciler) removeCore(cr *nvmeshv1.NVMesh, nvmeshr *NVMeshReconciler) error {
-	return nvmeshr.removeObjectsFromDir(cr, r, nvmeshCoreAssestLocation, true)
+	if err := nvmeshr.removeObjectsFromDir(cr, r, nvmeshCoreAssestLocation, true); err != nil {
+		return err
+	}
+
+	return r.removeNodeConfigMaps(cr)
 }
 
 func (r *NVMeshCoreReconciler) deployCore(cr *nvmeshv1.NVMesh, nvmeshr *NVMeshReconciler) error {
@@ -77,6 +117,8 @@ func (r *NVMeshCoreReconciler) ShouldUpdateObject(cr *nvmeshv1.NVMesh, expected
 	case *v1.ConfigMap:
 		expectedConfigMap := (expected).(*corev1.ConfigMap)
 		return r.shouldUpdateCoreConfigMap(cr, expectedConfigMap, o)
+	case *policyv1.PodDisruptionBudget:
+		return shouldUpdatePDB((expected).(*policyv1.PodDisruptionBudget), o)
 	default:
 	}
 
@@ -91,6 +133,29 @@ func (r *NVMeshCoreReconciler) shouldUpdateDaemonSet(cr *nvmeshv1.NVMesh, expect
 		return true
 	}
 
+	if ds.Spec.UpdateStrategy.Type != expected.Spec.UpdateStrategy.Type {
+		log.Info(fmt.Sprintf("Update strategy changed on DaemonSet %s", ds.ObjectMeta.Name))
+		return true
+	}
+
+	if len(ds.Spec.Template.Spec.InitContainers) != len(expected.Spec.Template.Spec.InitContainers) {
+		log.Info(fmt.Sprintf("Init containers changed on DaemonSet %s", ds.ObjectMeta.Name))
+		return true
+	}
+
+	for i, c := range ds.Spec.Template.Spec.InitContainers {
+		expectedInit := expected.Spec.Template.Spec.InitContainers[i]
+		if c.Image != expectedInit.Image || !reflect.DeepEqual(c.Command, expectedInit.Command) || !reflect.DeepEqual(c.Env, expectedInit.Env) {
+			log.Info(fmt.Sprintf("Init container %s changed on DaemonSet %s", c.Name, ds.ObjectMeta.Name))
+			return true
+		}
+	}
+
+	if driverProbesHashChanged(&expected.Spec.Template, &ds.Spec.Template) {
+		log.Info(fmt.Sprintf("Probes changed on DaemonSet %s", ds.ObjectMeta.Name))
+		return true
+	}
+
 	for i, c := range ds.Spec.Template.Spec.Containers {
 		expectedImage := expected.Spec.Template.Spec.Containers[i].Image
 		if c.Image != expectedImage {
@@ -98,6 +163,12 @@ func (r *NVMeshCoreReconciler) shouldUpdateDaemonSet(cr *nvmeshv1.NVMesh, expect
 			return true
 		}
 
+		expectedMounts := expected.Spec.Template.Spec.Containers[i].VolumeMounts
+		if !reflect.DeepEqual(c.VolumeMounts, expectedMounts) {
+			log.Info(fmt.Sprintf("Volume mounts changed on DaemonSet %s Container %s", ds.ObjectMeta.Name, c.Name))
+			return true
+		}
+
 		for _, env := range c.Env {
 			if env.Name == envVarNVMeshVersion {
 
@@ -112,31 +183,45 @@ func (r *NVMeshCoreReconciler) shouldUpdateDaemonSet(cr *nvmeshv1.NVMesh, expect
 	return false
 }
 
-func (r *NVMeshCoreReconciler) shouldUpdateNVMeshConf(cr *nvmeshv1.NVMesh, expected string, current string) (bool, string) {
+// shouldUpdateNVMeshConf - compares the effective values of the expected nvmesh.conf with the current one, and returns the current content with the expected values applied.
+// comments, order and any user added parameters are kept. parameters in extraConfigKeys were added by the operator from extraConfig, so they are removed when they are no longer expected
+func (r *NVMeshCoreReconciler) shouldUpdateNVMeshConf(cr *nvmeshv1.NVMesh, expected string, current string, extraConfigKeys map[string]bool) (bool, string) {
 	log := r.Log.WithName("shouldUpdateNVMeshConf")
 
-	expectedDict := r.configStringToDict(expected)
-	currentDict := r.configStringToDict(current)
+	expectedConf, err := nvmeshconf.Parse(expected)
+	if err != nil {
+		log.Error(err, "Failed to parse expected nvmesh.conf")
+		return false, current
+	}
 
-	shouldUpdate := false
+	currentConf, err := nvmeshconf.Parse(current)
+	if err != nil {
+		log.Info(fmt.Sprintf("nvmesh-core-config nvmesh.conf could not be parsed and will be replaced. Error: %s\n", err))
+		return true, expected
+	}
 
-	// Only check if expected field has a differnet value and preserve any user added parameters that do not appear in "expected"
-	for field, _ := range expectedDict {
-		if currentDict[field] != expectedDict[field] {
-			log.Info(fmt.Sprintf("nvmesh-core-config nvmesh.conf field %s will be updated from %s to %s\n", field, currentDict[field], expectedDict[field]))
-			shouldUpdate = true
-		}
+	if len(currentConf.Keys()) == 0 {
+		return len(expectedConf.Keys()) > 0, expected
 	}
 
-	// copy all fields that are not in expected
-	for field, _ := range currentDict {
-		if _, ok := expectedDict[field]; !ok {
-			expectedDict[field] = currentDict[field]
+	shouldUpdate := false
+	for _, change := range nvmeshconf.Diff(currentConf, expectedConf) {
+		if change.Type == nvmeshconf.Removed {
+			if !extraConfigKeys[change.Key] {
+				// a user added parameter
+				continue
+			}
+
+			currentConf.Delete(change.Key)
+		} else {
+			currentConf.Set(change.Key, change.NewValue)
 		}
+
+		log.Info(fmt.Sprintf("nvmesh-core-config nvmesh.conf %s\n", change))
+		shouldUpdate = true
 	}
 
-	newExpectedAsString := r.configDictToString(expectedDict)
-	return shouldUpdate, newExpectedAsString
+	return shouldUpdate, currentConf.String()
 }
 
 func (r *NVMeshCoreReconciler) shouldUpdateCoreConfigMap(cr *nvmeshv1.NVMesh, expected *corev1.ConfigMap, cm *corev1.ConfigMap) bool {
@@ -144,9 +229,14 @@ func (r *NVMeshCoreReconciler) shouldUpdateCoreConfigMap(cr *nvmeshv1.NVMesh, ex
 
 	shouldUpdate := false
 
+	extraConfigKeys := extraConfigKeysFromString(cm.GetAnnotations()[extraConfigKeysAnnotation])
+	if expected.GetAnnotations()[extraConfigKeysAnnotation] != cm.GetAnnotations()[extraConfigKeysAnnotation] {
+		shouldUpdate = true
+	}
+
 	for field, _ := range expected.Data {
-		if field == "nvmesh.conf" {
-			shouldUpdateConf, newExpectedConfString := r.shouldUpdateNVMeshConf(cr, expected.Data[field], cm.Data[field])
+		if field == nvmeshConfKey {
+			shouldUpdateConf, newExpectedConfString := r.shouldUpdateNVMeshConf(cr, expected.Data[field], cm.Data[field], extraConfigKeys)
 			expected.Data[field] = newExpectedConfString
 			shouldUpdate = shouldUpdate || shouldUpdateConf
 		} else if expected.Data[field] != cm.Data[field] {
@@ -158,6 +248,16 @@ func (r *NVMeshCoreReconciler) shouldUpdateCoreConfigMap(cr *nvmeshv1.NVMesh, ex
 	return shouldUpdate
 }
 
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
 func (r *NVMeshCoreReconciler) addVolumeAndMountToContainer(volumeName string, mountPath string, podSpec *v1.PodSpec, container *v1.Container) {
 	volume := v1.Volume{
 		Name: volumeName,
@@ -200,16 +300,18 @@ func (r *NVMeshCoreReconciler) initDaemonSets(cr *nvmeshv1.NVMesh, ds *appsv1.Da
 		case "toma":
 			imageName = "nvmesh-toma"
 
-			if !cr.Spec.Core.TCPOnly {
+			if clusterUsesRDMA(cr) {
 				r.addTomaIBLibMounts(podSpec, container)
 			}
 		case "tracer":
 			imageName = "nvmesh-tracer"
 		case "driver-container":
 			imageName = "nvmesh-driver-container"
-			if !cr.Spec.Core.TCPOnly {
+			if clusterUsesRDMA(cr) {
 				r.addVolumeAndMountToContainer("etc-infiniband", "/etc/infiniband", podSpec, container)
 			}
+
+			addDriverReadinessProbe(cr, ds.GetName(), &ds.Spec.Template, container)
 		}
 
 		podSpec.Containers[i].Image = cr.Spec.Core.ImageRegistry + "/" + imageName + ":" + cr.Spec.Core.ImageVersionTag
@@ -217,6 +319,7 @@ func (r *NVMeshCoreReconciler) initDaemonSets(cr *nvmeshv1.NVMesh, ds *appsv1.Da
 		r.setEnvVariableValues(cr, container)
 	}
 
+	r.addNodeConfigInitContainer(cr, podSpec)
 	return nil
 }
 
@@ -231,42 +334,6 @@ func (r *NVMeshCoreReconciler) setEnvVariableValues(cr *nvmeshv1.NVMesh, contain
 	r.addKeepRunningAfterFailureEnvVar(cr, container)
 }
 
-func (r *NVMeshCoreReconciler) configStringToDict(conf string) map[string]string {
-	var configDict map[string]string = make(map[string]string, 0)
-
-	lines := strings.Split(conf, "\n")
-	for _, line := range lines {
-		lineParts := strings.Split(line, "=")
-		key := lineParts[0]
-		value := strings.Join(lineParts[1:], "=")
-
-		// add to map
-		configDict[key] = value
-	}
-
-	return configDict
-}
-
-func (r *NVMeshCoreReconciler) configDictToString(configDict map[string]string) string {
-	var lines []string = make([]string, 0)
-
-	// get sorted list of keys
-	sortedKeys := make([]string, 0, len(configDict))
-	for k := range configDict {
-		sortedKeys = append(sortedKeys, k)
-	}
-
-	sort.Strings(sortedKeys)
-
-	// create conf string
-	for _, key := range sortedKeys {
-		value := configDict[key]
-		lines = append(lines, fmt.Sprintf("%s=%s", key, value))
-	}
-
-	return strings.Join(lines, "\n")
-}
-
 func (r *NVMeshCoreReconciler) getMgmtServersConnectionString(cr *nvmeshv1.NVMesh) string {
 	var servers []string
 
@@ -279,60 +346,98 @@ func (r *NVMeshCoreReconciler) getMgmtServersConnectionString(cr *nvmeshv1.NVMes
 	return strings.Join(servers, ",")
 }
 
-func nvmeshConfWrapWithQuotes(value string) string {
-	return fmt.Sprintf("\"%s\"", value)
-}
-
-func (r *NVMeshCoreReconciler) initCoreConfigMap(cr *nvmeshv1.NVMesh, cm *v1.ConfigMap) error {
+// getFileServerOptions - returns spec.operator.fileServer with the default address filled in
+func getFileServerOptions(cr *nvmeshv1.NVMesh) nvmeshv1.OperatorFileServerSpec {
 	fileServerOptions := nvmeshv1.OperatorFileServerSpec{}
 	if cr.Spec.Operator.FileServer != nil {
 		fileServerOptions = *cr.Spec.Operator.FileServer
 	}
 
-	if fileServerOptions.Address != "" {
-		cm.Data["fileServer.address"] = fileServerOptions.Address
-	} else {
-		cm.Data["fileServer.address"] = defaultFileServerAddress
+	if fileServerOptions.Address == "" {
+		fileServerOptions.Address = defaultFileServerAddress
 	}
 
+	return fileServerOptions
+}
+
+func (r *NVMeshCoreReconciler) initCoreConfigMap(cr *nvmeshv1.NVMesh, cm *v1.ConfigMap) error {
+	fileServerOptions := getFileServerOptions(cr)
+	cm.Data["fileServer.address"] = fileServerOptions.Address
 	cm.Data["fileServer.skipCheckCertificate"] = strconv.FormatBool(fileServerOptions.SkipCheckCertificate)
 
 	// get values from the hardcoded yaml and adds dynamic values
-	configDict := r.configStringToDict(cm.Data["nvmesh.conf"])
+	baseConf := cm.Data[nvmeshConfKey]
 
-	managementServers := r.getMgmtServersConnectionString(cr)
-	// Wrap value with double quotes
-	configDict["MANAGEMENT_SERVERS"] = nvmeshConfWrapWithQuotes(managementServers)
+	var err error
+	clusterConfig := getClusterCoreConfig(cr)
+	cm.Data[nvmeshConfKey], err = r.renderNVMeshConf(cr, baseConf, clusterConfig)
+	if err != nil {
+		return err
+	}
+
+	// copy spec.core.moduleParams -> ConfigMap.Data["modprobe.d"]
+	cm.Data[modprobeConfKey] = clusterConfig.ModuleParams
+
+	extraConfigKeys := make(map[string]bool)
+	for key := range clusterConfig.ExtraConfig {
+		extraConfigKeys[key] = true
+	}
+
+	// remember which keys came from extraConfig so they can be removed from nvmesh.conf when they are removed from the CR
+	annotations := cm.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	if len(extraConfigKeys) > 0 {
+		annotations[extraConfigKeysAnnotation] = extraConfigKeysToString(extraConfigKeys)
+	} else {
+		delete(annotations, extraConfigKeysAnnotation)
+	}
 
-	if cr.Spec.Core.TCPOnly {
-		configDict["IPV4_ONLY"] = nvmeshConfWrapWithQuotes("Yes")
-		configDict["TCP_ENABLED"] = nvmeshConfWrapWithQuotes("Yes")
-		configDict["CONFIGURED_NICS"] = nvmeshConfWrapWithQuotes(cr.Spec.Core.ConfiguredNICs)
+	cm.SetAnnotations(annotations)
+	return nil
+}
+
+// renderNVMeshConf - adds the dynamic values to the nvmesh.conf from the hardcoded yaml
+func (r *NVMeshCoreReconciler) renderNVMeshConf(cr *nvmeshv1.NVMesh, baseConf string, config nodeCoreConfig) (string, error) {
+	conf, err := nvmeshconf.Parse(baseConf)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to parse nvmesh.conf from nvmesh-core-config")
+	}
+
+	conf.Set("MANAGEMENT_SERVERS", r.getMgmtServersConnectionString(cr))
+
+	if config.TCPOnly {
+		conf.Set("IPV4_ONLY", "Yes")
+		conf.Set("TCP_ENABLED", "Yes")
+	}
+
+	if config.TCPOnly || config.AutoNICs || config.ConfiguredNICs != "" {
+		conf.Set("CONFIGURED_NICS", config.ConfiguredNICs)
 	}
 
 	if cr.Spec.Core.AzureOptimized {
-		configDict["CLOUD_OPTIMIZED"] = nvmeshConfWrapWithQuotes("Yes")
+		conf.Set("CLOUD_OPTIMIZED", "Yes")
 
 		// Reduces calls to S.M.A.R.T because of poor performance of NVMe SMART
-		configDict["TOMA_CLOUD_MODE"] = nvmeshConfWrapWithQuotes("Yes")
-		configDict["AGENT_CLOUD_MODE"] = nvmeshConfWrapWithQuotes("Yes")
+		conf.Set("TOMA_CLOUD_MODE", "Yes")
+		conf.Set("AGENT_CLOUD_MODE", "Yes")
 	}
 
-	if cr.Spec.Core.ExcludeDrives != nil {
-		serials := cr.Spec.Core.ExcludeDrives.SerialNumbers
-		if serials != nil {
-			configDict["EXCLUDE_DRIVE_SERIALS"] = nvmeshConfWrapWithQuotes(strings.Join(serials[:], ","))
+	if config.ExcludeDrives != nil {
+		if config.ExcludeDrives.SerialNumbers != nil {
+			conf.SetList("EXCLUDE_DRIVE_SERIALS", config.ExcludeDrives.SerialNumbers)
 		}
 
-		if cr.Spec.Core.ExcludeDrives.DevicePaths != nil {
-			devicePaths := cr.Spec.Core.ExcludeDrives.DevicePaths
-			configDict["EXCLUDE_DEVICE_PATHS"] = nvmeshConfWrapWithQuotes(strings.Join(devicePaths[:], ","))
+		if config.ExcludeDrives.DevicePaths != nil {
+			conf.SetList("EXCLUDE_DEVICE_PATHS", config.ExcludeDrives.DevicePaths)
 		}
 	}
 
-	// copy spec.core.moduleParams -> ConfigMap.Data["modprobe.d"]
-	cm.Data["modprobe.d"] = cr.Spec.Core.ModuleParams
+	for _, key := range sortedKeys(config.ExtraConfig) {
+		conf.Set(key, config.ExtraConfig[key])
+	}
 
-	cm.Data["nvmesh.conf"] = r.configDictToString(configDict)
-	return nil
+	return conf.String(), nil
 }
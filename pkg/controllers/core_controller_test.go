@@ -8,6 +8,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -67,3 +68,30 @@ func TestCoreReconciler(t *testing.T) {
 
 	By("Test Core Reconciler finished")
 }
+
+var _ = Describe("Core ConfigMap", func() {
+	It("keeps the formatting of a hand edited nvmesh.conf", func() {
+		cr := newTestCluster()
+		cr.Spec.Management.Replicas = 1
+
+		r := newFakeReconciler(cr)
+		corer := NVMeshCoreReconciler(*r)
+		baseConf := "K8S_ENV=\"True\"\nMANAGEMENT_SERVERS=\"placeholder\""
+
+		expected := &corev1.ConfigMap{Data: map[string]string{nvmeshConfKey: baseConf}}
+		Expect(corer.initCoreConfigMap(cr, expected)).To(BeNil())
+
+		By("a hand edited nvmesh.conf with the same values in a different format does not require an update")
+		current := expected.DeepCopy()
+		current.Data[nvmeshConfKey] = "# edited by hand\nexport MANAGEMENT_SERVERS='nvmesh-management-0.nvmesh-management-ws." + TestingNamespace + ".svc.cluster.local:4001'\nK8S_ENV=True\nUSER_KEY=1"
+		Expect(corer.shouldUpdateCoreConfigMap(cr, expected.DeepCopy(), current)).To(BeFalse())
+
+		By("a real change is applied on the current content")
+		cr.Spec.Management.Replicas = 2
+		expected = &corev1.ConfigMap{Data: map[string]string{nvmeshConfKey: baseConf}}
+		Expect(corer.initCoreConfigMap(cr, expected)).To(BeNil())
+		Expect(corer.shouldUpdateCoreConfigMap(cr, expected, current)).To(BeTrue())
+		Expect(expected.Data[nvmeshConfKey]).To(HavePrefix("# edited by hand\nexport MANAGEMENT_SERVERS=\"nvmesh-management-0"))
+		Expect(expected.Data[nvmeshConfKey]).To(HaveSuffix("\nK8S_ENV=True\nUSER_KEY=1"))
+	})
+})
@@ -8,6 +8,7 @@ import (
 	reflectutils "excelero.com/nvmesh-k8s-operator/pkg/reflectutils"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	rbac "k8s.io/api/rbac/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -68,6 +69,11 @@ func (r *NVMeshCSIReconciler) InitObject(cr *nvmeshv1.NVMesh, obj client.Object)
 		return r.initRoleBinding(cr, o)
 	case *rbac.ClusterRoleBinding:
 		return r.initClusterRoleBinding(cr, o)
+	case *policyv1.PodDisruptionBudget:
+		switch name {
+		case "nvmesh-csi-controller":
+			setPDBMinAvailable(o, cr.Spec.CSI.ControllerReplicas)
+		}
 	default:
 		//o is unknown for us
 		//log.Info(fmt.Sprintf("Object type %s not handled", o))
@@ -97,6 +103,8 @@ func (r *NVMeshCSIReconciler) ShouldUpdateObject(cr *nvmeshv1.NVMesh, exp client
 	case *appsv1.Deployment:
 	case *v1.ServiceAccount:
 	case *v1.ConfigMap:
+	case *policyv1.PodDisruptionBudget:
+		return shouldUpdatePDB((exp).(*policyv1.PodDisruptionBudget), o)
 	default:
 		//o is unknown for us
 		//log.Info(fmt.Sprintf("Object type %s not handled", o))
@@ -118,6 +126,7 @@ func (r *NVMeshCSIReconciler) initCSINodeDriverDaemonSet(cr *nvmeshv1.NVMesh, ds
 
 	ds.Spec.Template.Spec.Containers[0].Image = getCSIFullImageName(cr)
 	ds.Spec.Template.Spec.Containers[0].ImagePullPolicy = r.getImagePullPolicy(cr)
+	addCSIProbes(cr, &ds.Spec.Template, &ds.Spec.Template.Spec.Containers[0])
 
 	return nil
 }
@@ -131,6 +140,7 @@ func (r *NVMeshCSIReconciler) initCSIControllerStatefulSet(cr *nvmeshv1.NVMesh,
 
 	ss.Spec.Template.Spec.Containers[0].Image = getCSIFullImageName(cr)
 	ss.Spec.Template.Spec.Containers[0].ImagePullPolicy = r.getImagePullPolicy(cr)
+	addCSIProbes(cr, &ss.Spec.Template, &ss.Spec.Template.Spec.Containers[0])
 
 	// set replicas from CustomResource
 	ss.Spec.Replicas = &cr.Spec.CSI.ControllerReplicas
@@ -195,6 +205,16 @@ func (r *NVMeshCSIReconciler) shouldUpdateCSINodeDriverDaemonSet(cr *nvmeshv1.NV
 		return true
 	}
 
+	if len(expected.Spec.Template.Spec.Containers) != len(ds.Spec.Template.Spec.Containers) {
+		log.Info("CSI Node Driver containers changed")
+		return true
+	}
+
+	if probesHashChanged(&expected.Spec.Template, &ds.Spec.Template) {
+		log.Info("CSI Node Driver probes changed")
+		return true
+	}
+
 	return false
 }
 
@@ -216,5 +236,15 @@ func (r *NVMeshCSIReconciler) shouldUpdateCSIControllerStatefulSet(cr *nvmeshv1.
 		return true
 	}
 
+	if len(expected.Spec.Template.Spec.Containers) != len(ss.Spec.Template.Spec.Containers) {
+		log.Info("CSI Controller containers changed")
+		return true
+	}
+
+	if probesHashChanged(&expected.Spec.Template, &ss.Spec.Template) {
+		log.Info("CSI Controller probes changed")
+		return true
+	}
+
 	return false
 }
@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	goerrors "errors"
+	"fmt"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	csiManagementUsername = "nvmesh-csi-driver@nvmesh.local"
+	csiManagementRole     = "Admin"
+	csiPasswordBytes      = 24
+
+	// csiCredentialsStateAnnotation - set on the generated Secret, pending until the password was saved in Management
+	csiCredentialsStateAnnotation = "nvmesh.excelero.com/credentials-state"
+	csiCredentialsPending         = "pending"
+	csiCredentialsApplied         = "applied"
+
+	// csiPendingPasswordKey - holds the new password until it is saved in Management, the CSI pods keep using the password key meanwhile
+	csiPendingPasswordKey = "pendingPassword"
+
+	// default secret name, can be changed in spec.operator.secrets
+	mgmtAdminSecretName = "nvmesh-mgmt-admin-cred"
+
+	rotateCredentialsAction   = "rotate-credentials"
+	rotateCredentialsStage    = "rotate"
+	waitForCredentialsApplied = "waitForApplied"
+)
+
+func getMgmtAdminSecretName(cr *nvmeshv1.NVMesh) string {
+	return secretNameOrDefault(cr.Spec.Operator.Secrets.ManagementAdmin, mgmtAdminSecretName)
+}
+
+// isCSICredentialsGenerated - returns true if the operator manages the CSI Management user,
+// false if the user provided a Secret in spec.operator.secrets or Management is external and not managed by the operator
+func isCSICredentialsGenerated(cr *nvmeshv1.NVMesh) bool {
+	return !cr.Spec.CSI.Disabled && !cr.Spec.Management.Disabled && getCSIManagementSecretName(cr) == csiCredentialsSecretName
+}
+
+func generatePassword() (string, error) {
+	b := make([]byte, csiPasswordBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "Failed to generate a random password")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// setNewCSIPassword - stores a new random password in the pending key of the Secret and marks it as pending,
+// a new Secret has no password to keep so the new password is also set as its password
+func setNewCSIPassword(secret *corev1.Secret) error {
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+
+	secret.Type = corev1.SecretTypeOpaque
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+
+	if _, ok := secret.Data["password"]; !ok {
+		secret.Data["username"] = []byte(csiManagementUsername)
+		secret.Data["password"] = []byte(password)
+	}
+
+	secret.Data[csiPendingPasswordKey] = []byte(password)
+
+	annotations := secret.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	annotations[csiCredentialsStateAnnotation] = csiCredentialsPending
+	secret.SetAnnotations(annotations)
+	return nil
+}
+
+// getCSICredentialsSecret - returns the generated CSI credentials Secret, or nil if it does not exist
+func (r *NVMeshReconciler) getCSICredentialsSecret(cr *nvmeshv1.NVMesh) (*corev1.Secret, error) {
+	secret, err := r.getSecret(cr.GetNamespace(), csiCredentialsSecretName)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to get secret %s", csiCredentialsSecretName))
+	}
+
+	return secret, nil
+}
+
+// reconcileCSICredentials - makes sure the CSI driver has a dedicated Management user with a generated password.
+// The password is stored in a Secret owned by the cluster and is saved in Management, once saved the CSI pods are restarted to use it
+func (r *NVMeshReconciler) reconcileCSICredentials(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	if !isCSICredentialsGenerated(cr) {
+		// with an external Management the user provides the CSI credentials, an existing Secret is never changed
+		return DoNotRequeue(), nil
+	}
+
+	secret, err := r.getCSICredentialsSecret(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	if secret == nil {
+		secret = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: csiCredentialsSecretName, Namespace: cr.GetNamespace()}}
+		r.addOperatorLabels(cr, secret)
+		if err := controllerutil.SetControllerReference(cr, secret, r.Scheme); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if err := setNewCSIPassword(secret); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if err := r.Client.Create(context.TODO(), secret); err != nil {
+			return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to create secret %s", csiCredentialsSecretName))
+		}
+	} else if _, ok := secret.GetAnnotations()[csiCredentialsStateAnnotation]; !ok {
+		if !metav1.IsControlledBy(secret, cr) {
+			// created by the user before the operator generated the credentials, keep using it
+			return DoNotRequeue(), nil
+		}
+
+		// the default credentials deployed by older operator versions are replaced once the new password is saved
+		r.Log.Info("Replacing the default CSI credentials with a dedicated Management user")
+		if err := setNewCSIPassword(secret); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if err := r.Client.Update(context.TODO(), secret); err != nil {
+			return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to update secret %s", csiCredentialsSecretName))
+		}
+	}
+
+	if secret.GetAnnotations()[csiCredentialsStateAnnotation] != csiCredentialsPending {
+		return DoNotRequeue(), nil
+	}
+
+	if err := r.saveCSIManagementUser(cr, secret); err != nil {
+		// Management may not be ready yet
+		r.Log.Info(fmt.Sprintf("Failed to save the CSI Management user, will retry. %s", err))
+		return Requeue(jobProgressSafetyRequeue), nil
+	}
+
+	secret.Data["username"] = []byte(csiManagementUsername)
+	secret.Data["password"] = secret.Data[csiPendingPasswordKey]
+	delete(secret.Data, csiPendingPasswordKey)
+	secret.Annotations[csiCredentialsStateAnnotation] = csiCredentialsApplied
+	if err := r.Client.Update(context.TODO(), secret); err != nil {
+		return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to update secret %s", csiCredentialsSecretName))
+	}
+
+	r.EventManager.Normal(cr, "CSICredentialsApplied", fmt.Sprintf("Management user %s was saved for the CSI driver", csiManagementUsername))
+
+	// the CSI pods read the credentials on start
+	if err := r.restartStatefulSet(cr.GetNamespace(), csiStatefulSetName); err != nil && !k8serrors.IsNotFound(err) {
+		return DoNotRequeue(), err
+	}
+
+	if err := r.restartDaemonSet(cr.GetNamespace(), csiDaemonSetName); err != nil && !k8serrors.IsNotFound(err) {
+		return DoNotRequeue(), err
+	}
+
+	return DoNotRequeue(), nil
+}
+
+// getMgmtAdminCredentials - returns the admin credentials from the mgmt admin Secret
+func (r *NVMeshReconciler) getMgmtAdminCredentials(cr *nvmeshv1.NVMesh) (string, string, error) {
+	name := getMgmtAdminSecretName(cr)
+	secret, err := r.getSecret(cr.GetNamespace(), name)
+	if err != nil {
+		return "", "", errors.Wrap(err, fmt.Sprintf("Failed to get secret %s", name))
+	}
+
+	return string(secret.Data["username"]), string(secret.Data["password"]), nil
+}
+
+// saveCSIManagementUser - creates or updates the CSI user in Management with the pending password from the Secret
+func (r *NVMeshReconciler) saveCSIManagementUser(cr *nvmeshv1.NVMesh, secret *corev1.Secret) error {
+	username, password, err := r.getMgmtAdminCredentials(cr)
+	if err != nil {
+		return err
+	}
+
+	api, err := newManagementAPI(r.getManagementAPIAddress(cr))
+	if err != nil {
+		return err
+	}
+
+	if err := api.Login(username, password); err != nil {
+		return err
+	}
+
+	csiPassword := string(secret.Data[csiPendingPasswordKey])
+	if csiPassword == "" {
+		return errors.New(fmt.Sprintf("Secret %s has no %s", secret.GetName(), csiPendingPasswordKey))
+	}
+
+	return api.SaveUser(managementUser{
+		Email:                csiManagementUsername,
+		Password:             csiPassword,
+		ConfirmationPassword: csiPassword,
+		Role:                 csiManagementRole,
+	})
+}
+
+// rotateCredentials - generates a new password for the CSI Management user and waits until it is saved in Management
+type rotateCredentials struct {
+	actionOptions
+}
+
+func (rotateCredentials) Validate(r *NVMeshReconciler, cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error {
+	if !isCSICredentialsGenerated(cr) {
+		return goerrors.New("rotate-credentials only rotates the CSI credentials generated by the operator, spec.operator.secrets.csiManagement is set, CSI is disabled or Management is external")
+	}
+
+	return nil
+}
+
+func (rotateCredentials) Stages(r *NVMeshReconciler, a nvmeshv1.ClusterAction) []Task {
+	return []Task{
+		{rotateCredentialsStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			secret, err := r.getCSICredentialsSecret(cr)
+			if err != nil {
+				return DoNotRequeue(), err
+			}
+
+			if secret != nil && !metav1.IsControlledBy(secret, cr) {
+				return DoNotRequeue(), newActionFailure("rotate-credentials does not change the Secret %s that was created by the user", csiCredentialsSecretName)
+			}
+
+			// a missing Secret is created with a new password by reconcileCSICredentials
+			if secret != nil {
+				if err := setNewCSIPassword(secret); err != nil {
+					return DoNotRequeue(), err
+				}
+
+				if err := r.Client.Update(context.TODO(), secret); err != nil {
+					return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to update secret %s", csiCredentialsSecretName))
+				}
+			}
+
+			return DoNotRequeue(), nil
+		}},
+		{waitForCredentialsApplied, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return r.reconcileCSICredentials(cr)
+		}},
+	}
+}
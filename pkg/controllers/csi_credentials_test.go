@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("CSI credentials", func() {
+	It("generates the CSI Management credentials and rotates them", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+
+		csiController := &appsv1.StatefulSet{}
+		csiController.SetName(csiStatefulSetName)
+		csiController.SetNamespace(TestingNamespace)
+
+		api := &fakeManagementAPI{}
+		defer useFakeManagementAPI(api)()
+
+		r := newFakeReconciler(cr, csiController)
+		getSecret := func() *corev1.Secret {
+			secret, err := r.getCSICredentialsSecret(cr)
+			Expect(err).To(BeNil())
+			Expect(secret).NotTo(BeNil())
+			return secret
+		}
+
+		By("generating a password and retrying until the admin Secret exists")
+		api.available = true
+		result, err := r.reconcileCSICredentials(cr)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeTrue())
+		Expect(api.logins).To(BeEmpty())
+
+		secret := getSecret()
+		password := string(secret.Data["password"])
+		Expect(string(secret.Data["username"])).To(Equal(csiManagementUsername))
+		Expect(password).To(HaveLen(32))
+		Expect(string(secret.Data[csiPendingPasswordKey])).To(Equal(password))
+		Expect(secret.Annotations[csiCredentialsStateAnnotation]).To(Equal(csiCredentialsPending))
+		Expect(secret.OwnerReferences).To(HaveLen(1))
+
+		Expect(r.Client.Create(ctx, newMgmtAdminSecret())).To(Succeed())
+
+		By("retrying until Management is available")
+		api.available = false
+		result, err = r.reconcileCSICredentials(cr)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeTrue())
+
+		By("saving the user in Management with the admin Secret and restarting the CSI pods")
+		api.available = true
+		result, err = r.reconcileCSICredentials(cr)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeFalse())
+		Expect(api.logins).To(Equal([]string{"root@company.com:secret"}))
+		Expect(api.users).To(Equal([]managementUser{{Email: csiManagementUsername, Password: password, ConfirmationPassword: password, Role: csiManagementRole}}))
+		secret = getSecret()
+		Expect(secret.Annotations[csiCredentialsStateAnnotation]).To(Equal(csiCredentialsApplied))
+		Expect(secret.Data).NotTo(HaveKey(csiPendingPasswordKey))
+
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: csiStatefulSetName, Namespace: TestingNamespace}, csiController)).To(Succeed())
+		Expect(csiController.Spec.Template.Annotations).To(HaveKey("operator.nvmesh.excelero.com/restartedAt"))
+
+		_, err = r.reconcileCSICredentials(cr)
+		Expect(err).To(BeNil())
+		Expect(api.users).To(HaveLen(1))
+
+		By("keeping the old password until the rotated password is saved")
+		action := nvmeshv1.ClusterAction{Name: rotateCredentialsAction, ID: "rotate-1"}
+		api.available = false
+		done, _, err := r.handleAction(action, cr)
+		Expect(err).To(BeNil())
+		Expect(done).To(BeFalse())
+		secret = getSecret()
+		Expect(string(secret.Data["password"])).To(Equal(password))
+		Expect(string(secret.Data[csiPendingPasswordKey])).NotTo(Equal(password))
+
+		api.available = true
+		done, _, err = r.handleAction(action, cr)
+		Expect(err).To(BeNil())
+		Expect(done).To(BeTrue())
+		Expect(api.users).To(HaveLen(2))
+		Expect(api.users[1].Password).NotTo(Equal(password))
+		Expect(string(getSecret().Data["password"])).To(Equal(api.users[1].Password))
+
+		By("replacing the default credentials of older versions only after the new password is saved")
+		secret = getSecret()
+		delete(secret.Annotations, csiCredentialsStateAnnotation)
+		secret.Data = map[string][]byte{"username": []byte("admin@excelero.com"), "password": []byte("admin")}
+		Expect(r.Client.Update(ctx, secret)).To(Succeed())
+
+		api.available = false
+		_, err = r.reconcileCSICredentials(cr)
+		Expect(err).To(BeNil())
+		secret = getSecret()
+		Expect(string(secret.Data["password"])).To(Equal("admin"))
+		Expect(secret.Data).To(HaveKey(csiPendingPasswordKey))
+
+		api.available = true
+		_, err = r.reconcileCSICredentials(cr)
+		Expect(err).To(BeNil())
+		secret = getSecret()
+		Expect(string(secret.Data["username"])).To(Equal(csiManagementUsername))
+		Expect(string(secret.Data["password"])).To(Equal(api.users[2].Password))
+
+		By("not changing the Secret with an external Management")
+		cr.Spec.Management.Disabled = true
+		_, err = r.reconcileCSICredentials(cr)
+		Expect(err).To(BeNil())
+		Expect(getSecret().Data).To(Equal(secret.Data))
+		Expect(api.users).To(HaveLen(3))
+		cr.Spec.Management.Disabled = false
+
+		By("keeping a Secret created by the user and failing to rotate it")
+		Expect(r.Client.Delete(ctx, getSecret())).To(Succeed())
+		Expect(r.Client.Create(ctx, newTestSecret(csiCredentialsSecretName, map[string]string{"username": "csi", "password": "pass"}))).To(Succeed())
+
+		_, err = r.reconcileCSICredentials(cr)
+		Expect(err).To(BeNil())
+		Expect(string(getSecret().Data["password"])).To(Equal("pass"))
+
+		cr.Status.ActionsStatus = nil
+		done, _, err = r.handleAction(action, cr)
+		Expect(err).NotTo(BeNil())
+		Expect(done).To(BeTrue())
+		Expect(string(getSecret().Data["password"])).To(Equal("pass"))
+
+		cr.Spec.Operator.Secrets.CSIManagement = "my-csi-user"
+		done, _, err = r.handleAction(action, cr)
+		Expect(err).NotTo(BeNil())
+		Expect(done).To(BeTrue())
+	})
+})
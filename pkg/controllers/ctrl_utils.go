@@ -22,7 +22,6 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
-	"k8s.io/apimachinery/pkg/watch"
 	memory "k8s.io/client-go/discovery/cached"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/utils/pointer"
@@ -47,18 +46,13 @@ const (
 	nvmeshClusterNameLabelKey = "nvmesh.excelero.com/cluster-name"
 	deleteOnUninstallLabelKey = "nvmesh.excelero.com/delete-on-uninstall"
 
+	// default secret names, can be changed in spec.operator.secrets
 	fileServerSecretName       = "nvmesh-file-server-cred"
 	exceleroRegistrySecretName = "excelero-registry-cred"
 
 	operatorSCCName = "privileged"
 )
 
-var (
-
-	// keep all watcher to stop them before creating new ones
-	watchers []watch.Interface
-)
-
 func (r *NVMeshReconciler) reconcileObject(cr *nvmeshv1.NVMesh, newObj client.Object, component *NVMeshComponent, removeObject bool) error {
 	if removeObject == false {
 		return r.makeSureObjectExists(cr, newObj, component)
@@ -103,6 +97,8 @@ func (r *NVMeshReconciler) makeSureObjectExists(cr *nvmeshv1.NVMesh, newObj clie
 		}
 	}
 
+	applySecretReferences(cr, newObj)
+
 	// Set NVMesh instance as the owner and controller
 	if err := controllerutil.SetControllerReference(cr, newObj, r.Scheme); err != nil {
 		if err != nil {
@@ -123,7 +119,7 @@ func (r *NVMeshReconciler) makeSureObjectExists(cr *nvmeshv1.NVMesh, newObj clie
 	} else if err != nil {
 		log.Error(err, "Error while getting object")
 		return err
-	} else if component != nil && (*component).ShouldUpdateObject(cr, newObj, foundObj) {
+	} else if (component != nil && (*component).ShouldUpdateObject(cr, newObj, foundObj)) || secretReferencesChanged(newObj, foundObj) {
 		log.Info("shouldUpdate returned true > Updating...")
 
 		// Update the resource version before an update
@@ -186,10 +182,35 @@ func (r *NVMeshReconciler) getGenericObject(fromObject client.Object, namespace
 	}
 
 	foundObject := (fromObject.DeepCopyObject()).(client.Object)
-	err := r.Client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, foundObject)
+	reader := r.getCachedReader()
+	if _, ok := fromObject.(*unstructured.Unstructured); ok {
+		reader = r.getAPIReader()
+	}
+
+	err := reader.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, foundObject)
 	return foundObject, err
 }
 
+// getCachedReader - returns a reader that reads objects from the manager's informer cache instead of the api-server
+func (r *NVMeshBaseReconciler) getCachedReader() client.Reader {
+	if r.Manager != nil {
+		return r.Manager.GetCache()
+	}
+
+	// When running without a manager (i.e. in tests) fallback to the client
+	return r.Client
+}
+
+// getAPIReader - returns a reader that reads directly from the api-server, used for unstructured objects that should not start a cluster-wide informer
+func (r *NVMeshBaseReconciler) getAPIReader() client.Reader {
+	if r.Manager != nil {
+		return r.Manager.GetAPIReader()
+	}
+
+	// When running without a manager (i.e. in tests) fallback to the client
+	return r.Client
+}
+
 func (r *NVMeshReconciler) getDecoder() runtime.Decoder {
 	var Codecs = serializer.NewCodecFactory(r.Scheme)
 	return Codecs.UniversalDeserializer()
@@ -342,32 +363,20 @@ func indexOwnerRef(ownerReferences []metav1.OwnerReference, ref metav1.OwnerRefe
 	return -1
 }
 
-func (r *NVMeshReconciler) stopAllUnstructuredWatchers() {
-	for _, w := range watchers {
-		w.Stop()
+func (r *NVMeshReconciler) watchUnstructuredObject(cr *nvmeshv1.NVMesh, gvrMapping *meta.RESTMapping, obj *unstructured.Unstructured, shouldWatch bool) {
+	if r.DynamicWatches == nil {
+		return
 	}
-}
 
-func (r *NVMeshReconciler) listenOnChanAndReconcile(ch <-chan watch.Event) {
-	log := r.Log.WithName("listenOnChanAndReconcile")
+	gvk := gvrMapping.GroupVersionKind
+	objKey := types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}
 
-	for e := range ch {
-		log.Info(fmt.Sprintf("received Event %s %s", e.Object.GetObjectKind().GroupVersionKind().Kind, e.Type))
-		// NOTE: this runs in a separate goroutine so we should not reconcile here but enqueue the reconcile request
-		// TODO: Enqueue Reconcile
-		// This flow is relevant only for the mongodb custom-resource object when deploying a mongodb operator
-	}
-}
-
-func (r *NVMeshReconciler) addUnstructuredWatch(res dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
-	opt := metav1.ListOptions{FieldSelector: "metadata.name=" + obj.GetName()}
-	watcher, err := res.Watch(context.TODO(), opt)
-	if err != nil {
-		return err
+	if shouldWatch {
+		owner := types.NamespacedName{Name: cr.GetName(), Namespace: cr.GetNamespace()}
+		r.DynamicWatches.Watch(gvk, gvrMapping.Resource, objKey, owner)
+	} else {
+		r.DynamicWatches.Unwatch(gvk, objKey)
 	}
-	watchers = append(watchers, watcher)
-	go r.listenOnChanAndReconcile(watcher.ResultChan())
-	return nil
 }
 
 // find the corresponding GVR (available in *meta.RESTMapping) for gvk
@@ -438,15 +447,12 @@ func (r *NVMeshReconciler) reconcileUnstructuredObjects(cr *nvmeshv1.NVMesh, dir
 			continue
 		}
 
-		if shouldCreate == true {
-			err = r.addUnstructuredWatch(res, obj)
-			if err != nil {
-				return err
-			}
-		}
+		obj.SetNamespace(namespace)
+		r.watchUnstructuredObject(cr, gvrMapping, obj, shouldCreate)
 
 		objName := obj.GetName()
 
+		// read through the namespaced dynamic client, the cache would start a cluster-wide informer for each unstructured kind
 		_, err = res.Get(context.TODO(), objName, metav1.GetOptions{})
 		if err != nil && k8serrors.IsNotFound(err) {
 			if shouldCreate == true {
@@ -515,14 +521,6 @@ func (r *NVMeshBaseReconciler) getImagePullPolicy(cr *nvmeshv1.NVMesh) corev1.Pu
 	return corev1.PullIfNotPresent
 }
 
-func (r *NVMeshBaseReconciler) imagePullSecretsFromName(secretName string) []corev1.LocalObjectReference {
-	return []corev1.LocalObjectReference{{Name: secretName}}
-}
-
-func (r *NVMeshBaseReconciler) getExceleroRegistryPullSecrets() []corev1.LocalObjectReference {
-	return r.imagePullSecretsFromName(exceleroRegistrySecretName)
-}
-
 func (r *NVMeshBaseReconciler) getClusterServiceAccountName(cr *nvmeshv1.NVMesh) string {
 	return clusterServiceAccountName
 }
@@ -534,9 +532,7 @@ func (r *NVMeshBaseReconciler) getClusterServiceAccount(cr *nvmeshv1.NVMesh) *co
 			Name:      r.getClusterServiceAccountName(cr),
 			Namespace: cr.GetNamespace(),
 		},
-		ImagePullSecrets: []corev1.LocalObjectReference{
-			{Name: exceleroRegistrySecretName},
-		},
+		ImagePullSecrets: getImagePullSecrets(cr),
 	}
 
 	return sa
@@ -553,6 +549,12 @@ func (r *NVMeshBaseReconciler) getNVMeshClusterRoleAndRoleBinding(cr *nvmeshv1.N
 				ResourceNames: []string{operatorSCCName},
 				Verbs:         []string{"use"},
 			},
+			{
+				// the node-config init container of the Core pods reads the ConfigMap of its node
+				APIGroups: []string{""},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"get"},
+			},
 		},
 	}
 
@@ -592,6 +594,11 @@ func (r *NVMeshReconciler) makeSureServiceAccountExists(cr *nvmeshv1.NVMesh) err
 		return err2
 	}
 
+	err2 = r.makeSureRoleRulesUpdated(role)
+	if err2 != nil {
+		return err2
+	}
+
 	objToCreate = rb
 	err3 := r.makeSureObjectExists(cr, objToCreate, nil)
 	if err3 != nil {
@@ -601,6 +608,29 @@ func (r *NVMeshReconciler) makeSureServiceAccountExists(cr *nvmeshv1.NVMesh) err
 	return nil
 }
 
+// makeSureRoleRulesUpdated - updates the rules of a Role that was created by an older version of the operator
+func (r *NVMeshReconciler) makeSureRoleRulesUpdated(expected *rbac.Role) error {
+	role := &rbac.Role{}
+	err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(expected), role)
+	if k8serrors.IsNotFound(err) {
+		// the Role was just created with the expected rules
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to get Role %s", expected.GetName()))
+	}
+
+	if reflect.DeepEqual(role.Rules, expected.Rules) {
+		return nil
+	}
+
+	role.Rules = expected.Rules
+	if err := r.Client.Update(context.TODO(), role); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to update the rules of Role %s", expected.GetName()))
+	}
+
+	return nil
+}
+
 func (r *NVMeshBaseReconciler) printAllPodsStatuses(namespace string) {
 	allPodsList := &corev1.PodList{}
 	err := r.Client.List(context.TODO(), allPodsList, &client.ListOptions{Namespace: namespace})
@@ -617,6 +647,30 @@ func (r *NVMeshBaseReconciler) printAllPodsStatuses(namespace string) {
 	}
 }
 
+func (r *NVMeshBaseReconciler) restartDaemonSet(namespace string, name string) error {
+	log := r.Log.WithValues("method", "restartDaemonSet", "name", name, "namesapce", namespace)
+
+	log.Info(fmt.Sprintf("restarting DaemonSet %s in namespace %s\n", name, namespace))
+	var ds appsv1.DaemonSet
+	// Add dummy label to cause a rolling restart that will take into account the UpdateStrategy
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		err := r.Client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: namespace}, &ds)
+		if err != nil {
+			return err
+		}
+
+		if ds.Spec.Template.Annotations == nil {
+			ds.Spec.Template.Annotations = map[string]string{}
+		}
+
+		ds.Spec.Template.Annotations["operator.nvmesh.excelero.com/restartedAt"] = time.Now().UTC().Format(time.RFC3339)
+		err = r.Client.Update(context.TODO(), &ds)
+		return err
+	})
+
+	return err
+}
+
 func (r *NVMeshBaseReconciler) restartStatefulSet(namespace string, name string) error {
 	log := r.Log.WithValues("method", "restartStatefulSet", "name", name, "namesapce", namespace)
 
@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+
+const (
+	drainGuardPodPrefix  = "nvmesh-drain-guard-"
+	drainGuardLabelKey   = "nvmesh.excelero.com/drain-guard"
+	drainGuardContainer  = "drain-guard"
+	drainGuardInterval   = time.Minute
+	drainGuardVolumesKey = "nvmesh.excelero.com/last-copy-volumes"
+
+	// drainGuardMaxVolumesInAnnotation - the number of volume names listed on a drain guard pod
+	drainGuardMaxVolumesInAnnotation = 10
+)
+
+// setPDBMinAvailable - allows a drain to evict one pod of the workload at a time
+func setPDBMinAvailable(pdb *policyv1.PodDisruptionBudget, replicas int32) {
+	minAvailable := replicas - 1
+	if minAvailable < 0 {
+		minAvailable = 0
+	}
+
+	value := intstr.FromInt(int(minAvailable))
+	pdb.Spec.MinAvailable = &value
+}
+
+// setMongoPDBMinAvailable - keeps a majority of the MongoDB replica set available, a single replica can not keep a majority without itself so it is not protected
+func setMongoPDBMinAvailable(pdb *policyv1.PodDisruptionBudget, replicas int32) {
+	minAvailable := int32(0)
+	if replicas > 1 {
+		minAvailable = replicas/2 + 1
+	}
+
+	value := intstr.FromInt(int(minAvailable))
+	pdb.Spec.MinAvailable = &value
+	pdb.Spec.MaxUnavailable = nil
+}
+
+func shouldUpdatePDB(expected *policyv1.PodDisruptionBudget, pdb *policyv1.PodDisruptionBudget) bool {
+	return !reflect.DeepEqual(expected.Spec.MinAvailable, pdb.Spec.MinAvailable) ||
+		!reflect.DeepEqual(expected.Spec.MaxUnavailable, pdb.Spec.MaxUnavailable) ||
+		!reflect.DeepEqual(expected.Spec.Selector, pdb.Spec.Selector)
+}
+
+// getLastHealthyCopy - returns the segment of the praid when it is the only healthy copy of the data.
+// a praid with a single data segment (concatenated and RAID-0 volumes) has no other copy to rebuild from, so it is not guarded
+func getLastHealthyCopy(praid managementPraid) (managementDiskSegment, bool) {
+	dataSegments := 0
+	healthy := make([]managementDiskSegment, 0)
+	for _, segment := range praid.DiskSegments {
+		if !isDataSegment(segment) {
+			continue
+		}
+
+		dataSegments++
+		if segment.Health == "healthy" {
+			healthy = append(healthy, segment)
+		}
+	}
+
+	if dataSegments < 2 || len(healthy) != 1 {
+		return managementDiskSegment{}, false
+	}
+
+	return healthy[0], true
+}
+
+// isDataSegment - raft only segments and reserved segments do not hold volume data
+func isDataSegment(segment managementDiskSegment) bool {
+	return segment.Type != "raftonly" && !segment.IsReserved
+}
+
+// getLastCopyNodes - returns the Management node IDs that hold the only healthy copy of a part of a volume, and the volumes on each of them
+func getLastCopyNodes(volumes []managementVolume) map[string][]string {
+	nodes := make(map[string][]string)
+	for _, volume := range volumes {
+		volumeNodes := make(map[string]bool)
+		for _, chunk := range volume.Chunks {
+			for _, praid := range chunk.Praids {
+				if segment, ok := getLastHealthyCopy(praid); ok {
+					volumeNodes[segment.NodeID] = true
+				}
+			}
+		}
+
+		for node := range volumeNodes {
+			nodes[node] = append(nodes[node], volume.Name)
+		}
+	}
+
+	for node := range nodes {
+		sort.Strings(nodes[node])
+	}
+
+	return nodes
+}
+
+// reconcileDrainGuard - runs a drain guard pod on every target node that holds the last healthy copy of a volume.
+// the guard pods are covered by a PodDisruptionBudget that does not allow evictions, so a drain of such a node waits until the volume is rebuilt elsewhere
+func (r *NVMeshReconciler) reconcileDrainGuard(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	if cr.Spec.Core.Disabled || cr.Spec.Core.DisableDrainGuard || cr.Spec.Management.Disabled {
+		return DoNotRequeue(), r.removeDrainGuards(cr, map[string][]string{})
+	}
+
+	nodes, err := r.getLastCopyNodesFromManagement(cr)
+	if err != nil {
+		// keep the current guards until Management is available again
+		r.Log.Info(fmt.Sprintf("Failed to get the volumes from Management, drain guards are not updated. %s", err))
+		return Requeue(drainGuardInterval), nil
+	}
+
+	if err := r.removeDrainGuards(cr, nodes); err != nil {
+		return DoNotRequeue(), err
+	}
+
+	for node, volumes := range nodes {
+		if err := r.makeSureDrainGuardExists(cr, node, volumes); err != nil {
+			return DoNotRequeue(), err
+		}
+	}
+
+	// the health of the volumes changes without any event in the cluster
+	return Requeue(drainGuardInterval), nil
+}
+
+func (r *NVMeshReconciler) getLastCopyNodesFromManagement(cr *nvmeshv1.NVMesh) (map[string][]string, error) {
+	api, err := r.loginToManagement(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes, err := api.GetVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	nodeNames, err := r.getMgmtNodeNames()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string][]string)
+	for nodeID, nodeVolumes := range getLastCopyNodes(volumes) {
+		nodeName, ok := nodeNames.NodeName(nodeID)
+		if !ok {
+			r.Log.Info(fmt.Sprintf("Management node %s holds the last healthy copy of volumes %v, but it does not match a Kubernetes node and can not be guarded", nodeID, nodeVolumes))
+			continue
+		}
+
+		nodes[nodeName] = nodeVolumes
+	}
+
+	return nodes, nil
+}
+
+// removeDrainGuards - deletes the drain guard pods of nodes that are not in the given nodes
+func (r *NVMeshReconciler) removeDrainGuards(cr *nvmeshv1.NVMesh, nodes map[string][]string) error {
+	pods := &corev1.PodList{}
+	err := r.Client.List(context.TODO(), pods, client.InNamespace(cr.GetNamespace()), client.MatchingLabels{drainGuardLabelKey: "true", nvmeshClusterNameLabelKey: cr.GetName()})
+	if err != nil {
+		return errors.Wrap(err, "Failed to list the drain guard pods")
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		node := pod.Spec.NodeName
+		if _, ok := nodes[node]; ok {
+			continue
+		}
+
+		if err := r.Client.Delete(context.TODO(), pod); err != nil && !k8serrors.IsNotFound(err) {
+			return errors.Wrap(err, fmt.Sprintf("Failed to delete drain guard pod %s", pod.GetName()))
+		}
+
+		r.EventManager.Normal(cr, "DrainGuardRemoved", fmt.Sprintf("Node %s no longer holds the last healthy copy of a volume and can be drained", node))
+	}
+
+	return nil
+}
+
+func (r *NVMeshReconciler) makeSureDrainGuardExists(cr *nvmeshv1.NVMesh, node string, volumes []string) error {
+	listed := volumes
+	if len(listed) > drainGuardMaxVolumesInAnnotation {
+		listed = listed[:drainGuardMaxVolumesInAnnotation]
+	}
+
+	volumesAnnotation := strings.Join(listed, ",")
+	name := drainGuardPodPrefix + node
+	pod := &corev1.Pod{}
+	err := r.Client.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: cr.GetNamespace()}, pod)
+	if err == nil {
+		if pod.GetAnnotations()[drainGuardVolumesKey] == volumesAnnotation {
+			return nil
+		}
+
+		pod.SetAnnotations(map[string]string{drainGuardVolumesKey: volumesAnnotation})
+		return errors.Wrap(r.Client.Update(context.TODO(), pod), fmt.Sprintf("Failed to update drain guard pod %s", name))
+	} else if !k8serrors.IsNotFound(err) {
+		return errors.Wrap(err, fmt.Sprintf("Failed to get drain guard pod %s", name))
+	}
+
+	pod = r.newDrainGuardPod(cr, node)
+	pod.SetAnnotations(map[string]string{drainGuardVolumesKey: volumesAnnotation})
+	if err := controllerutil.SetControllerReference(cr, pod, r.Scheme); err != nil {
+		return err
+	}
+
+	if err := r.Client.Create(context.TODO(), pod); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to create drain guard pod %s", name))
+	}
+
+	r.EventManager.Normal(cr, "DrainGuardAdded", fmt.Sprintf("Node %s holds the last healthy copy of volumes %s, draining it is blocked until the volumes are rebuilt", node, volumesAnnotation))
+	return nil
+}
+
+// newDrainGuardPod - a pod that does nothing, bound to the node. it uses the MCS image which is already present on the target nodes
+func (r *NVMeshReconciler) newDrainGuardPod(cr *nvmeshv1.NVMesh, node string) *corev1.Pod {
+	labels := r.getOperatorLabels(cr)
+	labels[drainGuardLabelKey] = "true"
+
+	gracePeriod := int64(1)
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      drainGuardPodPrefix + node,
+			Namespace: cr.GetNamespace(),
+			Labels:    labels,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:                      node,
+			ServiceAccountName:            r.getClusterServiceAccountName(cr),
+			ImagePullSecrets:              getImagePullSecrets(cr),
+			TerminationGracePeriodSeconds: &gracePeriod,
+			// the guard should stay on the node until the operator removes it, also when the node is tainted
+			Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers: []corev1.Container{{
+				Name:            drainGuardContainer,
+				Image:           r.getCoreFullImageName(cr, "nvmesh-mcs"),
+				ImagePullPolicy: r.getImagePullPolicy(cr),
+				Command:         []string{"sleep", "infinity"},
+			}},
+		},
+	}
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"context"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Disruption budgets", func() {
+	var (
+		cr    *nvmeshv1.NVMesh
+		r     *NVMeshReconciler
+		mgmtr NVMeshMgmtReconciler
+	)
+
+	BeforeEach(func() {
+		cr = newTestCluster()
+		cr.Spec.Management.Replicas = 3
+		cr.Spec.CSI.ControllerReplicas = 1
+		r = newFakeReconciler(cr)
+		mgmtr = NVMeshMgmtReconciler(*r)
+	})
+
+	It("allows evicting one Management pod at a time", func() {
+		pdb := newTestPDB("nvmesh-management")
+		Expect(mgmtr.InitObject(cr, pdb)).To(Succeed())
+		Expect(pdb.Spec.MinAvailable.IntValue()).To(Equal(2))
+
+		By("updating the budget when the replicas change")
+		cr.Spec.Management.Replicas = 1
+		expected := newTestPDB("nvmesh-management")
+		Expect(mgmtr.InitObject(cr, expected)).To(Succeed())
+		Expect(mgmtr.ShouldUpdateObject(cr, expected, pdb)).To(BeTrue())
+		Expect(mgmtr.ShouldUpdateObject(cr, expected, expected.DeepCopy())).To(BeFalse())
+	})
+
+	It("does not block the single CSI controller", func() {
+		csir := NVMeshCSIReconciler(*r)
+		pdb := newTestPDB("nvmesh-csi-controller")
+		Expect(csir.InitObject(cr, pdb)).To(Succeed())
+		Expect(pdb.Spec.MinAvailable.IntValue()).To(Equal(0))
+	})
+
+	It("keeps the majority of the MongoDB replica set", func() {
+		pdb := newTestPDB("mongo")
+		Expect(mgmtr.InitObject(cr, pdb)).To(Succeed())
+		Expect(pdb.Spec.MinAvailable.IntValue()).To(Equal(0))
+		Expect(pdb.Spec.MaxUnavailable).To(BeNil())
+
+		By("reading the replicas of the deployed StatefulSet")
+		replicas := int32(3)
+		mongo := &appsv1.StatefulSet{}
+		mongo.SetName("mongo")
+		mongo.SetNamespace(TestingNamespace)
+		mongo.Spec.Replicas = &replicas
+		Expect(r.Client.Create(context.TODO(), mongo)).To(Succeed())
+
+		Expect(mgmtr.InitObject(cr, pdb)).To(Succeed())
+		Expect(pdb.Spec.MinAvailable.IntValue()).To(Equal(2))
+	})
+})
+
+var _ = Describe("Drain guard", func() {
+	var (
+		cr       *nvmeshv1.NVMesh
+		r        *NVMeshReconciler
+		api      *fakeManagementAPI
+		recorder *record.FakeRecorder
+		restore  func()
+	)
+
+	getGuards := func() []corev1.Pod {
+		pods := &corev1.PodList{}
+		Expect(r.Client.List(context.TODO(), pods, client.MatchingLabels{drainGuardLabelKey: "true"})).To(Succeed())
+		return pods.Items
+	}
+
+	BeforeEach(func() {
+		cr = newTestCluster()
+
+		api = &fakeManagementAPI{available: true}
+		restore = useFakeManagementAPI(api)
+
+		r = newFakeReconciler(cr, newMgmtAdminSecret(),
+			newTestNode("node1.example.com", map[string]string{corev1.LabelHostname: "node1"}),
+			newTestNode("node2", nil),
+		)
+		recorder = r.EventManager.recorder.(*record.FakeRecorder)
+	})
+
+	AfterEach(func() {
+		restore()
+	})
+
+	It("guards the node with the last healthy copy of a volume", func() {
+		api.volumes = []managementVolume{newTestVolume("vol-1",
+			newTestSegment("node1", "healthy"),
+			newTestSegment("node2", "critical"),
+			managementDiskSegment{NodeID: "node3", Type: "raftonly", Health: "healthy"})}
+
+		result, err := r.reconcileDrainGuard(cr)
+		Expect(err).To(BeNil())
+		Expect(result.RequeueAfter).To(Equal(drainGuardInterval))
+
+		By("binding the guard to the Kubernetes node that matches the Management node ID")
+		guard := &corev1.Pod{}
+		Expect(r.Client.Get(context.TODO(), client.ObjectKey{Name: drainGuardPodPrefix + "node1.example.com", Namespace: TestingNamespace}, guard)).To(Succeed())
+		Expect(guard.Spec.NodeName).To(Equal("node1.example.com"))
+		Expect(guard.GetLabels()).To(HaveKeyWithValue(drainGuardLabelKey, "true"))
+		Expect(guard.GetAnnotations()).To(HaveKeyWithValue(drainGuardVolumesKey, "vol-1"))
+		Expect(recorder.Events).To(HaveLen(1))
+	})
+
+	It("keeps the guard while Management is unavailable and removes it once the volume is rebuilt", func() {
+		volume := newTestVolume("vol-1", newTestSegment("node2", "healthy"), newTestSegment("node1", "critical"))
+		api.volumes = []managementVolume{volume}
+		_, err := r.reconcileDrainGuard(cr)
+		Expect(err).To(BeNil())
+		Expect(getGuards()).To(HaveLen(1))
+
+		api.available = false
+		_, err = r.reconcileDrainGuard(cr)
+		Expect(err).To(BeNil())
+		Expect(getGuards()).To(HaveLen(1))
+
+		api.available = true
+		volume.Chunks[0].Praids[0].DiskSegments[1].Health = "healthy"
+		api.volumes = []managementVolume{volume}
+		_, err = r.reconcileDrainGuard(cr)
+		Expect(err).To(BeNil())
+		Expect(getGuards()).To(BeEmpty())
+	})
+
+	It("does not guard volumes without redundancy", func() {
+		api.volumes = []managementVolume{newTestVolume("concatenated", newTestSegment("node1", "healthy"))}
+		_, err := r.reconcileDrainGuard(cr)
+		Expect(err).To(BeNil())
+		Expect(getGuards()).To(BeEmpty())
+	})
+
+	It("does not guard Management nodes that do not match a Kubernetes node", func() {
+		api.volumes = []managementVolume{newTestVolume("vol-1", newTestSegment("other", "healthy"), newTestSegment("node2", "critical"))}
+		_, err := r.reconcileDrainGuard(cr)
+		Expect(err).To(BeNil())
+		Expect(getGuards()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Management node names", func() {
+	It("matches FQDN and short names in both directions", func() {
+		names := newMgmtNodeNames([]corev1.Node{
+			*newTestNode("node1.example.com", map[string]string{corev1.LabelHostname: "node1"}),
+			*newTestNode("node2", nil),
+		})
+
+		for nodeID, expected := range map[string]string{
+			"node1.example.com": "node1.example.com",
+			"node1":             "node1.example.com",
+			"node1.other.com":   "node1.example.com",
+			"node2.example.com": "node2",
+		} {
+			name, ok := names.NodeName(nodeID)
+			Expect(ok).To(BeTrue(), nodeID)
+			Expect(name).To(Equal(expected), nodeID)
+		}
+	})
+
+	It("does not match a short name shared by two nodes", func() {
+		names := newMgmtNodeNames([]corev1.Node{
+			*newTestNode("node1.a.com", nil),
+			*newTestNode("node1.b.com", nil),
+		})
+
+		_, ok := names.NodeName("node1")
+		Expect(ok).To(BeFalse())
+
+		name, ok := names.NodeName("node1.b.com")
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal("node1.b.com"))
+	})
+})
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"strings"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	formatDriveAction    = "format-drive"
+	evictDriveAction     = "evict-drive"
+	reincludeDriveAction = "reinclude-drive"
+
+	driveNodeKey         = "node"
+	driveSerialNumberKey = "serialNumber"
+	driveDevicePathKey   = "devicePath"
+	driveDiskIDKey       = "diskID"
+	driveResultKey       = "result"
+
+	// the statuses Management reports for drives that do not hold volume data
+	mgmtDiskStatusEvicted  = "Evicted"
+	mgmtDiskStatusExcluded = "Excluded"
+)
+
+// driveActionRequest - the arguments of a drive action
+type driveActionRequest struct {
+	Node         string
+	SerialNumber string
+	DevicePath   string
+}
+
+func (d driveActionRequest) String() string {
+	if d.SerialNumber != "" {
+		return fmt.Sprintf("%s on node %s", d.SerialNumber, d.Node)
+	}
+
+	return fmt.Sprintf("%s on node %s", d.DevicePath, d.Node)
+}
+
+func getDriveActionRequest(a nvmeshv1.ClusterAction) (driveActionRequest, error) {
+	req := driveActionRequest{}
+	req.Node, _ = getActionArg(a, driveNodeKey)
+	req.SerialNumber, _ = getActionArg(a, driveSerialNumberKey)
+	req.DevicePath, _ = getActionArg(a, driveDevicePathKey)
+
+	if req.Node == "" {
+		return req, goerrors.New("the node argument is required")
+	}
+
+	if (req.SerialNumber == "") == (req.DevicePath == "") {
+		return req, fmt.Errorf("exactly one of the arguments %s or %s is required", driveSerialNumberKey, driveDevicePathKey)
+	}
+
+	return req, nil
+}
+
+// findManagementDisks - returns the drives of the node with the serial number or the device path of the request
+func findManagementDisks(servers []managementServer, names *mgmtNodeNames, req driveActionRequest) []managementDisk {
+	disks := make([]managementDisk, 0)
+	for _, server := range servers {
+		if name, ok := names.NodeName(server.NodeID); !ok || name != req.Node {
+			continue
+		}
+
+		for _, disk := range server.Disks {
+			if req.SerialNumber != "" && disk.SerialNumber == req.SerialNumber {
+				disks = append(disks, disk)
+			}
+
+			if req.DevicePath != "" && disk.Path == req.DevicePath {
+				disks = append(disks, disk)
+			}
+		}
+	}
+
+	return disks
+}
+
+// isDiskOutOfUse - returns true if Management does not use the drive for volume data
+func isDiskOutOfUse(disk managementDisk) bool {
+	return strings.EqualFold(disk.Status, mgmtDiskStatusEvicted) || strings.EqualFold(disk.Status, mgmtDiskStatusExcluded)
+}
+
+// getVolumesOnDrive - returns the volumes with data on the drive, and the volumes for which the drive holds the last healthy copy
+func getVolumesOnDrive(volumes []managementVolume, diskID string) ([]string, []string) {
+	withData := make([]string, 0)
+	lastCopy := make([]string, 0)
+	for _, volume := range volumes {
+		hasData, isLastCopy := false, false
+		for _, chunk := range volume.Chunks {
+			for _, praid := range chunk.Praids {
+				for _, segment := range praid.DiskSegments {
+					if isDataSegment(segment) && segment.DiskID == diskID {
+						hasData = true
+					}
+				}
+
+				if segment, ok := getLastHealthyCopy(praid); ok && segment.DiskID == diskID {
+					isLastCopy = true
+				}
+			}
+		}
+
+		if hasData {
+			withData = append(withData, volume.Name)
+		}
+
+		if isLastCopy {
+			lastCopy = append(lastCopy, volume.Name)
+		}
+	}
+
+	return withData, lastCopy
+}
+
+// isDriveExcludedInSpec - a drive excluded in spec.core.excludeDrives or in a node override should not be formatted or reincluded from an action
+func (r *NVMeshReconciler) isDriveExcludedInSpec(cr *nvmeshv1.NVMesh, req driveActionRequest, disk managementDisk) (bool, error) {
+	node := &corev1.Node{}
+	if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: req.Node}, node); err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("Failed to get node %s", req.Node))
+	}
+
+	nodeConfig, err := getNodeCoreConfig(cr, req.Node, node.GetLabels(), nil)
+	if err != nil {
+		return false, err
+	}
+
+	for _, excluded := range getExcludedDrives(nodeConfig) {
+		if excluded == req.SerialNumber || excluded == req.DevicePath || excluded == disk.SerialNumber || excluded == disk.Path {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// checkDriveAction - returns the reason the action is not safe on the drive, or an empty string
+func (r *NVMeshReconciler) checkDriveAction(cr *nvmeshv1.NVMesh, actionName nvmeshv1.ActionName, req driveActionRequest, disk managementDisk, volumes []managementVolume) (string, error) {
+	withData, lastCopy := getVolumesOnDrive(volumes, disk.DiskID)
+
+	switch actionName {
+	case formatDriveAction, reincludeDriveAction:
+		excluded, err := r.isDriveExcludedInSpec(cr, req, disk)
+		if err != nil {
+			return "", err
+		}
+
+		if excluded {
+			return fmt.Sprintf("drive %s is excluded in spec.core.excludeDrives", req), nil
+		}
+
+		if actionName == formatDriveAction && !isDiskOutOfUse(disk) {
+			return fmt.Sprintf("drive %s is in status %q, only an evicted or excluded drive can be formatted", req, disk.Status), nil
+		}
+
+		if actionName == formatDriveAction && len(withData) > 0 {
+			return fmt.Sprintf("drive %s holds data of volumes %v, evict it first", req, withData), nil
+		}
+	case evictDriveAction:
+		if len(lastCopy) > 0 {
+			return fmt.Sprintf("drive %s holds the last healthy copy of volumes %v", req, lastCopy), nil
+		}
+	}
+
+	return "", nil
+}
+
+// driveAction - formats, evicts or reincludes a single drive through Management after checking that it is safe
+type driveAction struct {
+	actionOptions
+}
+
+func (driveAction) Validate(r *NVMeshReconciler, cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error {
+	if _, err := getDriveActionRequest(a); err != nil {
+		return err
+	}
+
+	if cr.Spec.Core.Disabled || cr.Spec.Management.Disabled {
+		return goerrors.New("NVMesh Core and Management must be deployed by the operator")
+	}
+
+	return nil
+}
+
+func (driveAction) Stages(r *NVMeshReconciler, a nvmeshv1.ClusterAction) []Task {
+	return []Task{
+		{string(a.Name), func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return DoNotRequeue(), r.runDriveAction(cr, a)
+		}},
+	}
+}
+
+func (r *NVMeshReconciler) runDriveAction(cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error {
+	failAction := func(message string) error {
+		r.setTaskStatus(cr, a, driveResultKey, message)
+		return newActionFailure("%s", message)
+	}
+
+	req, err := getDriveActionRequest(a)
+	if err != nil {
+		return failAction(err.Error())
+	}
+
+	names, err := r.getMgmtNodeNames()
+	if err != nil {
+		return err
+	}
+
+	api, err := r.loginToManagement(cr)
+	if err != nil {
+		return err
+	}
+
+	servers, err := api.GetServers()
+	if err != nil {
+		return err
+	}
+
+	disks := findManagementDisks(servers, names, req)
+	if len(disks) == 0 {
+		return failAction(fmt.Sprintf("drive %s was not found in Management", req))
+	}
+
+	if len(disks) > 1 {
+		ids := make([]string, 0, len(disks))
+		for _, disk := range disks {
+			ids = append(ids, disk.DiskID)
+		}
+
+		return failAction(fmt.Sprintf("drive %s matches more than one drive in Management: %s", req, strings.Join(ids, ", ")))
+	}
+
+	disk := disks[0]
+
+	r.setTaskStatus(cr, a, driveDiskIDKey, disk.DiskID)
+
+	volumes, err := api.GetVolumes()
+	if err != nil {
+		return err
+	}
+
+	reason, err := r.checkDriveAction(cr, a.Name, req, disk, volumes)
+	if err != nil {
+		return err
+	}
+
+	if reason != "" {
+		return failAction(reason)
+	}
+
+	var eventReason string
+	switch a.Name {
+	case formatDriveAction:
+		eventReason = "DriveFormatted"
+		err = api.FormatDrive(disk.DiskID)
+	case evictDriveAction:
+		eventReason = "DriveEvicted"
+		err = api.EvictDrive(disk.DiskID)
+	case reincludeDriveAction:
+		eventReason = "DriveReincluded"
+		err = api.ReincludeDrive(disk.DiskID)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r.setTaskStatus(cr, a, driveResultKey, eventReason)
+	r.EventManager.Normal(cr, eventReason, fmt.Sprintf("%s finished for drive %s (%s)", a.Name, req, disk.DiskID))
+	return nil
+}
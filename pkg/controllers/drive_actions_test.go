@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/tools/record"
+)
+
+func newDriveAction(name nvmeshv1.ActionName, id string, args map[string]string) nvmeshv1.ClusterAction {
+	args["node"] = "node1"
+	return nvmeshv1.ClusterAction{Name: name, ID: id, Args: args}
+}
+
+var _ = Describe("Drive actions", func() {
+	var (
+		cr       *nvmeshv1.NVMesh
+		r        *NVMeshReconciler
+		api      *fakeManagementAPI
+		recorder *record.FakeRecorder
+		restore  func()
+	)
+
+	BeforeEach(func() {
+		cr = newTestCluster()
+		cr.Spec.Core.ExcludeDrives = &nvmeshv1.ExcludeNVMeDrivesSpec{DevicePaths: []string{"/dev/nvme2n1"}}
+
+		api = &fakeManagementAPI{available: true}
+		api.servers = []managementServer{
+			{NodeID: "node1.example.com", Health: "healthy", Disks: []managementDisk{
+				{DiskID: "nvme.SERIAL0-1", SerialNumber: "SERIAL0", Path: "/dev/nvme0n1", Status: "Ok"},
+				{DiskID: "nvme.SERIAL1-1", SerialNumber: "SERIAL1", Path: "/dev/nvme1n1", Status: "Excluded"},
+				{DiskID: "nvme.SERIAL2-1", SerialNumber: "SERIAL2", Path: "/dev/nvme2n1", Status: "Evicted"},
+				{DiskID: "nvme.SERIAL10-1", SerialNumber: "SERIAL10", Path: "/dev/nvme3n1", Status: "Evicted"},
+			}},
+			{NodeID: "node2", Health: "healthy", Disks: []managementDisk{
+				{DiskID: "nvme.SERIAL0-1", SerialNumber: "SERIAL0", Path: "/dev/nvme0n1", Status: "Ok"},
+			}},
+		}
+		api.volumes = []managementVolume{newTestVolume("vol-1",
+			managementDiskSegment{NodeID: "node1.example.com", DiskID: "nvme.SERIAL0-1", Type: "data", Health: "healthy"},
+			managementDiskSegment{NodeID: "node2", DiskID: "nvme.SERIAL9-1", Type: "data", Health: "critical"},
+		)}
+		restore = useFakeManagementAPI(api)
+
+		r = newFakeReconciler(cr, newTestNode("node1", map[string]string{nvmeshTargetLabelKey: ""}), newTestNode("node2", nil), newMgmtAdminSecret())
+		recorder = r.EventManager.recorder.(*record.FakeRecorder)
+	})
+
+	AfterEach(func() {
+		restore()
+	})
+
+	It("rejects an action without a drive", func() {
+		done, _, err := r.handleAction(newDriveAction(evictDriveAction, "evict-1", map[string]string{}), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(HaveOccurred())
+		Expect(recorder.Events).To(Receive(ContainSubstring("ActionFailed")))
+	})
+
+	It("matches the serial number exactly on the node of the request", func() {
+		evict := newDriveAction(evictDriveAction, "evict-1", map[string]string{"serialNumber": "SERIAL1"})
+		done, _, err := r.handleAction(evict, cr)
+		Expect(done).To(BeTrue())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(api.drives).To(Equal([]string{"evict:nvme.SERIAL1-1"}))
+		Expect(r.getTaskStatus(cr, evict, driveResultKey)).To(Equal("DriveEvicted"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("DriveEvicted")))
+
+		By("not matching a serial number that is part of another")
+		done, _, err = r.handleAction(newDriveAction(evictDriveAction, "evict-2", map[string]string{"serialNumber": "SERIAL"}), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("was not found")))
+	})
+
+	It("fails when more than one drive matches", func() {
+		api.servers[0].Disks = append(api.servers[0].Disks, managementDisk{DiskID: "nvme.SERIAL1-2", SerialNumber: "SERIAL1", Path: "/dev/nvme4n1"})
+
+		done, _, err := r.handleAction(newDriveAction(evictDriveAction, "evict-1", map[string]string{"serialNumber": "SERIAL1"}), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("nvme.SERIAL1-1, nvme.SERIAL1-2")))
+		Expect(api.drives).To(BeEmpty())
+	})
+
+	It("refuses to evict the last healthy copy of a volume", func() {
+		evict := newDriveAction(evictDriveAction, "evict-1", map[string]string{"serialNumber": "SERIAL0"})
+		done, _, err := r.handleAction(evict, cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("last healthy copy of volumes [vol-1]")))
+		Expect(r.getTaskStatus(cr, evict, driveDiskIDKey)).To(Equal("nvme.SERIAL0-1"))
+		Expect(api.drives).To(BeEmpty())
+
+		By("evicting the drive once the volume is rebuilt")
+		api.volumes[0].Chunks[0].Praids[0].DiskSegments[1].Health = "healthy"
+		evict.ID = "evict-2"
+		done, _, err = r.handleAction(evict, cr)
+		Expect(done).To(BeTrue())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(api.drives).To(Equal([]string{"evict:nvme.SERIAL0-1"}))
+	})
+
+	It("formats only an evicted or excluded drive", func() {
+		done, _, err := r.handleAction(newDriveAction(formatDriveAction, "format-1", map[string]string{"devicePath": "/dev/nvme0n1"}), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("only an evicted or excluded drive can be formatted")))
+
+		By("formatting an excluded drive")
+		done, _, err = r.handleAction(newDriveAction(formatDriveAction, "format-2", map[string]string{"devicePath": "/dev/nvme1n1"}), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(api.drives).To(Equal([]string{"format:nvme.SERIAL1-1"}))
+	})
+
+	It("refuses to format an evicted drive that still holds data", func() {
+		api.volumes[0].Chunks[0].Praids[0].DiskSegments[0].DiskID = "nvme.SERIAL10-1"
+
+		done, _, err := r.handleAction(newDriveAction(formatDriveAction, "format-1", map[string]string{"serialNumber": "SERIAL10"}), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("evict it first")))
+		Expect(api.drives).To(BeEmpty())
+	})
+
+	It("refuses to reinclude a drive excluded in the spec", func() {
+		done, _, err := r.handleAction(newDriveAction(reincludeDriveAction, "reinclude-1", map[string]string{"serialNumber": "SERIAL2"}), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("excluded in spec.core.excludeDrives")))
+		Expect(api.drives).To(BeEmpty())
+	})
+
+	It("retries while Management is unavailable", func() {
+		api.available = false
+
+		done, result, err := r.handleAction(newDriveAction(evictDriveAction, "evict-1", map[string]string{"serialNumber": "SERIAL1"}), cr)
+		Expect(done).To(BeFalse())
+		Expect(err).To(HaveOccurred())
+		Expect(result.Requeue).To(BeTrue())
+	})
+})
@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	dynamicWatchEventsBufferSize = 100
+	dynamicWatchRetryInterval    = 5 * time.Second
+)
+
+// DynamicWatchRegistry - Keeps a single watch for each GroupVersionKind and namespace of the objects created using the dynamic client (i.e. the MongoDB CustomResource).
+// Events on watched objects are mapped to the NVMesh cluster that owns them and are sent on a channel that is consumed by the NVMesh controller
+type DynamicWatchRegistry struct {
+	mutex         sync.Mutex
+	log           logr.Logger
+	dynamicClient dynamic.Interface
+	ctx           context.Context
+	cancel        context.CancelFunc
+	events        chan event.GenericEvent
+
+	// one running watch per GVK and namespace
+	watches map[dynamicWatchKey]context.CancelFunc
+
+	// maps a watched object to the NVMesh cluster that owns it
+	owners map[schema.GroupVersionKind]map[types.NamespacedName]types.NamespacedName
+}
+
+// dynamicWatchKey - identifies a watch, the namespace is empty for cluster scoped kinds
+type dynamicWatchKey struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+}
+
+// NewDynamicWatchRegistry - creates a new DynamicWatchRegistry
+func NewDynamicWatchRegistry(dynamicClient dynamic.Interface, log logr.Logger) *DynamicWatchRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &DynamicWatchRegistry{
+		log:           log,
+		dynamicClient: dynamicClient,
+		ctx:           ctx,
+		cancel:        cancel,
+		events:        make(chan event.GenericEvent, dynamicWatchEventsBufferSize),
+		watches:       make(map[dynamicWatchKey]context.CancelFunc),
+		owners:        make(map[schema.GroupVersionKind]map[types.NamespacedName]types.NamespacedName),
+	}
+}
+
+// Events - returns the channel on which reconcile requests for the owning NVMesh clusters are sent
+func (d *DynamicWatchRegistry) Events() <-chan event.GenericEvent {
+	return d.events
+}
+
+// Start - implements manager.Runnable, all watches are stopped when the manager is stopped
+func (d *DynamicWatchRegistry) Start(ctx context.Context) error {
+	<-ctx.Done()
+	d.cancel()
+	return nil
+}
+
+// Watch - registers an object owned by an NVMesh cluster, a watch for the object's GVK in the object's namespace is started if one is not already running
+func (d *DynamicWatchRegistry) Watch(gvk schema.GroupVersionKind, gvr schema.GroupVersionResource, obj types.NamespacedName, owner types.NamespacedName) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.owners[gvk]; !ok {
+		d.owners[gvk] = make(map[types.NamespacedName]types.NamespacedName)
+	}
+
+	d.owners[gvk][obj] = owner
+
+	key := dynamicWatchKey{gvk: gvk, namespace: obj.Namespace}
+	if _, ok := d.watches[key]; ok {
+		return
+	}
+
+	watchCtx, cancel := context.WithCancel(d.ctx)
+	d.watches[key] = cancel
+
+	resource := d.dynamicClient.Resource(gvr)
+	var res dynamic.ResourceInterface = resource
+	if obj.Namespace != "" {
+		res = resource.Namespace(obj.Namespace)
+	}
+
+	d.log.Info(fmt.Sprintf("Starting watch on %s in namespace '%s'", gvk, obj.Namespace))
+	go d.runWatch(watchCtx, gvk, res)
+}
+
+// Unwatch - removes an object from the registry, the watch for the object's GVK and namespace is stopped when no more objects of that kind are registered in the namespace
+func (d *DynamicWatchRegistry) Unwatch(gvk schema.GroupVersionKind, obj types.NamespacedName) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	objects, ok := d.owners[gvk]
+	if !ok {
+		return
+	}
+
+	delete(objects, obj)
+
+	for key := range objects {
+		if key.Namespace == obj.Namespace {
+			return
+		}
+	}
+
+	if len(objects) == 0 {
+		delete(d.owners, gvk)
+	}
+
+	key := dynamicWatchKey{gvk: gvk, namespace: obj.Namespace}
+	if cancel, ok := d.watches[key]; ok {
+		d.log.Info(fmt.Sprintf("Stopping watch on %s in namespace '%s'", gvk, obj.Namespace))
+		cancel()
+		delete(d.watches, key)
+	}
+}
+
+func (d *DynamicWatchRegistry) getOwner(gvk schema.GroupVersionKind, obj *unstructured.Unstructured) (types.NamespacedName, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}
+	if owner, ok := d.owners[gvk][key]; ok {
+		return owner, true
+	}
+
+	return types.NamespacedName{}, false
+}
+
+func (d *DynamicWatchRegistry) runWatch(ctx context.Context, gvk schema.GroupVersionKind, res dynamic.ResourceInterface) {
+	log := d.log.WithValues("gvk", gvk.String())
+	resourceVersion := ""
+
+	for {
+		w, err := res.Watch(ctx, metav1.ListOptions{ResourceVersion: resourceVersion})
+		if err != nil {
+			log.Info(fmt.Sprintf("Failed to watch %s, will retry. Error: %s", gvk.Kind, err))
+		} else {
+			resourceVersion = d.consumeEvents(ctx, gvk, w, resourceVersion)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dynamicWatchRetryInterval):
+		}
+	}
+}
+
+// consumeEvents - reads events until the watch is closed by the server or the context is cancelled, and returns the last resourceVersion seen
+func (d *DynamicWatchRegistry) consumeEvents(ctx context.Context, gvk schema.GroupVersionKind, w watch.Interface, resourceVersion string) string {
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion
+		case e, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion
+			}
+
+			if e.Type == watch.Error {
+				// the resourceVersion is probably too old, start over from the current state
+				return ""
+			}
+
+			obj, ok := e.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+
+			resourceVersion = obj.GetResourceVersion()
+
+			owner, found := d.getOwner(gvk, obj)
+			if !found {
+				continue
+			}
+
+			d.log.V(VerboseLogging).Info(fmt.Sprintf("received Event %s on %s %s, enqueueing NVMesh %s", e.Type, gvk.Kind, obj.GetName(), owner))
+
+			cr := &nvmeshv1.NVMesh{}
+			cr.SetName(owner.Name)
+			cr.SetNamespace(owner.Namespace)
+
+			select {
+			case d.events <- event.GenericEvent{Object: cr}:
+			case <-ctx.Done():
+				return resourceVersion
+			}
+		}
+	}
+}
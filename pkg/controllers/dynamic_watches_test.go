@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Dynamic watches", func() {
+	var (
+		client   *dynamicfake.FakeDynamicClient
+		registry *DynamicWatchRegistry
+		gvk      = schema.GroupVersionKind{Group: "mongodbcommunity.mongodb.com", Version: "v1", Kind: "MongoDBCommunity"}
+		gvr      = schema.GroupVersionResource{Group: "mongodbcommunity.mongodb.com", Version: "v1", Resource: "mongodbcommunity"}
+		owner    = types.NamespacedName{Name: "cluster1", Namespace: TestingNamespace}
+	)
+
+	getWatchedNamespaces := func() []string {
+		namespaces := make([]string, 0)
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "watch" {
+				namespaces = append(namespaces, action.GetNamespace())
+			}
+		}
+
+		return namespaces
+	}
+
+	BeforeEach(func() {
+		client = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{gvr: "MongoDBCommunityList"})
+		registry = NewDynamicWatchRegistry(client, logf.Log)
+	})
+
+	AfterEach(func() {
+		registry.cancel()
+	})
+
+	It("watches namespaced objects in their namespace only", func() {
+		registry.Watch(gvk, gvr, types.NamespacedName{Name: "mongo", Namespace: TestingNamespace}, owner)
+		registry.Watch(gvk, gvr, types.NamespacedName{Name: "mongo-2", Namespace: TestingNamespace}, owner)
+		Eventually(getWatchedNamespaces).Should(Equal([]string{TestingNamespace}))
+
+		By("starting another watch for an object in another namespace")
+		registry.Watch(gvk, gvr, types.NamespacedName{Name: "mongo", Namespace: "other"}, owner)
+		Eventually(getWatchedNamespaces).Should(ConsistOf(TestingNamespace, "other"))
+		Expect(registry.watches).To(HaveLen(2))
+
+		By("stopping the watch of a namespace when its last object is removed")
+		registry.Unwatch(gvk, types.NamespacedName{Name: "mongo", Namespace: TestingNamespace})
+		Expect(registry.watches).To(HaveLen(2))
+		registry.Unwatch(gvk, types.NamespacedName{Name: "mongo-2", Namespace: TestingNamespace})
+		Expect(registry.watches).To(HaveKey(dynamicWatchKey{gvk: gvk, namespace: "other"}))
+		Expect(registry.watches).To(HaveLen(1))
+	})
+
+	It("enqueues the owning NVMesh on events of a registered object", func() {
+		registry.Watch(gvk, gvr, types.NamespacedName{Name: "mongo", Namespace: TestingNamespace}, owner)
+		Eventually(getWatchedNamespaces).Should(HaveLen(1))
+
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		obj.SetName("mongo")
+		obj.SetNamespace(TestingNamespace)
+		_, err := client.Resource(gvr).Namespace(TestingNamespace).Create(context.TODO(), obj, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		var e event.GenericEvent
+		Eventually(registry.Events()).Should(Receive(&e))
+		Expect(e.Object.GetName()).To(Equal(owner.Name))
+		Expect(e.Object.GetNamespace()).To(Equal(owner.Namespace))
+	})
+})
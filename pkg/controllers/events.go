@@ -3,6 +3,7 @@ package controllers
 import (
 	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
@@ -25,6 +26,16 @@ func (e *EventManager) Warning(cr *nvmeshv1.NVMesh, reason string, message strin
 	e.recorder.Event(cr, "Warning", reason, message)
 }
 
+//NormalOnObject - create an event with type Normal on any object (i.e. a Node)
+func (e *EventManager) NormalOnObject(obj runtime.Object, reason string, message string) {
+	e.recorder.Event(obj, "Normal", reason, message)
+}
+
+//WarningOnObject - create an event with type Warning on any object (i.e. a Node)
+func (e *EventManager) WarningOnObject(obj runtime.Object, reason string, message string) {
+	e.recorder.Event(obj, "Warning", reason, message)
+}
+
 //NewEventManager - create a new EventManager to update events on objects
 func NewEventManager(config *rest.Config) (*EventManager, error) {
 	recorder, err := getEventRecorder(config)
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	goerrors "errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+)
+
+const (
+	// extraConfigKeysAnnotation - the nvmesh.conf keys that were rendered from extraConfig, used to remove keys that were removed from the CR
+	extraConfigKeysAnnotation = "nvmesh.excelero.com/extra-config-keys"
+	configProfileKeyPrefix    = "CONFIG_PROFILE_"
+)
+
+var (
+	nvmeshConfKeyPattern      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	verboseLoggingTypePattern = regexp.MustCompile(`^[A-Za-z0-9_*]+>[A-Za-z0-9_*]+$`)
+	configProfileIDPattern    = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	defaultLoggingLevels      = []string{"ERROR", "WARNING", "INFO", "DEBUG", "VERBOSE"}
+	agentLoggingLevels        = []string{"CRITICAL", "ERROR", "WARNING", "INFO", "DEBUG"}
+	nvmeshConfBooleanValues   = []string{"True", "False"}
+)
+
+// knownConfigKeys - validators for nvmesh.conf keys with a known format
+var knownConfigKeys = map[string]func(string) error{
+	"MCS_LOGGING_LEVEL":         oneOfValues(defaultLoggingLevels),
+	"AGENT_LOGGING_LEVEL":       oneOfValues(agentLoggingLevels),
+	"MCS_LOG_TO_STDOUT":         oneOfValues(nvmeshConfBooleanValues),
+	"AGENT_LOG_TO_STDOUT":       oneOfValues(nvmeshConfBooleanValues),
+	"MCS_LOGGING_VERBOSE_TYPES": validateVerboseLoggingTypes,
+	"CONFIG_PROFILE_ID":         validateConfigProfileID,
+	"CONFIG_PROFILE_NAME":       validateNotEmpty,
+	"CONFIG_PROFILE_VERSION":    validatePositiveInteger,
+}
+
+// operatorManagedConfigKeys - nvmesh.conf keys that are set by the operator and can not be set using extraConfig
+var operatorManagedConfigKeys = map[string]string{
+	"K8S_ENV":               "It is required when running on Kubernetes.",
+	"MANAGEMENT_SERVERS":    "It is derived from spec.management.replicas.",
+	"TCP_ENABLED":           "Use spec.core.tcpOnly instead.",
+	"IPV4_ONLY":             "Use spec.core.tcpOnly instead.",
+	"CONFIGURED_NICS":       "Use configuredNICs instead.",
+	"EXCLUDE_DRIVE_SERIALS": "Use excludeDrives.serialNumbers instead.",
+	"EXCLUDE_DEVICE_PATHS":  "Use excludeDrives.devicePaths instead.",
+}
+
+// validateExtraConfig - verifies that the keys and values in extraConfig can be written to nvmesh.conf and that the values of known keys are valid
+func validateExtraConfig(cr *nvmeshv1.NVMesh, field string, extraConfig map[string]string) error {
+	for key, value := range extraConfig {
+		if !nvmeshConfKeyPattern.MatchString(key) {
+			return validationError(cr, fmt.Sprintf("Invalid nvmesh.conf key %q in %s.", key, field), "Keys may contain only letters, digits and underscores.")
+		}
+
+		if reason, ok := operatorManagedConfigKeys[key]; ok {
+			return validationError(cr, fmt.Sprintf("The nvmesh.conf key %s in %s is managed by the operator.", key, field), reason)
+		}
+
+		if strings.ContainsAny(value, "\n\r") {
+			return validationError(cr, fmt.Sprintf("Invalid value for %s in %s.", key, field), "Values must be a single line.")
+		}
+
+		validate, known := knownConfigKeys[key]
+		if !known && strings.HasPrefix(key, configProfileKeyPrefix) {
+			return validationError(cr, fmt.Sprintf("Unknown config profile key %s in %s.", key, field), "Supported keys are CONFIG_PROFILE_ID, CONFIG_PROFILE_NAME and CONFIG_PROFILE_VERSION.")
+		}
+
+		if known {
+			if err := validate(value); err != nil {
+				return validationError(cr, fmt.Sprintf("Invalid value %q for %s in %s.", value, key, field), err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+func oneOfValues(allowed []string) func(string) error {
+	return func(value string) error {
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+
+		return goerrors.New(fmt.Sprintf("Expected one of %s.", strings.Join(allowed, ", ")))
+	}
+}
+
+func validateVerboseLoggingTypes(value string) error {
+	for _, t := range strings.Split(value, ",") {
+		if !verboseLoggingTypePattern.MatchString(strings.TrimSpace(t)) {
+			return goerrors.New("Expected a comma seperated list of <source>><destination> pairs, i.e. \"MGMT>*,*>MGMT\".")
+		}
+	}
+
+	return nil
+}
+
+func validateConfigProfileID(value string) error {
+	if !configProfileIDPattern.MatchString(value) {
+		return goerrors.New("Expected a non empty value containing only letters, digits, '.', '-' and '_'.")
+	}
+
+	return nil
+}
+
+func validateNotEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return goerrors.New("Expected a non empty value.")
+	}
+
+	return nil
+}
+
+func validatePositiveInteger(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return goerrors.New("Expected a positive integer.")
+	}
+
+	return nil
+}
+
+func extraConfigKeysToString(keys map[string]bool) string {
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+
+	sort.Strings(sortedKeys)
+	return strings.Join(sortedKeys, ",")
+}
+
+func extraConfigKeysFromString(value string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range strings.Split(value, ",") {
+		if key != "" {
+			keys[key] = true
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Extra config", func() {
+	It("validates the keys and values of extraConfig", func() {
+		cr := newTestCluster()
+
+		valid := map[string]string{
+			"MCS_LOGGING_LEVEL":         "DEBUG",
+			"AGENT_LOGGING_LEVEL":       "CRITICAL",
+			"MCS_LOGGING_VERBOSE_TYPES": "MGMT>*, *>MGMT",
+			"CONFIG_PROFILE_ID":         "rack_a",
+			"CONFIG_PROFILE_VERSION":    "3",
+			"SOME_OTHER_KEY":            "any \"value\" $HOME",
+		}
+		Expect(validateExtraConfig(cr, "spec.core.extraConfig", valid)).To(BeNil())
+
+		invalid := []map[string]string{
+			{"MCS_LOGGING_LEVEL": "LOUD"},
+			{"MCS_LOGGING_VERBOSE_TYPES": "MGMT"},
+			{"CONFIG_PROFILE_VERSION": "0"},
+			{"CONFIG_PROFILE_COLOR": "blue"},
+			{"MANAGEMENT_SERVERS": "mgmt:4001"},
+			{"BAD-KEY": "1"},
+			{"SOME_KEY": "two\nlines"},
+		}
+
+		for _, extraConfig := range invalid {
+			Expect(validateExtraConfig(cr, "spec.core.extraConfig", extraConfig)).NotTo(BeNil(), "%v", extraConfig)
+		}
+	})
+
+	It("quotes extraConfig values and removes keys that were removed from the CR", func() {
+		cr := newTestCluster()
+		cr.Spec.Core.ExtraConfig = map[string]string{"MY_KEY": "a \"b\" $c", "MCS_LOGGING_LEVEL": "INFO"}
+
+		r := newFakeReconciler(cr)
+		corer := NVMeshCoreReconciler(*r)
+		baseConf := "K8S_ENV=\"True\"\nMCS_LOGGING_LEVEL=\"VERBOSE\""
+
+		current := &corev1.ConfigMap{Data: map[string]string{nvmeshConfKey: baseConf}}
+		Expect(corer.initCoreConfigMap(cr, current)).To(BeNil())
+		Expect(current.Data[nvmeshConfKey]).To(ContainSubstring(`MY_KEY="a \"b\" \$c"`))
+		Expect(current.Data[nvmeshConfKey]).To(ContainSubstring(`MCS_LOGGING_LEVEL="INFO"`))
+		Expect(current.GetAnnotations()[extraConfigKeysAnnotation]).To(Equal("MCS_LOGGING_LEVEL,MY_KEY"))
+
+		By("a parameter that was added manually is kept")
+		current.Data[nvmeshConfKey] += "\nUSER_KEY=1"
+
+		By("keys removed from the CR are removed, and overridden keys return to their default")
+		cr.Spec.Core.ExtraConfig = nil
+		expected := &corev1.ConfigMap{Data: map[string]string{nvmeshConfKey: baseConf}}
+		Expect(corer.initCoreConfigMap(cr, expected)).To(BeNil())
+		Expect(corer.shouldUpdateCoreConfigMap(cr, expected, current)).To(BeTrue())
+		Expect(expected.Data[nvmeshConfKey]).NotTo(ContainSubstring("MY_KEY"))
+		Expect(expected.Data[nvmeshConfKey]).To(ContainSubstring(`MCS_LOGGING_LEVEL="VERBOSE"`))
+		Expect(expected.Data[nvmeshConfKey]).To(ContainSubstring("USER_KEY=1"))
+		Expect(expected.GetAnnotations()).NotTo(HaveKey(extraConfigKeysAnnotation))
+
+		Expect(corer.shouldUpdateCoreConfigMap(cr, expected.DeepCopy(), expected)).To(BeFalse())
+	})
+})
@@ -96,7 +96,7 @@ func (r *NVMeshReconciler) doBeforeDeletingNVMesh(nvmeshCluster *nvmeshv1.NVMesh
 		err := r.Client.Status().Update(context.TODO(), nvmeshCluster)
 		if err != nil {
 			log := r.Log.WithName("DoBeforeDeletingNVMesh")
-			uninstallStatus := nvmeshCluster.Status.ActionsStatus[uninstallAction.Name]
+			uninstallStatus := nvmeshCluster.Status.ActionsStatus[actionStatusKey(uninstallAction)]
 			log.Info(fmt.Sprintf("Failed to update uninstall status with %+v", uninstallStatus))
 		}
 
@@ -19,7 +19,8 @@ import (
 )
 
 const (
-	registryCredSecretName = "excelero-registry-cred"
+	// Job progress triggers a reconcile through the Jobs watch, this requeue is only a safety net in case an event was missed
+	jobProgressSafetyRequeue = 30 * time.Second
 )
 
 func (r *NVMeshBaseReconciler) waitForJobToFinish(cr *nvmeshv1.NVMesh, jobName string) (ctrl.Result, error) {
@@ -46,7 +47,7 @@ func (r *NVMeshBaseReconciler) waitForJobToFinishWithoutCR(namespace string, job
 	if !completed {
 		r.Log.Info(fmt.Sprintf("Waiting for %s to finish", job.ObjectMeta.GetName()))
 		r.monitorJob(jobName, namespace, debug)
-		return Requeue(time.Second), nil
+		return Requeue(jobProgressSafetyRequeue), nil
 	}
 
 	return DoNotRequeue(), nil
@@ -127,7 +128,7 @@ func (r *NVMeshBaseReconciler) getNewJob(cr *nvmeshv1.NVMesh, jobName string, im
 				},
 				Spec: v1.PodSpec{
 					ServiceAccountName: r.getClusterServiceAccountName(cr),
-					ImagePullSecrets:   []v1.LocalObjectReference{{Name: registryCredSecretName}},
+					ImagePullSecrets:   getImagePullSecrets(cr),
 					RestartPolicy:      corev1.RestartPolicyOnFailure,
 					Containers: []v1.Container{
 						{
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+)
+
+// knownKernelModules - the kernel modules loaded by the NVMesh driver container
+var knownKernelModules = map[string]string{
+	"nvmeibc": "NVMesh client",
+	"nvmeibs": "NVMesh target",
+	"siw":     "soft iWARP used for TCP",
+}
+
+var (
+	moduleNamePattern       = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	moduleParamNamePattern  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	moduleParamValuePattern = regexp.MustCompile(`^[^\s"'#\\]+$`)
+)
+
+// validateKernelModules - verifies that spec.core.modules refers to known modules and can be rendered into valid options lines
+func validateKernelModules(cr *nvmeshv1.NVMesh) error {
+	for module, params := range cr.Spec.Core.Modules {
+		field := fmt.Sprintf("spec.core.modules.%s", module)
+
+		if _, ok := knownKernelModules[module]; !ok {
+			return validationError(cr, fmt.Sprintf("Unknown kernel module in %s.", field), fmt.Sprintf("Supported modules are %s.", strings.Join(getKnownKernelModules(), ", ")))
+		}
+
+		for param, value := range params {
+			if !moduleParamNamePattern.MatchString(param) {
+				return validationError(cr, fmt.Sprintf("Invalid parameter name %q in %s.", param, field), "Parameter names may contain only letters, digits and underscores.")
+			}
+
+			if !moduleParamValuePattern.MatchString(value) {
+				return validationError(cr, fmt.Sprintf("Invalid value %q for %s.%s.", value, field, param), "Values must not be empty and must not contain whitespace, quotes, '#' or '\\'.")
+			}
+		}
+	}
+
+	if err := validateModuleParams(cr, "spec.core.moduleParams", cr.Spec.Core.ModuleParams); err != nil {
+		return err
+	}
+
+	for i, override := range cr.Spec.Core.NodeOverrides {
+		if err := validateModuleParams(cr, fmt.Sprintf("spec.core.nodeOverrides[%d].moduleParams", i), override.ModuleParams); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateModuleParams - verifies that free text module parameters contain only comments and options lines.
+// other modprobe.d commands are refused, install and remove run shell commands when the modules are loaded
+func validateModuleParams(cr *nvmeshv1.NVMesh, field string, moduleParams string) error {
+	for i, line := range strings.Split(moduleParams, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		invalidLine := fmt.Sprintf("Invalid line %d in %s: %q.", i+1, field, strings.TrimSpace(line))
+		if fields[0] != "options" {
+			return validationError(cr, invalidLine, "Only options lines and comments are allowed.")
+		}
+
+		if len(fields) < 3 || !moduleNamePattern.MatchString(fields[1]) {
+			return validationError(cr, invalidLine, "Expected options <module> <parameter>=<value>.")
+		}
+
+		for _, option := range fields[2:] {
+			parts := strings.SplitN(option, "=", 2)
+			if len(parts) != 2 || !moduleParamNamePattern.MatchString(parts[0]) || !moduleParamValuePattern.MatchString(parts[1]) {
+				return validationError(cr, invalidLine, fmt.Sprintf("Invalid parameter %q, expected <parameter>=<value>.", option))
+			}
+		}
+	}
+
+	return nil
+}
+
+func getKnownKernelModules() []string {
+	modules := make([]string, 0, len(knownKernelModules))
+	for module := range knownKernelModules {
+		modules = append(modules, module)
+	}
+
+	sort.Strings(modules)
+	return modules
+}
+
+// renderModuleOptions - renders spec.core.modules as modprobe.d options lines, one line per module
+func renderModuleOptions(modules map[string]map[string]string) string {
+	moduleNames := make([]string, 0, len(modules))
+	for module := range modules {
+		moduleNames = append(moduleNames, module)
+	}
+
+	sort.Strings(moduleNames)
+
+	lines := make([]string, 0, len(modules))
+	for _, module := range moduleNames {
+		params := modules[module]
+		if len(params) == 0 {
+			continue
+		}
+
+		options := make([]string, 0, len(params))
+		for _, param := range sortedKeys(params) {
+			options = append(options, fmt.Sprintf("%s=%s", param, params[param]))
+		}
+
+		lines = append(lines, fmt.Sprintf("options %s %s", module, strings.Join(options, " ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// joinModprobeConf - joins modprobe.d content, later lines override earlier ones
+func joinModprobeConf(parts ...string) string {
+	nonEmpty := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimRight(part, "\n")
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+
+	return strings.Join(nonEmpty, "\n")
+}
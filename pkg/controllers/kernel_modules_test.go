@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Kernel modules", func() {
+	It("renders and validates the kernel module parameters", func() {
+		cr := newTestCluster()
+		cr.Spec.Core.ModuleParams = "# free text\noptions nvmeibc a=1\n"
+		cr.Spec.Core.Modules = map[string]map[string]string{
+			"nvmeibs": {"min_local_nvmeqs": "32", "max_local_nvmeqs": "32"},
+			"nvmeibc": {"nr_max_channels_per_disk": "32"},
+		}
+
+		Expect(validateKernelModules(cr)).To(BeNil())
+		Expect(getClusterCoreConfig(cr).ModuleParams).To(Equal(
+			"# free text\noptions nvmeibc a=1\n" +
+				"options nvmeibc nr_max_channels_per_disk=32\n" +
+				"options nvmeibs max_local_nvmeqs=32 min_local_nvmeqs=32"))
+
+		invalid := []map[string]map[string]string{
+			{"nvme_tcp": {"a": "1"}},
+			{"nvmeibc": {"bad-name": "1"}},
+			{"nvmeibc": {"a": "two words"}},
+			{"nvmeibc": {"a": ""}},
+		}
+
+		for _, modules := range invalid {
+			cr.Spec.Core.Modules = modules
+			Expect(validateKernelModules(cr)).NotTo(BeNil(), "%v", modules)
+		}
+
+		cr.Spec.Core.Modules = nil
+		invalidParams := []string{
+			"install nvmeibc /bin/sh -c 'rm -rf /'",
+			"blacklist nvmeibc",
+			"options nvmeibc",
+			"options nvmeibc a",
+			"options nvmeibc a=\"1\"",
+		}
+
+		for _, moduleParams := range invalidParams {
+			cr.Spec.Core.ModuleParams = moduleParams
+			Expect(validateKernelModules(cr)).NotTo(BeNil(), moduleParams)
+		}
+
+		cr.Spec.Core.ModuleParams = ""
+		cr.Spec.Core.NodeOverrides = []nvmeshv1.NodeConfigOverride{{NodeName: "worker-1", ModuleParams: "options nvmeibc a=1\nremove nvmeibc echo"}}
+		err := validateKernelModules(cr)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("spec.core.nodeOverrides[0].moduleParams"))
+	})
+})
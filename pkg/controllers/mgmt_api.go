@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+)
+
+const (
+	mgmtAPIPort    = 4000
+	mgmtAPITimeout = 10 * time.Second
+)
+
+// managementUser - a user in NVMesh Management
+type managementUser struct {
+	Email                string `json:"email"`
+	Password             string `json:"password"`
+	ConfirmationPassword string `json:"confirmationPassword"`
+	Role                 string `json:"role"`
+}
+
+// managementServer - a server (node running the MCS agent) as reported by NVMesh Management
+type managementServer struct {
+	NodeID string           `json:"node_id"`
+	Health string           `json:"health"`
+	Disks  []managementDisk `json:"disks"`
+}
+
+// managementDisk - an NVMe drive of a server as reported by NVMesh Management
+type managementDisk struct {
+	DiskID       string `json:"diskID"`
+	SerialNumber string `json:"serialNumber"`
+	Path         string `json:"pathName"`
+	Status       string `json:"status"`
+}
+
+// managementVolume - a volume as reported by NVMesh Management. each chunk is built of praids, the segments of a praid hold copies of the same data
+type managementVolume struct {
+	Name   string                  `json:"_id"`
+	Health string                  `json:"health"`
+	Chunks []managementVolumeChunk `json:"chunks"`
+}
+
+type managementVolumeChunk struct {
+	Praids []managementPraid `json:"praids"`
+}
+
+type managementPraid struct {
+	DiskSegments []managementDiskSegment `json:"diskSegments"`
+}
+
+// managementDiskSegment - a part of a volume on a drive. raft only segments and reserved segments do not hold data
+type managementDiskSegment struct {
+	NodeID     string `json:"node_id"`
+	DiskID     string `json:"diskID"`
+	Type       string `json:"type"`
+	IsReserved bool   `json:"isReserved"`
+	Health     string `json:"health"`
+}
+
+// managementAPI - the calls the operator makes to the NVMesh Management REST API
+type managementAPI interface {
+	Login(username string, password string) error
+	SaveUser(user managementUser) error
+	GetServers() ([]managementServer, error)
+	GetVolumes() ([]managementVolume, error)
+	FormatDrive(diskID string) error
+	EvictDrive(diskID string) error
+	ReincludeDrive(diskID string) error
+}
+
+// newManagementAPI - creates a Management API client for the given address, replaced in tests
+var newManagementAPI = func(address string) (managementAPI, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &managementHTTPClient{
+		address: address,
+		client: &http.Client{
+			Jar:     jar,
+			Timeout: mgmtAPITimeout,
+			// Management uses a self signed certificate by default
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		},
+	}, nil
+}
+
+// getManagementAPIAddress - returns the address of the Management service inside the cluster
+func (r *NVMeshBaseReconciler) getManagementAPIAddress(cr *nvmeshv1.NVMesh) string {
+	protocol := "https"
+	if cr.Spec.Management.NoSSL {
+		protocol = "http"
+	}
+
+	// Used for development when we don't have access to the Service ClusterIP
+	if r.Options.Development {
+		return fmt.Sprintf("%s://localhost:%d", protocol, mgmtAPIPort)
+	}
+
+	return fmt.Sprintf("%s://%s.%s.svc.cluster.local:%d", protocol, mgmtGuiServiceName, cr.GetNamespace(), mgmtAPIPort)
+}
+
+// loginToManagement - returns a Management API client logged in with the admin credentials
+func (r *NVMeshReconciler) loginToManagement(cr *nvmeshv1.NVMesh) (managementAPI, error) {
+	username, password, err := r.getMgmtAdminCredentials(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	api, err := newManagementAPI(r.getManagementAPIAddress(cr))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := api.Login(username, password); err != nil {
+		return nil, err
+	}
+
+	return api, nil
+}
+
+type managementHTTPClient struct {
+	address string
+	client  *http.Client
+}
+
+// Login - logs in to Management, the session cookie is kept for the following calls
+func (c *managementHTTPClient) Login(username string, password string) error {
+	form := url.Values{"username": {username}, "password": {password}}
+	resp, err := c.client.PostForm(c.address+"/login", form)
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to NVMesh Management")
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to login to NVMesh Management as %s, status: %s", username, resp.Status)
+	}
+
+	return nil
+}
+
+// SaveUser - creates the user or updates it if it exists
+func (c *managementHTTPClient) SaveUser(user managementUser) error {
+	body, err := json.Marshal([]managementUser{user})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(c.address+"/users/save", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to NVMesh Management")
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to save the NVMesh Management user %s, status: %s %s", user.Email, resp.Status, string(content))
+	}
+
+	// each saved item is answered with its own result
+	results := make([]struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}, 0)
+
+	if err := json.NewDecoder(resp.Body).Decode(&results); err == nil {
+		for _, result := range results {
+			if !result.Success {
+				return fmt.Errorf("Failed to save the NVMesh Management user %s. %s", user.Email, result.Error)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetServers - returns the servers known to Management
+func (c *managementHTTPClient) GetServers() ([]managementServer, error) {
+	resp, err := c.client.Get(c.address + "/servers/all/0/0")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to connect to NVMesh Management")
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to get the servers from NVMesh Management, status: %s", resp.Status)
+	}
+
+	servers := make([]managementServer, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse the servers from NVMesh Management")
+	}
+
+	return servers, nil
+}
+
+// GetVolumes - returns the volumes known to Management with their segments
+func (c *managementHTTPClient) GetVolumes() ([]managementVolume, error) {
+	resp, err := c.client.Get(c.address + "/volumes/all/0/0")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to connect to NVMesh Management")
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to get the volumes from NVMesh Management, status: %s", resp.Status)
+	}
+
+	volumes := make([]managementVolume, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&volumes); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse the volumes from NVMesh Management")
+	}
+
+	return volumes, nil
+}
+
+// FormatDrive - formats the drive for NVMesh, all the data on the drive is lost
+func (c *managementHTTPClient) FormatDrive(diskID string) error {
+	return c.post("/disks/formatDiskByDiskIds", map[string][]string{"Ids": {diskID}}, fmt.Sprintf("format drive %s", diskID))
+}
+
+// EvictDrive - stops using the drive, the segments on it are rebuilt on other drives
+func (c *managementHTTPClient) EvictDrive(diskID string) error {
+	return c.post("/disks/evictDiskByDiskIds", map[string][]string{"Ids": {diskID}}, fmt.Sprintf("evict drive %s", diskID))
+}
+
+// ReincludeDrive - returns an evicted drive to use
+func (c *managementHTTPClient) ReincludeDrive(diskID string) error {
+	return c.post("/disks/reincludeDiskByDiskIds", map[string][]string{"Ids": {diskID}}, fmt.Sprintf("reinclude drive %s", diskID))
+}
+
+// post - sends a JSON request and checks the response status
+func (c *managementHTTPClient) post(path string, body interface{}, description string) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(c.address+path, "application/json", bytes.NewReader(content))
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to NVMesh Management")
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		content, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to %s in NVMesh Management, status: %s %s", description, resp.Status, string(content))
+	}
+
+	return nil
+}
@@ -23,6 +23,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	reconcile "sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -37,6 +38,8 @@ const (
 	mgmtGuiServiceName                = "nvmesh-management-gui"
 	mgmtProtocol                      = "https"
 	mgmtInitDbJobName                 = "mgmt-init-db"
+	mongoConfigMapName                = "mongo-conf"
+	mongodConfKey                     = "mongod.conf"
 	recursive                         = true
 	nonRecursive                      = false
 	SettingsKeyAutoFromatDrives       = "hidden.autoFormatDrive"
@@ -70,12 +73,18 @@ func (r *NVMeshMgmtReconciler) Reconcile(cr *nvmeshv1.NVMesh, nvmeshr *NVMeshRec
 	if cr.Spec.Management.Disabled {
 		err = nvmeshr.removeObjectsFromDir(cr, r, mgmtAssetsLocation, recursive)
 	} else {
-		err = r.handleDBManipulations(cr)
+		var mongoConn *mongoConnection
+		mongoConn, err = r.reconcileMongoConnection(cr)
+		if err != nil {
+			return defaultRequeue, err
+		}
+
+		err = r.handleDBManipulations(cr, mongoConn)
 		if err == mongo.ErrNoDocuments {
 			// First run - Init DB
 			r.Log.Info("No globalSettings document found in MongoDB - Running initDB")
 			err = r.runMgmtInitDBJob(cr)
-			return Requeue(time.Second * 3), err
+			return Requeue(jobProgressSafetyRequeue), err
 		} else if err != nil {
 			_, isServerSelectionError := err.(mongotopology.ServerSelectionError)
 			if isServerSelectionError {
@@ -87,13 +96,23 @@ func (r *NVMeshMgmtReconciler) Reconcile(cr *nvmeshv1.NVMesh, nvmeshr *NVMeshRec
 				return defaultRequeue, err
 			}
 		}
+
+		var upgradeResult reconcile.Result
+		upgradeResult, err = r.reconcileMgmtUpgrade(cr, nvmeshr)
+		if err != nil {
+			return defaultRequeue, err
+		}
+
 		err = nvmeshr.createObjectsFromDir(cr, r, mgmtAssetsLocation, recursive)
+		if err == nil && upgradeResult.Requeue {
+			return upgradeResult, nil
+		}
 	}
 
 	return DoNotRequeue(), err
 }
 
-func (r *NVMeshMgmtReconciler) handleDBManipulations(cr *nvmeshv1.NVMesh) error {
+func (r *NVMeshMgmtReconciler) handleDBManipulations(cr *nvmeshv1.NVMesh, mongoConn *mongoConnection) error {
 	log := r.Log.WithName("handleDBManipulations")
 
 	filter := bson.D{}
@@ -112,16 +131,24 @@ func (r *NVMeshMgmtReconciler) handleDBManipulations(cr *nvmeshv1.NVMesh) error
 	}
 
 	var result FindResult
-	mongoURI := getMongoURI(cr)
+	mongoURI := mongoConn.URI(mgmtDBName)
 
 	// Used for development when we don't have access to the Pod's ClusterIP where mongo is listening
 	if r.Options.Development {
-		mongoURI = "mongodb://localhost:27017"
+		devConn := *mongoConn
+		devConn.SRV = false
+		devConn.Hosts = []string{"localhost:27017"}
+		mongoURI = devConn.URI(mgmtDBName)
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	opts := options.Client().ApplyURI(mongoURI)
+	if err := r.applyMongoClientSecurity(cr, opts); err != nil {
+		return err
+	}
+
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		return errors.Wrap(err, "Failed to connect to MongoDB")
 	}
@@ -160,6 +187,23 @@ func (r *NVMeshMgmtReconciler) handleDBManipulations(cr *nvmeshv1.NVMesh) error
 	return nil
 }
 
+// getMongoReplicas - returns the replicas of the deployed MongoDB StatefulSet, which can be scaled by the user, or the replicas it is deployed with
+func (r *NVMeshMgmtReconciler) getMongoReplicas(cr *nvmeshv1.NVMesh) (int32, error) {
+	ss := &appsv1.StatefulSet{}
+	err := r.Client.Get(context.TODO(), client.ObjectKey{Name: "mongo", Namespace: cr.GetNamespace()}, ss)
+	if k8serrors.IsNotFound(err) {
+		return 1, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "Failed to get StatefulSet mongo")
+	}
+
+	if ss.Spec.Replicas == nil {
+		return 1, nil
+	}
+
+	return *ss.Spec.Replicas, nil
+}
+
 func (r *NVMeshMgmtReconciler) deployMongoDB(cr *nvmeshv1.NVMesh, nvmeshr *NVMeshReconciler) error {
 	return nvmeshr.createObjectsFromDir(cr, r, mongoDBAssetsLocation, nonRecursive)
 }
@@ -184,8 +228,8 @@ func (r *NVMeshMgmtReconciler) runMgmtInitDBJob(cr *nvmeshv1.NVMesh) error {
 	container := &job.Spec.Template.Spec.Containers[0]
 
 	container.Command = []string{"mongo"}
-	mongoConnString := getMongoConnectionString(cr) + "/management"
-	container.Args = []string{mongoConnString, "/opt/NVMesh/management/initDB.js"}
+	container.Args = []string{"/opt/NVMesh/management/initDB.js"}
+	setMongoShellJobConnection(cr, &job.Spec.Template.Spec, container)
 
 	err := r.Client.Create(context.TODO(), job)
 	if err == nil {
@@ -212,12 +256,26 @@ func (r *NVMeshMgmtReconciler) InitObject(cr *nvmeshv1.NVMesh, obj client.Object
 		switch name {
 		case "nvmesh-mgmt-config":
 			return r.initConfigMap(cr, o)
+		case mongoConfigMapName:
+			o.Data[mongodConfKey] = renderMongodConf(cr, o.Data[mongodConfKey])
 		}
 	case *v1.Service:
 		switch name {
 		case "nvmesh-management-gui":
 			return r.initMgmtGuiService(cr, o)
 		}
+	case *policyv1.PodDisruptionBudget:
+		switch name {
+		case "nvmesh-management":
+			setPDBMinAvailable(o, cr.Spec.Management.Replicas)
+		case "mongo":
+			replicas, err := r.getMongoReplicas(cr)
+			if err != nil {
+				return err
+			}
+
+			setMongoPDBMinAvailable(o, replicas)
+		}
 	default:
 		//o is unknown for us
 		//log.Info(fmt.Sprintf("Object type %s not handled", o))
@@ -251,6 +309,10 @@ func (r *NVMeshMgmtReconciler) ShouldUpdateObject(cr *nvmeshv1.NVMesh, exp clien
 				}
 				return false
 			}
+		case mongoConfigMapName:
+			// mongod reads the config on start, it is restarted by the change in the mongo StatefulSet security annotation
+			expectedConf := (exp).(*v1.ConfigMap)
+			return expectedConf.Data[mongodConfKey] != o.Data[mongodConfKey]
 		}
 	case *v1.Service:
 		switch name {
@@ -258,6 +320,8 @@ func (r *NVMeshMgmtReconciler) ShouldUpdateObject(cr *nvmeshv1.NVMesh, exp clien
 			expectedService := (exp).(*v1.Service)
 			return r.shouldUpdateGuiService(cr, expectedService, o)
 		}
+	case *policyv1.PodDisruptionBudget:
+		return shouldUpdatePDB((exp).(*policyv1.PodDisruptionBudget), o)
 	default:
 		//o is unknown for us
 		//log.Info(fmt.Sprintf("Object type %s not handled", o))
@@ -270,23 +334,15 @@ func getMongoConnectionString(cr *nvmeshv1.NVMesh) string {
 	return fmt.Sprintf("mongo-svc.%s.svc.cluster.local:27017", cr.GetNamespace())
 }
 
-func getMongoURI(cr *nvmeshv1.NVMesh) string {
-	return fmt.Sprintf("mongodb://%s", getMongoConnectionString(cr))
-}
-
 func (r *NVMeshMgmtReconciler) initConfigMap(cr *nvmeshv1.NVMesh, o *v1.ConfigMap) error {
 	o.Data["configVersion"] = cr.Spec.Management.Version
 
-	var mongoConnectionString string
-	if cr.Spec.Management.MongoDB.External {
-		mongoConnectionString = cr.Spec.Management.MongoDB.Address
-	} else {
-		mongoConnectionString = getMongoConnectionString(cr)
+	mongoConn, err := r.getMongoConnection(cr)
+	if err != nil {
+		return err
 	}
 
-	mongoConnection := map[string]string{
-		"hosts": mongoConnectionString,
-	}
+	mongoConnection := getMgmtMongoConnection(cr, mongoConn)
 
 	useSSL := strconv.FormatBool(!cr.Spec.Management.NoSSL)
 
@@ -332,10 +388,15 @@ func (r *NVMeshMgmtReconciler) initMgmtStatefulSet(cr *nvmeshv1.NVMesh, o *appsv
 		return goerrors.New("Missing Management Version (NVMesh.Spec.Management.Version)")
 	}
 
-	o.Spec.Template.Spec.Containers[0].Image = getMgmtImageFromResource(cr)
-	o.Spec.Replicas = &cr.Spec.Management.Replicas
+	o.Spec.Template.Spec.Containers[0].Image = getMgmtDeployImage(cr)
+	o.Spec.Replicas = getMgmtDeployReplicas(cr)
 	r.addKeepRunningAfterFailureEnvVar(cr, &o.Spec.Template.Spec.Containers[0])
 
+	setMongoSecurityHashAnnotation(cr, &o.Spec.Template)
+	addSecretVolume(&o.Spec.Template.Spec, &o.Spec.Template.Spec.Containers[0], mongoConnectionVolumeName, mongoConnectionSecretName, mongoConnectionMountPath)
+	addMongoClientVolumes(cr, &o.Spec.Template.Spec, &o.Spec.Template.Spec.Containers[0])
+	addMgmtProbes(cr, &o.Spec.Template, &o.Spec.Template.Spec.Containers[0])
+
 	overrideVolumeClaimFields(&o.Spec.VolumeClaimTemplates[0].Spec, &cr.Spec.Management.BackupsVolumeClaim)
 	r.addDeleteOnUninstallLabel(cr, &o.Spec.VolumeClaimTemplates[0])
 	return nil
@@ -361,6 +422,9 @@ func overrideVolumeClaimFields(target *v1.PersistentVolumeClaimSpec, source *v1.
 
 func (r *NVMeshMgmtReconciler) initMongoStatefulSet(cr *nvmeshv1.NVMesh, o *appsv1.StatefulSet) error {
 	o.Spec.Template.Spec.Containers[0].Image = r.getCoreFullImageName(cr, mongoInstanceImageName)
+	initMongoStatefulSetSecurity(cr, &o.Spec.Template.Spec, &o.Spec.Template.Spec.Containers[0])
+	setMongoSecurityHashAnnotation(cr, &o.Spec.Template)
+	addMongoProbes(cr, &o.Spec.Template, &o.Spec.Template.Spec.Containers[0])
 
 	overrideVolumeClaimFields(&o.Spec.VolumeClaimTemplates[0].Spec, &cr.Spec.Management.MongoDB.DataVolumeClaim)
 	r.addDeleteOnUninstallLabel(cr, &o.Spec.VolumeClaimTemplates[0])
@@ -409,6 +473,16 @@ func (r *NVMeshMgmtReconciler) shouldUpdateManagementStatefulSet(cr *nvmeshv1.NV
 		return true
 	}
 
+	if expected.Spec.Template.GetAnnotations()[mongoSecurityHashAnnotation] != ss.Spec.Template.GetAnnotations()[mongoSecurityHashAnnotation] {
+		log.Info("MongoDB auth or tls configuration changed")
+		return true
+	}
+
+	if probesHashChanged(&expected.Spec.Template, &ss.Spec.Template) {
+		log.Info(fmt.Sprintf("Probes changed on StatefulSet %s", ss.GetName()))
+		return true
+	}
+
 	return false
 }
 
@@ -430,6 +504,16 @@ func (r *NVMeshMgmtReconciler) shouldUpdateMongoStatefulSet(cr *nvmeshv1.NVMesh,
 		return true
 	}
 
+	if expected.Spec.Template.GetAnnotations()[mongoSecurityHashAnnotation] != ss.Spec.Template.GetAnnotations()[mongoSecurityHashAnnotation] {
+		log.Info("MongoDB auth or tls configuration changed")
+		return true
+	}
+
+	if probesHashChanged(&expected.Spec.Template, &ss.Spec.Template) {
+		log.Info(fmt.Sprintf("Probes changed on StatefulSet %s", ss.GetName()))
+		return true
+	}
+
 	return false
 }
 
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	errors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// mgmtNodeNames - maps the node IDs reported by Management to Kubernetes node names.
+// Management identifies a server by its hostname, which can be the FQDN or the short name, and so can the Kubernetes node name and its hostname label
+type mgmtNodeNames struct {
+	exact map[string]string
+	short map[string]string
+}
+
+func shortHostname(name string) string {
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+// newMgmtNodeNames - indexes the names and the hostname labels of the given nodes, short names that belong to more than one node are not used
+func newMgmtNodeNames(nodes []corev1.Node) *mgmtNodeNames {
+	names := &mgmtNodeNames{exact: make(map[string]string), short: make(map[string]string)}
+	ambiguous := make(map[string]bool)
+	for _, node := range nodes {
+		for _, name := range []string{node.GetName(), node.GetLabels()[corev1.LabelHostname]} {
+			if name == "" {
+				continue
+			}
+
+			names.exact[name] = node.GetName()
+			short := shortHostname(name)
+			if other, ok := names.short[short]; ok && other != node.GetName() {
+				ambiguous[short] = true
+			}
+
+			names.short[short] = node.GetName()
+		}
+	}
+
+	for short := range ambiguous {
+		delete(names.short, short)
+	}
+
+	return names
+}
+
+// NodeName - returns the Kubernetes node name of a Management node ID
+func (n *mgmtNodeNames) NodeName(nodeID string) (string, bool) {
+	if name, ok := n.exact[nodeID]; ok {
+		return name, true
+	}
+
+	name, ok := n.short[shortHostname(nodeID)]
+	return name, ok
+}
+
+// getMgmtNodeNames - returns the mapping of Management node IDs for the nodes of the cluster
+func (r *NVMeshBaseReconciler) getMgmtNodeNames() (*mgmtNodeNames, error) {
+	nodeList := &corev1.NodeList{}
+	if err := r.Client.List(context.TODO(), nodeList); err != nil {
+		return nil, errors.Wrap(err, "Failed to list nodes")
+	}
+
+	return newMgmtNodeNames(nodeList.Items), nil
+}
@@ -0,0 +1,510 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// mgmtUpgradeStatusName - the key of the Management upgrade in status.actionsStatus, the upgrade is started by the operator and not by an action in spec.actions
+	mgmtUpgradeStatusName = "management-upgrade"
+
+	mgmtUpgradeFromImageKey   = "fromImage"
+	mgmtUpgradeToImageKey     = "toImage"
+	mgmtUpgradeDeployImageKey = "deployImage"
+	mgmtUpgradeBackupFileKey  = "backupFile"
+	mgmtUpgradeFailureKey     = "failure"
+	mgmtUpgradeWaitSinceKey   = "waitingSince"
+
+	backupMgmtDBStage    = "BackupDB"
+	rollOutMgmtStage     = "RollOut"
+	verifyMCSAgentsStage = "VerifyMCSAgents"
+	restoreMgmtDBStage   = "RestoreDB"
+	revertMgmtStage      = "Revert"
+
+	mgmtDBBackupJobName  = "mgmt-db-backup"
+	mgmtDBRestoreJobName = "mgmt-db-restore"
+	mgmtDBBackupsPVCName = "nvmesh-mgmt-db-backups"
+	mgmtDBBackupsPath    = "/backups"
+
+	defaultMgmtUpgradeTimeoutSeconds = 600
+	mgmtUpgradeRequeue               = 10 * time.Second
+
+	// the StatefulSet controller labels each pod with the revision of the template it was created from
+	controllerRevisionHashLabel = "controller-revision-hash"
+)
+
+func getMgmtUpgradeTimeout(cr *nvmeshv1.NVMesh) time.Duration {
+	if cr.Spec.Management.Upgrade.TimeoutSeconds > 0 {
+		return time.Duration(cr.Spec.Management.Upgrade.TimeoutSeconds) * time.Second
+	}
+
+	return defaultMgmtUpgradeTimeoutSeconds * time.Second
+}
+
+// getMgmtDeployImage - returns the image of the Management StatefulSet. while an upgrade to spec.management.version is in progress or after it failed, the image is chosen by the upgrade flow
+func getMgmtDeployImage(cr *nvmeshv1.NVMesh) string {
+	target := getMgmtImageFromResource(cr)
+	status, ok := cr.Status.ActionsStatus[mgmtUpgradeStatusName]
+	if ok && status[mgmtUpgradeToImageKey] == target && status[mgmtUpgradeDeployImageKey] != "" {
+		return status[mgmtUpgradeDeployImageKey]
+	}
+
+	return target
+}
+
+// getMgmtDeployReplicas - returns the replicas of the Management StatefulSet, Management is scaled down while the upgrade restores the database backup
+func getMgmtDeployReplicas(cr *nvmeshv1.NVMesh) *int32 {
+	status, ok := cr.Status.ActionsStatus[mgmtUpgradeStatusName]
+	if ok && status[mgmtUpgradeToImageKey] == getMgmtImageFromResource(cr) && status[restoreMgmtDBStage] == taskStarted {
+		replicas := int32(0)
+		return &replicas
+	}
+
+	return &cr.Spec.Management.Replicas
+}
+
+func isMgmtUpgradeDone(status nvmeshv1.ActionStatus) bool {
+	return status[actionComplete] == taskFinished || status[revertMgmtStage] == taskFinished
+}
+
+// reconcileMgmtUpgrade - upgrades Management when spec.management.version is changed: backs up the database, rolls the pods to the new image and verifies that the MCS agents reconnect.
+// The image applied to the StatefulSet is taken from getMgmtDeployImage, the returned result requeues until the upgrade is finished
+func (r *NVMeshMgmtReconciler) reconcileMgmtUpgrade(cr *nvmeshv1.NVMesh, nvmeshr *NVMeshReconciler) (ctrl.Result, error) {
+	ss := &appsv1.StatefulSet{}
+	err := r.Client.Get(context.TODO(), client.ObjectKey{Name: mgmtStatefulSetName, Namespace: cr.GetNamespace()}, ss)
+	if k8serrors.IsNotFound(err) {
+		// first deployment
+		return DoNotRequeue(), nil
+	} else if err != nil {
+		return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to get StatefulSet %s", mgmtStatefulSetName))
+	}
+
+	target := getMgmtImageFromResource(cr)
+	status := cr.Status.ActionsStatus[mgmtUpgradeStatusName]
+	if status[mgmtUpgradeToImageKey] != target {
+		current := ss.Spec.Template.Spec.Containers[0].Image
+		if current == target {
+			return DoNotRequeue(), nil
+		}
+
+		if cr.Status.ActionsStatus == nil {
+			cr.Status.ActionsStatus = make(map[string]nvmeshv1.ActionStatus)
+		}
+
+		status = nvmeshv1.ActionStatus{
+			mgmtUpgradeFromImageKey:   current,
+			mgmtUpgradeToImageKey:     target,
+			mgmtUpgradeDeployImageKey: current,
+		}
+
+		cr.Status.ActionsStatus[mgmtUpgradeStatusName] = status
+		r.EventManager.Normal(cr, "ManagementUpgradeStarted", fmt.Sprintf("Upgrading Management from %s to %s", current, target))
+	}
+
+	if isMgmtUpgradeDone(status) {
+		return DoNotRequeue(), nil
+	}
+
+	a := nvmeshv1.ClusterAction{Name: mgmtUpgradeStatusName}
+	if status[mgmtUpgradeFailureKey] != "" {
+		return r.revertMgmtUpgrade(cr, nvmeshr, a, ss)
+	}
+
+	if !nvmeshr.isTaskFinished(cr, a, backupMgmtDBStage) {
+		if cr.Spec.Management.Upgrade.SkipBackup {
+			nvmeshr.setTaskFinished(cr, a, backupMgmtDBStage)
+		} else {
+			nvmeshr.setTaskStarted(cr, a, backupMgmtDBStage)
+			if status[mgmtUpgradeBackupFileKey] == "" {
+				status[mgmtUpgradeBackupFileKey] = path.Join(mgmtDBBackupsPath, fmt.Sprintf("management-%s.archive.gz", time.Now().UTC().Format("20060102-150405")))
+			}
+
+			done, err := r.runMgmtDBJob(cr, mgmtDBBackupJobName, "mongodump", status[mgmtUpgradeBackupFileKey])
+			if err != nil {
+				return r.failMgmtUpgrade(cr, fmt.Sprintf("Failed to back up the management database. %s", err))
+			}
+
+			if !done {
+				return Requeue(jobProgressSafetyRequeue), nil
+			}
+
+			nvmeshr.setTaskFinished(cr, a, backupMgmtDBStage)
+		}
+	}
+
+	if !nvmeshr.isTaskFinished(cr, a, rollOutMgmtStage) {
+		if nvmeshr.getTaskStatus(cr, a, rollOutMgmtStage) != taskStarted {
+			nvmeshr.setTaskStarted(cr, a, rollOutMgmtStage)
+			status[mgmtUpgradeDeployImageKey] = target
+			status[mgmtUpgradeWaitSinceKey] = time.Now().UTC().Format(time.RFC3339)
+			// the StatefulSet is updated by createObjectsFromDir, it rolls the pods one at a time and waits for each to be ready
+			return Requeue(mgmtUpgradeRequeue), nil
+		}
+
+		if !isStatefulSetRolledOut(ss, target) {
+			if r.isMgmtUpgradeTimedOut(cr, status) {
+				return r.failMgmtUpgrade(cr, fmt.Sprintf("Management pods did not become ready with %s within %s", target, getMgmtUpgradeTimeout(cr)))
+			}
+
+			return Requeue(mgmtUpgradeRequeue), nil
+		}
+
+		nvmeshr.setTaskFinished(cr, a, rollOutMgmtStage)
+		status[mgmtUpgradeWaitSinceKey] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	if !nvmeshr.isTaskFinished(cr, a, verifyMCSAgentsStage) {
+		adminSecretName := getMgmtAdminSecretName(cr)
+		_, err := r.getSecret(cr.GetNamespace(), adminSecretName)
+		if !cr.Spec.Core.Disabled && k8serrors.IsNotFound(err) {
+			// the upgrade is not reverted because it can not be verified
+			reason := fmt.Sprintf("Secret %s is missing, the reconnection of the MCS agents was not verified", adminSecretName)
+			nvmeshr.setTaskStatus(cr, a, verifyMCSAgentsStage, fmt.Sprintf("Skipped: %s", reason))
+			r.EventManager.Warning(cr, "ManagementUpgradeNotVerified", reason)
+			delete(status, mgmtUpgradeWaitSinceKey)
+			nvmeshr.setActionComplete(cr, a)
+			r.EventManager.Normal(cr, "ManagementUpgraded", fmt.Sprintf("Management was upgraded to %s", target))
+			return DoNotRequeue(), nil
+		}
+
+		nvmeshr.setTaskStarted(cr, a, verifyMCSAgentsStage)
+		err = r.verifyMCSAgentsConnected(cr, nvmeshr)
+		if err != nil {
+			if r.isMgmtUpgradeTimedOut(cr, status) {
+				return r.failMgmtUpgrade(cr, fmt.Sprintf("MCS agents did not reconnect to Management within %s. %s", getMgmtUpgradeTimeout(cr), err))
+			}
+
+			r.Log.Info(fmt.Sprintf("Waiting for the MCS agents to reconnect to Management. %s", err))
+			return Requeue(mgmtUpgradeRequeue), nil
+		}
+
+		nvmeshr.setTaskFinished(cr, a, verifyMCSAgentsStage)
+	}
+
+	delete(status, mgmtUpgradeWaitSinceKey)
+	nvmeshr.setActionComplete(cr, a)
+	r.EventManager.Normal(cr, "ManagementUpgraded", fmt.Sprintf("Management was upgraded to %s", target))
+	return DoNotRequeue(), nil
+}
+
+func (r *NVMeshMgmtReconciler) isMgmtUpgradeTimedOut(cr *nvmeshv1.NVMesh, status nvmeshv1.ActionStatus) bool {
+	since, err := time.Parse(time.RFC3339, status[mgmtUpgradeWaitSinceKey])
+	if err != nil {
+		return false
+	}
+
+	return time.Since(since) > getMgmtUpgradeTimeout(cr)
+}
+
+// failMgmtUpgrade - records the failure, the following reconcile cycles restore the backup if requested and revert Management to the previous image
+func (r *NVMeshMgmtReconciler) failMgmtUpgrade(cr *nvmeshv1.NVMesh, reason string) (ctrl.Result, error) {
+	status := cr.Status.ActionsStatus[mgmtUpgradeStatusName]
+	status[mgmtUpgradeFailureKey] = reason
+	delete(status, mgmtUpgradeWaitSinceKey)
+	r.EventManager.Warning(cr, "ManagementUpgradeFailed", reason)
+	return Requeue(mgmtUpgradeRequeue), nil
+}
+
+// revertMgmtUpgrade - restores the database backup when spec.management.upgrade.restoreBackupOnFailure is set and rolls Management back to the image it ran before the upgrade.
+// Management is scaled down before the backup is restored, so the failed pods do not write to the database while it is replaced
+func (r *NVMeshMgmtReconciler) revertMgmtUpgrade(cr *nvmeshv1.NVMesh, nvmeshr *NVMeshReconciler, a nvmeshv1.ClusterAction, ss *appsv1.StatefulSet) (ctrl.Result, error) {
+	status := cr.Status.ActionsStatus[mgmtUpgradeStatusName]
+	from := status[mgmtUpgradeFromImageKey]
+
+	backupTaken := nvmeshr.isTaskFinished(cr, a, backupMgmtDBStage) && status[mgmtUpgradeBackupFileKey] != ""
+	restoreStatus := nvmeshr.getTaskStatus(cr, a, restoreMgmtDBStage)
+	if cr.Spec.Management.Upgrade.RestoreBackupOnFailure && backupTaken && (restoreStatus == "" || restoreStatus == taskStarted) {
+		if restoreStatus == "" {
+			// the StatefulSet is scaled down by createObjectsFromDir, see getMgmtDeployReplicas
+			nvmeshr.setTaskStarted(cr, a, restoreMgmtDBStage)
+			r.Log.Info("Scaling Management down to restore the database backup")
+			return Requeue(mgmtUpgradeRequeue), nil
+		}
+
+		scaledDown, err := r.scaleDownStatefulSet(ss)
+		if err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if !scaledDown {
+			return Requeue(mgmtUpgradeRequeue), nil
+		}
+
+		done, err := r.runMgmtDBJob(cr, mgmtDBRestoreJobName, "mongorestore", status[mgmtUpgradeBackupFileKey])
+		if err != nil {
+			// Management is reverted anyway, the backup is kept on the PVC for a manual restore
+			nvmeshr.setTaskStatus(cr, a, restoreMgmtDBStage, fmt.Sprintf("Failed: %s", err))
+			r.EventManager.Warning(cr, "ManagementRestoreFailed", fmt.Sprintf("Failed to restore the management database from %s. %s", status[mgmtUpgradeBackupFileKey], err))
+		} else if !done {
+			return Requeue(jobProgressSafetyRequeue), nil
+		} else {
+			nvmeshr.setTaskFinished(cr, a, restoreMgmtDBStage)
+		}
+	}
+
+	if nvmeshr.getTaskStatus(cr, a, revertMgmtStage) != taskStarted {
+		nvmeshr.setTaskStarted(cr, a, revertMgmtStage)
+		status[mgmtUpgradeDeployImageKey] = from
+		status[mgmtUpgradeWaitSinceKey] = time.Now().UTC().Format(time.RFC3339)
+		return Requeue(mgmtUpgradeRequeue), nil
+	}
+
+	if !isStatefulSetRolledOut(ss, from) {
+		// a pod of the new version that never became ready blocks the StatefulSet rolling update, it is deleted to let it be recreated with the previous image
+		if err := r.deleteStuckStatefulSetPods(ss); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if !r.isMgmtUpgradeTimedOut(cr, status) {
+			return Requeue(mgmtUpgradeRequeue), nil
+		}
+
+		r.EventManager.Warning(cr, "ManagementRevertFailed", fmt.Sprintf("Management pods did not become ready with %s within %s", from, getMgmtUpgradeTimeout(cr)))
+	} else {
+		r.EventManager.Normal(cr, "ManagementReverted", fmt.Sprintf("Management was reverted to %s", from))
+	}
+
+	delete(status, mgmtUpgradeWaitSinceKey)
+	nvmeshr.setTaskFinished(cr, a, revertMgmtStage)
+	return DoNotRequeue(), nil
+}
+
+// isStatefulSetRolledOut - returns true when all the pods of the StatefulSet run the given image and are ready
+func isStatefulSetRolledOut(ss *appsv1.StatefulSet, image string) bool {
+	replicas := int32(1)
+	if ss.Spec.Replicas != nil {
+		replicas = *ss.Spec.Replicas
+	}
+
+	s := ss.Status
+	return ss.Spec.Template.Spec.Containers[0].Image == image &&
+		s.ObservedGeneration >= ss.GetGeneration() &&
+		s.UpdatedReplicas == replicas &&
+		s.ReadyReplicas == replicas &&
+		s.CurrentRevision == s.UpdateRevision
+}
+
+// scaleDownStatefulSet - returns true when a StatefulSet scaled to 0 has no pods left. pods that are not ready block an ordered scale down, so the remaining pods are deleted
+func (r *NVMeshMgmtReconciler) scaleDownStatefulSet(ss *appsv1.StatefulSet) (bool, error) {
+	if ss.Spec.Replicas == nil || *ss.Spec.Replicas != 0 || ss.Status.ObservedGeneration < ss.GetGeneration() {
+		return false, nil
+	}
+
+	if ss.Status.Replicas == 0 {
+		return true, nil
+	}
+
+	pods := &corev1.PodList{}
+	err := r.Client.List(context.TODO(), pods, client.InNamespace(ss.GetNamespace()), client.MatchingLabels(ss.Spec.Selector.MatchLabels))
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("Failed to list the pods of StatefulSet %s", ss.GetName()))
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.GetDeletionTimestamp() != nil {
+			continue
+		}
+
+		r.Log.Info(fmt.Sprintf("Deleting pod %s to scale down StatefulSet %s", pod.GetName(), ss.GetName()))
+		if err := r.Client.Delete(context.TODO(), pod); err != nil && !k8serrors.IsNotFound(err) {
+			return false, errors.Wrap(err, fmt.Sprintf("Failed to delete pod %s", pod.GetName()))
+		}
+	}
+
+	return false, nil
+}
+
+func (r *NVMeshMgmtReconciler) deleteStuckStatefulSetPods(ss *appsv1.StatefulSet) error {
+	pods := &corev1.PodList{}
+	err := r.Client.List(context.TODO(), pods, client.InNamespace(ss.GetNamespace()), client.MatchingLabels(ss.Spec.Selector.MatchLabels))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to list the pods of StatefulSet %s", ss.GetName()))
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Labels[controllerRevisionHashLabel] == ss.Status.UpdateRevision || isPodReady(&pod) {
+			continue
+		}
+
+		r.Log.Info(fmt.Sprintf("Deleting pod %s that is not ready to roll it back", pod.GetName()))
+		if err := r.Client.Delete(context.TODO(), &pod); err != nil && !k8serrors.IsNotFound(err) {
+			return errors.Wrap(err, fmt.Sprintf("Failed to delete pod %s", pod.GetName()))
+		}
+	}
+
+	return nil
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// verifyMCSAgentsConnected - returns an error until every node with a ready MCS agent is reported as connected by Management
+func (r *NVMeshMgmtReconciler) verifyMCSAgentsConnected(cr *nvmeshv1.NVMesh, nvmeshr *NVMeshReconciler) error {
+	if cr.Spec.Core.Disabled {
+		return nil
+	}
+
+	pods := &corev1.PodList{}
+	err := r.Client.List(context.TODO(), pods, client.InNamespace(cr.GetNamespace()), client.MatchingLabels{"app": coreUserspaceDaemonSetName})
+	if err != nil {
+		return errors.Wrap(err, "Failed to list the MCS agent pods")
+	}
+
+	expected := make([]string, 0)
+	for _, pod := range pods.Items {
+		if isPodReady(&pod) {
+			expected = append(expected, pod.Spec.NodeName)
+		}
+	}
+
+	if len(expected) == 0 {
+		return nil
+	}
+
+	username, password, err := nvmeshr.getMgmtAdminCredentials(cr)
+	if err != nil {
+		return err
+	}
+
+	api, err := newManagementAPI(r.getManagementAPIAddress(cr))
+	if err != nil {
+		return err
+	}
+
+	if err := api.Login(username, password); err != nil {
+		return err
+	}
+
+	servers, err := api.GetServers()
+	if err != nil {
+		return err
+	}
+
+	names, err := r.getMgmtNodeNames()
+	if err != nil {
+		return err
+	}
+
+	connected := make(map[string]bool)
+	for _, server := range servers {
+		// a server that lost its connection to Management is reported as critical
+		if server.Health != "healthy" && server.Health != "alarm" {
+			continue
+		}
+
+		if nodeName, ok := names.NodeName(server.NodeID); ok {
+			connected[nodeName] = true
+		}
+	}
+
+	missing := make([]string, 0)
+	for _, node := range expected {
+		if !connected[node] {
+			missing = append(missing, node)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("MCS agents on nodes %v are not connected", missing)
+	}
+
+	return nil
+}
+
+// makeSureMgmtDBBackupsPVCExists - the backups are stored on a dedicated PVC so they are available to the restore job and kept after the upgrade
+func (r *NVMeshMgmtReconciler) makeSureMgmtDBBackupsPVCExists(cr *nvmeshv1.NVMesh) error {
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.Client.Get(context.TODO(), client.ObjectKey{Name: mgmtDBBackupsPVCName, Namespace: cr.GetNamespace()}, pvc)
+	if err == nil {
+		return nil
+	} else if !k8serrors.IsNotFound(err) {
+		return errors.Wrap(err, fmt.Sprintf("Failed to get PersistentVolumeClaim %s", mgmtDBBackupsPVCName))
+	}
+
+	pvc = &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: mgmtDBBackupsPVCName, Namespace: cr.GetNamespace()},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("5Gi")},
+			},
+		},
+	}
+
+	overrideVolumeClaimFields(&pvc.Spec, &cr.Spec.Management.Upgrade.BackupVolumeClaim)
+	r.addOperatorLabels(cr, pvc)
+	r.addDeleteOnUninstallLabel(cr, pvc)
+	return errors.Wrap(r.Client.Create(context.TODO(), pvc), fmt.Sprintf("Failed to create PersistentVolumeClaim %s", mgmtDBBackupsPVCName))
+}
+
+// runMgmtDBJob - runs mongodump or mongorestore with the given archive file on the backups PVC. returns true when the job finished successfully, the job is then deleted
+func (r *NVMeshMgmtReconciler) runMgmtDBJob(cr *nvmeshv1.NVMesh, jobName string, tool string, archive string) (bool, error) {
+	if err := r.makeSureMgmtDBBackupsPVCExists(cr); err != nil {
+		return false, err
+	}
+
+	job := r.getNewJob(cr, jobName, r.getCoreFullImageName(cr, mongoInstanceImageName))
+	backoffLimit := int32(1)
+	job.Spec.BackoffLimit = &backoffLimit
+	podSpec := &job.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+
+	container.Command = []string{tool}
+	container.Args = []string{"--gzip", "--archive=" + archive}
+	if tool == "mongorestore" {
+		container.Args = append(container.Args, "--drop")
+	}
+
+	setMongoToolJobConnection(cr, podSpec, container)
+	addVolumeClaimVolume(podSpec, container, "backups", mgmtDBBackupsPVCName, mgmtDBBackupsPath)
+
+	err := r.Client.Create(context.TODO(), job)
+	if err == nil {
+		r.Log.Info(fmt.Sprintf("Job %s created", jobName))
+		return false, nil
+	} else if !k8serrors.IsAlreadyExists(err) {
+		return false, errors.Wrap(err, fmt.Sprintf("Failed to create job %s", jobName))
+	}
+
+	result, err := r.waitForJobToFinish(cr, jobName)
+	if err != nil {
+		_ = r.deleteJob(cr.GetNamespace(), jobName)
+		return false, err
+	}
+
+	if result.Requeue {
+		return false, nil
+	}
+
+	return true, r.deleteJob(cr.GetNamespace(), jobName)
+}
+
+func addVolumeClaimVolume(podSpec *corev1.PodSpec, container *corev1.Container, volumeName string, claimName string, mountPath string) {
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         volumeName,
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}},
+	})
+
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: volumeName, MountPath: mountPath})
+}
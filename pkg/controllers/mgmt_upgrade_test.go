@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Management upgrade", func() {
+	It("verifies the upgrade and reverts it when the new pods fail", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Spec.Management.ImageRegistry = "registry.excelero.com"
+		cr.Spec.Management.Version = "2.5.0"
+		cr.Spec.Management.Replicas = 1
+
+		ss := &appsv1.StatefulSet{}
+		ss.SetName(mgmtStatefulSetName)
+		ss.SetNamespace(TestingNamespace)
+		ss.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": mgmtStatefulSetName}}
+		ss.Spec.Template.Spec.Containers = []corev1.Container{{Name: "nvmesh-management", Image: "registry.excelero.com/nvmesh-management:2.4.0"}}
+
+		agent := newTestPod("nvmesh-mcs-agent-abcde", "node1", map[string]string{"app": coreUserspaceDaemonSetName})
+		mgmtPod := newTestPod("nvmesh-management-0", "node1", map[string]string{"app": mgmtStatefulSetName})
+
+		api := &fakeManagementAPI{available: true}
+		defer useFakeManagementAPI(api)()
+
+		r := newFakeReconciler(cr, ss, agent, mgmtPod, newTestNode("node1", nil), newMgmtAdminSecret())
+		mgmtr := NVMeshMgmtReconciler(*r)
+		recorder := r.EventManager.recorder.(*record.FakeRecorder)
+		upgrade := func() bool {
+			result, err := mgmtr.reconcileMgmtUpgrade(cr, r)
+			Expect(err).To(BeNil())
+			return result.Requeue
+		}
+
+		rollOut := func(image string) {
+			Expect(r.Client.Get(ctx, client.ObjectKey{Name: mgmtStatefulSetName, Namespace: TestingNamespace}, ss)).To(Succeed())
+			ss.Spec.Template.Spec.Containers[0].Image = image
+			ss.Spec.Replicas = getMgmtDeployReplicas(cr)
+			ss.Status = appsv1.StatefulSetStatus{ObservedGeneration: ss.GetGeneration(), Replicas: 1, ReadyReplicas: 1, UpdatedReplicas: 1}
+			Expect(r.Client.Update(ctx, ss)).To(Succeed())
+		}
+
+		By("backing up the database before the new image is deployed")
+		Expect(upgrade()).To(BeTrue())
+		status := cr.Status.ActionsStatus[mgmtUpgradeStatusName]
+		Expect(status[backupMgmtDBStage]).To(Equal(taskStarted))
+		Expect(getMgmtDeployImage(cr)).To(Equal("registry.excelero.com/nvmesh-management:2.4.0"))
+
+		job := &batchv1.Job{}
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: mgmtDBBackupJobName, Namespace: TestingNamespace}, job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(Equal([]string{"mongodump"}))
+		Expect(job.Spec.Template.Spec.Containers[0].Args).To(Equal([]string{"--uri=$(MONGO_URI)", "--gzip", "--archive=" + status[mgmtUpgradeBackupFileKey]}))
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: mgmtDBBackupsPVCName, Namespace: TestingNamespace}, pvc)).To(Succeed())
+
+		Expect(completeJobWithReport(r, mgmtDBBackupJobName, "")).To(Succeed())
+		Expect(upgrade()).To(BeTrue())
+		Expect(status[backupMgmtDBStage]).To(Equal(taskFinished))
+
+		By("rolling the pods to the new image and waiting for the MCS agents to reconnect")
+		Expect(status[rollOutMgmtStage]).To(Equal(taskStarted))
+		Expect(getMgmtDeployImage(cr)).To(Equal("registry.excelero.com/nvmesh-management:2.5.0"))
+		Expect(upgrade()).To(BeTrue())
+
+		rollOut("registry.excelero.com/nvmesh-management:2.5.0")
+		Expect(upgrade()).To(BeTrue())
+		Expect(status[rollOutMgmtStage]).To(Equal(taskFinished))
+		Expect(status[verifyMCSAgentsStage]).To(Equal(taskStarted))
+
+		By("matching the MCS agents by the short hostname reported by Management")
+		api.servers = []managementServer{{NodeID: "node1", Health: "critical"}}
+		Expect(upgrade()).To(BeTrue())
+		api.servers = []managementServer{{NodeID: "node1.example.com", Health: "healthy"}}
+		Expect(upgrade()).To(BeFalse())
+		Expect(status[actionComplete]).To(Equal(taskFinished))
+		Expect(recorder.Events).To(HaveLen(2))
+
+		By("keeping the upgrade status after it finished")
+		Expect(r.removeFinishedActionStatuses(cr).Requeue).To(BeFalse())
+		Expect(cr.Status.ActionsStatus).To(HaveKey(mgmtUpgradeStatusName))
+		Expect(upgrade()).To(BeFalse())
+
+		By("reverting and restoring the backup when the new pods do not become ready")
+		cr.Spec.Management.Version = "2.6.0"
+		cr.Spec.Management.Upgrade.RestoreBackupOnFailure = true
+		Expect(upgrade()).To(BeTrue())
+		status = cr.Status.ActionsStatus[mgmtUpgradeStatusName]
+		Expect(status[mgmtUpgradeFromImageKey]).To(Equal("registry.excelero.com/nvmesh-management:2.5.0"))
+		Expect(completeJobWithReport(r, mgmtDBBackupJobName, "")).To(Succeed())
+		Expect(upgrade()).To(BeTrue())
+		Expect(upgrade()).To(BeTrue())
+
+		status[mgmtUpgradeWaitSinceKey] = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+		Expect(upgrade()).To(BeTrue())
+		Expect(status[mgmtUpgradeFailureKey]).To(ContainSubstring("did not become ready"))
+
+		By("scaling Management down before the backup is restored")
+		Expect(upgrade()).To(BeTrue())
+		Expect(*getMgmtDeployReplicas(cr)).To(BeZero())
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: mgmtStatefulSetName, Namespace: TestingNamespace}, ss)).To(Succeed())
+		ss.Spec.Replicas = getMgmtDeployReplicas(cr)
+		ss.Status = appsv1.StatefulSetStatus{ObservedGeneration: ss.GetGeneration(), Replicas: 1}
+		Expect(r.Client.Update(ctx, ss)).To(Succeed())
+
+		Expect(upgrade()).To(BeTrue())
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: mgmtDBRestoreJobName, Namespace: TestingNamespace}, job)).NotTo(Succeed())
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: mgmtPod.GetName(), Namespace: TestingNamespace}, &corev1.Pod{})).NotTo(Succeed())
+
+		ss.Status.Replicas = 0
+		Expect(r.Client.Update(ctx, ss)).To(Succeed())
+		Expect(upgrade()).To(BeTrue())
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: mgmtDBRestoreJobName, Namespace: TestingNamespace}, job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.Containers[0].Args).To(ContainElement("--drop"))
+		Expect(completeJobWithReport(r, mgmtDBRestoreJobName, "")).To(Succeed())
+
+		Expect(upgrade()).To(BeTrue())
+		Expect(status[restoreMgmtDBStage]).To(Equal(taskFinished))
+		Expect(getMgmtDeployReplicas(cr)).To(Equal(&cr.Spec.Management.Replicas))
+		Expect(status[revertMgmtStage]).To(Equal(taskStarted))
+		Expect(getMgmtDeployImage(cr)).To(Equal("registry.excelero.com/nvmesh-management:2.5.0"))
+
+		rollOut("registry.excelero.com/nvmesh-management:2.5.0")
+		Expect(upgrade()).To(BeFalse())
+		Expect(status[revertMgmtStage]).To(Equal(taskFinished))
+		Expect(getMgmtDeployImage(cr)).To(Equal("registry.excelero.com/nvmesh-management:2.5.0"))
+		Expect(upgrade()).To(BeFalse())
+	})
+
+	It("completes the upgrade without verifying the MCS agents when the admin Secret is missing", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Spec.Management.ImageRegistry = "registry.excelero.com"
+		cr.Spec.Management.Version = "2.5.0"
+		cr.Spec.Management.Upgrade.SkipBackup = true
+
+		ss := &appsv1.StatefulSet{}
+		ss.SetName(mgmtStatefulSetName)
+		ss.SetNamespace(TestingNamespace)
+		ss.Spec.Template.Spec.Containers = []corev1.Container{{Name: "nvmesh-management", Image: "registry.excelero.com/nvmesh-management:2.4.0"}}
+
+		agent := newTestPod("nvmesh-mcs-agent-abcde", "node1", map[string]string{"app": coreUserspaceDaemonSetName})
+		api := &fakeManagementAPI{available: true}
+		defer useFakeManagementAPI(api)()
+
+		r := newFakeReconciler(cr, ss, agent)
+		mgmtr := NVMeshMgmtReconciler(*r)
+		recorder := r.EventManager.recorder.(*record.FakeRecorder)
+		result, err := mgmtr.reconcileMgmtUpgrade(cr, r)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeTrue())
+
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: mgmtStatefulSetName, Namespace: TestingNamespace}, ss)).To(Succeed())
+		ss.Spec.Template.Spec.Containers[0].Image = getMgmtDeployImage(cr)
+		ss.Status = appsv1.StatefulSetStatus{ObservedGeneration: ss.GetGeneration(), Replicas: 1, ReadyReplicas: 1, UpdatedReplicas: 1}
+		Expect(r.Client.Update(ctx, ss)).To(Succeed())
+
+		result, err = mgmtr.reconcileMgmtUpgrade(cr, r)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeFalse())
+
+		status := cr.Status.ActionsStatus[mgmtUpgradeStatusName]
+		Expect(status[actionComplete]).To(Equal(taskFinished))
+		Expect(status[verifyMCSAgentsStage]).To(ContainSubstring("Skipped: Secret nvmesh-mgmt-admin-cred is missing"))
+		Expect(status).NotTo(HaveKey(mgmtUpgradeFailureKey))
+		Expect(getMgmtDeployImage(cr)).To(Equal("registry.excelero.com/nvmesh-management:2.5.0"))
+		Expect(api.logins).To(BeEmpty())
+
+		<-recorder.Events
+		Expect(<-recorder.Events).To(ContainSubstring("ManagementUpgradeNotVerified"))
+	})
+})
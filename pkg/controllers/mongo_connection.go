@@ -0,0 +1,332 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	mongoScheme    = "mongodb://"
+	mongoSRVScheme = "mongodb+srv://"
+	mgmtDBName     = "management"
+
+	// mongoConnectionSecretName - an operator owned Secret with the connection URI used by all the pods that connect to MongoDB
+	mongoConnectionSecretName  = "nvmesh-mongo-connection"
+	mongoConnectionURIKey      = "uri"
+	mongoConnectionUsernameKey = "username"
+	mongoConnectionPasswordKey = "password"
+	mongoURIEnvVar             = "MONGO_URI"
+
+	// Management reads the credentials of the connection from the connection Secret, whether they were given in the connection string or in spec.management.mongoDB.auth
+	mongoConnectionVolumeName = "mongo-connection"
+	mongoConnectionMountPath  = "/etc/nvmesh/mongo-connection"
+)
+
+// mongoConnection - a parsed MongoDB connection string
+type mongoConnection struct {
+	SRV      bool
+	Hosts    []string
+	Username string
+	Password string
+	Database string
+	Options  url.Values
+}
+
+// parseMongoConnectionString - parses a mongodb:// or mongodb+srv:// URI without resolving the SRV record
+func parseMongoConnectionString(connectionString string) (*mongoConnection, error) {
+	conn := &mongoConnection{Options: url.Values{}}
+	var rest string
+	if strings.HasPrefix(connectionString, mongoSRVScheme) {
+		conn.SRV = true
+		rest = strings.TrimPrefix(connectionString, mongoSRVScheme)
+	} else if strings.HasPrefix(connectionString, mongoScheme) {
+		rest = strings.TrimPrefix(connectionString, mongoScheme)
+	} else {
+		return nil, fmt.Errorf("MongoDB connection string must start with %s or %s", mongoScheme, mongoSRVScheme)
+	}
+
+	if i := strings.Index(rest, "?"); i >= 0 {
+		options, err := url.ParseQuery(rest[i+1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to parse the MongoDB connection string options")
+		}
+
+		conn.Options = options
+		rest = rest[:i]
+	}
+
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		userInfo := rest[:i]
+		rest = rest[i+1:]
+
+		username, password := userInfo, ""
+		if j := strings.Index(userInfo, ":"); j >= 0 {
+			username, password = userInfo[:j], userInfo[j+1:]
+		}
+
+		var err error
+		if conn.Username, err = url.PathUnescape(username); err != nil {
+			return nil, errors.Wrap(err, "Failed to parse the MongoDB connection string username")
+		}
+
+		if conn.Password, err = url.PathUnescape(password); err != nil {
+			return nil, errors.Wrap(err, "Failed to parse the MongoDB connection string password")
+		}
+	}
+
+	hosts := rest
+	if i := strings.Index(rest, "/"); i >= 0 {
+		hosts = rest[:i]
+		conn.Database = rest[i+1:]
+	}
+
+	for _, host := range strings.Split(hosts, ",") {
+		if host == "" {
+			return nil, goerrors.New("MongoDB connection string has an empty host")
+		}
+
+		conn.Hosts = append(conn.Hosts, host)
+	}
+
+	if conn.SRV && (len(conn.Hosts) != 1 || strings.Contains(conn.Hosts[0], ":")) {
+		return nil, fmt.Errorf("A %s connection string must have a single host name without a port", mongoSRVScheme)
+	}
+
+	return conn, nil
+}
+
+// URI - returns the connection string for the given database
+func (c *mongoConnection) URI(database string) string {
+	scheme := mongoScheme
+	if c.SRV {
+		scheme = mongoSRVScheme
+	}
+
+	credentials := ""
+	if c.Username != "" {
+		userInfo := url.User(c.Username)
+		if c.Password != "" {
+			userInfo = url.UserPassword(c.Username, c.Password)
+		}
+
+		credentials = userInfo.String() + "@"
+	}
+
+	uri := fmt.Sprintf("%s%s%s/%s", scheme, credentials, strings.Join(c.Hosts, ","), database)
+	if len(c.Options) > 0 {
+		uri += "?" + encodeMongoOptions(c.Options)
+	}
+
+	return uri
+}
+
+// encodeMongoOptions - encodes the options in a stable order, keeping file paths readable
+func encodeMongoOptions(options url.Values) string {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range options[key] {
+			pairs = append(pairs, url.QueryEscape(key)+"="+strings.ReplaceAll(url.QueryEscape(value), "%2F", "/"))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func hasMongoConnectionString(cr *nvmeshv1.NVMesh) bool {
+	mongo := cr.Spec.Management.MongoDB
+	return mongo.ConnectionString != "" || mongo.ConnectionStringSecret != nil
+}
+
+func validateMongoConnection(cr *nvmeshv1.NVMesh) error {
+	mongo := cr.Spec.Management.MongoDB
+	if mongo.ConnectionString != "" && mongo.ConnectionStringSecret != nil {
+		return validationError(cr, "Conflicting MongoDB connection strings.", "Only one of spec.management.mongoDB.connectionString and spec.management.mongoDB.connectionStringSecret can be set")
+	}
+
+	if hasMongoConnectionString(cr) && !mongo.External {
+		return validationError(cr, "MongoDB connection string requires an external MongoDB.", "spec.management.mongoDB.external must be true when a connection string is given")
+	}
+
+	if mongo.ConnectionStringSecret != nil && (mongo.ConnectionStringSecret.Name == "" || mongo.ConnectionStringSecret.Key == "") {
+		return validationError(cr, "Invalid MongoDB connection string Secret.", "spec.management.mongoDB.connectionStringSecret must have a name and a key")
+	}
+
+	if mongo.ConnectionString != "" {
+		conn, err := parseMongoConnectionString(mongo.ConnectionString)
+		if err != nil {
+			return validationError(cr, "Invalid MongoDB connection string.", err.Error())
+		}
+
+		if conn.Username != "" && mongo.Auth != nil {
+			return validationError(cr, "Conflicting MongoDB credentials.", "The connection string contains credentials and spec.management.mongoDB.auth is also set")
+		}
+	}
+
+	return nil
+}
+
+// getMongoConnectionStringFromSpec - returns the connection string given in the spec, the address of an external MongoDB or the address of the MongoDB deployed by the operator
+func (r *NVMeshBaseReconciler) getMongoConnectionStringFromSpec(cr *nvmeshv1.NVMesh) (string, error) {
+	mongo := cr.Spec.Management.MongoDB
+	if mongo.ConnectionStringSecret != nil {
+		data, err := r.getSecretData(cr, mongo.ConnectionStringSecret.Name)
+		if err != nil {
+			return "", err
+		}
+
+		value, ok := data[mongo.ConnectionStringSecret.Key]
+		if !ok || len(value) == 0 {
+			return "", fmt.Errorf("Secret %s is missing the key %s", mongo.ConnectionStringSecret.Name, mongo.ConnectionStringSecret.Key)
+		}
+
+		return strings.TrimSpace(string(value)), nil
+	}
+
+	if mongo.ConnectionString != "" {
+		return mongo.ConnectionString, nil
+	}
+
+	if mongo.External {
+		return mongoScheme + mongo.Address, nil
+	}
+
+	return mongoScheme + getMongoConnectionString(cr), nil
+}
+
+// getMongoConnection - returns the parsed connection used by the operator and by all the pods that connect to MongoDB, including the credentials from spec.management.mongoDB.auth
+func (r *NVMeshBaseReconciler) getMongoConnection(cr *nvmeshv1.NVMesh) (*mongoConnection, error) {
+	connectionString, err := r.getMongoConnectionStringFromSpec(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := parseMongoConnectionString(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth := cr.Spec.Management.MongoDB.Auth; auth != nil {
+		if conn.Username != "" {
+			return nil, goerrors.New("MongoDB credentials are given both in the connection string and in spec.management.mongoDB.auth")
+		}
+
+		data, err := r.getSecretData(cr, auth.SecretName)
+		if err != nil {
+			return nil, err
+		}
+
+		conn.Username = string(data["username"])
+		conn.Password = string(data["password"])
+		conn.Options.Set("authSource", getMongoAuthSource(cr))
+	}
+
+	if conn.Username != "" && conn.Options.Get("authSource") == "" && conn.Database != "" {
+		// the database of the connection string is the auth database, it is replaced by the management database
+		conn.Options.Set("authSource", conn.Database)
+	}
+
+	return conn, nil
+}
+
+// getPodMongoURI - returns the URI used inside pods, with the TLS options pointing to the mounted certificates
+func getPodMongoURI(cr *nvmeshv1.NVMesh, conn *mongoConnection) string {
+	podConn := *conn
+	podConn.Options = url.Values{}
+	for key, values := range conn.Options {
+		podConn.Options[key] = values
+	}
+
+	if tlsSpec := cr.Spec.Management.MongoDB.TLS; tlsSpec != nil {
+		podConn.Options.Set("tls", "true")
+		podConn.Options.Set("tlsCAFile", path.Join(mongoCAMountPath, mongoCAKey))
+		if tlsSpec.ClientCertSecretName != "" {
+			podConn.Options.Set("tlsCertificateKeyFile", mongoClientPEMPath)
+		}
+	}
+
+	return podConn.URI(mgmtDBName)
+}
+
+// getMongoConnectionSecretData - returns the URI used inside pods, and the credentials as separate keys for the pods that do not take a URI
+func getMongoConnectionSecretData(cr *nvmeshv1.NVMesh, conn *mongoConnection) map[string][]byte {
+	data := map[string][]byte{mongoConnectionURIKey: []byte(getPodMongoURI(cr, conn))}
+	if conn.Username != "" {
+		data[mongoConnectionUsernameKey] = []byte(conn.Username)
+		data[mongoConnectionPasswordKey] = []byte(conn.Password)
+	}
+
+	return data
+}
+
+// reconcileMongoConnectionSecret - stores the MongoDB URI and credentials in a Secret owned by the cluster, so that the credentials are not placed in pod specs or ConfigMaps
+func (r *NVMeshBaseReconciler) reconcileMongoConnectionSecret(cr *nvmeshv1.NVMesh, conn *mongoConnection) error {
+	data := getMongoConnectionSecretData(cr, conn)
+	secret, err := r.getSecret(cr.GetNamespace(), mongoConnectionSecretName)
+	if k8serrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: mongoConnectionSecretName, Namespace: cr.GetNamespace()},
+			Type:       corev1.SecretTypeOpaque,
+			Data:       data,
+		}
+
+		r.addOperatorLabels(cr, secret)
+		if err := controllerutil.SetControllerReference(cr, secret, r.Scheme); err != nil {
+			return err
+		}
+
+		return errors.Wrap(r.Client.Create(context.TODO(), secret), fmt.Sprintf("Failed to create secret %s", mongoConnectionSecretName))
+	} else if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to get secret %s", mongoConnectionSecretName))
+	}
+
+	if reflect.DeepEqual(secret.Data, data) {
+		return nil
+	}
+
+	secret.Data = data
+	return errors.Wrap(r.Client.Update(context.TODO(), secret), fmt.Sprintf("Failed to update secret %s", mongoConnectionSecretName))
+}
+
+// reconcileMongoConnection - returns the parsed MongoDB connection and makes sure the connection Secret used by the pods is up to date
+func (r *NVMeshBaseReconciler) reconcileMongoConnection(cr *nvmeshv1.NVMesh) (*mongoConnection, error) {
+	conn, err := r.getMongoConnection(cr)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get the MongoDB connection")
+	}
+
+	if err := r.reconcileMongoConnectionSecret(cr, conn); err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// addMongoURIEnvVar - adds the MONGO_URI env var from the connection Secret, it can be referenced in args as $(MONGO_URI)
+func addMongoURIEnvVar(container *corev1.Container) {
+	container.Env = append(container.Env, corev1.EnvVar{
+		Name: mongoURIEnvVar,
+		ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: mongoConnectionSecretName},
+			Key:                  mongoConnectionURIKey,
+		}},
+	})
+}
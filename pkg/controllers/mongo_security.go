@@ -0,0 +1,380 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultMongoAuthSource = "admin"
+
+	mongoAuthVolumeName       = "mongo-auth"
+	mongoCAVolumeName         = "mongo-tls-ca"
+	mongoClientCertVolumeName = "mongo-tls-client"
+	mongoServerCertVolumeName = "mongo-tls-server"
+
+	mongoAuthMountPath       = "/etc/nvmesh/mongo-auth"
+	mongoCAMountPath         = "/etc/nvmesh/mongo-tls/ca"
+	mongoClientCertMountPath = "/etc/nvmesh/mongo-tls/client"
+	mongoServerCertMountPath = "/etc/nvmesh/mongo-tls/server"
+
+	// the mongo shell and mongod expect the certificate and the key in a single PEM file
+	mongoClientPEMPath = "/tmp/mongo-client.pem"
+	mongoServerPEMPath = "/tmp/mongo-server.pem"
+
+	mongoCAKey = "ca.crt"
+
+	// mongoSecurityHashAnnotation - set on the pod templates that connect to MongoDB, so that a change in auth or tls restarts them
+	mongoSecurityHashAnnotation = "nvmesh.excelero.com/mongo-security-hash"
+)
+
+func getMongoAuthSource(cr *nvmeshv1.NVMesh) string {
+	if auth := cr.Spec.Management.MongoDB.Auth; auth != nil && auth.AuthSource != "" {
+		return auth.AuthSource
+	}
+
+	return defaultMongoAuthSource
+}
+
+func validateMongoSecurity(cr *nvmeshv1.NVMesh) error {
+	mongo := cr.Spec.Management.MongoDB
+	if mongo.Auth != nil && mongo.Auth.SecretName == "" {
+		return validationError(cr, "Missing MongoDB credentials Secret.", "spec.management.mongoDB.auth.secretName must be set when spec.management.mongoDB.auth is given")
+	}
+
+	if mongo.TLS != nil {
+		if mongo.TLS.CASecretName == "" {
+			return validationError(cr, "Missing MongoDB CA Secret.", "spec.management.mongoDB.tls.caSecretName must be set when spec.management.mongoDB.tls is given")
+		}
+
+		if !mongo.External && mongo.TLS.ServerCertSecretName == "" {
+			return validationError(cr, "Missing MongoDB server certificate Secret.", "spec.management.mongoDB.tls.serverCertSecretName must be set when MongoDB is deployed by the operator")
+		}
+	}
+
+	return nil
+}
+
+// getMongoSecuritySecrets - returns the Secrets referenced in spec.management.mongoDB.auth and tls
+func getMongoSecuritySecrets(cr *nvmeshv1.NVMesh) []requiredSecret {
+	secrets := make([]requiredSecret, 0)
+	if cr.Spec.Management.Disabled {
+		return secrets
+	}
+
+	mongo := cr.Spec.Management.MongoDB
+	if mongo.ConnectionStringSecret != nil {
+		secrets = append(secrets, requiredSecret{Name: mongo.ConnectionStringSecret.Name, Keys: []string{mongo.ConnectionStringSecret.Key}, UsedBy: "the MongoDB connection string"})
+	}
+
+	if mongo.Auth != nil {
+		secrets = append(secrets, requiredSecret{Name: mongo.Auth.SecretName, Keys: []string{"username", "password"}, UsedBy: "MongoDB authentication"})
+	}
+
+	if mongo.TLS != nil {
+		secrets = append(secrets, requiredSecret{Name: mongo.TLS.CASecretName, Keys: []string{mongoCAKey}, UsedBy: "verifying the MongoDB server certificate"})
+		if mongo.TLS.ClientCertSecretName != "" {
+			secrets = append(secrets, requiredSecret{Name: mongo.TLS.ClientCertSecretName, Keys: []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey}, UsedBy: "the MongoDB client certificate"})
+		}
+
+		if !mongo.External && mongo.TLS.ServerCertSecretName != "" {
+			secrets = append(secrets, requiredSecret{Name: mongo.TLS.ServerCertSecretName, Keys: []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey}, UsedBy: "the MongoDB server certificate"})
+		}
+	}
+
+	return secrets
+}
+
+// getMongoSecurityHash - returns a hash of the MongoDB connection, auth and tls configuration
+func getMongoSecurityHash(cr *nvmeshv1.NVMesh) string {
+	mongo := cr.Spec.Management.MongoDB
+	data, _ := json.Marshal(struct {
+		Auth                   *nvmeshv1.MongoDBAuthSpec
+		TLS                    *nvmeshv1.MongoDBTLSSpec
+		Address                string
+		ConnectionString       string
+		ConnectionStringSecret *corev1.SecretKeySelector
+	}{mongo.Auth, mongo.TLS, mongo.Address, mongo.ConnectionString, mongo.ConnectionStringSecret})
+
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+}
+
+func setMongoSecurityHashAnnotation(cr *nvmeshv1.NVMesh, template *corev1.PodTemplateSpec) {
+	annotations := template.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	annotations[mongoSecurityHashAnnotation] = getMongoSecurityHash(cr)
+	template.SetAnnotations(annotations)
+}
+
+func (r *NVMeshBaseReconciler) getSecretData(cr *nvmeshv1.NVMesh, name string) (map[string][]byte, error) {
+	secret, err := r.getSecret(cr.GetNamespace(), name)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to get secret %s", name))
+	}
+
+	return secret.Data, nil
+}
+
+// applyMongoClientSecurity - sets the TLS configuration of the operator's MongoDB client, the credentials are part of the connection URI
+func (r *NVMeshBaseReconciler) applyMongoClientSecurity(cr *nvmeshv1.NVMesh, opts *options.ClientOptions) error {
+	mongo := cr.Spec.Management.MongoDB
+	if mongo.TLS != nil {
+		data, err := r.getSecretData(cr, mongo.TLS.CASecretName)
+		if err != nil {
+			return err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data[mongoCAKey]) {
+			return fmt.Errorf("Secret %s does not contain a valid PEM certificate in the key %s", mongo.TLS.CASecretName, mongoCAKey)
+		}
+
+		tlsConfig := &tls.Config{RootCAs: pool}
+		if mongo.TLS.ClientCertSecretName != "" {
+			data, err := r.getSecretData(cr, mongo.TLS.ClientCertSecretName)
+			if err != nil {
+				return err
+			}
+
+			cert, err := tls.X509KeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("Secret %s does not contain a valid client certificate", mongo.TLS.ClientCertSecretName))
+			}
+
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	return nil
+}
+
+func addSecretVolume(podSpec *corev1.PodSpec, container *corev1.Container, volumeName string, secretName string, mountPath string) {
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         volumeName,
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secretName}},
+	})
+
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: volumeName, MountPath: mountPath, ReadOnly: true})
+}
+
+// addMongoClientVolumes - mounts the credentials, the CA and the client certificate used to connect to MongoDB
+func addMongoClientVolumes(cr *nvmeshv1.NVMesh, podSpec *corev1.PodSpec, container *corev1.Container) {
+	mongo := cr.Spec.Management.MongoDB
+	if mongo.Auth != nil {
+		addSecretVolume(podSpec, container, mongoAuthVolumeName, mongo.Auth.SecretName, mongoAuthMountPath)
+	}
+
+	if mongo.TLS != nil {
+		addSecretVolume(podSpec, container, mongoCAVolumeName, mongo.TLS.CASecretName, mongoCAMountPath)
+		if mongo.TLS.ClientCertSecretName != "" {
+			addSecretVolume(podSpec, container, mongoClientCertVolumeName, mongo.TLS.ClientCertSecretName, mongoClientCertMountPath)
+		}
+	}
+}
+
+// getMongoShellFlags - returns the mongo shell flags for the configured tls, the mongo shell does not read the certificate files from the URI
+func getMongoShellFlags(cr *nvmeshv1.NVMesh) []string {
+	mongo := cr.Spec.Management.MongoDB
+	flags := make([]string, 0)
+	if mongo.TLS != nil {
+		flags = append(flags, "--tls", "--tlsCAFile", path.Join(mongoCAMountPath, mongoCAKey))
+		if mongo.TLS.ClientCertSecretName != "" {
+			flags = append(flags, "--tlsCertificateKeyFile", mongoClientPEMPath)
+		}
+	}
+
+	return flags
+}
+
+// wrapWithPEMFile - runs the container command after joining a certificate and its key into a single PEM file
+func wrapWithPEMFile(container *corev1.Container, certDir string, pemPath string) {
+	script := fmt.Sprintf(`cat %s %s > %s && exec "$@"`, path.Join(certDir, corev1.TLSCertKey), path.Join(certDir, corev1.TLSPrivateKeyKey), pemPath)
+	container.Args = append(append([]string{}, container.Command...), container.Args...)
+	container.Command = []string{"/bin/sh", "-c", script, "sh"}
+}
+
+// setMongoShellJobConnection - configures a job container that runs the mongo shell with the given args to connect with the MongoDB connection Secret and the configured tls
+func setMongoShellJobConnection(cr *nvmeshv1.NVMesh, podSpec *corev1.PodSpec, container *corev1.Container) {
+	flags := append(getMongoShellFlags(cr), fmt.Sprintf("$(%s)", mongoURIEnvVar))
+	container.Args = append(flags, container.Args...)
+	addMongoURIEnvVar(container)
+	addMongoClientVolumes(cr, podSpec, container)
+
+	if tlsSpec := cr.Spec.Management.MongoDB.TLS; tlsSpec != nil && tlsSpec.ClientCertSecretName != "" {
+		wrapWithPEMFile(container, mongoClientCertMountPath, mongoClientPEMPath)
+	}
+}
+
+// getMongoToolFlags - returns the mongodump and mongorestore flags for the configured tls, the database tools use their own ssl flags
+func getMongoToolFlags(cr *nvmeshv1.NVMesh) []string {
+	mongo := cr.Spec.Management.MongoDB
+	flags := make([]string, 0)
+	if mongo.TLS != nil {
+		flags = append(flags, "--ssl", "--sslCAFile="+path.Join(mongoCAMountPath, mongoCAKey))
+		if mongo.TLS.ClientCertSecretName != "" {
+			flags = append(flags, "--sslPEMKeyFile="+mongoClientPEMPath)
+		}
+	}
+
+	return flags
+}
+
+// setMongoToolJobConnection - configures a job container that runs mongodump or mongorestore with the given args to connect with the MongoDB connection Secret and the configured tls
+func setMongoToolJobConnection(cr *nvmeshv1.NVMesh, podSpec *corev1.PodSpec, container *corev1.Container) {
+	flags := append([]string{fmt.Sprintf("--uri=$(%s)", mongoURIEnvVar)}, getMongoToolFlags(cr)...)
+	container.Args = append(flags, container.Args...)
+	addMongoURIEnvVar(container)
+	addMongoClientVolumes(cr, podSpec, container)
+
+	if tlsSpec := cr.Spec.Management.MongoDB.TLS; tlsSpec != nil && tlsSpec.ClientCertSecretName != "" {
+		wrapWithPEMFile(container, mongoClientCertMountPath, mongoClientPEMPath)
+	}
+}
+
+// getMgmtMongoConnection - returns the mongoConnection section of the Management config, from the same parsed connection used by the operator and its jobs.
+// The credentials and the certificates are not placed in the ConfigMap, Management is given the paths of the mounted Secret files
+func getMgmtMongoConnection(cr *nvmeshv1.NVMesh, mongoConn *mongoConnection) map[string]interface{} {
+	conn := map[string]interface{}{
+		"hosts": strings.Join(mongoConn.Hosts, ","),
+	}
+
+	if mongoConn.SRV {
+		conn["srv"] = true
+	}
+
+	options := make(map[string]string)
+	for key := range mongoConn.Options {
+		options[key] = mongoConn.Options.Get(key)
+	}
+
+	if replicaSet, ok := options["replicaSet"]; ok {
+		conn["replicaSet"] = replicaSet
+		delete(options, "replicaSet")
+	}
+
+	if authSource, ok := options["authSource"]; ok {
+		conn["authSource"] = authSource
+		delete(options, "authSource")
+	}
+
+	if mongoConn.Username != "" {
+		conn["usernameFile"] = path.Join(mongoConnectionMountPath, mongoConnectionUsernameKey)
+		conn["passwordFile"] = path.Join(mongoConnectionMountPath, mongoConnectionPasswordKey)
+	}
+
+	if tlsSpec := cr.Spec.Management.MongoDB.TLS; tlsSpec != nil {
+		conn["tls"] = true
+		conn["tlsCAFile"] = path.Join(mongoCAMountPath, mongoCAKey)
+		if tlsSpec.ClientCertSecretName != "" {
+			conn["tlsCertificateFile"] = path.Join(mongoClientCertMountPath, corev1.TLSCertKey)
+			conn["tlsCertificateKeyFile"] = path.Join(mongoClientCertMountPath, corev1.TLSPrivateKeyKey)
+		}
+	}
+
+	// the other options of the connection string (i.e. readPreference or retryWrites) are passed as they are
+	if len(options) > 0 {
+		conn["options"] = options
+	}
+
+	return conn
+}
+
+// renderMongodConf - adds the security and tls sections to the mongod.conf of the MongoDB deployed by the operator
+func renderMongodConf(cr *nvmeshv1.NVMesh, conf string) string {
+	mongo := cr.Spec.Management.MongoDB
+	lines := []string{strings.TrimRight(conf, "\n")}
+
+	// net is the last section in the base mongod.conf
+	if mongo.TLS != nil {
+		lines = append(lines,
+			"  tls:",
+			"    mode: requireTLS",
+			"    certificateKeyFile: "+mongoServerPEMPath,
+			"    CAFile: "+path.Join(mongoCAMountPath, mongoCAKey))
+
+		if mongo.TLS.ClientCertSecretName == "" {
+			lines = append(lines, "    allowConnectionsWithoutCertificates: true")
+		}
+	}
+
+	if mongo.Auth != nil {
+		lines = append(lines,
+			"security:",
+			"  authorization: enabled")
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// mongoUserRoles - the roles of the MongoDB user created by the operator, enough for Management, the operator and the mongo jobs to read, write, dump, restore and drop the databases, without user or cluster administration
+var mongoUserRoles = []string{"readWriteAnyDatabase", "dbAdminAnyDatabase"}
+
+// mongoCreateUserScript - creates the MongoDB user on the first start using the localhost exception, once a user exists the command is rejected and ignored.
+// The JavaScript is passed in an env var and the credentials are read from the mounted Secret files, so they are never parsed as code
+const mongoCreateUserScript = `for i in $(seq 1 60); do
+  mongo $MONGO_LOCAL_FLAGS --quiet admin --eval 'db.runCommand({ping: 1})' > /dev/null 2>&1 && break
+  sleep 2
+done
+mongo $MONGO_LOCAL_FLAGS --quiet admin --eval "$MONGO_CREATE_USER_JS" > /dev/null 2>&1
+exit 0`
+
+// getMongoCreateUserJS - returns the mongo shell JavaScript that creates the user, every value is JSON encoded into a JavaScript literal
+func getMongoCreateUserJS(cr *nvmeshv1.NVMesh) string {
+	quote := func(value interface{}) string {
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+
+	return fmt.Sprintf("db.getSiblingDB(%s).createUser({user: cat(%s), pwd: cat(%s), roles: %s})",
+		quote(getMongoAuthSource(cr)),
+		quote(path.Join(mongoAuthMountPath, "username")),
+		quote(path.Join(mongoAuthMountPath, "password")),
+		quote(mongoUserRoles))
+}
+
+// getMongoLocalFlags - the mongo shell flags for connecting to the MongoDB deployed by the operator from inside its own pod
+func getMongoLocalFlags(cr *nvmeshv1.NVMesh) []string {
+	if cr.Spec.Management.MongoDB.TLS == nil {
+		return []string{}
+	}
+
+	// the server certificate is used as the client certificate for local connections, its hostname does not match localhost
+	return []string{"--tls", "--tlsCAFile", path.Join(mongoCAMountPath, mongoCAKey), "--tlsCertificateKeyFile", mongoServerPEMPath, "--tlsAllowInvalidHostnames"}
+}
+
+// initMongoStatefulSetSecurity - mounts the certificates of the MongoDB deployed by the operator and creates the user on the first start
+func initMongoStatefulSetSecurity(cr *nvmeshv1.NVMesh, podSpec *corev1.PodSpec, container *corev1.Container) {
+	mongo := cr.Spec.Management.MongoDB
+	localFlags := getMongoLocalFlags(cr)
+	if mongo.TLS != nil {
+		addSecretVolume(podSpec, container, mongoCAVolumeName, mongo.TLS.CASecretName, mongoCAMountPath)
+		addSecretVolume(podSpec, container, mongoServerCertVolumeName, mongo.TLS.ServerCertSecretName, mongoServerCertMountPath)
+		wrapWithPEMFile(container, mongoServerCertMountPath, mongoServerPEMPath)
+	}
+
+	if mongo.Auth != nil {
+		addSecretVolume(podSpec, container, mongoAuthVolumeName, mongo.Auth.SecretName, mongoAuthMountPath)
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "MONGO_CREATE_USER_JS", Value: getMongoCreateUserJS(cr)},
+			corev1.EnvVar{Name: "MONGO_LOCAL_FLAGS", Value: strings.Join(localFlags, " ")})
+
+		container.Lifecycle = &corev1.Lifecycle{
+			PostStart: &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", mongoCreateUserScript}}},
+		}
+	}
+}
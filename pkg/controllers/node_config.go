@@ -0,0 +1,484 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	nvmeshconf "excelero.com/nvmesh-k8s-operator/pkg/nvmeshconf"
+	errors "github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	nodeConfigInitContainerName = "node-config"
+	nodeConfigVolumeName        = "node-config"
+	nodeConfigMapNamePrefix     = "nvmesh-node-config-"
+	nodeConfigMapLabelKey       = "nvmesh.excelero.com/node-config"
+
+	// coreRestartRequeue - how often the Core pods are checked while outdated pods are restarted
+	coreRestartRequeue = 30 * time.Second
+)
+
+// nodeConfigScript - waits for the ConfigMap of the node and copies its files to the node-config volume.
+// Each node has its own ConfigMap so that the config of large clusters does not exceed the size limit of a single ConfigMap. A pod can not mount a ConfigMap
+// that is named after its node, so the ConfigMap is read from the API server. The files are kept in binaryData, whose base64 values can be extracted from the JSON without a JSON parser.
+// The hashes of the copied files are reported in the termination message so the operator can find pods that started with an outdated config
+const nodeConfigScript = `sa=/var/run/secrets/kubernetes.io/serviceaccount
+url="https://$KUBERNETES_SERVICE_HOST:$KUBERNETES_SERVICE_PORT/api/v1/namespaces/$NAMESPACE/configmaps/$NODE_CONFIG_MAP"
+until curl -sSf --max-time 20 --cacert $sa/ca.crt -H "Authorization: Bearer $(cat $sa/token)" -o /tmp/node-config.json "$url"; do
+  echo "Waiting for the ConfigMap $NODE_CONFIG_MAP"
+  sleep 10
+done
+for file in $NODE_CONFIG_FILES; do
+  grep -o "\"${file//./\\.}\": *\"[A-Za-z0-9+/=]*\"" /tmp/node-config.json | cut -d'"' -f4 | base64 -d > "/node-config/$file"
+done
+cd /node-config && md5sum $NODE_CONFIG_FILES > /dev/termination-log`
+
+// coreComponents - the values of the component label of the Core pods that mount the node-config volume
+var coreComponents = []string{"mcs-agent", "client", "target"}
+
+// +kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list
+
+// driverDaemonSets - the driver DaemonSets use the OnDelete update strategy, their pods are restarted by the operator
+// so that the client and the target drivers of a node are reloaded together, one node at a time
+var driverDaemonSets = []string{clientDriverDaemonSetName, targetDriverDaemonSetName}
+
+// getNodeConfigFiles - returns the per-node files the containers of the pod mount from the node-config volume
+func getNodeConfigFiles(podSpec *corev1.PodSpec) []string {
+	files := make(map[string]string)
+	for _, c := range podSpec.Containers {
+		for _, m := range c.VolumeMounts {
+			if m.Name == nodeConfigVolumeName && m.SubPath != "" {
+				files[m.SubPath] = m.SubPath
+			}
+		}
+	}
+
+	return sortedKeys(files)
+}
+
+// addNodeConfigInitContainer - adds the init container that copies the files in the ConfigMap of the node to the node-config volume
+func (r *NVMeshCoreReconciler) addNodeConfigInitContainer(cr *nvmeshv1.NVMesh, podSpec *corev1.PodSpec) {
+	files := getNodeConfigFiles(podSpec)
+	if len(files) == 0 {
+		return
+	}
+
+	initContainer := corev1.Container{
+		Name:            nodeConfigInitContainerName,
+		Image:           r.getCoreFullImageName(cr, driverContainerImageName),
+		ImagePullPolicy: r.getImagePullPolicy(cr),
+		Command:         []string{"/bin/bash", "-c", nodeConfigScript},
+		Env: []corev1.EnvVar{
+			{
+				Name:      "NODE_NAME",
+				ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+			},
+			{
+				Name:      "NAMESPACE",
+				ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+			},
+			{Name: "NODE_CONFIG_MAP", Value: nodeConfigMapNamePrefix + "$(NODE_NAME)"},
+			{Name: "NODE_CONFIG_FILES", Value: strings.Join(files, " ")},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: nodeConfigVolumeName, MountPath: "/node-config"},
+		},
+	}
+
+	for i := range podSpec.InitContainers {
+		if podSpec.InitContainers[i].Name == nodeConfigInitContainerName {
+			podSpec.InitContainers[i] = initContainer
+			return
+		}
+	}
+
+	podSpec.InitContainers = append(podSpec.InitContainers, initContainer)
+}
+
+// getNodeConfigMapName - the ConfigMap of a node is named after the node so that the node-config init container can find it
+func getNodeConfigMapName(nodeName string) string {
+	return nodeConfigMapNamePrefix + nodeName
+}
+
+// getNodeConfigMaps - returns the ConfigMaps of the nodes of the cluster by node name
+func (r *NVMeshCoreReconciler) getNodeConfigMaps(cr *nvmeshv1.NVMesh) (map[string]*corev1.ConfigMap, error) {
+	cmList := &corev1.ConfigMapList{}
+	err := r.Client.List(context.TODO(), cmList, client.InNamespace(cr.GetNamespace()), client.MatchingLabels{nvmeshClusterNameLabelKey: cr.GetName(), nodeConfigMapLabelKey: "true"})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list the ConfigMaps of the NVMesh nodes")
+	}
+
+	nodeConfigMaps := make(map[string]*corev1.ConfigMap)
+	for i := range cmList.Items {
+		cm := &cmList.Items[i]
+		nodeConfigMaps[strings.TrimPrefix(cm.GetName(), nodeConfigMapNamePrefix)] = cm
+	}
+
+	return nodeConfigMaps, nil
+}
+
+// reconcileNodeConfigMaps - keeps a ConfigMap for each node with its nvmesh.conf and modprobe.d, rendered with spec.core.nodeOverrides applied.
+// A node that waits for its discovery gets its ConfigMap once it is discovered, the ConfigMaps of nodes that left the cluster are removed
+func (r *NVMeshCoreReconciler) reconcileNodeConfigMaps(cr *nvmeshv1.NVMesh) error {
+	clusterConfigMap := &corev1.ConfigMap{}
+	err := r.Client.Get(context.TODO(), client.ObjectKey{Name: nvmeshConfigMapName, Namespace: cr.GetNamespace()}, clusterConfigMap)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to get ConfigMap %s", nvmeshConfigMapName))
+	}
+
+	nodes, err := r.getAllNVMeshClusterNodes(cr)
+	if err != nil {
+		return errors.Wrap(err, "Failed to list NVMesh nodes")
+	}
+
+	discoveries, err := r.getNVMeshNodeStatuses(cr)
+	if err != nil {
+		return err
+	}
+
+	nodeConfigMaps, err := r.getNodeConfigMaps(cr)
+	if err != nil {
+		return err
+	}
+
+	clusterConfig := getClusterCoreConfig(cr)
+	for nodeName, node := range nodes {
+		var discovery *nvmeshv1.NodeDiscovery
+		if status, ok := discoveries[nodeName]; ok {
+			discovery = status.Discovery
+		}
+
+		nodeConfig, err := getNodeCoreConfig(cr, nodeName, node.GetLabels(), discovery)
+		if err != nil {
+			return err
+		}
+
+		if nodeConfig.PendingDiscovery {
+			continue
+		}
+
+		conf, err := r.renderNodeNVMeshConf(cr, clusterConfigMap.Data[nvmeshConfKey], clusterConfig, nodeConfig)
+		if err != nil {
+			return err
+		}
+
+		files := map[string][]byte{
+			nvmeshConfKey:   []byte(conf),
+			modprobeConfKey: []byte(nodeConfig.ModuleParams),
+		}
+
+		if err := r.makeSureNodeConfigMapUpdated(cr, nodeName, nodeConfigMaps[nodeName], files); err != nil {
+			return err
+		}
+
+		delete(nodeConfigMaps, nodeName)
+	}
+
+	for nodeName, cm := range nodeConfigMaps {
+		r.Log.Info(fmt.Sprintf("Removing ConfigMap %s of node %s that is no longer part of the cluster", cm.GetName(), nodeName))
+		if err := r.Client.Delete(context.TODO(), cm); err != nil && !k8serrors.IsNotFound(err) {
+			return errors.Wrap(err, fmt.Sprintf("Failed to delete ConfigMap %s", cm.GetName()))
+		}
+	}
+
+	return nil
+}
+
+// makeSureNodeConfigMapUpdated - creates the ConfigMap of the node if current is nil, or updates it if its files changed
+func (r *NVMeshCoreReconciler) makeSureNodeConfigMapUpdated(cr *nvmeshv1.NVMesh, nodeName string, current *corev1.ConfigMap, files map[string][]byte) error {
+	if current == nil {
+		cm := &corev1.ConfigMap{}
+		cm.SetName(getNodeConfigMapName(nodeName))
+		cm.SetNamespace(cr.GetNamespace())
+		r.addOperatorLabels(cr, cm)
+		cm.Labels[nodeConfigMapLabelKey] = "true"
+		cm.BinaryData = files
+		if err := controllerutil.SetControllerReference(cr, cm, r.Scheme); err != nil {
+			return err
+		}
+
+		if err := r.Client.Create(context.TODO(), cm); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Failed to create ConfigMap %s", cm.GetName()))
+		}
+
+		return nil
+	}
+
+	if !nodeConfigFilesChanged(current.BinaryData, files) {
+		return nil
+	}
+
+	r.Log.Info(fmt.Sprintf("Updating ConfigMap %s of node %s", current.GetName(), nodeName))
+	current.BinaryData = files
+	if err := r.Client.Update(context.TODO(), current); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to update ConfigMap %s", current.GetName()))
+	}
+
+	return nil
+}
+
+// nodeConfigFilesChanged - compares the files by content, an empty file may be read back from the API server as nil
+func nodeConfigFilesChanged(current map[string][]byte, expected map[string][]byte) bool {
+	if len(current) != len(expected) {
+		return true
+	}
+
+	for file, content := range expected {
+		currentContent, ok := current[file]
+		if !ok || !bytes.Equal(currentContent, content) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// renderNodeNVMeshConf - returns the nvmesh.conf of a node. The values of the node are applied on top of the cluster wide nvmesh.conf,
+// so parameters that were added manually to nvmesh-core-config also reach the nodes
+func (r *NVMeshCoreReconciler) renderNodeNVMeshConf(cr *nvmeshv1.NVMesh, clusterConf string, clusterConfig nodeCoreConfig, nodeConfig nodeCoreConfig) (string, error) {
+	conf, err := nvmeshconf.Parse(clusterConf)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to parse nvmesh.conf from nvmesh-core-config")
+	}
+
+	clusterValues, err := r.renderNVMeshConf(cr, "", clusterConfig)
+	if err != nil {
+		return "", err
+	}
+
+	nodeValues, err := r.renderNVMeshConf(cr, "", nodeConfig)
+	if err != nil {
+		return "", err
+	}
+
+	for _, change := range nvmeshconf.Diff(nvmeshconf.MustParse(clusterValues), nvmeshconf.MustParse(nodeValues)) {
+		if change.Type == nvmeshconf.Removed {
+			conf.Delete(change.Key)
+		} else {
+			conf.Set(change.Key, change.NewValue)
+		}
+	}
+
+	return conf.String(), nil
+}
+
+// removeNodeConfigMaps - removes the ConfigMaps of all the nodes when Core is removed
+func (r *NVMeshCoreReconciler) removeNodeConfigMaps(cr *nvmeshv1.NVMesh) error {
+	err := r.Client.DeleteAllOf(context.TODO(), &corev1.ConfigMap{}, client.InNamespace(cr.GetNamespace()), client.MatchingLabels{nvmeshClusterNameLabelKey: cr.GetName(), nodeConfigMapLabelKey: "true"})
+	if err != nil {
+		return errors.Wrap(err, "Failed to delete the ConfigMaps of the NVMesh nodes")
+	}
+
+	return nil
+}
+
+// getNodeConfigHash - the hash of a file of the node, as reported by md5sum in the node-config init container
+func getNodeConfigHash(content []byte) string {
+	sum := md5.Sum(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// getStartedNodeConfigHashes - returns the hashes of the files the pod started with by file name, or nil if the node-config init container did not finish
+func getStartedNodeConfigHashes(pod *corev1.Pod) map[string]string {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name != nodeConfigInitContainerName {
+			continue
+		}
+
+		if status.State.Terminated == nil || status.State.Terminated.ExitCode != 0 {
+			return nil
+		}
+
+		hashes := make(map[string]string)
+		for _, line := range strings.Split(status.State.Terminated.Message, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 {
+				hashes[fields[1]] = fields[0]
+			}
+		}
+
+		return hashes
+	}
+
+	return nil
+}
+
+// isNodeConfigOutdated - returns true if one of the files the pod started with differs from the file in the ConfigMap of its node
+func isNodeConfigOutdated(pod *corev1.Pod, nodeConfigMaps map[string]*corev1.ConfigMap) bool {
+	cm, ok := nodeConfigMaps[pod.Spec.NodeName]
+	if !ok {
+		return false
+	}
+
+	for file, hash := range getStartedNodeConfigHashes(pod) {
+		expected, ok := cm.BinaryData[file]
+		if ok && getNodeConfigHash(expected) != hash {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getDriverRevisions - returns the current revision of each driver DaemonSet, which is the value of the controller-revision-hash label of its up to date pods
+func (r *NVMeshCoreReconciler) getDriverRevisions(cr *nvmeshv1.NVMesh) (map[string]string, error) {
+	revisions := make(map[string]string)
+	for _, name := range driverDaemonSets {
+		ds := &appsv1.DaemonSet{}
+		err := r.Client.Get(context.TODO(), client.ObjectKey{Name: name, Namespace: cr.GetNamespace()}, ds)
+		if k8serrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Failed to get DaemonSet %s", name))
+		}
+
+		if ds.Spec.UpdateStrategy.Type != appsv1.OnDeleteDaemonSetStrategyType || ds.Spec.Selector == nil {
+			continue
+		}
+
+		// ControllerRevisions are read from the API server to avoid caching the ControllerRevisions of the whole cluster
+		revisionList := &appsv1.ControllerRevisionList{}
+		err = r.getAPIReader().List(context.TODO(), revisionList, client.InNamespace(cr.GetNamespace()), client.MatchingLabels(ds.Spec.Selector.MatchLabels))
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Failed to list the ControllerRevisions of DaemonSet %s", name))
+		}
+
+		var current *appsv1.ControllerRevision
+		for i := range revisionList.Items {
+			revision := &revisionList.Items[i]
+			if owner := metav1.GetControllerOf(revision); owner == nil || owner.UID != ds.GetUID() {
+				continue
+			}
+
+			if current == nil || revision.Revision > current.Revision {
+				current = revision
+			}
+		}
+
+		if current != nil {
+			revisions[name] = current.GetLabels()[appsv1.DefaultDaemonSetUniqueLabelKey]
+		}
+	}
+
+	return revisions, nil
+}
+
+// getOutdatedReason - returns why the pod should be restarted, or an empty string if it is up to date
+func getOutdatedReason(pod *corev1.Pod, nodeConfigMaps map[string]*corev1.ConfigMap, driverRevisions map[string]string) string {
+	if isNodeConfigOutdated(pod, nodeConfigMaps) {
+		return "the config of the node changed"
+	}
+
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		revision, ok := driverRevisions[owner.Name]
+		if ok && revision != "" && pod.GetLabels()[appsv1.DefaultDaemonSetUniqueLabelKey] != revision {
+			return fmt.Sprintf("DaemonSet %s was updated", owner.Name)
+		}
+	}
+
+	return ""
+}
+
+// restartOutdatedCorePods - the Core pods read the config of their node only when they start, and the driver pods are not replaced by their DaemonSets when the DaemonSets are updated.
+// Pods that started with a config that has since changed and driver pods of an older DaemonSet revision are deleted, and recreated by their DaemonSet,
+// one node at a time and only while all the other Core pods are ready
+func (r *NVMeshCoreReconciler) restartOutdatedCorePods(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	nodeConfigMaps, err := r.getNodeConfigMaps(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	driverRevisions, err := r.getDriverRevisions(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	podList := &corev1.PodList{}
+	if err := r.Client.List(context.TODO(), podList, client.InNamespace(cr.GetNamespace()), client.HasLabels{componentLabelKey}); err != nil {
+		return DoNotRequeue(), errors.Wrap(err, "Failed to list NVMesh Core pods")
+	}
+
+	outdated := make(map[string][]*corev1.Pod)
+	reasons := make(map[string]string)
+	waiting := false
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !isCoreComponentPod(pod) {
+			continue
+		}
+
+		if pod.GetDeletionTimestamp() != nil {
+			waiting = true
+			continue
+		}
+
+		// pods that wait in the init container for the ConfigMap of their node do not block the other nodes
+		if getStartedNodeConfigHashes(pod) == nil {
+			continue
+		}
+
+		if !isPodReady(pod) {
+			waiting = true
+		}
+
+		if reason := getOutdatedReason(pod, nodeConfigMaps, driverRevisions); reason != "" {
+			outdated[pod.Spec.NodeName] = append(outdated[pod.Spec.NodeName], pod)
+			reasons[pod.GetName()] = reason
+		}
+	}
+
+	if len(outdated) == 0 {
+		return DoNotRequeue(), nil
+	}
+
+	if waiting {
+		r.Log.Info(fmt.Sprintf("Waiting for the NVMesh Core pods to be ready before restarting the outdated pods of %d nodes", len(outdated)))
+		return Requeue(coreRestartRequeue), nil
+	}
+
+	nodeNames := make([]string, 0, len(outdated))
+	for nodeName := range outdated {
+		nodeNames = append(nodeNames, nodeName)
+	}
+
+	sort.Strings(nodeNames)
+	nodeName := nodeNames[0]
+	restarted := make([]string, 0)
+	for _, pod := range outdated[nodeName] {
+		if err := r.Client.Delete(context.TODO(), pod); err != nil && !k8serrors.IsNotFound(err) {
+			return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to restart pod %s", pod.GetName()))
+		}
+
+		restarted = append(restarted, fmt.Sprintf("%s (%s)", pod.GetName(), reasons[pod.GetName()]))
+	}
+
+	msg := fmt.Sprintf("Restarting the NVMesh Core pods of node %s: %s", nodeName, strings.Join(restarted, ", "))
+	r.Log.Info(msg)
+	r.EventManager.Normal(cr, "CorePodsRestarted", msg)
+	return Requeue(coreRestartRequeue), nil
+}
+
+func isCoreComponentPod(pod *corev1.Pod) bool {
+	component := pod.GetLabels()[componentLabelKey]
+	for _, c := range coreComponents {
+		if c == component {
+			return true
+		}
+	}
+
+	return false
+}
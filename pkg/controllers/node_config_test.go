@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newCorePod(name string, component string, nodeName string, ready bool, startedWith map[string]string) *corev1.Pod {
+	pod := newTestPod(name, nodeName, map[string]string{componentLabelKey: component})
+
+	message := ""
+	for _, file := range sortedKeys(startedWith) {
+		message += fmt.Sprintf("%s  %s\n", getNodeConfigHash([]byte(startedWith[file])), file)
+	}
+
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:  nodeConfigInitContainerName,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Message: message}},
+	}}
+
+	if !ready {
+		pod.Status.Conditions[0].Status = corev1.ConditionFalse
+	}
+
+	return pod
+}
+
+var _ = Describe("Node config", func() {
+	It("copies the config of the node with an init container", func() {
+		cr := newTestCluster()
+
+		r := newFakeReconciler(cr)
+		corer := NVMeshCoreReconciler(*r)
+
+		ds := &appsv1.DaemonSet{}
+		ds.SetName(targetDriverDaemonSetName)
+		ds.Spec.Template.Spec.Containers = []corev1.Container{
+			{Name: driverContainerName, VolumeMounts: []corev1.VolumeMount{
+				{Name: nodeConfigVolumeName, MountPath: "/coreConfigMap/nvmesh.conf", SubPath: nvmeshConfKey},
+				{Name: nodeConfigVolumeName, MountPath: "/etc/modprobe.d/zz_nvmesh_from_configmap.conf", SubPath: modprobeConfKey},
+			}},
+			{Name: "toma", VolumeMounts: []corev1.VolumeMount{
+				{Name: nodeConfigVolumeName, MountPath: "/coreConfigMap/nvmesh.conf", SubPath: nvmeshConfKey},
+			}},
+		}
+
+		Expect(corer.initDaemonSets(cr, ds)).To(BeNil())
+		Expect(ds.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+		initContainer := ds.Spec.Template.Spec.InitContainers[0]
+		Expect(initContainer.Name).To(Equal(nodeConfigInitContainerName))
+		Expect(initContainer.Env).To(ContainElement(corev1.EnvVar{Name: "NODE_CONFIG_FILES", Value: "modprobe.d nvmesh.conf"}))
+		Expect(initContainer.Env).To(ContainElement(corev1.EnvVar{Name: "NODE_CONFIG_MAP", Value: "nvmesh-node-config-$(NODE_NAME)"}))
+		Expect(initContainer.VolumeMounts).To(Equal([]corev1.VolumeMount{{Name: nodeConfigVolumeName, MountPath: "/node-config"}}))
+
+		By("running init again does not add another init container")
+		Expect(corer.initDaemonSets(cr, ds)).To(BeNil())
+		Expect(ds.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+
+		By("a DaemonSet created before the init container was added is updated")
+		current := ds.DeepCopy()
+		current.Spec.Template.Spec.InitContainers = nil
+		Expect(corer.shouldUpdateDaemonSet(cr, ds, current)).To(BeTrue())
+	})
+
+	It("restarts pods with an outdated node config node by node", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+
+		nodeConfs := map[string]string{"worker-1": "CONFIGURED_NICS=\"ib0\"", "worker-2": "CONFIGURED_NICS=\"ib1\""}
+		nodeConfigMaps := make([]client.Object, 0)
+		for nodeName, conf := range nodeConfs {
+			cm := &corev1.ConfigMap{}
+			cm.SetName(getNodeConfigMapName(nodeName))
+			cm.SetNamespace(TestingNamespace)
+			cm.SetLabels(map[string]string{nvmeshClusterNameLabelKey: cr.GetName(), nodeConfigMapLabelKey: "true"})
+			cm.BinaryData = map[string][]byte{nvmeshConfKey: []byte(conf), modprobeConfKey: nil}
+			nodeConfigMaps = append(nodeConfigMaps, cm)
+		}
+
+		current := func(nodeName string) map[string]string {
+			return map[string]string{nvmeshConfKey: nodeConfs[nodeName], modprobeConfKey: ""}
+		}
+
+		outdated := map[string]string{nvmeshConfKey: "CONFIGURED_NICS=\"eth0\"", modprobeConfKey: ""}
+
+		agent1 := newCorePod("mcs-agent-1", "mcs-agent", "worker-1", true, map[string]string{nvmeshConfKey: outdated[nvmeshConfKey]})
+		target1 := newCorePod("target-1", "target", "worker-1", true, outdated)
+		target2 := newCorePod("target-2", "target", "worker-2", true, outdated)
+		client2 := newCorePod("client-2", "client", "worker-2", true, current("worker-2"))
+		// a new node that waits for its ConfigMap does not block the other nodes
+		waiting := newCorePod("target-3", "target", "worker-3", false, nil)
+		waiting.Status.InitContainerStatuses[0].State = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+
+		r := newFakeReconciler(append(nodeConfigMaps, cr, agent1, target1, target2, client2, waiting)...)
+		corer := NVMeshCoreReconciler(*r)
+		exists := func(pod *corev1.Pod) bool {
+			err := r.Client.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{})
+			Expect(err == nil || k8serrors.IsNotFound(err)).To(BeTrue())
+			return err == nil
+		}
+
+		By("the pods of the first node are restarted together")
+		result, err := corer.restartOutdatedCorePods(cr)
+		Expect(err).To(BeNil())
+		Expect(result.RequeueAfter).To(Equal(coreRestartRequeue))
+		Expect(exists(agent1)).To(BeFalse())
+		Expect(exists(target1)).To(BeFalse())
+		Expect(exists(target2)).To(BeTrue())
+
+		By("the next node waits for the restarted pods to be ready")
+		restarted := newCorePod("target-1b", "target", "worker-1", false, current("worker-1"))
+		Expect(r.Client.Create(ctx, restarted)).To(Succeed())
+		_, err = corer.restartOutdatedCorePods(cr)
+		Expect(err).To(BeNil())
+		Expect(exists(target2)).To(BeTrue())
+
+		restarted.Status.Conditions[0].Status = corev1.ConditionTrue
+		Expect(r.Client.Update(ctx, restarted)).To(Succeed())
+		_, err = corer.restartOutdatedCorePods(cr)
+		Expect(err).To(BeNil())
+		Expect(exists(target2)).To(BeFalse())
+		Expect(exists(client2)).To(BeTrue())
+		Expect(exists(waiting)).To(BeTrue())
+
+		Expect(r.Client.Delete(ctx, waiting)).To(Succeed())
+		result, err = corer.restartOutdatedCorePods(cr)
+		Expect(err).To(BeNil())
+		Expect(result).To(Equal(DoNotRequeue()))
+	})
+
+	It("uses the configured NICs of a node with RDMA", func() {
+		tcpOnly := false
+		cr := newTestCluster()
+		cr.Spec.Core.NodeOverrides = []nvmeshv1.NodeConfigOverride{{NodeName: "worker-1", ConfiguredNICs: "ib0", TCPOnly: &tcpOnly}}
+
+		r := newFakeReconciler(cr)
+		corer := NVMeshCoreReconciler(*r)
+
+		config, err := getNodeCoreConfig(cr, "worker-1", nil, nil)
+		Expect(err).To(BeNil())
+		conf, err := corer.renderNVMeshConf(cr, "", config)
+		Expect(err).To(BeNil())
+		Expect(conf).To(ContainSubstring("CONFIGURED_NICS=\"ib0\""))
+		Expect(conf).NotTo(ContainSubstring("TCP_ENABLED"))
+	})
+
+	It("restarts the driver pods of an older revision together", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+
+		objects := []client.Object{cr}
+		pods := make(map[string]*corev1.Pod)
+		for _, name := range []string{clientDriverDaemonSetName, targetDriverDaemonSetName} {
+			selector := map[string]string{"app": name}
+			ds := &appsv1.DaemonSet{}
+			ds.SetName(name)
+			ds.SetNamespace(TestingNamespace)
+			ds.SetUID(types.UID(name + "-uid"))
+			ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: selector}
+			ds.Spec.UpdateStrategy.Type = appsv1.OnDeleteDaemonSetStrategyType
+			objects = append(objects, ds)
+
+			for revision, hash := range map[int64]string{1: "old", 2: "new"} {
+				controllerRevision := &appsv1.ControllerRevision{}
+				controllerRevision.SetName(fmt.Sprintf("%s-%s", name, hash))
+				controllerRevision.SetNamespace(TestingNamespace)
+				controllerRevision.SetLabels(map[string]string{"app": name, appsv1.DefaultDaemonSetUniqueLabelKey: hash})
+				controllerRevision.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(ds, appsv1.SchemeGroupVersion.WithKind("DaemonSet"))})
+				controllerRevision.Revision = revision
+				objects = append(objects, controllerRevision)
+			}
+
+			component := strings.TrimPrefix(name, "nvmesh-")
+			for _, nodeName := range []string{"worker-1", "worker-2"} {
+				pod := newCorePod(fmt.Sprintf("%s-%s", component, nodeName), component, nodeName, true, map[string]string{})
+				pod.SetLabels(map[string]string{componentLabelKey: component, appsv1.DefaultDaemonSetUniqueLabelKey: "old"})
+				pod.SetOwnerReferences([]metav1.OwnerReference{*metav1.NewControllerRef(ds, appsv1.SchemeGroupVersion.WithKind("DaemonSet"))})
+				pods[pod.GetName()] = pod
+				objects = append(objects, pod)
+			}
+		}
+
+		r := newFakeReconciler(objects...)
+		corer := NVMeshCoreReconciler(*r)
+		exists := func(name string) bool {
+			err := r.Client.Get(ctx, client.ObjectKeyFromObject(pods[name]), &corev1.Pod{})
+			return err == nil
+		}
+
+		// the client and the target of the first node are restarted together while the other node keeps running
+		_, err := corer.restartOutdatedCorePods(cr)
+		Expect(err).To(BeNil())
+		Expect(exists("client-worker-1")).To(BeFalse())
+		Expect(exists("target-worker-1")).To(BeFalse())
+		Expect(exists("client-worker-2")).To(BeTrue())
+		Expect(exists("target-worker-2")).To(BeTrue())
+	})
+})
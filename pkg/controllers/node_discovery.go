@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	discoveryJobNamePrefix = "nvmesh-discovery-"
+	discoveryNodeLabelKey  = "nvmesh.excelero.com/discovery-node"
+
+	// configuredNICsAuto - the value of configuredNICs that lets the operator choose the NICs of each node from its discovery
+	configuredNICsAuto = "auto"
+
+	discoverNodesAction = "discover-nodes"
+
+	startDiscoveryStage   = "StartDiscovery"
+	waitForDiscoveryStage = "WaitForDiscovery"
+
+	// discoveryJobDeletionRequeue - how often the discover-nodes action checks if the jobs of the previous discovery were deleted
+	discoveryJobDeletionRequeue = 5 * time.Second
+)
+
+// discoveryScript - reports the kernel version, the boot ID, the NVMe drives and the NICs of the node to the termination message of the container, one key=value line per item.
+// per-pod interfaces (veth, calico, lxc) are skipped to stay within the size limit of the termination message
+const discoveryScript = `report=/dev/termination-log
+echo "kernel=$(uname -r) bootID=$(cat /proc/sys/kernel/random/boot_id)" > $report
+for path in /sys/block/nvme*n*; do
+	[ -e $path ] || continue
+	name=$(basename $path)
+	case $name in nvme*c*n*) continue;; esac
+	serial=$(cat $path/device/serial 2>/dev/null | tr -d ' ')
+	echo "drive=/dev/$name serial=$serial" >> $report
+done
+for path in /sys/class/net/*; do
+	name=$(basename $path)
+	case $name in lo|veth*|cali*|lxc*) continue;; esac
+	speed=$(cat $path/speed 2>/dev/null || echo 0)
+	mtu=$(cat $path/mtu 2>/dev/null || echo 0)
+	state=$(cat $path/operstate 2>/dev/null || echo unknown)
+	virtual=false
+	if [ -e /sys/devices/virtual/net/$name ]; then virtual=true; fi
+	rdma=""
+	if [ -d $path/device/infiniband ]; then rdma=$(ls $path/device/infiniband | head -n 1); fi
+	linkLayer=""
+	if [ -n "$rdma" ]; then linkLayer=$(cat /sys/class/infiniband/$rdma/ports/1/link_layer 2>/dev/null || true); fi
+	echo "nic=$name speed=$speed mtu=$mtu state=$state virtual=$virtual rdma=$rdma linkLayer=$linkLayer" >> $report
+done
+`
+
+func getDiscoveryJobName(nodeName string) string {
+	return discoveryJobNamePrefix + sanitizeString(nodeName)
+}
+
+// reconcileNodeDiscovery - runs a discovery job on each active Core node that was not discovered yet or was rebooted since, and records the results of finished jobs on the NVMeshNodes.
+// failed discoveries are retried with a backoff, until then a node with configuredNICs: auto keeps waiting for its discovery
+func (r *NVMeshReconciler) reconcileNodeDiscovery(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	discoveries, err := r.getNVMeshNodeStatuses(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	jobs, err := r.getNodeJobs(cr, discoveryNodeLabelKey)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	nodes, err := r.getAllNVMeshClusterNodes(cr)
+	if err != nil {
+		return DoNotRequeue(), errors.Wrap(err, "Failed to list NVMesh nodes")
+	}
+
+	inProgress := false
+	results := newErrorCollector()
+	for _, job := range jobs {
+		nodeName := job.GetLabels()[discoveryNodeLabelKey]
+		if !isActiveCoreNode(cr, nodeName) {
+			// the node was removed from the cluster while it was discovered
+			if err := r.deleteJob(cr.GetNamespace(), job.GetName()); err != nil {
+				return DoNotRequeue(), err
+			}
+
+			continue
+		}
+
+		// the job was already handled and is being deleted
+		if job.GetDeletionTimestamp() != nil {
+			inProgress = true
+			continue
+		}
+
+		completed, jobErr := r.isSingleJobCompleted(&job)
+		if !completed {
+			inProgress = true
+			continue
+		}
+
+		var discovery *nvmeshv1.NodeDiscovery
+		if jobErr == nil {
+			discovery, jobErr = r.getDiscoveryJobResult(cr, job.GetName())
+		}
+
+		r.recordNodeDiscovery(cr, nodeName, discovery, jobErr)
+		if err := r.deleteJob(cr.GetNamespace(), job.GetName()); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if jobErr != nil {
+			// the delay before the next attempt is calculated from the recorded failures in the next cycle
+			results.add(Requeue(nodeTaskRetryBackoff), nil)
+		}
+	}
+
+	for nodeName := range cr.Status.Nodes {
+		if !isActiveCoreNode(cr, nodeName) {
+			continue
+		}
+
+		if _, ok := jobs[nodeName]; ok {
+			continue
+		}
+
+		if status, ok := discoveries[nodeName]; ok && status.Discovery != nil {
+			node, found := nodes[nodeName]
+			if !found || !isDiscoveryOutdated(status.Discovery, &node) {
+				continue
+			}
+
+			r.Log.Info(fmt.Sprintf("Node %s was rebooted or its kernel changed since it was discovered, discovering it again", nodeName))
+		}
+
+		// a node whose last discovery failed is discovered again after a delay that grows with each failure
+		if status, ok := discoveries[nodeName]; ok && status.LastDiscovery != nil && status.LastDiscovery.Failures > 0 {
+			if delay := getNodeTaskRetryDelay(status.LastDiscovery.Failures, status.LastDiscovery.Time); delay > 0 {
+				results.add(Requeue(delay), nil)
+				continue
+			}
+		}
+
+		if err := r.createDiscoveryJob(cr, nodeName); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		inProgress = true
+	}
+
+	if inProgress {
+		results.add(Requeue(jobProgressSafetyRequeue), nil)
+	}
+
+	return results.Result()
+}
+
+// isDiscoveryOutdated - the NICs of a node may change when it is rebooted or its kernel is upgraded
+func isDiscoveryOutdated(discovery *nvmeshv1.NodeDiscovery, node *corev1.Node) bool {
+	nodeInfo := node.Status.NodeInfo
+	if nodeInfo.KernelVersion != "" && nodeInfo.KernelVersion != discovery.KernelVersion {
+		return true
+	}
+
+	return nodeInfo.BootID != "" && nodeInfo.BootID != discovery.BootID
+}
+
+func (r *NVMeshBaseReconciler) createDiscoveryJob(cr *nvmeshv1.NVMesh, nodeName string) error {
+	jobName := getDiscoveryJobName(nodeName)
+	job := r.getNewJob(cr, jobName, r.getCoreFullImageName(cr, driverContainerImageName))
+	job.ObjectMeta.Labels[discoveryNodeLabelKey] = nodeName
+
+	podSpec := &job.Spec.Template.Spec
+
+	// use HostNetwork so that the container sees the network interfaces of the node
+	podSpec.HostNetwork = true
+	podSpec.NodeSelector = matchNode(nodeName)
+
+	container := &podSpec.Containers[0]
+	container.Command = []string{"/bin/sh"}
+	container.Args = []string{"-c", discoveryScript}
+	container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	setContainerAsPrivileged(container)
+
+	err := r.Client.Create(context.TODO(), job)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return errors.Wrap(err, fmt.Sprintf("Failed to create %s on node %s", jobName, nodeName))
+	}
+
+	r.Log.Info(fmt.Sprintf("Created discovery job for node %s", nodeName))
+	return nil
+}
+
+// getDiscoveryJobResult - reads the report of a successful discovery job
+func (r *NVMeshBaseReconciler) getDiscoveryJobResult(cr *nvmeshv1.NVMesh, jobName string) (*nvmeshv1.NodeDiscovery, error) {
+	report, err := r.getJobTerminationMessage(cr, jobName)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDiscoveryReport(report)
+}
+
+// parseDiscoveryReport - parses the report written by discoveryScript. lines that can not be parsed are skipped, the report may be truncated
+func parseDiscoveryReport(report string) (*nvmeshv1.NodeDiscovery, error) {
+	discovery := &nvmeshv1.NodeDiscovery{Time: metav1.Now()}
+
+	for _, line := range strings.Split(report, "\n") {
+		fields := make(map[string]string)
+		for _, field := range strings.Fields(line) {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) == 2 {
+				fields[parts[0]] = parts[1]
+			}
+		}
+
+		if kernel, ok := fields["kernel"]; ok {
+			discovery.KernelVersion = kernel
+			discovery.BootID = fields["bootID"]
+		}
+
+		if devicePath := fields["drive"]; devicePath != "" {
+			discovery.Drives = append(discovery.Drives, nvmeshv1.DiscoveredDrive{DevicePath: devicePath, SerialNumber: fields["serial"]})
+		}
+
+		if name := fields["nic"]; name != "" {
+			nic := nvmeshv1.DiscoveredNIC{
+				Name:       name,
+				State:      fields["state"],
+				Virtual:    fields["virtual"] == "true",
+				RDMADevice: fields["rdma"],
+				LinkLayer:  fields["linkLayer"],
+			}
+
+			// speed is -1 when the link is down
+			if speed, err := strconv.Atoi(fields["speed"]); err == nil && speed > 0 {
+				nic.SpeedMbps = speed
+			}
+
+			if mtu, err := strconv.Atoi(fields["mtu"]); err == nil {
+				nic.MTU = mtu
+			}
+
+			discovery.NICs = append(discovery.NICs, nic)
+		}
+	}
+
+	if discovery.KernelVersion == "" {
+		return nil, goerrors.New("Discovery report is missing the kernel version")
+	}
+
+	return discovery, nil
+}
+
+// recordNodeDiscovery - saves the discovery result on the NVMeshNode. a failed discovery keeps the result of the last successful one
+func (r *NVMeshBaseReconciler) recordNodeDiscovery(cr *nvmeshv1.NVMesh, nodeName string, discovery *nvmeshv1.NodeDiscovery, jobErr error) {
+	if jobErr != nil {
+		r.EventManager.Warning(cr, "NodeDiscoveryFailed", fmt.Sprintf("Discovery failed on node %s. %s", nodeName, jobErr))
+	} else {
+		r.Log.Info(fmt.Sprintf("Node %s discovered %d NICs and %d drives, kernel %s", nodeName, len(discovery.NICs), len(discovery.Drives), discovery.KernelVersion))
+	}
+
+	r.recordNodeTaskResult(cr, nodeName, func(status *nvmeshv1.NVMeshNodeStatus, result *nvmeshv1.NodeTaskResult) {
+		status.LastDiscovery = countNodeTaskFailures(status.LastDiscovery, result)
+		if discovery != nil {
+			status.Discovery = discovery
+		}
+	}, jobErr)
+}
+
+// chooseNICs - chooses the NICs to use on a node from its discovery. only physical interfaces that are up are used, and of these the fastest.
+// when rdma is true only RDMA capable interfaces are chosen. returns nil if there is no suitable interface
+func chooseNICs(discovery *nvmeshv1.NodeDiscovery, rdma bool) []string {
+	candidates := make([]nvmeshv1.DiscoveredNIC, 0)
+	maxSpeed := 0
+	for _, nic := range discovery.NICs {
+		if nic.Virtual || nic.State != "up" {
+			continue
+		}
+
+		if rdma && nic.RDMADevice == "" {
+			continue
+		}
+
+		candidates = append(candidates, nic)
+		if nic.SpeedMbps > maxSpeed {
+			maxSpeed = nic.SpeedMbps
+		}
+	}
+
+	nics := make([]string, 0)
+	for _, nic := range candidates {
+		if nic.SpeedMbps == maxSpeed {
+			nics = append(nics, nic.Name)
+		}
+	}
+
+	if len(nics) == 0 {
+		return nil
+	}
+
+	sort.Strings(nics)
+	return nics
+}
+
+// applyDiscoveredNICs - chooses the NICs of a node with configuredNICs: auto. a node without RDMA capable interfaces falls back to TCP
+func applyDiscoveredNICs(config *nodeCoreConfig, discovery *nvmeshv1.NodeDiscovery) {
+	if discovery == nil {
+		config.PendingDiscovery = true
+		return
+	}
+
+	var nics []string
+	if !config.TCPOnly {
+		nics = chooseNICs(discovery, true)
+		if nics == nil {
+			config.TCPOnly = true
+		}
+	}
+
+	if config.TCPOnly {
+		nics = chooseNICs(discovery, false)
+	}
+
+	config.ConfiguredNICs = strings.Join(nics, ",")
+}
+
+// discoverNodes - starts a new discovery on all nodes, the results are recorded by reconcileNodeDiscovery
+type discoverNodes struct {
+	actionOptions
+}
+
+func (discoverNodes) Stages(r *NVMeshReconciler, a nvmeshv1.ClusterAction) []Task {
+	return []Task{
+		{startDiscoveryStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			startTime, _ := r.getActionStartTime(cr, a)
+			jobs, err := r.getNodeJobs(cr, discoveryNodeLabelKey)
+			if err != nil {
+				return DoNotRequeue(), err
+			}
+
+			// jobs that started before the action are deleted, and the new jobs are created only once they are gone
+			// as a job that is still terminating would fail the creation of the new job with the same name
+			deleting := false
+			nodeNames := make([]string, 0)
+			for nodeName := range cr.Status.Nodes {
+				if !isActiveCoreNode(cr, nodeName) {
+					continue
+				}
+
+				job, ok := jobs[nodeName]
+				if !ok {
+					nodeNames = append(nodeNames, nodeName)
+					continue
+				}
+
+				if job.GetCreationTimestamp().Time.Before(startTime) {
+					if job.GetDeletionTimestamp() == nil {
+						if err := r.deleteJob(cr.GetNamespace(), job.GetName()); err != nil {
+							return DoNotRequeue(), err
+						}
+					}
+
+					deleting = true
+				}
+			}
+
+			if deleting {
+				return Requeue(discoveryJobDeletionRequeue), nil
+			}
+
+			for _, nodeName := range nodeNames {
+				if err := r.createDiscoveryJob(cr, nodeName); err != nil {
+					return DoNotRequeue(), err
+				}
+			}
+
+			return DoNotRequeue(), nil
+		}},
+		{waitForDiscoveryStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return r.waitForNodeJobs(cr, discoveryNodeLabelKey)
+		}},
+	}
+}
@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	rdmaNodeReport = "kernel=5.4.0-100-generic\n" +
+		"drive=/dev/nvme0n1 serial=S4EVNF0M100001\n" +
+		"drive=/dev/nvme1n1 serial=S4EVNF0M100002\n" +
+		"nic=eno1 speed=1000 mtu=1500 state=up virtual=false rdma= linkLayer=\n" +
+		"nic=ib0 speed=100000 mtu=4092 state=up virtual=false rdma=mlx5_0 linkLayer=InfiniBand\n" +
+		"nic=ib1 speed=-1 mtu=4092 state=down virtual=false rdma=mlx5_1 linkLayer=InfiniBand\n" +
+		"nic=docker0 speed=0 mtu=1500 state=up virtual=true rdma= linkLayer=\n"
+
+	tcpNodeReport = "kernel=5.4.0-100-generic\n" +
+		"nic=eno1 speed=1000 mtu=1500 state=up virtual=false rdma= linkLayer=\n" +
+		"nic=ens1f0 speed=25000 mtu=9000 state=up virtual=false rdma= linkLayer=\n" +
+		"nic=ens1f1 speed=25000 mtu=9000 state=up virtual=false rdma= lin"
+)
+
+var _ = Describe("Node discovery", func() {
+	It("chooses the NICs of each node from its discovery", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Spec.Core.ConfiguredNICs = configuredNICsAuto
+		cr.Spec.Core.Preflight.Skip = true
+
+		reports := map[string]string{"rdma-node": rdmaNodeReport, "tcp-node": tcpNodeReport}
+		objects := []client.Object{cr}
+		for nodeName := range reports {
+			objects = append(objects, newTestNode(nodeName, map[string]string{nvmeshClientLabelKey: ""}))
+		}
+
+		r := newFakeReconciler(objects...)
+		corer := NVMeshCoreReconciler(*r)
+		clusterConfigMap := &corev1.ConfigMap{Data: map[string]string{nvmeshConfKey: "K8S_ENV=\"True\""}}
+		clusterConfigMap.SetName(nvmeshConfigMapName)
+		clusterConfigMap.SetNamespace(TestingNamespace)
+		Expect(corer.initCoreConfigMap(cr, clusterConfigMap)).To(BeNil())
+		Expect(r.Client.Create(ctx, clusterConfigMap)).To(Succeed())
+		getNodeConfs := func() map[string]string {
+			Expect(corer.reconcileNodeConfigMaps(cr)).To(Succeed())
+			nodeConfigMaps, err := corer.getNodeConfigMaps(cr)
+			Expect(err).To(BeNil())
+
+			confs := make(map[string]string)
+			for nodeName, cm := range nodeConfigMaps {
+				confs[nodeName] = string(cm.BinaryData[nvmeshConfKey])
+			}
+
+			return confs
+		}
+
+		By("starting a discovery job on each node")
+		result, err := r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeTrue())
+
+		for nodeName := range reports {
+			Expect(r.Client.Get(ctx, client.ObjectKey{Name: getDiscoveryJobName(nodeName), Namespace: TestingNamespace}, &batchv1.Job{})).To(BeNil())
+		}
+
+		By("not rendering the config of the nodes before they are discovered")
+		Expect(getNodeConfs()).To(BeEmpty())
+		Expect(clusterConfigMap.Data[nvmeshConfKey]).NotTo(ContainSubstring("auto"))
+
+		By("recording the reports of the finished jobs")
+		for nodeName, report := range reports {
+			Expect(completeJobWithReport(r, getDiscoveryJobName(nodeName), report)).To(Succeed())
+		}
+
+		result, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeFalse())
+
+		jobs, err := r.getNodeJobs(cr, discoveryNodeLabelKey)
+		Expect(err).To(BeNil())
+		Expect(jobs).To(BeEmpty())
+
+		nvmeshNode := &nvmeshv1.NVMeshNode{}
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: getNVMeshNodeName(cr, "rdma-node"), Namespace: TestingNamespace}, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Status.LastDiscovery.Result).To(Equal(nvmeshv1.NodeTaskSucceeded))
+		Expect(nvmeshNode.Status.Discovery.KernelVersion).To(Equal("5.4.0-100-generic"))
+		Expect(nvmeshNode.Status.Discovery.NICs).To(HaveLen(4))
+		Expect(nvmeshNode.Status.Discovery.NICs[1]).To(Equal(nvmeshv1.DiscoveredNIC{Name: "ib0", SpeedMbps: 100000, MTU: 4092, State: "up", RDMADevice: "mlx5_0", LinkLayer: "InfiniBand"}))
+		Expect(nvmeshNode.Status.Discovery.Drives).To(Equal([]nvmeshv1.DiscoveredDrive{{DevicePath: "/dev/nvme0n1", SerialNumber: "S4EVNF0M100001"}, {DevicePath: "/dev/nvme1n1", SerialNumber: "S4EVNF0M100002"}}))
+
+		By("using RDMA where it is available and falling back to TCP on the other nodes")
+		confs := getNodeConfs()
+		Expect(confs["rdma-node"]).To(ContainSubstring("CONFIGURED_NICS=\"ib0\""))
+		Expect(confs["rdma-node"]).NotTo(ContainSubstring("TCP_ENABLED"))
+		Expect(confs["tcp-node"]).To(ContainSubstring("CONFIGURED_NICS=\"ens1f0,ens1f1\""))
+		Expect(confs["tcp-node"]).To(ContainSubstring("TCP_ENABLED=\"Yes\""))
+
+		By("the node statuses are synced in the next cycle")
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: getNVMeshNodeName(cr, "tcp-node"), Namespace: TestingNamespace}, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Status.NICs).To(Equal([]nvmeshv1.NVMeshNodeNIC{{Name: "ens1f0", Protocol: nicProtocolTCP}, {Name: "ens1f1", Protocol: nicProtocolTCP}}))
+		Expect(nvmeshNode.Status.Discovery).NotTo(BeNil())
+		Expect(nvmeshNode.Status.Drives).To(BeEmpty())
+
+		By("listing the discovered drives of a node and marking the excluded ones")
+		cr.Spec.Core.ExcludeDrives = &nvmeshv1.ExcludeNVMeDrivesSpec{SerialNumbers: []string{"S4EVNF0M100002"}}
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: getNVMeshNodeName(cr, "rdma-node"), Namespace: TestingNamespace}, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Status.Drives).To(Equal([]nvmeshv1.NVMeshNodeDrive{
+			{SerialNumber: "S4EVNF0M100001", DevicePath: "/dev/nvme0n1"},
+			{SerialNumber: "S4EVNF0M100002", DevicePath: "/dev/nvme1n1", Excluded: true},
+		}))
+		cr.Spec.Core.ExcludeDrives = nil
+
+		By("forcing TCP on a node with a node override")
+		tcpOnly := true
+		cr.Spec.Core.NodeOverrides = []nvmeshv1.NodeConfigOverride{{NodeName: "rdma-node", TCPOnly: &tcpOnly}}
+		confs = getNodeConfs()
+		Expect(confs["rdma-node"]).To(ContainSubstring("CONFIGURED_NICS=\"ib0\""))
+		Expect(confs["rdma-node"]).To(ContainSubstring("TCP_ENABLED=\"Yes\""))
+	})
+
+	It("retries a failed discovery with a backoff", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Spec.Core.ConfiguredNICs = configuredNICsAuto
+		cr.Spec.Core.Preflight.Skip = true
+
+		node := newTestNode("worker-1", map[string]string{nvmeshClientLabelKey: ""})
+
+		r := newFakeReconciler(cr, node)
+		jobKey := client.ObjectKey{Name: getDiscoveryJobName("worker-1"), Namespace: TestingNamespace}
+		nvmeshNodeKey := client.ObjectKey{Name: getNVMeshNodeName(cr, "worker-1"), Namespace: TestingNamespace}
+		failJob := func() {
+			job := &batchv1.Job{}
+			Expect(r.Client.Get(ctx, jobKey, job)).To(BeNil())
+			job.Status.Failed = 1
+			Expect(r.Client.Status().Update(ctx, job)).To(BeNil())
+		}
+
+		_, err := r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		failJob()
+
+		By("recording the failure and waiting before the next attempt")
+		result, err := r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeTrue())
+		Expect(result.RequeueAfter).To(BeNumerically("~", nodeTaskRetryBackoff, time.Second))
+		Expect(k8serrors.IsNotFound(r.Client.Get(ctx, jobKey, &batchv1.Job{}))).To(BeTrue())
+
+		nvmeshNode := &nvmeshv1.NVMeshNode{}
+		Expect(r.Client.Get(ctx, nvmeshNodeKey, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Status.LastDiscovery.Result).To(Equal(nvmeshv1.NodeTaskFailed))
+		Expect(nvmeshNode.Status.LastDiscovery.Failures).To(Equal(1))
+
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(k8serrors.IsNotFound(r.Client.Get(ctx, jobKey, &batchv1.Job{}))).To(BeTrue())
+
+		By("retrying once the backoff passed")
+		nvmeshNode.Status.LastDiscovery.Time = metav1.NewTime(time.Now().Add(-nodeTaskRetryBackoff))
+		Expect(r.Client.Status().Update(ctx, nvmeshNode)).To(BeNil())
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		failJob()
+
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		result, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(result.RequeueAfter).To(BeNumerically("~", 2*nodeTaskRetryBackoff, time.Second))
+		Expect(r.Client.Get(ctx, nvmeshNodeKey, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Status.LastDiscovery.Failures).To(Equal(2))
+
+		By("limiting the backoff")
+		Expect(getNodeTaskRetryDelay(20, metav1.Now())).To(BeNumerically("~", maxNodeTaskRetryBackoff, time.Second))
+	})
+
+	It("waits for the old discovery jobs to be deleted", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Status.Nodes = map[string]nvmeshv1.NodeStatus{
+			"worker-1": {State: nvmeshv1.NodeActive, Roles: []string{nodeRoleClient}},
+		}
+
+		r := newFakeReconciler(cr)
+		a := nvmeshv1.ClusterAction{Name: discoverNodesAction, ID: "discover-1"}
+		r.setTaskStatus(cr, a, actionStartTimeKey, time.Now().Format(time.RFC3339))
+
+		By("a job of an earlier discovery that is still terminating")
+		Expect(r.createDiscoveryJob(cr, "worker-1")).To(BeNil())
+		oldJob := &batchv1.Job{}
+		jobKey := client.ObjectKey{Name: getDiscoveryJobName("worker-1"), Namespace: TestingNamespace}
+		Expect(r.Client.Get(ctx, jobKey, oldJob)).To(BeNil())
+		oldJob.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-time.Hour)))
+		oldJob.SetFinalizers([]string{"test/terminating"})
+		Expect(r.Client.Update(ctx, oldJob)).To(BeNil())
+
+		startDiscovery := discoverNodes{}.Stages(r, a)[0]
+		Expect(startDiscovery.Name).To(Equal(startDiscoveryStage))
+
+		result, err := startDiscovery.Run(cr)
+		Expect(err).To(BeNil())
+		Expect(result.RequeueAfter).To(Equal(discoveryJobDeletionRequeue))
+		Expect(r.Client.Get(ctx, jobKey, oldJob)).To(BeNil())
+		Expect(oldJob.GetDeletionTimestamp()).NotTo(BeNil())
+
+		By("creating the new job only after the old job is gone")
+		result, err = startDiscovery.Run(cr)
+		Expect(err).To(BeNil())
+		Expect(result.RequeueAfter).To(Equal(discoveryJobDeletionRequeue))
+
+		oldJob.SetFinalizers(nil)
+		Expect(r.Client.Update(ctx, oldJob)).To(BeNil())
+		result, err = startDiscovery.Run(cr)
+		Expect(err).To(BeNil())
+		Expect(result).To(Equal(DoNotRequeue()))
+
+		newJob := &batchv1.Job{}
+		Expect(r.Client.Get(ctx, jobKey, newJob)).To(BeNil())
+		Expect(newJob.GetDeletionTimestamp()).To(BeNil())
+	})
+
+	It("discovers a node again after it was rebooted or its kernel changed", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Spec.Core.ConfiguredNICs = configuredNICsAuto
+		cr.Spec.Core.Preflight.Skip = true
+
+		node := newTestNode("worker-1", map[string]string{nvmeshClientLabelKey: ""})
+		node.Status.NodeInfo = corev1.NodeSystemInfo{KernelVersion: "5.4.0-100-generic", BootID: "boot-1"}
+
+		r := newFakeReconciler(cr, node)
+		jobKey := client.ObjectKey{Name: getDiscoveryJobName("worker-1"), Namespace: TestingNamespace}
+		discover := func(report string) *nvmeshv1.NodeDiscovery {
+			_, err := r.reconcileNodes(cr)
+			Expect(err).To(BeNil())
+			Expect(completeJobWithReport(r, jobKey.Name, report)).To(Succeed())
+			_, err = r.reconcileNodes(cr)
+			Expect(err).To(BeNil())
+
+			nvmeshNode := &nvmeshv1.NVMeshNode{}
+			Expect(r.Client.Get(ctx, client.ObjectKey{Name: getNVMeshNodeName(cr, "worker-1"), Namespace: TestingNamespace}, nvmeshNode)).To(Succeed())
+			return nvmeshNode.Status.Discovery
+		}
+
+		discovery := discover("kernel=5.4.0-100-generic bootID=boot-1\n" + "nic=ib0 speed=100000 mtu=4092 state=up virtual=false rdma=mlx5_0 linkLayer=InfiniBand\n")
+		Expect(discovery.BootID).To(Equal("boot-1"))
+
+		_, err := r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(k8serrors.IsNotFound(r.Client.Get(ctx, jobKey, &batchv1.Job{}))).To(BeTrue())
+
+		By("discovering the node again after it was rebooted with a new kernel")
+		node.Status.NodeInfo = corev1.NodeSystemInfo{KernelVersion: "5.15.0-50-generic", BootID: "boot-2"}
+		Expect(r.Client.Update(ctx, node)).To(Succeed())
+		Expect(nodeChangedPredicate().Update(event.UpdateEvent{ObjectOld: newTestNode("worker-1", node.GetLabels()), ObjectNew: node})).To(BeTrue())
+
+		discovery = discover("kernel=5.15.0-50-generic bootID=boot-2\n" + "nic=ib1 speed=100000 mtu=4092 state=up virtual=false rdma=mlx5_1 linkLayer=InfiniBand\n")
+		Expect(discovery.KernelVersion).To(Equal("5.15.0-50-generic"))
+		Expect(discovery.NICs[0].Name).To(Equal("ib1"))
+
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(k8serrors.IsNotFound(r.Client.Get(ctx, jobKey, &batchv1.Job{}))).To(BeTrue())
+	})
+})
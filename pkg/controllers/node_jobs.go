@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// nodeTaskRetryBackoff - the delay before a failed node task is retried, doubled after each consecutive failure up to maxNodeTaskRetryBackoff
+	nodeTaskRetryBackoff    = time.Minute
+	maxNodeTaskRetryBackoff = time.Hour
+)
+
+// isActiveCoreNode - returns true if NVMesh Core is installed on the node
+func isActiveCoreNode(cr *nvmeshv1.NVMesh, nodeName string) bool {
+	status, ok := cr.Status.Nodes[nodeName]
+	return ok && status.State == nvmeshv1.NodeActive && nodeHasCoreRole(status.Roles)
+}
+
+// getNVMeshNodeStatuses - returns the status of the NVMeshNodes of the cluster mapped by node name
+func (r *NVMeshBaseReconciler) getNVMeshNodeStatuses(cr *nvmeshv1.NVMesh) (map[string]*nvmeshv1.NVMeshNodeStatus, error) {
+	nvmeshNodeList := &nvmeshv1.NVMeshNodeList{}
+	err := r.Client.List(context.TODO(), nvmeshNodeList, client.InNamespace(cr.GetNamespace()), client.MatchingLabels{nvmeshClusterNameLabelKey: cr.GetName()})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list NVMeshNodes")
+	}
+
+	statuses := make(map[string]*nvmeshv1.NVMeshNodeStatus)
+	for i := range nvmeshNodeList.Items {
+		nvmeshNode := &nvmeshNodeList.Items[i]
+		statuses[nvmeshNode.Spec.NodeName] = &nvmeshNode.Status
+	}
+
+	return statuses, nil
+}
+
+// countNodeTaskFailures - sets the number of consecutive failures on a failed result, counting the failures of the previous result
+func countNodeTaskFailures(previous *nvmeshv1.NodeTaskResult, result *nvmeshv1.NodeTaskResult) *nvmeshv1.NodeTaskResult {
+	if result.Result != nvmeshv1.NodeTaskFailed {
+		return result
+	}
+
+	result.Failures = 1
+	if previous != nil && previous.Result == nvmeshv1.NodeTaskFailed {
+		result.Failures = previous.Failures + 1
+	}
+
+	return result
+}
+
+// getNodeTaskRetryDelay - returns how long to wait before a node task that failed a number of consecutive times and last finished at the given time is retried, zero or less if it can be retried now
+func getNodeTaskRetryDelay(failures int, finished metav1.Time) time.Duration {
+	backoff := nodeTaskRetryBackoff
+	for i := 1; i < failures && backoff < maxNodeTaskRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxNodeTaskRetryBackoff {
+		backoff = maxNodeTaskRetryBackoff
+	}
+
+	return time.Until(finished.Add(backoff))
+}
+
+// getNodeJobs - returns the per-node jobs of the cluster that have the given label, mapped by the value of the label which is the node they run on
+func (r *NVMeshBaseReconciler) getNodeJobs(cr *nvmeshv1.NVMesh, nodeLabelKey string) (map[string]batchv1.Job, error) {
+	jobList := &batchv1.JobList{}
+	err := r.Client.List(context.TODO(), jobList, client.InNamespace(cr.GetNamespace()), client.MatchingLabels{nvmeshClusterNameLabelKey: cr.GetName()}, client.HasLabels{nodeLabelKey})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list jobs")
+	}
+
+	jobs := make(map[string]batchv1.Job)
+	for _, job := range jobList.Items {
+		jobs[job.GetLabels()[nodeLabelKey]] = job
+	}
+
+	return jobs, nil
+}
+
+// waitForNodeJobs - requeues until the node jobs with the label are finished
+func (r *NVMeshBaseReconciler) waitForNodeJobs(cr *nvmeshv1.NVMesh, labelKey string) (ctrl.Result, error) {
+	jobs, err := r.getNodeJobs(cr, labelKey)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	if len(jobs) > 0 {
+		return Requeue(jobProgressSafetyRequeue), nil
+	}
+
+	return DoNotRequeue(), nil
+}
+
+// getJobTerminationMessage - returns the termination message of the successful container of a job. jobs that report a result write it to /dev/termination-log
+func (r *NVMeshBaseReconciler) getJobTerminationMessage(cr *nvmeshv1.NVMesh, jobName string) (string, error) {
+	podList, err := r.getJobPods(cr.GetNamespace(), jobName)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Failed to list pods of job %s", jobName))
+	}
+
+	for _, pod := range podList.Items {
+		for _, s := range pod.Status.ContainerStatuses {
+			if s.State.Terminated != nil && s.State.Terminated.ExitCode == 0 {
+				return s.State.Terminated.Message, nil
+			}
+		}
+	}
+
+	return "", goerrors.New(fmt.Sprintf("Could not find the report of job %s", jobName))
+}
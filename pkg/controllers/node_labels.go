@@ -1,108 +1,342 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
 	"time"
 
 	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
-	v1 "k8s.io/api/core/v1"
-	fields "k8s.io/apimachinery/pkg/fields"
-	"k8s.io/client-go/kubernetes"
-	cache "k8s.io/client-go/tools/cache"
+	errors "github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-//ListenToNodeLabels - Listens to node events and runs uninstall if necessary
-func (r *NVMeshReconciler) ListenToNodeLabels() (chan struct{}, error) {
-	clientset, err := kubernetes.NewForConfig(r.Manager.GetConfig())
+const (
+	// maxNodeUninstallAttempts - the number of uninstall jobs that will be started on a node before giving up
+	maxNodeUninstallAttempts = 3
+
+	nodeRoleClient     = "client"
+	nodeRoleTarget     = "target"
+	nodeRoleManagement = "management"
+)
+
+// reconcileNodes - compares the nodes that have NVMesh role labels with the nodes recorded in the status
+// and runs an uninstall job on nodes that had all of their NVMesh role labels removed.
+// The state of each node is kept in cr.Status.Nodes so that an uninstall in progress survives an operator restart,
+// and is reported on an NVMeshNode object for each node
+func (r *NVMeshReconciler) reconcileNodes(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	log := r.Log.WithName("reconcileNodes")
+	statusBefore := cr.Status.DeepCopy()
+
+	result, err := r.reconcileNodeStates(cr)
+
+	// Node states are saved right away so that jobs started in this cycle are not started again if a component requeues before the status is updated
+	if !reflect.DeepEqual(statusBefore.Nodes, cr.Status.Nodes) || !reflect.DeepEqual(statusBefore.Conditions, cr.Status.Conditions) {
+		if updateErr := r.UpdateStatus(cr); updateErr != nil {
+			log.Error(updateErr, "Failed to update nodes status")
+			return Requeue(time.Second), err
+		}
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	if err := r.syncNVMeshNodes(cr); err != nil {
+		return DoNotRequeue(), err
+	}
+
+	discoveryResult, err := r.reconcileNodeDiscovery(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	preflightResult, err := r.reconcilePreflight(cr)
 	if err != nil {
-		return nil, err
-	}
-
-	watchlist := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "nodes", "", fields.Everything())
-	_, controller := cache.NewInformer(
-		watchlist,
-		&v1.Node{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				fmt.Printf("node added: %s \n", obj.(*v1.Node).GetName())
-			},
-			DeleteFunc: func(obj interface{}) {
-				fmt.Printf("node deleted: %s \n", obj.(*v1.Node).GetName())
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldNode := oldObj.(*v1.Node)
-				newNode := newObj.(*v1.Node)
-				r.onNodeUpdate(oldNode, newNode)
-			},
-		},
-	)
-
-	stopChannel := make(chan struct{})
-
-	go controller.Run(stopChannel)
-	return stopChannel, nil
+		return DoNotRequeue(), err
+	}
+
+	results := newErrorCollector()
+	for _, res := range []ctrl.Result{result, discoveryResult, preflightResult} {
+		results.add(res, nil)
+	}
+
+	return results.Result()
 }
 
-func (r *NVMeshReconciler) onNodeUpdate(oldNode *v1.Node, newNode *v1.Node) {
-	var targetRemoved bool
-	var clientRemoved bool
+func (r *NVMeshReconciler) reconcileNodeStates(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	log := r.Log.WithName("reconcileNodes")
 
-	nodeName := newNode.GetName()
+	if err := r.reconcileNodeRoleLabels(cr); err != nil {
+		return DoNotRequeue(), err
+	}
 
-	_, clientInNew := newNode.ObjectMeta.Labels[nvmeshClientLabelKey]
-	_, clientInOld := oldNode.ObjectMeta.Labels[nvmeshClientLabelKey]
+	// nodes with the client or target labels, NVMesh Core is installed on these nodes
+	coreNodes, err := r.getAllNVMeshClusterNodes(cr)
+	if err != nil {
+		return DoNotRequeue(), errors.Wrap(err, "Failed to list NVMesh nodes")
+	}
 
-	if !clientInOld && clientInNew {
-		fmt.Printf("nvmesh-client added on node %s\n", nodeName)
+	mgmtNodeList, err := r.getAllMgmtLabelledNodes(cr)
+	if err != nil {
+		return DoNotRequeue(), errors.Wrap(err, "Failed to list NVMesh management nodes")
 	}
 
-	if clientInOld && !clientInNew {
-		clientRemoved = true
-		fmt.Printf("nvmesh-client removed from node %s\n", nodeName)
+	mgmtNodes := make(map[string]corev1.Node)
+	for _, n := range mgmtNodeList.Items {
+		mgmtNodes[n.GetName()] = n
 	}
 
-	_, targetInNew := newNode.ObjectMeta.Labels[nvmeshTargetLabelKey]
-	_, targetInOld := oldNode.ObjectMeta.Labels[nvmeshTargetLabelKey]
+	if cr.Status.Nodes == nil {
+		cr.Status.Nodes = make(map[string]nvmeshv1.NodeStatus)
+	}
+
+	for nodeName, node := range coreNodes {
+		status, found := cr.Status.Nodes[nodeName]
+		roles := getNodeRoles(&node)
+
+		if !found || status.State != nvmeshv1.NodeActive {
+			if found && status.State == nvmeshv1.NodeUninstalling {
+				// The node was labelled again before the uninstall finished, the new client/target pods will re-install the node
+				if err := r.deleteJob(cr.GetNamespace(), r.getUninstallJobName(nodeName)); err != nil {
+					return DoNotRequeue(), err
+				}
+			}
 
-	if !targetInOld && targetInNew {
-		fmt.Printf("nvmesh-target added on node %s\n", nodeName)
+			log.Info(fmt.Sprintf("Node %s joined the cluster with roles %v", nodeName, roles))
+			r.setNodeState(cr, nodeName, roles, nvmeshv1.NodeActive, 0, "Node has NVMesh role labels")
+			continue
+		}
+
+		if !stringSlicesEqual(status.Roles, roles) {
+			status.Roles = roles
+			cr.Status.Nodes[nodeName] = status
+		}
 	}
 
-	if targetInOld && !targetInNew {
-		targetRemoved = true
-		fmt.Printf("nvmesh-target removed from node %s\n", nodeName)
+	// management only nodes are tracked but nothing is installed on them
+	for nodeName, node := range mgmtNodes {
+		if _, ok := coreNodes[nodeName]; ok {
+			continue
+		}
+
+		status, found := cr.Status.Nodes[nodeName]
+		if !found {
+			log.Info(fmt.Sprintf("Node %s joined the cluster with roles %v", nodeName, getNodeRoles(&node)))
+			r.setNodeState(cr, nodeName, getNodeRoles(&node), nvmeshv1.NodeActive, 0, "Node has NVMesh role labels")
+		} else if status.State == nvmeshv1.NodeActive && !nodeHasCoreRole(status.Roles) {
+			status.Roles = getNodeRoles(&node)
+			cr.Status.Nodes[nodeName] = status
+		}
 	}
 
-	if !targetInNew && !clientInNew && (clientRemoved || targetRemoved) {
-		go r.uninstallAndWaitToFinish(nodeName)
+	requeue := false
+	for nodeName, status := range cr.Status.Nodes {
+		if _, ok := coreNodes[nodeName]; ok {
+			continue
+		}
+
+		_, isMgmtNode := mgmtNodes[nodeName]
+		nodeRequeue, err := r.reconcileUnlabelledNode(cr, nodeName, status, isMgmtNode)
+		if err != nil {
+			return DoNotRequeue(), err
+		}
+
+		requeue = requeue || nodeRequeue
+	}
+
+	if requeue {
+		return Requeue(jobProgressSafetyRequeue), nil
 	}
+
+	return DoNotRequeue(), nil
 }
 
-func (r *NVMeshReconciler) uninstallAndWaitToFinish(nodeName string) {
-	namespace := "default"
-	jobName := uninstallJobNamePrefix + nodeName
+// reconcileUnlabelledNode - advances the uninstall of a single node that no longer has the client or target labels, returns true if the uninstall is still in progress
+func (r *NVMeshReconciler) reconcileUnlabelledNode(cr *nvmeshv1.NVMesh, nodeName string, status nvmeshv1.NodeStatus, isMgmtNode bool) (bool, error) {
+	log := r.Log.WithName("reconcileNodes").WithValues("node", nodeName)
 
-	fakeCR := &nvmeshv1.NVMesh{}
-	fakeCR.SetName("uninstall-node-from-event")
-	err := r.uninstallNode(fakeCR, nodeName)
+	node := &corev1.Node{}
+	err := r.Client.Get(context.TODO(), client.ObjectKey{Name: nodeName}, node)
 	if err != nil {
-		fmt.Printf("Failed to create uninstall job on node %s\n", nodeName)
+		if !k8serrors.IsNotFound(err) {
+			return false, errors.Wrap(err, fmt.Sprintf("Failed to get node %s", nodeName))
+		}
+
+		// The node was removed from the k8s cluster, there is nothing to uninstall
+		log.Info("Node was deleted, removing it from the status")
+		r.EventManager.Normal(cr, "NodeRemoved", fmt.Sprintf("Node %s was deleted from the cluster", nodeName))
+		delete(cr.Status.Nodes, nodeName)
+		return false, r.deleteJob(cr.GetNamespace(), r.getUninstallJobName(nodeName))
 	}
 
-	completed := false
-	for !completed {
-		debugJobs := false
-		result, err := r.waitForJobToFinishWithoutCR(namespace, jobName, debugJobs)
-		if !result.Requeue && err == nil {
-			break
+	switch status.State {
+	case nvmeshv1.NodeActive:
+		if !nodeHasCoreRole(status.Roles) {
+			if !isMgmtNode {
+				// Nothing is installed on the host by the management role
+				log.Info("NVMesh management label removed from node")
+				delete(cr.Status.Nodes, nodeName)
+			}
+
+			return false, nil
+		}
+
+		if cr.Spec.Operator.SkipUninstall {
+			log.Info("NVMesh role labels removed from node. Spec.Operator.SkipUninstall: true - Skipping Uninstall")
+			r.setNodeState(cr, nodeName, getNodeRoles(node), nvmeshv1.NodeUninstalled, 0, "Uninstall skipped")
+			return false, nil
 		}
 
-		time.Sleep(3 * time.Second)
+		msg := fmt.Sprintf("NVMesh role labels removed from node %s, node will be uninstalled", nodeName)
+		log.Info(msg)
+		r.EventManager.Normal(cr, "NodeLabelsRemoved", msg)
+		r.EventManager.NormalOnObject(node, "NVMeshUninstallPending", msg)
+		r.setNodeState(cr, nodeName, getNodeRoles(node), nvmeshv1.NodeUninstallPending, 0, "Waiting for uninstall")
+		return r.startNodeUninstall(cr, node, 0)
+	case nvmeshv1.NodeUninstallPending:
+		return r.startNodeUninstall(cr, node, status.UninstallAttempts)
+	case nvmeshv1.NodeUninstalling:
+		return r.checkNodeUninstall(cr, node, status)
 	}
 
-	err = r.deleteJob(namespace, jobName)
+	// NodeUninstalled and NodeUninstallFailed are final, they are kept in the status until the node is labelled again or deleted
+	return false, nil
+}
+
+func (r *NVMeshReconciler) startNodeUninstall(cr *nvmeshv1.NVMesh, node *corev1.Node, previousAttempts int) (bool, error) {
+	nodeName := node.GetName()
+	attempt := previousAttempts + 1
+
+	// A job from a previous attempt may still be terminating, creating a job with the same name must wait until it is gone
+	existingJob := &batchv1.Job{}
+	err := r.Client.Get(context.TODO(), client.ObjectKey{Name: r.getUninstallJobName(nodeName), Namespace: cr.GetNamespace()}, existingJob)
+	if err == nil {
+		return true, nil
+	} else if !k8serrors.IsNotFound(err) {
+		return false, errors.Wrap(err, fmt.Sprintf("Failed to get uninstall job for node %s", nodeName))
+	}
+
+	if err := r.uninstallNode(cr, nodeName); err != nil {
+		return false, err
+	}
+
+	msg := fmt.Sprintf("Uninstall job started on node %s (attempt %d/%d)", nodeName, attempt, maxNodeUninstallAttempts)
+	r.EventManager.NormalOnObject(node, "NVMeshUninstallStarted", msg)
+	r.setNodeState(cr, nodeName, nil, nvmeshv1.NodeUninstalling, attempt, msg)
+	return true, nil
+}
+
+func (r *NVMeshReconciler) checkNodeUninstall(cr *nvmeshv1.NVMesh, node *corev1.Node, status nvmeshv1.NodeStatus) (bool, error) {
+	nodeName := node.GetName()
+	jobName := r.getUninstallJobName(nodeName)
+
+	job := &batchv1.Job{}
+	err := r.Client.Get(context.TODO(), client.ObjectKey{Name: jobName, Namespace: cr.GetNamespace()}, job)
 	if err != nil {
-		fmt.Printf("Failed to delete uninstall job on node %s\n", nodeName)
+		if !k8serrors.IsNotFound(err) {
+			return false, errors.Wrap(err, fmt.Sprintf("Failed to get job %s", jobName))
+		}
+
+		// The job was removed by someone else, start it again without counting this as an attempt
+		return r.startNodeUninstall(cr, node, status.UninstallAttempts-1)
+	}
+
+	completed, jobErr := r.isSingleJobCompleted(job)
+	if !completed {
+		return true, nil
+	}
+
+	if err := r.deleteJob(cr.GetNamespace(), jobName); err != nil {
+		return false, err
+	}
+
+	if jobErr == nil {
+		msg := fmt.Sprintf("Node %s uninstalled successfully", nodeName)
+		r.EventManager.Normal(cr, "NodeUninstalled", msg)
+		r.EventManager.NormalOnObject(node, "NVMeshUninstalled", msg)
+		r.setNodeState(cr, nodeName, nil, nvmeshv1.NodeUninstalled, status.UninstallAttempts, msg)
+		return false, nil
+	}
+
+	if status.UninstallAttempts < maxNodeUninstallAttempts {
+		msg := fmt.Sprintf("Uninstall job failed on node %s (attempt %d/%d), will retry. Error: %s", nodeName, status.UninstallAttempts, maxNodeUninstallAttempts, jobErr)
+		r.EventManager.WarningOnObject(node, "NVMeshUninstallRetry", msg)
+		r.setNodeState(cr, nodeName, nil, nvmeshv1.NodeUninstallPending, status.UninstallAttempts, msg)
+		return true, nil
+	}
+
+	msg := fmt.Sprintf("Uninstall failed on node %s after %d attempts. Error: %s", nodeName, status.UninstallAttempts, jobErr)
+	r.EventManager.Warning(cr, "NodeUninstallFailed", msg)
+	r.EventManager.WarningOnObject(node, "NVMeshUninstallFailed", msg)
+	r.setNodeState(cr, nodeName, nil, nvmeshv1.NodeUninstallFailed, status.UninstallAttempts, msg)
+	return false, nil
+}
+
+func (r *NVMeshReconciler) setNodeState(cr *nvmeshv1.NVMesh, nodeName string, roles []string, state nvmeshv1.NodeState, attempts int, message string) {
+	status := cr.Status.Nodes[nodeName]
+	if roles != nil {
+		status.Roles = roles
+	}
+
+	if status.State != state {
+		status.LastTransitionTime = metav1.Now()
+	}
+
+	status.State = state
+	status.UninstallAttempts = attempts
+	status.Message = message
+	cr.Status.Nodes[nodeName] = status
+}
+
+// getNodeRoles - returns the NVMesh roles of a node according to its labels
+func getNodeRoles(node *corev1.Node) []string {
+	roles := make([]string, 0)
+	nodeLabels := node.GetLabels()
+
+	if _, ok := nodeLabels[nvmeshClientLabelKey]; ok {
+		roles = append(roles, nodeRoleClient)
 	}
+
+	if _, ok := nodeLabels[nvmeshTargetLabelKey]; ok {
+		roles = append(roles, nodeRoleTarget)
+	}
+
+	if _, ok := nodeLabels[nvmeshMgmtLabelKey]; ok {
+		roles = append(roles, nodeRoleManagement)
+	}
+
+	sort.Strings(roles)
+	return roles
+}
+
+// nodeHasCoreRole - returns true if NVMesh Core software is installed on nodes with these roles
+func nodeHasCoreRole(roles []string) bool {
+	for _, role := range roles {
+		if role == nodeRoleClient || role == nodeRoleTarget {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stringSlicesEqual(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
 }
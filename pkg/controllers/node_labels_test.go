@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Node labels", func() {
+	It("retries the uninstall of a node whose labels were removed and tracks it in the status", func() {
+		cr := newTestCluster()
+
+		node := newTestNode("worker-1", map[string]string{nvmeshClientLabelKey: "", nvmeshTargetLabelKey: ""})
+
+		r := newFakeReconciler(cr, node)
+		ctx := context.TODO()
+		jobKey := client.ObjectKey{Name: r.getUninstallJobName("worker-1"), Namespace: TestingNamespace}
+
+		By("tracking a labelled node")
+		_, err := r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(cr.Status.Nodes["worker-1"].State).To(Equal(nvmeshv1.NodeActive))
+		Expect(cr.Status.Nodes["worker-1"].Roles).To(Equal([]string{nodeRoleClient, nodeRoleTarget}))
+
+		By("starting an uninstall job in the cluster namespace when the labels are removed")
+		node.SetLabels(map[string]string{})
+		Expect(r.Client.Update(ctx, node)).To(BeNil())
+
+		result, err := r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeTrue())
+		Expect(cr.Status.Nodes["worker-1"].State).To(Equal(nvmeshv1.NodeUninstalling))
+		Expect(r.Client.Get(ctx, jobKey, &batchv1.Job{})).To(BeNil())
+
+		By("giving up after the maximum number of attempts")
+		for attempt := 1; attempt <= maxNodeUninstallAttempts; attempt++ {
+			Expect(cr.Status.Nodes["worker-1"].UninstallAttempts).To(Equal(attempt))
+
+			job := &batchv1.Job{}
+			Expect(r.Client.Get(ctx, jobKey, job)).To(BeNil())
+			job.Status.Failed = 1
+			Expect(r.Client.Status().Update(ctx, job)).To(BeNil())
+
+			// first cycle sees the failure, second cycle starts the next attempt
+			_, err = r.reconcileNodes(cr)
+			Expect(err).To(BeNil())
+			_, err = r.reconcileNodes(cr)
+			Expect(err).To(BeNil())
+		}
+
+		Expect(cr.Status.Nodes["worker-1"].State).To(Equal(nvmeshv1.NodeUninstallFailed))
+
+		By("persisting the node states in the status")
+		stored := &nvmeshv1.NVMesh{}
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(cr), stored)).To(BeNil())
+		Expect(stored.Status.Nodes["worker-1"].State).To(Equal(nvmeshv1.NodeUninstallFailed))
+	})
+})
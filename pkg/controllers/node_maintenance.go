@@ -0,0 +1,574 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	nodeMaintenanceAction = "node-maintenance"
+	maintenanceModeEnter  = "enter"
+	maintenanceModeExit   = "exit"
+
+	// nvmeshMaintenanceLabelKey - a node with this label does not run the NVMesh target, see the node affinity of the target DaemonSet
+	nvmeshMaintenanceLabelKey = "nvmesh.excelero.com/maintenance"
+	// maintenanceCordonedAnnotation - set when the node was cordoned by the node-maintenance action, so that exit only uncordons nodes it cordoned
+	maintenanceCordonedAnnotation = "nvmesh.excelero.com/maintenance-cordoned"
+	// maintenanceEvictedDrivesAnnotation - the IDs of the drives evicted by the node-maintenance action, so that exit only reincludes drives it evicted
+	maintenanceEvictedDrivesAnnotation = "nvmesh.excelero.com/maintenance-evicted-drives"
+
+	maintenanceNodeKey       = "node"
+	maintenanceModeKey       = "mode"
+	maintenanceWaitingForKey = "waitingFor"
+
+	cordonNodeStage      = "CordonNode"
+	evictDrivesStage     = "EvictDrives"
+	waitForRebuildStage  = "WaitForRebuild"
+	stopTargetStage      = "StopTarget"
+	startTargetStage     = "StartTarget"
+	reincludeDrivesStage = "ReincludeDrives"
+	uncordonNodeStage    = "UncordonNode"
+
+	maintenanceProgressRequeue = 15 * time.Second
+)
+
+// nodeMaintenance - prepares a target node for maintenance (mode=enter) or returns it to service (mode=exit).
+// enter cordons the node, evicts its drives in Management, waits until the volumes are rebuilt on the drives of other nodes and stops the target pod.
+// exit starts the target, reincludes the drives and uncordons the node. an enter that fails or times out is reverted
+type nodeMaintenance struct {
+	actionOptions
+}
+
+func (nodeMaintenance) Validate(r *NVMeshReconciler, cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error {
+	nodeName, _ := getActionArg(a, maintenanceNodeKey)
+	mode, _ := getActionArg(a, maintenanceModeKey)
+
+	if nodeName == "" {
+		return goerrors.New("the node argument is required")
+	}
+
+	if mode != maintenanceModeEnter && mode != maintenanceModeExit {
+		return fmt.Errorf("the mode argument should be %s or %s, got %q", maintenanceModeEnter, maintenanceModeExit, mode)
+	}
+
+	if cr.Spec.Core.Disabled || cr.Spec.Management.Disabled {
+		return goerrors.New("NVMesh Core and Management must be deployed by the operator")
+	}
+
+	node := &corev1.Node{}
+	if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: nodeName}, node); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to get node %s", nodeName))
+	}
+
+	if _, ok := node.GetLabels()[nvmeshTargetLabelKey]; !ok {
+		return fmt.Errorf("node %s is not an NVMesh target node", nodeName)
+	}
+
+	if mode == maintenanceModeEnter {
+		ds := &appsv1.DaemonSet{}
+		if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: targetDriverDaemonSetName, Namespace: cr.GetNamespace()}, ds); err != nil {
+			return errors.Wrap(err, "Failed to get the target DaemonSet")
+		}
+
+		if !hasMaintenanceAffinity(&ds.Spec.Template) {
+			return goerrors.New("the target DaemonSet was deployed by an older operator and does not stop the target on nodes in maintenance, it is updated with the next NVMesh Core upgrade")
+		}
+	}
+
+	return nil
+}
+
+// Revert - returns a node that did not finish entering maintenance to service, the target is started again, the evicted drives are reincluded and the node is uncordoned
+func (nodeMaintenance) Revert(r *NVMeshReconciler, cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error {
+	nodeName, _ := getActionArg(a, maintenanceNodeKey)
+	mode, _ := getActionArg(a, maintenanceModeKey)
+	if mode != maintenanceModeEnter {
+		return nil
+	}
+
+	node := &corev1.Node{}
+	node.SetName(nodeName)
+	if err := r.setNodeMaintenanceLabel(node, false); err != nil {
+		return err
+	}
+
+	// the reinclusion is verified by node-maintenance exit, Revert only requests it
+	if _, err := r.reincludeMaintenanceDrives(cr, node); err != nil {
+		return err
+	}
+
+	if err := r.uncordonNodeAfterMaintenance(node); err != nil {
+		return err
+	}
+
+	r.EventManager.Warning(cr, "NodeMaintenanceReverted", fmt.Sprintf("node-maintenance enter of node %s did not finish, the target was started again, the drives were reincluded and the node was uncordoned", nodeName))
+	return nil
+}
+
+func (nodeMaintenance) Stages(r *NVMeshReconciler, a nvmeshv1.ClusterAction) []Task {
+	nodeName, _ := getActionArg(a, maintenanceNodeKey)
+	mode, _ := getActionArg(a, maintenanceModeKey)
+
+	// the helpers get the node before changing it
+	node := &corev1.Node{}
+	node.SetName(nodeName)
+
+	if mode == maintenanceModeExit {
+		return []Task{
+			{startTargetStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+				return r.startTargetPod(cr, node)
+			}},
+			{reincludeDrivesStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+				return r.reincludeMaintenanceDrives(cr, node)
+			}},
+			{uncordonNodeStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+				if err := r.uncordonNodeAfterMaintenance(node); err != nil {
+					return DoNotRequeue(), err
+				}
+
+				r.EventManager.Normal(cr, "NodeMaintenance", fmt.Sprintf("node-maintenance exit of node %s finished", nodeName))
+				return DoNotRequeue(), nil
+			}},
+		}
+	}
+
+	return []Task{
+		{cordonNodeStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return DoNotRequeue(), r.cordonNodeForMaintenance(node)
+		}},
+		{evictDrivesStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return r.evictDrivesForMaintenance(cr, a, node)
+		}},
+		{waitForRebuildStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return r.waitForVolumesToRebuild(cr, a, node)
+		}},
+		{stopTargetStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			result, err := r.stopTargetPod(cr, node)
+			if err == nil && !result.Requeue {
+				r.EventManager.Normal(cr, "NodeMaintenance", fmt.Sprintf("node-maintenance enter of node %s finished", nodeName))
+			}
+
+			return result, err
+		}},
+	}
+}
+
+func (r *NVMeshReconciler) cordonNodeForMaintenance(node *corev1.Node) error {
+	if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(node), node); err != nil {
+		return err
+	}
+
+	if node.Spec.Unschedulable {
+		// cordoned by the user, it is left cordoned when maintenance ends
+		return nil
+	}
+
+	node.Spec.Unschedulable = true
+	annotations := node.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	annotations[maintenanceCordonedAnnotation] = "true"
+	node.SetAnnotations(annotations)
+	return errors.Wrap(r.Client.Update(context.TODO(), node), fmt.Sprintf("Failed to cordon node %s", node.GetName()))
+}
+
+func (r *NVMeshReconciler) uncordonNodeAfterMaintenance(node *corev1.Node) error {
+	if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(node), node); err != nil {
+		return err
+	}
+
+	annotations := node.GetAnnotations()
+	if _, ok := annotations[maintenanceCordonedAnnotation]; !ok {
+		return nil
+	}
+
+	node.Spec.Unschedulable = false
+	delete(annotations, maintenanceCordonedAnnotation)
+	node.SetAnnotations(annotations)
+	return errors.Wrap(r.Client.Update(context.TODO(), node), fmt.Sprintf("Failed to uncordon node %s", node.GetName()))
+}
+
+// getVolumesOnNode - returns the volumes that have a copy on the node and are not fully healthy, these volumes are still being rebuilt,
+// and the volumes without another copy of the data that is on the node, these volumes can not be served while the target is stopped
+func getVolumesOnNode(volumes []managementVolume, names *mgmtNodeNames, nodeName string) ([]string, []string) {
+	degraded := make([]string, 0)
+	nonRedundant := make([]string, 0)
+	for _, volume := range volumes {
+		isDegraded, isNonRedundant := false, false
+		for _, chunk := range volume.Chunks {
+			for _, praid := range chunk.Praids {
+				onNode, healthy, data := false, 0, 0
+				for _, segment := range praid.DiskSegments {
+					if !isDataSegment(segment) {
+						continue
+					}
+
+					data++
+					if segment.Health == "healthy" {
+						healthy++
+					}
+
+					if name, ok := names.NodeName(segment.NodeID); ok && name == nodeName {
+						onNode = true
+					}
+				}
+
+				if !onNode {
+					continue
+				}
+
+				if data < 2 {
+					isNonRedundant = true
+				} else if healthy < data {
+					isDegraded = true
+				}
+			}
+		}
+
+		if isNonRedundant {
+			nonRedundant = append(nonRedundant, volume.Name)
+		} else if isDegraded {
+			degraded = append(degraded, volume.Name)
+		}
+	}
+
+	sort.Strings(degraded)
+	sort.Strings(nonRedundant)
+	return degraded, nonRedundant
+}
+
+// getNodeDisks - returns the drives Management reports for the node
+func getNodeDisks(servers []managementServer, names *mgmtNodeNames, nodeName string) []managementDisk {
+	disks := make([]managementDisk, 0)
+	for _, server := range servers {
+		if name, ok := names.NodeName(server.NodeID); ok && name == nodeName {
+			disks = append(disks, server.Disks...)
+		}
+	}
+
+	return disks
+}
+
+// getMaintenanceEvictedDrives - returns the IDs of the drives the node-maintenance action evicted on the node
+func (r *NVMeshReconciler) getMaintenanceEvictedDrives(node *corev1.Node) ([]string, error) {
+	if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(node), node); err != nil {
+		return nil, err
+	}
+
+	value := node.GetAnnotations()[maintenanceEvictedDrivesAnnotation]
+	if value == "" {
+		return []string{}, nil
+	}
+
+	return strings.Split(value, ","), nil
+}
+
+func (r *NVMeshReconciler) setMaintenanceEvictedDrives(node *corev1.Node, diskIDs []string) error {
+	if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(node), node); err != nil {
+		return err
+	}
+
+	annotations := node.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	if len(diskIDs) > 0 {
+		annotations[maintenanceEvictedDrivesAnnotation] = strings.Join(diskIDs, ",")
+	} else {
+		delete(annotations, maintenanceEvictedDrivesAnnotation)
+	}
+
+	node.SetAnnotations(annotations)
+	return errors.Wrap(r.Client.Update(context.TODO(), node), fmt.Sprintf("Failed to update the annotations of node %s", node.GetName()))
+}
+
+// evictDrivesForMaintenance - evicts the drives of the node in Management and waits until Management reports them as evicted.
+// the drives are evicted once the volumes with a copy on the node are healthy, it fails when a volume has no other copy of the data on the node
+func (r *NVMeshReconciler) evictDrivesForMaintenance(cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction, node *corev1.Node) (ctrl.Result, error) {
+	nodeName := node.GetName()
+	names, err := r.getMgmtNodeNames()
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	api, err := r.loginToManagement(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	servers, err := api.GetServers()
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	disks := getNodeDisks(servers, names, nodeName)
+	if len(disks) == 0 {
+		return DoNotRequeue(), newActionFailure("node %s has no drives in Management", nodeName)
+	}
+
+	evicted, err := r.getMaintenanceEvictedDrives(node)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	toEvict := make([]string, 0)
+	for _, disk := range disks {
+		if !isDiskOutOfUse(disk) && !stringInSlice(disk.DiskID, evicted) {
+			toEvict = append(toEvict, disk.DiskID)
+		}
+	}
+
+	if len(toEvict) > 0 {
+		volumes, err := api.GetVolumes()
+		if err != nil {
+			return DoNotRequeue(), err
+		}
+
+		degraded, nonRedundant := getVolumesOnNode(volumes, names, nodeName)
+		if len(nonRedundant) > 0 {
+			return DoNotRequeue(), newActionFailure("volumes %s have no other copy of their data on node %s, they would be unavailable while the target is stopped", strings.Join(nonRedundant, ", "), nodeName)
+		}
+
+		r.setTaskStatus(cr, a, maintenanceWaitingForKey, strings.Join(degraded, ","))
+		if len(degraded) > 0 {
+			r.Log.Info(fmt.Sprintf("Waiting for volumes %v to be healthy before the drives of node %s are evicted", degraded, nodeName))
+			return Requeue(maintenanceProgressRequeue), nil
+		}
+
+		// the drives are recorded before they are evicted, so that a revert or exit reincludes them even if the action is interrupted
+		evicted = append(evicted, toEvict...)
+		if err := r.setMaintenanceEvictedDrives(node, evicted); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		for _, diskID := range toEvict {
+			if err := api.EvictDrive(diskID); err != nil {
+				return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to evict drive %s of node %s", diskID, nodeName))
+			}
+		}
+
+		if servers, err = api.GetServers(); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		disks = getNodeDisks(servers, names, nodeName)
+	}
+
+	for _, disk := range disks {
+		if stringInSlice(disk.DiskID, evicted) && !strings.EqualFold(disk.Status, mgmtDiskStatusEvicted) {
+			r.Log.Info(fmt.Sprintf("Waiting for Management to evict drive %s of node %s, status: %s", disk.DiskID, nodeName, disk.Status))
+			return Requeue(maintenanceProgressRequeue), nil
+		}
+	}
+
+	return DoNotRequeue(), nil
+}
+
+// waitForVolumesToRebuild - waits until no volume has data on the drives evicted from the node, their segments are rebuilt on the drives of other nodes
+func (r *NVMeshReconciler) waitForVolumesToRebuild(cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction, node *corev1.Node) (ctrl.Result, error) {
+	evicted, err := r.getMaintenanceEvictedDrives(node)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	api, err := r.loginToManagement(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	volumes, err := api.GetVolumes()
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	rebuilding := make([]string, 0)
+	for _, diskID := range evicted {
+		withData, _ := getVolumesOnDrive(volumes, diskID)
+		for _, volume := range withData {
+			if !stringInSlice(volume, rebuilding) {
+				rebuilding = append(rebuilding, volume)
+			}
+		}
+	}
+
+	sort.Strings(rebuilding)
+	r.setTaskStatus(cr, a, maintenanceWaitingForKey, strings.Join(rebuilding, ","))
+	if len(rebuilding) > 0 {
+		r.Log.Info(fmt.Sprintf("Waiting for volumes %v to be rebuilt before node %s enters maintenance", rebuilding, node.GetName()))
+		return Requeue(maintenanceProgressRequeue), nil
+	}
+
+	return DoNotRequeue(), nil
+}
+
+// reincludeMaintenanceDrives - reincludes the drives the node-maintenance action evicted on the node and waits until Management no longer reports them as evicted.
+// drives excluded in the spec are left evicted
+func (r *NVMeshReconciler) reincludeMaintenanceDrives(cr *nvmeshv1.NVMesh, node *corev1.Node) (ctrl.Result, error) {
+	nodeName := node.GetName()
+	evicted, err := r.getMaintenanceEvictedDrives(node)
+	if err != nil || len(evicted) == 0 {
+		return DoNotRequeue(), err
+	}
+
+	names, err := r.getMgmtNodeNames()
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	api, err := r.loginToManagement(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	servers, err := api.GetServers()
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	req := driveActionRequest{Node: nodeName}
+	toReinclude := make([]string, 0)
+	for _, disk := range getNodeDisks(servers, names, nodeName) {
+		if !stringInSlice(disk.DiskID, evicted) || !strings.EqualFold(disk.Status, mgmtDiskStatusEvicted) {
+			continue
+		}
+
+		excluded, err := r.isDriveExcludedInSpec(cr, req, disk)
+		if err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if !excluded {
+			toReinclude = append(toReinclude, disk.DiskID)
+		}
+	}
+
+	if len(toReinclude) > 0 {
+		for _, diskID := range toReinclude {
+			if err := api.ReincludeDrive(diskID); err != nil {
+				return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to reinclude drive %s of node %s", diskID, nodeName))
+			}
+		}
+
+		if servers, err = api.GetServers(); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		for _, disk := range getNodeDisks(servers, names, nodeName) {
+			if stringInSlice(disk.DiskID, toReinclude) && strings.EqualFold(disk.Status, mgmtDiskStatusEvicted) {
+				r.Log.Info(fmt.Sprintf("Waiting for Management to reinclude drive %s of node %s", disk.DiskID, nodeName))
+				return Requeue(maintenanceProgressRequeue), nil
+			}
+		}
+	}
+
+	return DoNotRequeue(), r.setMaintenanceEvictedDrives(node, nil)
+}
+
+func (r *NVMeshReconciler) getTargetPodsOnNode(cr *nvmeshv1.NVMesh, nodeName string) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := r.Client.List(context.TODO(), pods, client.InNamespace(cr.GetNamespace()), client.MatchingLabels{"app": targetDriverDaemonSetName}); err != nil {
+		return nil, errors.Wrap(err, "Failed to list the target pods")
+	}
+
+	onNode := make([]corev1.Pod, 0)
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == nodeName {
+			onNode = append(onNode, pod)
+		}
+	}
+
+	return onNode, nil
+}
+
+// hasMaintenanceAffinity - returns true if the pods of the template do not run on nodes with the maintenance label
+func hasMaintenanceAffinity(template *corev1.PodTemplateSpec) bool {
+	affinity := template.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		found := false
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == nvmeshMaintenanceLabelKey && expr.Operator == corev1.NodeSelectorOpDoesNotExist {
+				found = true
+			}
+		}
+
+		// the terms are ORed, every term must exclude the nodes in maintenance
+		if !found {
+			return false
+		}
+	}
+
+	return len(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms) > 0
+}
+
+// setNodeMaintenanceLabel - the target DaemonSet does not run on nodes with the maintenance label, so the DaemonSet controller terminates the target pod gracefully and starts it again when the label is removed
+func (r *NVMeshReconciler) setNodeMaintenanceLabel(node *corev1.Node, set bool) error {
+	if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(node), node); err != nil {
+		return err
+	}
+
+	labels := node.GetLabels()
+	if _, ok := labels[nvmeshMaintenanceLabelKey]; ok == set {
+		return nil
+	}
+
+	if set {
+		labels[nvmeshMaintenanceLabelKey] = ""
+	} else {
+		delete(labels, nvmeshMaintenanceLabelKey)
+	}
+
+	node.SetLabels(labels)
+	return errors.Wrap(r.Client.Update(context.TODO(), node), fmt.Sprintf("Failed to update the labels of node %s", node.GetName()))
+}
+
+func (r *NVMeshReconciler) stopTargetPod(cr *nvmeshv1.NVMesh, node *corev1.Node) (ctrl.Result, error) {
+	if err := r.setNodeMaintenanceLabel(node, true); err != nil {
+		return DoNotRequeue(), err
+	}
+
+	pods, err := r.getTargetPodsOnNode(cr, node.GetName())
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	if len(pods) > 0 {
+		return Requeue(maintenanceProgressRequeue), nil
+	}
+
+	return DoNotRequeue(), nil
+}
+
+func (r *NVMeshReconciler) startTargetPod(cr *nvmeshv1.NVMesh, node *corev1.Node) (ctrl.Result, error) {
+	if err := r.setNodeMaintenanceLabel(node, false); err != nil {
+		return DoNotRequeue(), err
+	}
+
+	pods, err := r.getTargetPodsOnNode(cr, node.GetName())
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	if len(pods) == 0 || !isPodReady(&pods[0]) {
+		return Requeue(maintenanceProgressRequeue), nil
+	}
+
+	return DoNotRequeue(), nil
+}
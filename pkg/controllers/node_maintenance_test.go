@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newMaintenanceAction(id string, mode string) nvmeshv1.ClusterAction {
+	return nvmeshv1.ClusterAction{Name: nodeMaintenanceAction, ID: id, Args: map[string]string{"node": "node1", "mode": mode}}
+}
+
+var _ = Describe("Node maintenance", func() {
+	var (
+		ctx      = context.TODO()
+		cr       *nvmeshv1.NVMesh
+		r        *NVMeshReconciler
+		api      *fakeManagementAPI
+		recorder *record.FakeRecorder
+		restore  func()
+	)
+
+	getNode := func() *corev1.Node {
+		node := &corev1.Node{}
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: "node1"}, node)).To(Succeed())
+		return node
+	}
+
+	BeforeEach(func() {
+		cr = newTestCluster()
+
+		api = &fakeManagementAPI{available: true}
+		api.servers = []managementServer{
+			{NodeID: "node1.example.com", Health: "healthy", Disks: []managementDisk{
+				{DiskID: "nvme.SERIAL0-1", SerialNumber: "SERIAL0", Path: "/dev/nvme0n1", Status: "Ok"},
+				{DiskID: "nvme.SERIAL1-1", SerialNumber: "SERIAL1", Path: "/dev/nvme1n1", Status: "Excluded"},
+			}},
+			{NodeID: "node2", Health: "healthy", Disks: []managementDisk{
+				{DiskID: "nvme.SERIAL2-1", SerialNumber: "SERIAL2", Path: "/dev/nvme0n1", Status: "Ok"},
+			}},
+		}
+		restore = useFakeManagementAPI(api)
+
+		r = newFakeReconciler(cr, newTestNode("node1", map[string]string{nvmeshTargetLabelKey: ""}), newTestNode("node2", nil), newTestTargetDaemonSet(true), newTestTargetPod("node1"), newMgmtAdminSecret())
+		recorder = r.EventManager.recorder.(*record.FakeRecorder)
+	})
+
+	AfterEach(func() {
+		restore()
+	})
+
+	It("rejects an unknown mode", func() {
+		done, _, err := r.handleAction(newMaintenanceAction("maintenance-1", "pause"), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(HaveOccurred())
+		Expect(getNode().Spec.Unschedulable).To(BeFalse())
+	})
+
+	It("refuses to enter while the target DaemonSet runs on nodes in maintenance", func() {
+		Expect(r.Client.Update(ctx, newTestTargetDaemonSet(false))).To(Succeed())
+
+		done, _, err := r.handleAction(newMaintenanceAction("maintenance-1", maintenanceModeEnter), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("older operator")))
+		Expect(getNode().Spec.Unschedulable).To(BeFalse())
+	})
+
+	It("does not update the target DaemonSet only to add the maintenance affinity", func() {
+		corer := NVMeshCoreReconciler(*r)
+		Expect(corer.shouldUpdateDaemonSet(cr, newTestTargetDaemonSet(true), newTestTargetDaemonSet(false))).To(BeFalse())
+	})
+
+	It("evicts the drives of the node and waits for the volumes to be rebuilt before stopping the target", func() {
+		enter := newMaintenanceAction("maintenance-1", maintenanceModeEnter)
+		api.volumes = []managementVolume{newTestVolume("vol-1",
+			managementDiskSegment{NodeID: "node1.example.com", DiskID: "nvme.SERIAL0-1", Type: "data", Health: "critical"},
+			managementDiskSegment{NodeID: "node2", DiskID: "nvme.SERIAL2-1", Type: "data", Health: "healthy"},
+		)}
+
+		done, result, err := r.handleAction(enter, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeFalse())
+		Expect(result.RequeueAfter).To(Equal(maintenanceProgressRequeue))
+		Expect(getNode().Spec.Unschedulable).To(BeTrue())
+		Expect(api.drives).To(BeEmpty())
+		Expect(cr.Status.ActionsStatus[enter.ID]).To(HaveKeyWithValue(maintenanceWaitingForKey, "vol-1"))
+
+		By("evicting the drives in use once the volume is healthy")
+		api.volumes[0].Chunks[0].Praids[0].DiskSegments[0].Health = "healthy"
+		done, _, err = r.handleAction(enter, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeFalse())
+		Expect(api.drives).To(Equal([]string{"evict:nvme.SERIAL0-1"}))
+		Expect(getNode().GetAnnotations()).To(HaveKeyWithValue(maintenanceEvictedDrivesAnnotation, "nvme.SERIAL0-1"))
+		Expect(cr.Status.ActionsStatus[enter.ID]).To(HaveKeyWithValue(evictDrivesStage, taskFinished))
+		Expect(getNode().GetLabels()).NotTo(HaveKey(nvmeshMaintenanceLabelKey))
+
+		By("stopping the target once the volume is rebuilt on other nodes")
+		api.volumes = []managementVolume{newTestVolume("vol-1",
+			managementDiskSegment{NodeID: "node2", DiskID: "nvme.SERIAL2-1", Type: "data", Health: "healthy"},
+			managementDiskSegment{NodeID: "node3", DiskID: "nvme.SERIAL3-1", Type: "data", Health: "healthy"},
+		)}
+		done, _, err = r.handleAction(enter, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeFalse())
+		Expect(getNode().GetLabels()).To(HaveKey(nvmeshMaintenanceLabelKey))
+		Expect(api.drives).To(HaveLen(1))
+
+		By("finishing once the target pod is gone")
+		Expect(r.Client.Delete(ctx, newTestTargetPod("node1"))).To(Succeed())
+		done, _, err = r.handleAction(enter, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeTrue())
+		Expect(getNode().Spec.Unschedulable).To(BeTrue())
+	})
+
+	It("fails without evicting the drives when a volume has no other copy on other nodes", func() {
+		api.volumes = []managementVolume{newTestVolume("vol-1", newTestSegment("node1", "healthy"))}
+
+		done, _, err := r.handleAction(newMaintenanceAction("maintenance-1", maintenanceModeEnter), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("vol-1 have no other copy")))
+		Expect(api.drives).To(BeEmpty())
+
+		By("returning the node to service")
+		Expect(getNode().Spec.Unschedulable).To(BeFalse())
+		Expect(getNode().GetAnnotations()).NotTo(HaveKey(maintenanceCordonedAnnotation))
+		Expect(recorder.Events).To(Receive(ContainSubstring("NodeMaintenanceReverted")))
+	})
+
+	It("fails when Management has no drives of the node", func() {
+		api.servers = api.servers[1:]
+
+		done, _, err := r.handleAction(newMaintenanceAction("maintenance-1", maintenanceModeEnter), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("node node1 has no drives in Management")))
+		Expect(getNode().Spec.Unschedulable).To(BeFalse())
+	})
+
+	It("returns the node to service when entering times out", func() {
+		enter := newMaintenanceAction("maintenance-1", maintenanceModeEnter)
+		api.volumes = []managementVolume{newTestVolume("vol-1",
+			managementDiskSegment{NodeID: "node1.example.com", DiskID: "nvme.SERIAL0-1", Type: "data", Health: "healthy"},
+			managementDiskSegment{NodeID: "node2", DiskID: "nvme.SERIAL2-1", Type: "data", Health: "healthy"},
+		)}
+
+		done, _, err := r.handleAction(enter, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeFalse())
+		Expect(api.drives).To(Equal([]string{"evict:nvme.SERIAL0-1"}))
+
+		By("timing out while the volumes are rebuilt")
+		r.setTaskStatus(cr, enter, actionStartTimeKey, time.Now().Add(-25*time.Hour).Format(time.RFC3339))
+		done, _, err = r.handleAction(enter, cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(HaveOccurred())
+		Expect(cr.Status.ActionHistory[0].Result).To(Equal(nvmeshv1.ActionTimedOut))
+		Expect(api.drives).To(Equal([]string{"evict:nvme.SERIAL0-1", "reinclude:nvme.SERIAL0-1"}))
+		Expect(getNode().Spec.Unschedulable).To(BeFalse())
+		Expect(getNode().GetLabels()).NotTo(HaveKey(nvmeshMaintenanceLabelKey))
+		Expect(getNode().GetAnnotations()).NotTo(HaveKey(maintenanceEvictedDrivesAnnotation))
+		Expect(recorder.Events).To(Receive(ContainSubstring("NodeMaintenanceReverted")))
+	})
+
+	It("starts the target, reincludes the drives and uncordons the node on exit", func() {
+		node := getNode()
+		node.Spec.Unschedulable = true
+		node.Labels[nvmeshMaintenanceLabelKey] = ""
+		node.SetAnnotations(map[string]string{maintenanceCordonedAnnotation: "true", maintenanceEvictedDrivesAnnotation: "nvme.SERIAL0-1"})
+		Expect(r.Client.Update(ctx, node)).To(Succeed())
+		Expect(r.Client.Delete(ctx, newTestTargetPod("node1"))).To(Succeed())
+		api.servers[0].Disks[0].Status = mgmtDiskStatusEvicted
+
+		exit := newMaintenanceAction("maintenance-1", maintenanceModeExit)
+		done, _, err := r.handleAction(exit, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeFalse())
+		Expect(getNode().GetLabels()).NotTo(HaveKey(nvmeshMaintenanceLabelKey))
+		Expect(getNode().Spec.Unschedulable).To(BeTrue())
+		Expect(api.drives).To(BeEmpty())
+
+		By("reincluding the drives and uncordoning once the target is ready")
+		Expect(r.Client.Create(ctx, newTestTargetPod("node1"))).To(Succeed())
+		done, _, err = r.handleAction(exit, cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeTrue())
+		Expect(api.drives).To(Equal([]string{"reinclude:nvme.SERIAL0-1"}))
+		Expect(getNode().Spec.Unschedulable).To(BeFalse())
+		Expect(getNode().GetAnnotations()).NotTo(HaveKey(maintenanceCordonedAnnotation))
+		Expect(getNode().GetAnnotations()).NotTo(HaveKey(maintenanceEvictedDrivesAnnotation))
+	})
+
+	It("leaves drives excluded in the spec evicted on exit", func() {
+		cr.Spec.Core.ExcludeDrives = &nvmeshv1.ExcludeNVMeDrivesSpec{SerialNumbers: []string{"SERIAL0"}}
+		node := getNode()
+		node.SetAnnotations(map[string]string{maintenanceEvictedDrivesAnnotation: "nvme.SERIAL0-1"})
+		Expect(r.Client.Update(ctx, node)).To(Succeed())
+		api.servers[0].Disks[0].Status = mgmtDiskStatusEvicted
+
+		done, _, err := r.handleAction(newMaintenanceAction("maintenance-1", maintenanceModeExit), cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(done).To(BeTrue())
+		Expect(api.drives).To(BeEmpty())
+		Expect(getNode().GetAnnotations()).NotTo(HaveKey(maintenanceEvictedDrivesAnnotation))
+	})
+
+	It("leaves a node cordoned by the user cordoned", func() {
+		node := getNode()
+		node.Spec.Unschedulable = true
+		Expect(r.Client.Update(ctx, node)).To(Succeed())
+		api.volumes = []managementVolume{newTestVolume("vol-1", newTestSegment("node1", "healthy"))}
+
+		done, _, err := r.handleAction(newMaintenanceAction("maintenance-1", maintenanceModeEnter), cr)
+		Expect(done).To(BeTrue())
+		Expect(err).To(HaveOccurred())
+		Expect(getNode().Spec.Unschedulable).To(BeTrue())
+	})
+})
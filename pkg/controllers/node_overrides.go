@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"fmt"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	nvmeshConfKey   = "nvmesh.conf"
+	modprobeConfKey = "modprobe.d"
+)
+
+// nodeCoreConfig - the Core configuration of a single node after applying spec.core.nodeOverrides
+type nodeCoreConfig struct {
+	ConfiguredNICs string
+	TCPOnly        bool
+	// AutoNICs - configuredNICs is "auto", the NICs are chosen from the node's discovery
+	AutoNICs bool
+	// PendingDiscovery - the NICs are chosen automatically but the node was not discovered yet
+	PendingDiscovery bool
+	ExcludeDrives    *nvmeshv1.ExcludeNVMeDrivesSpec
+	ModuleParams     string
+	ExtraConfig      map[string]string
+}
+
+func getClusterCoreConfig(cr *nvmeshv1.NVMesh) nodeCoreConfig {
+	config := nodeCoreConfig{
+		ConfiguredNICs: cr.Spec.Core.ConfiguredNICs,
+		TCPOnly:        cr.Spec.Core.TCPOnly,
+		ExcludeDrives:  cr.Spec.Core.ExcludeDrives,
+		ModuleParams:   joinModprobeConf(cr.Spec.Core.ModuleParams, renderModuleOptions(cr.Spec.Core.Modules)),
+		ExtraConfig:    make(map[string]string),
+	}
+
+	for key, value := range cr.Spec.Core.ExtraConfig {
+		config.ExtraConfig[key] = value
+	}
+
+	// the cluster wide config can not choose NICs automatically, only the per-node config can
+	if config.ConfiguredNICs == configuredNICsAuto {
+		config.ConfiguredNICs = ""
+		config.AutoNICs = true
+	}
+
+	return config
+}
+
+// getNodeCoreConfig - returns the Core configuration of a node, overrides are applied in order so that later overrides win.
+// discovery is the node's last discovery, used to choose the NICs when configuredNICs is "auto"
+func getNodeCoreConfig(cr *nvmeshv1.NVMesh, nodeName string, nodeLabels map[string]string, discovery *nvmeshv1.NodeDiscovery) (nodeCoreConfig, error) {
+	config := getClusterCoreConfig(cr)
+
+	for i, override := range cr.Spec.Core.NodeOverrides {
+		matches, err := nodeOverrideMatches(&override, nodeName, nodeLabels)
+		if err != nil {
+			return config, validationError(cr, fmt.Sprintf("Invalid label selector in spec.core.nodeOverrides[%d].nodeSelector.", i), err.Error())
+		}
+
+		if !matches {
+			continue
+		}
+
+		if override.ConfiguredNICs == configuredNICsAuto {
+			config.ConfiguredNICs = ""
+			config.AutoNICs = true
+		} else if override.ConfiguredNICs != "" {
+			config.ConfiguredNICs = override.ConfiguredNICs
+			config.AutoNICs = false
+		}
+
+		if override.TCPOnly != nil {
+			config.TCPOnly = *override.TCPOnly
+		}
+
+		if override.ExcludeDrives != nil {
+			config.ExcludeDrives = override.ExcludeDrives
+		}
+
+		if override.ModuleParams != "" {
+			config.ModuleParams = joinModprobeConf(config.ModuleParams, override.ModuleParams)
+		}
+
+		for key, value := range override.ExtraConfig {
+			config.ExtraConfig[key] = value
+		}
+	}
+
+	if config.AutoNICs {
+		applyDiscoveredNICs(&config, discovery)
+	}
+
+	return config, nil
+}
+
+func nodeOverrideMatches(override *nvmeshv1.NodeConfigOverride, nodeName string, nodeLabels map[string]string) (bool, error) {
+	if override.NodeName != "" {
+		return override.NodeName == nodeName, nil
+	}
+
+	if override.NodeSelector == nil {
+		return false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(override.NodeSelector)
+	if err != nil {
+		return false, err
+	}
+
+	return selector.Matches(labels.Set(nodeLabels)), nil
+}
+
+// validateNodeOverrides - verifies each override selects nodes in exactly one way and that its extra config is valid
+func validateNodeOverrides(cr *nvmeshv1.NVMesh) error {
+	for i, override := range cr.Spec.Core.NodeOverrides {
+		field := fmt.Sprintf("spec.core.nodeOverrides[%d]", i)
+
+		if (override.NodeName == "") == (override.NodeSelector == nil) {
+			return validationError(cr, fmt.Sprintf("Invalid node override in %s.", field), "Exactly one of nodeName or nodeSelector should be set.")
+		}
+
+		if override.NodeSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(override.NodeSelector); err != nil {
+				return validationError(cr, fmt.Sprintf("Invalid label selector in %s.nodeSelector.", field), err.Error())
+			}
+		}
+
+		if err := validateExtraConfig(cr, field+".extraConfig", override.ExtraConfig); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clusterUsesRDMA - returns true if any node may use RDMA, in which case the Core pods mount the Infiniband libraries
+func clusterUsesRDMA(cr *nvmeshv1.NVMesh) bool {
+	if !cr.Spec.Core.TCPOnly {
+		return true
+	}
+
+	for _, override := range cr.Spec.Core.NodeOverrides {
+		if override.TCPOnly != nil && !*override.TCPOnly {
+			return true
+		}
+	}
+
+	return false
+}
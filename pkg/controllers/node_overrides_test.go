@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Node overrides", func() {
+	It("renders the config of each node with its overrides applied to its own ConfigMap", func() {
+		ctx := context.TODO()
+		tcpOnly := false
+		cr := newTestCluster()
+		cr.Spec.Core.TCPOnly = true
+		cr.Spec.Core.ConfiguredNICs = "eth0"
+		cr.Spec.Core.ModuleParams = "options nvmeibc a=1"
+		cr.Spec.Core.NodeOverrides = []nvmeshv1.NodeConfigOverride{
+			{
+				NodeSelector:   &metav1.LabelSelector{MatchLabels: map[string]string{"nic": "ens"}},
+				ConfiguredNICs: "ens1f0",
+				ExcludeDrives:  &nvmeshv1.ExcludeNVMeDrivesSpec{DevicePaths: []string{"/dev/nvme0n1"}},
+			},
+			{
+				NodeName:     "worker-2",
+				TCPOnly:      &tcpOnly,
+				ModuleParams: "options nvmeibs b=2",
+				ExtraConfig:  map[string]string{"MCS_LOGGING_LEVEL": "INFO"},
+			},
+		}
+
+		worker1 := newTestNode("worker-1", map[string]string{nvmeshClientLabelKey: ""})
+		worker2 := newTestNode("worker-2", map[string]string{nvmeshTargetLabelKey: "", "nic": "ens"})
+
+		clusterConfigMap := &corev1.ConfigMap{Data: map[string]string{nvmeshConfKey: "K8S_ENV=\"True\"\nMCS_LOGGING_LEVEL=\"VERBOSE\""}}
+		clusterConfigMap.SetName(nvmeshConfigMapName)
+		clusterConfigMap.SetNamespace(TestingNamespace)
+
+		r := newFakeReconciler(cr, worker1, worker2)
+		corer := NVMeshCoreReconciler(*r)
+
+		Expect(validateNodeOverrides(cr)).To(BeNil())
+		Expect(corer.initCoreConfigMap(cr, clusterConfigMap)).To(BeNil())
+		Expect(clusterConfigMap.Data[nvmeshConfKey]).To(ContainSubstring("CONFIGURED_NICS=\"eth0\""))
+		Expect(r.Client.Create(ctx, clusterConfigMap)).To(Succeed())
+
+		getNodeFile := func(nodeName string, file string) string {
+			cm := &corev1.ConfigMap{}
+			Expect(r.Client.Get(ctx, client.ObjectKey{Name: getNodeConfigMapName(nodeName), Namespace: TestingNamespace}, cm)).To(Succeed())
+			Expect(cm.GetLabels()).To(HaveKeyWithValue(nvmeshClusterNameLabelKey, cr.GetName()))
+			return string(cm.BinaryData[file])
+		}
+
+		Expect(corer.reconcileNodeConfigMaps(cr)).To(Succeed())
+
+		Expect(getNodeFile("worker-1", nvmeshConfKey)).To(ContainSubstring("CONFIGURED_NICS=\"eth0\""))
+		Expect(getNodeFile("worker-1", nvmeshConfKey)).To(ContainSubstring("TCP_ENABLED=\"Yes\""))
+		Expect(getNodeFile("worker-1", nvmeshConfKey)).NotTo(ContainSubstring("EXCLUDE_DEVICE_PATHS"))
+		Expect(getNodeFile("worker-1", modprobeConfKey)).To(Equal("options nvmeibc a=1"))
+
+		Expect(getNodeFile("worker-2", nvmeshConfKey)).To(ContainSubstring("CONFIGURED_NICS=\"ens1f0\""))
+		Expect(getNodeFile("worker-2", nvmeshConfKey)).To(ContainSubstring("EXCLUDE_DEVICE_PATHS=\"/dev/nvme0n1\""))
+		Expect(getNodeFile("worker-2", nvmeshConfKey)).To(ContainSubstring("MCS_LOGGING_LEVEL=\"INFO\""))
+		Expect(getNodeFile("worker-2", nvmeshConfKey)).NotTo(ContainSubstring("TCP_ENABLED"))
+		Expect(getNodeFile("worker-2", modprobeConfKey)).To(Equal("options nvmeibc a=1\noptions nvmeibs b=2"))
+
+		By("not updating the ConfigMaps of the nodes when their config did not change")
+		nodeConfigMaps, err := corer.getNodeConfigMaps(cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodeConfigMaps).To(HaveLen(2))
+		resourceVersion := nodeConfigMaps["worker-1"].GetResourceVersion()
+		Expect(corer.reconcileNodeConfigMaps(cr)).To(Succeed())
+		nodeConfigMaps, err = corer.getNodeConfigMaps(cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodeConfigMaps["worker-1"].GetResourceVersion()).To(Equal(resourceVersion))
+
+		By("applying parameters that were added manually to nvmesh-core-config to the nodes")
+		clusterConfigMap.Data[nvmeshConfKey] += "\nUSER_KEY=\"1\""
+		Expect(r.Client.Update(ctx, clusterConfigMap)).To(Succeed())
+		Expect(corer.reconcileNodeConfigMaps(cr)).To(Succeed())
+		Expect(getNodeFile("worker-1", nvmeshConfKey)).To(ContainSubstring("USER_KEY=\"1\""))
+		Expect(getNodeFile("worker-2", nvmeshConfKey)).To(ContainSubstring("USER_KEY=\"1\""))
+
+		By("removing the ConfigMaps of nodes that left the cluster")
+		Expect(r.Client.Delete(ctx, worker1)).To(Succeed())
+		Expect(corer.reconcileNodeConfigMaps(cr)).To(Succeed())
+		nodeConfigMaps, err = corer.getNodeConfigMaps(cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodeConfigMaps).To(HaveLen(1))
+		Expect(nodeConfigMaps).To(HaveKey("worker-2"))
+
+		By("removing the ConfigMaps of all the nodes when Core is removed")
+		Expect(corer.removeNodeConfigMaps(cr)).To(Succeed())
+		nodeConfigMaps, err = corer.getNodeConfigMaps(cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodeConfigMaps).To(BeEmpty())
+
+		cr.Spec.Core.NodeOverrides = append(cr.Spec.Core.NodeOverrides, nvmeshv1.NodeConfigOverride{ConfiguredNICs: "ib0"})
+		Expect(validateNodeOverrides(cr)).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	conditions "excelero.com/nvmesh-k8s-operator/pkg/conditions"
+	errors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;update;patch
+
+// nodeRoleSelector - a role label that is managed by the operator according to a LabelSelector in the CR
+type nodeRoleSelector struct {
+	role     string
+	labelKey string
+	selector labels.Selector
+}
+
+func (r *NVMeshReconciler) getNodeRoleSelectors(cr *nvmeshv1.NVMesh) ([]nodeRoleSelector, error) {
+	specSelectors := []struct {
+		role     string
+		labelKey string
+		field    string
+		selector *metav1.LabelSelector
+	}{
+		{nodeRoleClient, nvmeshClientLabelKey, "spec.core.clientNodeSelector", cr.Spec.Core.ClientNodeSelector},
+		{nodeRoleTarget, nvmeshTargetLabelKey, "spec.core.targetNodeSelector", cr.Spec.Core.TargetNodeSelector},
+		{nodeRoleManagement, nvmeshMgmtLabelKey, "spec.management.nodeSelector", cr.Spec.Management.NodeSelector},
+	}
+
+	selectors := make([]nodeRoleSelector, 0)
+	for _, s := range specSelectors {
+		if s.selector == nil {
+			// the role label is managed manually
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(s.selector)
+		if err != nil {
+			return nil, validationError(cr, fmt.Sprintf("Invalid label selector in %s.", s.field), err.Error())
+		}
+
+		selectors = append(selectors, nodeRoleSelector{role: s.role, labelKey: s.labelKey, selector: selector})
+	}
+
+	return selectors, nil
+}
+
+// reconcileNodeRoleLabels - applies and removes the NVMesh role labels on nodes according to the node selectors in the CR.
+// Nodes that lose their labels are uninstalled by reconcileNodes. The role labels of all nodes are checked, also when they are all set manually
+func (r *NVMeshReconciler) reconcileNodeRoleLabels(cr *nvmeshv1.NVMesh) error {
+	selectors, err := r.getNodeRoleSelectors(cr)
+	if err != nil {
+		return err
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := r.Client.List(context.TODO(), nodeList); err != nil {
+		return errors.Wrap(err, "Failed to list nodes")
+	}
+
+	matchedRoles := make(map[string][]string)
+	invalidNodes := make([]string, 0)
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		original := node.DeepCopy()
+		nodeLabels := node.GetLabels()
+		if nodeLabels == nil {
+			nodeLabels = make(map[string]string)
+		}
+
+		// selectors are matched against the labels before any change is made, so that a selector can refer to other NVMesh role labels
+		labelSet := labels.Set(original.GetLabels())
+		changes := make([]string, 0)
+
+		for _, s := range selectors {
+			_, hasLabel := nodeLabels[s.labelKey]
+			if s.selector.Matches(labelSet) {
+				matchedRoles[node.GetName()] = append(matchedRoles[node.GetName()], s.role)
+				if !hasLabel {
+					nodeLabels[s.labelKey] = ""
+					changes = append(changes, "+"+s.role)
+				}
+			} else if hasLabel {
+				delete(nodeLabels, s.labelKey)
+				changes = append(changes, "-"+s.role)
+			}
+		}
+
+		if !hasValidRoles(nodeLabels) {
+			invalidNodes = append(invalidNodes, node.GetName())
+		}
+
+		if len(changes) == 0 {
+			continue
+		}
+
+		node.SetLabels(nodeLabels)
+		if err := r.Client.Patch(context.TODO(), node, client.MergeFrom(original)); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Failed to update NVMesh role labels on node %s", node.GetName()))
+		}
+
+		msg := fmt.Sprintf("NVMesh role labels changed by node selectors of NVMesh %s: %s", cr.GetName(), strings.Join(changes, ", "))
+		r.Log.Info(fmt.Sprintf("Node %s: %s", node.GetName(), msg))
+		r.EventManager.NormalOnObject(node, "NVMeshRolesChanged", msg)
+	}
+
+	r.reportNodeRolesOverlap(cr, matchedRoles)
+	r.reportInvalidNodeRoles(cr, invalidNodes)
+	return nil
+}
+
+// hasValidRoles - the target and the MCS agent run only on client nodes, a node with the target role and without the client role runs none of the NVMesh Core components
+func hasValidRoles(nodeLabels map[string]string) bool {
+	_, isClient := nodeLabels[nvmeshClientLabelKey]
+	_, isTarget := nodeLabels[nvmeshTargetLabelKey]
+	return isClient || !isTarget
+}
+
+// reportNodeRolesOverlap - sets the NodeRolesOverlap condition when some nodes are matched by more than one of the node role selectors
+func (r *NVMeshReconciler) reportNodeRolesOverlap(cr *nvmeshv1.NVMesh, matchedRoles map[string][]string) {
+	overlaps := make([]string, 0)
+	for nodeName, roles := range matchedRoles {
+		if len(roles) > 1 {
+			overlaps = append(overlaps, fmt.Sprintf("%s (%s)", nodeName, strings.Join(roles, ",")))
+		}
+	}
+
+	if len(overlaps) == 0 {
+		conditions.RemoveStatusCondition(&cr.Status.Conditions, nvmeshv1.NodeRolesOverlap)
+		return
+	}
+
+	sort.Strings(overlaps)
+	msg := fmt.Sprintf("Nodes matched by more than one node role selector: %s", strings.Join(overlaps, ", "))
+
+	existing := conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.NodeRolesOverlap)
+	if existing == nil || existing.Message != msg {
+		r.EventManager.Normal(cr, "NodeRolesOverlap", msg)
+	}
+
+	conditions.SetStatusCondition(&cr.Status.Conditions, &nvmeshv1.ClusterCondition{
+		Type:    nvmeshv1.NodeRolesOverlap,
+		Status:  nvmeshv1.ConditionTrue,
+		Reason:  "MultipleRoles",
+		Message: msg,
+	})
+}
+
+// reportInvalidNodeRoles - sets the InvalidNodeRoles condition when the role labels of some nodes, set by the selectors or manually, are a combination that cannot run
+func (r *NVMeshReconciler) reportInvalidNodeRoles(cr *nvmeshv1.NVMesh, invalidNodes []string) {
+	if len(invalidNodes) == 0 {
+		conditions.RemoveStatusCondition(&cr.Status.Conditions, nvmeshv1.InvalidNodeRoles)
+		return
+	}
+
+	sort.Strings(invalidNodes)
+	msg := fmt.Sprintf("Nodes with the target role and without the client role will not run an NVMesh target: %s", strings.Join(invalidNodes, ", "))
+
+	existing := conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.InvalidNodeRoles)
+	if existing == nil || existing.Message != msg {
+		r.EventManager.Warning(cr, "InvalidNodeRoles", msg)
+	}
+
+	conditions.SetStatusCondition(&cr.Status.Conditions, &nvmeshv1.ClusterCondition{
+		Type:    nvmeshv1.InvalidNodeRoles,
+		Status:  nvmeshv1.ConditionTrue,
+		Reason:  "TargetWithoutClient",
+		Message: msg,
+	})
+}
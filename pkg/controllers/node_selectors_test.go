@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	conditions "excelero.com/nvmesh-k8s-operator/pkg/conditions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Node selectors", func() {
+	It("applies and removes the role labels of the nodes matched by the selectors", func() {
+		cr := newTestCluster()
+		cr.Spec.Core.ClientNodeSelector = &metav1.LabelSelector{}
+		cr.Spec.Core.TargetNodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"node-role/storage": "true"}}
+
+		storageNode := newTestNode("storage-1", map[string]string{"node-role/storage": "true"})
+
+		// a label that was set manually is removed when the role is managed by a selector
+		computeNode := newTestNode("compute-1", map[string]string{nvmeshTargetLabelKey: ""})
+
+		r := newFakeReconciler(cr, storageNode, computeNode)
+		ctx := context.TODO()
+
+		_, err := r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(storageNode), storageNode)).To(BeNil())
+		Expect(storageNode.GetLabels()).To(HaveKey(nvmeshClientLabelKey))
+		Expect(storageNode.GetLabels()).To(HaveKey(nvmeshTargetLabelKey))
+
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(computeNode), computeNode)).To(BeNil())
+		Expect(computeNode.GetLabels()).To(HaveKey(nvmeshClientLabelKey))
+		Expect(computeNode.GetLabels()).NotTo(HaveKey(nvmeshTargetLabelKey))
+
+		// a converged client and target node is a valid combination of roles, that is reported as matched by more than one selector
+		Expect(conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.InvalidNodeRoles)).To(BeNil())
+		overlap := conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.NodeRolesOverlap)
+		Expect(overlap).NotTo(BeNil())
+		Expect(overlap.Message).To(ContainSubstring("storage-1 (client,target)"))
+		Expect(overlap.Message).NotTo(ContainSubstring("compute-1"))
+
+		// nodes that stop matching the selectors go through the uninstall flow
+		cr.Spec.Core.ClientNodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"node-role/storage": "true"}}
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(computeNode), computeNode)).To(BeNil())
+		Expect(computeNode.GetLabels()).NotTo(HaveKey(nvmeshClientLabelKey))
+		Expect(cr.Status.Nodes["compute-1"].State).To(Equal(nvmeshv1.NodeUninstalling))
+		Expect(cr.Status.Nodes["storage-1"].State).To(Equal(nvmeshv1.NodeActive))
+
+		// a node with the target role and without the client role is reported
+		cr.Spec.Core.TargetNodeSelector = &metav1.LabelSelector{}
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		invalid := conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.InvalidNodeRoles)
+		Expect(invalid).NotTo(BeNil())
+		Expect(invalid.Message).To(ContainSubstring("compute-1"))
+		Expect(invalid.Message).NotTo(ContainSubstring("storage-1"))
+
+		// an invalid selector fails validation
+		cr.Spec.Management.NodeSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "a", Operator: "Bogus"}}}
+		_, err = r.reconcileNodes(cr)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("spec.management.nodeSelector"))
+	})
+
+	It("reports invalid role labels that were set manually", func() {
+		cr := newTestCluster()
+		r := newFakeReconciler(cr,
+			newTestNode("converged-1", map[string]string{nvmeshClientLabelKey: "", nvmeshTargetLabelKey: ""}),
+			newTestNode("storage-1", map[string]string{nvmeshTargetLabelKey: ""}),
+		)
+
+		Expect(r.reconcileNodeRoleLabels(cr)).To(Succeed())
+		Expect(conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.NodeRolesOverlap)).To(BeNil())
+		invalid := conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.InvalidNodeRoles)
+		Expect(invalid).NotTo(BeNil())
+		Expect(invalid.Message).To(HaveSuffix(": storage-1"))
+
+		By("clearing the condition when the labels are fixed")
+		node := &corev1.Node{}
+		Expect(r.Client.Get(context.TODO(), client.ObjectKey{Name: "storage-1"}, node)).To(Succeed())
+		node.Labels[nvmeshClientLabelKey] = ""
+		Expect(r.Client.Update(context.TODO(), node)).To(Succeed())
+
+		Expect(r.reconcileNodeRoleLabels(cr)).To(Succeed())
+		Expect(conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.InvalidNodeRoles)).To(BeNil())
+	})
+})
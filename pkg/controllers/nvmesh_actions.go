@@ -2,10 +2,16 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	goerrors "errors"
+	"reflect"
 	"strings"
+	"time"
 
+	errors "github.com/pkg/errors"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
 
 	"fmt"
 
@@ -17,6 +23,18 @@ const (
 	actionComplete = "ActionComplete"
 	taskFinished   = "TaskFinished"
 	taskStarted    = "TaskStarted"
+
+	actionStartTimeKey = "startTime"
+	actionLastErrorKey = "lastError"
+
+	// startedActionsAnnotation - the actions of the cluster that started, from spec.actions and from NVMeshActions.
+	// Both controllers start an action by adding it with an update of the NVMesh, so a conflicting update keeps two actions that can not run together from starting
+	startedActionsAnnotation = "nvmesh.excelero.com/started-actions"
+	// nvmeshActionClaimPrefix - the prefix of the NVMeshActions in startedActionsAnnotation, actions from spec.actions are kept by their ID
+	nvmeshActionClaimPrefix = "nvmeshaction/"
+
+	// actionHistoryLimit - the number of finished actions kept in status.actionHistory
+	actionHistoryLimit = 20
 )
 
 // TaskFunc - Type to represent a function to invoke when task is started
@@ -28,61 +46,421 @@ type Task struct {
 	Run  TaskFunc
 }
 
+// Action - an action that can be requested in spec.actions
+type Action interface {
+	// Validate - checks the action before it starts, an invalid action fails without running any stage
+	Validate(r *NVMeshReconciler, cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error
+	// Stages - the stages of the action, run in order. A stage that requests a requeue runs again on the next reconcile
+	Stages(r *NVMeshReconciler, a nvmeshv1.ClusterAction) []Task
+	// Revert - undoes the changes of the stages that ran when the action fails or times out
+	Revert(r *NVMeshReconciler, cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error
+	// Timeout - the time the action has to finish unless timeoutSeconds is set on the action
+	Timeout() time.Duration
+	// Exclusive - an exclusive action does not run concurrently with any other action
+	Exclusive() bool
+	// Idempotent - an idempotent action can be stopped at any stage and started again, so a schedule can replace a running one
+	Idempotent() bool
+}
+
+// actionOptions - the timeout and concurrency of an action, embedded by actions that do not need validation or a revert
+type actionOptions struct {
+	timeout    time.Duration
+	exclusive  bool
+	idempotent bool
+}
+
+func (o actionOptions) Validate(r *NVMeshReconciler, cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error {
+	return nil
+}
+
+func (o actionOptions) Revert(r *NVMeshReconciler, cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error {
+	return nil
+}
+
+func (o actionOptions) Timeout() time.Duration {
+	return o.timeout
+}
+
+func (o actionOptions) Exclusive() bool {
+	return o.exclusive
+}
+
+func (o actionOptions) Idempotent() bool {
+	return o.idempotent
+}
+
+// registeredActions - the actions that can be requested in spec.actions by name
+var registeredActions = map[nvmeshv1.ActionName]Action{
+	collectLogsAction:       collectLogs{actionOptions{timeout: 30 * time.Minute, idempotent: true}},
+	discoverNodesAction:     discoverNodes{actionOptions{timeout: 10 * time.Minute, idempotent: true}},
+	preflightAction:         preflight{actionOptions{timeout: 10 * time.Minute, idempotent: true}},
+	rotateCredentialsAction: rotateCredentials{actionOptions{timeout: 10 * time.Minute, exclusive: true}},
+	nodeMaintenanceAction:   nodeMaintenance{actionOptions{timeout: 24 * time.Hour, exclusive: true}},
+	formatDriveAction:       driveAction{actionOptions{timeout: 10 * time.Minute, exclusive: true}},
+	evictDriveAction:        driveAction{actionOptions{timeout: 10 * time.Minute, exclusive: true}},
+	reincludeDriveAction:    driveAction{actionOptions{timeout: 10 * time.Minute, exclusive: true}},
+}
+
+// actionFailure - an error that fails the action, other errors are retried until the action times out
+type actionFailure struct {
+	reason string
+}
+
+func (e *actionFailure) Error() string {
+	return e.reason
+}
+
+func newActionFailure(format string, args ...interface{}) error {
+	return &actionFailure{reason: fmt.Sprintf(format, args...)}
+}
+
+func isActionFailure(err error) bool {
+	var failure *actionFailure
+	return goerrors.As(err, &failure)
+}
+
 func (r *NVMeshReconciler) hasActions(cr *nvmeshv1.NVMesh) bool {
 	return len(cr.Spec.Actions) > 0
 }
 
 func (r *NVMeshReconciler) handleActions(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
-	pendingActions := cr.Spec.Actions
-
-	if len(pendingActions) > 0 {
-		for actionIndex, action := range pendingActions {
-			shouldRemove, result, err := r.handleAction(action, cr)
-			if shouldRemove {
-				r.removeAction(actionIndex, cr)
-				err := r.updateNVMeshClusterObject(cr)
-				if err != nil {
-					fmt.Printf("Failed to remove action %s from cluster %s\n", action.Name, cr.GetName())
-				}
-			}
+	if assignActionIDs(cr) {
+		// the IDs are saved before the actions start, the status of each action is kept under its ID
+		return RequeueWithDefaultBackOff(), r.updateActionsInSpec(cr)
+	}
 
-			if result.Requeue || err != nil {
-				if result.Requeue {
-					r.UpdateStatus(cr)
-				}
-				return result, err
-			}
+	// an action that started keeps running, an action that did not start yet waits for the started actions it can not run with
+	started, err := r.getStartedActionsOfSpec(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	starting := make(map[string]bool)
+	for _, action := range cr.Spec.Actions {
+		if _, ok := started[action.ID]; !ok && canRunAction(startedActionsList(started), action) {
+			started[action.ID] = action.Name
+			starting[action.ID] = true
+		}
+	}
+
+	if !reflect.DeepEqual(started, getStartedActions(cr)) {
+		setStartedActions(cr, started)
+		if err := r.updateActionsInSpec(cr); err != nil {
+			// the actions that were starting start on the next reconcile if they still can
+			return DoNotRequeue(), errors.Wrap(err, "Failed to save the started actions")
 		}
 	}
 
-	return DoNotRequeue(), nil
+	// actions that run concurrently are not blocked by each other, the shortest requeue requested is kept
+	collector := newErrorCollector()
+	finished := make(map[string]bool)
+	for _, action := range cr.Spec.Actions {
+		if _, ok := started[action.ID]; !ok {
+			continue
+		}
+
+		done, actionResult, err := r.handleAction(action, cr)
+		if done {
+			// a failed action is recorded in the history and in an event, it does not fail the reconcile
+			finished[action.ID] = true
+			delete(started, action.ID)
+			continue
+		}
+
+		if err != nil {
+			r.Log.Info(fmt.Sprintf("Action %s %s will be retried. %s", action.Name, action.ID, err))
+		}
+
+		collector.add(actionResult, err)
+	}
+
+	if len(finished) > 0 {
+		r.removeActions(cr, finished)
+		setStartedActions(cr, started)
+		if err := r.updateActionsInSpec(cr); err != nil {
+			return DoNotRequeue(), errors.Wrap(err, "Failed to remove the finished actions")
+		}
+	}
+
+	return collector.Result()
 }
 
-func (r *NVMeshReconciler) handleAction(action nvmeshv1.ClusterAction, cr *nvmeshv1.NVMesh) (bool, ctrl.Result, error) {
-	switch action.Name {
-	case "collect-logs":
-		return r.handleCollectLogs(cr, action)
-	default:
-		return false, DoNotRequeue(), goerrors.New(fmt.Sprintf("Unknown Action %s", action.Name))
+// handleAction - runs the next stages of the action, returns true when the action succeeded or failed
+func (r *NVMeshReconciler) handleAction(a nvmeshv1.ClusterAction, cr *nvmeshv1.NVMesh) (bool, ctrl.Result, error) {
+	result, reason, requeue, err := r.runActionStages(cr, a)
+	if result == "" {
+		return false, requeue, err
 	}
+
+	return r.finishAction(cr, a, result, reason)
 }
 
-func (r *NVMeshReconciler) removeAction(indexToRemove int, cr *nvmeshv1.NVMesh) {
-	newList := make([]nvmeshv1.ClusterAction, len(cr.Spec.Actions)-1)
+// runActionStages - runs the next stages of the action and keeps their status in status.actionsStatus of the cluster.
+// returns the result of the action (Succeeded, Failed or TimedOut) and the reason it failed once it finished, or an empty result while it is running
+func (r *NVMeshReconciler) runActionStages(cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) (string, string, ctrl.Result, error) {
+	action, ok := registeredActions[a.Name]
+	if !ok {
+		return nvmeshv1.ActionFailed, fmt.Sprintf("Unknown Action %s", a.Name), DoNotRequeue(), nil
+	}
+
+	startTime, started := r.getActionStartTime(cr, a)
+	if !started {
+		if err := action.Validate(r, cr, a); err != nil {
+			return nvmeshv1.ActionFailed, err.Error(), DoNotRequeue(), nil
+		}
+
+		startTime = time.Now()
+		r.setTaskStatus(cr, a, actionStartTimeKey, startTime.Format(time.RFC3339))
+	}
+
+	timeout := getActionTimeout(action, a)
+	if time.Since(startTime) > timeout {
+		reason := fmt.Sprintf("the action did not finish in %s", timeout)
+		if lastError := r.getTaskStatus(cr, a, actionLastErrorKey); lastError != "" {
+			reason = fmt.Sprintf("%s, last error: %s", reason, lastError)
+		}
+
+		return nvmeshv1.ActionTimedOut, r.revertAction(cr, action, a, reason), DoNotRequeue(), nil
+	}
+
+	for _, stage := range action.Stages(r, a) {
+		if r.isTaskFinished(cr, a, stage.Name) {
+			continue
+		}
 
-	for i, item := range cr.Spec.Actions {
-		if i < indexToRemove {
-			newList[i] = item
+		r.setTaskStarted(cr, a, stage.Name)
+		result, err := stage.Run(cr)
+		if isActionFailure(err) {
+			return nvmeshv1.ActionFailed, r.revertAction(cr, action, a, err.Error()), DoNotRequeue(), nil
 		}
 
-		if i > indexToRemove {
-			newList[i-1] = item
+		if err != nil {
+			r.setTaskStatus(cr, a, actionLastErrorKey, err.Error())
+			return "", "", RequeueWithDefaultBackOff(), errors.Wrap(err, fmt.Sprintf("%s failed in stage %s", a.Name, stage.Name))
+		}
+
+		if result.Requeue || result.RequeueAfter > 0 {
+			return "", "", result, nil
+		}
+
+		r.setTaskFinished(cr, a, stage.Name)
+	}
+
+	return nvmeshv1.ActionSucceeded, "", DoNotRequeue(), nil
+}
+
+// revertAction - reverts an action that failed or timed out, returns the reason the action failed with the error of the revert if it failed
+func (r *NVMeshReconciler) revertAction(cr *nvmeshv1.NVMesh, action Action, a nvmeshv1.ClusterAction, reason string) string {
+	if err := action.Revert(r, cr, a); err != nil {
+		return fmt.Sprintf("%s, failed to revert the action: %s", reason, err)
+	}
+
+	return reason
+}
+
+// finishAction - records the result of the action in status.actionHistory
+func (r *NVMeshReconciler) finishAction(cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction, result string, reason string) (bool, ctrl.Result, error) {
+	entry := nvmeshv1.ActionHistoryEntry{
+		ID:      a.ID,
+		Name:    string(a.Name),
+		Args:    a.Args,
+		Result:  result,
+		Reason:  reason,
+		EndTime: metav1.Now(),
+	}
+
+	if startTime, ok := r.getActionStartTime(cr, a); ok {
+		entry.StartTime = metav1.NewTime(startTime)
+	} else {
+		entry.StartTime = entry.EndTime
+	}
+
+	cr.Status.ActionHistory = append(cr.Status.ActionHistory, entry)
+	if len(cr.Status.ActionHistory) > actionHistoryLimit {
+		cr.Status.ActionHistory = cr.Status.ActionHistory[len(cr.Status.ActionHistory)-actionHistoryLimit:]
+	}
+
+	if result == nvmeshv1.ActionSucceeded {
+		r.setActionComplete(cr, a)
+		return true, DoNotRequeue(), nil
+	}
+
+	r.setTaskStatus(cr, a, actionComplete, result)
+	r.EventManager.Warning(cr, "ActionFailed", fmt.Sprintf("%s %s: %s", a.Name, a.ID, reason))
+	return true, DoNotRequeue(), goerrors.New(reason)
+}
+
+func getActionTimeout(action Action, a nvmeshv1.ClusterAction) time.Duration {
+	if a.TimeoutSeconds > 0 {
+		return time.Duration(a.TimeoutSeconds) * time.Second
+	}
+
+	return action.Timeout()
+}
+
+func (r *NVMeshReconciler) getActionStartTime(cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) (time.Time, bool) {
+	startTime, err := time.Parse(time.RFC3339, r.getTaskStatus(cr, a, actionStartTimeKey))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return startTime, true
+}
+
+func isExclusiveAction(a nvmeshv1.ClusterAction) bool {
+	action, ok := registeredActions[a.Name]
+	return ok && action.Exclusive()
+}
+
+func isIdempotentAction(name nvmeshv1.ActionName) bool {
+	action, ok := registeredActions[name]
+	return ok && action.Idempotent()
+}
+
+// getStartedActions - returns the started actions of the cluster by their ID, NVMeshActions by nvmeshActionClaimPrefix and their name
+func getStartedActions(cr *nvmeshv1.NVMesh) map[string]nvmeshv1.ActionName {
+	started := make(map[string]nvmeshv1.ActionName)
+	if value, ok := cr.GetAnnotations()[startedActionsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &started); err != nil {
+			// the annotation is written again with the actions of the cluster that started
+			return make(map[string]nvmeshv1.ActionName)
+		}
+	}
+
+	return started
+}
+
+func setStartedActions(cr *nvmeshv1.NVMesh, started map[string]nvmeshv1.ActionName) {
+	annotations := cr.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	if len(started) == 0 {
+		delete(annotations, startedActionsAnnotation)
+	} else {
+		content, _ := json.Marshal(started)
+		annotations[startedActionsAnnotation] = string(content)
+	}
+
+	cr.SetAnnotations(annotations)
+}
+
+func startedActionsList(started map[string]nvmeshv1.ActionName) []nvmeshv1.ClusterAction {
+	list := make([]nvmeshv1.ClusterAction, 0, len(started))
+	for id, name := range started {
+		list = append(list, nvmeshv1.ClusterAction{ID: id, Name: name})
+	}
+
+	return list
+}
+
+// getCurrentStartedActions - returns the started actions of the cluster without the actions that are no longer in spec.actions and the NVMeshActions that finished or were deleted
+func (r *NVMeshBaseReconciler) getCurrentStartedActions(cr *nvmeshv1.NVMesh) (map[string]nvmeshv1.ActionName, error) {
+	nvmeshActions, err := r.getClusterNVMeshActions(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]bool)
+	for _, action := range cr.Spec.Actions {
+		current[action.ID] = true
+	}
+
+	for _, action := range nvmeshActions {
+		current[nvmeshActionClaimPrefix+action.GetName()] = true
+	}
+
+	started := getStartedActions(cr)
+	for id := range started {
+		if !current[id] {
+			delete(started, id)
+		}
+	}
+
+	return started, nil
+}
+
+// getStartedActionsOfSpec - returns the current started actions, an action of spec.actions that has a start time is started even if it is not in the annotation
+func (r *NVMeshReconciler) getStartedActionsOfSpec(cr *nvmeshv1.NVMesh) (map[string]nvmeshv1.ActionName, error) {
+	started, err := r.getCurrentStartedActions(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, action := range cr.Spec.Actions {
+		if _, ok := r.getActionStartTime(cr, action); ok {
+			started[action.ID] = action.Name
+		}
+	}
+
+	return started, nil
+}
+
+// canRunAction - an action runs concurrently with the started actions, unless one of them is exclusive or of the same type
+func canRunAction(started []nvmeshv1.ClusterAction, a nvmeshv1.ClusterAction) bool {
+	if len(started) == 0 {
+		return true
+	}
+
+	if isExclusiveAction(a) {
+		return false
+	}
+
+	for _, other := range started {
+		if other.Name == a.Name || isExclusiveAction(other) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// assignActionIDs - generates an ID for actions without an ID or with the ID of another action, returns true if an ID was generated
+func assignActionIDs(cr *nvmeshv1.NVMesh) bool {
+	assigned := false
+	ids := make(map[string]bool)
+	for i := range cr.Spec.Actions {
+		action := &cr.Spec.Actions[i]
+		if action.ID == "" || ids[action.ID] {
+			action.ID = fmt.Sprintf("%s-%s", action.Name, utilrand.String(5))
+			assigned = true
+		}
+
+		ids[action.ID] = true
+	}
+
+	return assigned
+}
+
+// actionStatusKey - the key of the action in status.actionsStatus. actions started by the operator do not have an ID
+func actionStatusKey(a nvmeshv1.ClusterAction) string {
+	if a.ID != "" {
+		return a.ID
+	}
+
+	return string(a.Name)
+}
+
+func (r *NVMeshReconciler) removeActions(cr *nvmeshv1.NVMesh, ids map[string]bool) {
+	newList := make([]nvmeshv1.ClusterAction, 0, len(cr.Spec.Actions))
+	for _, action := range cr.Spec.Actions {
+		if !ids[action.ID] {
+			newList = append(newList, action)
 		}
 	}
 
 	cr.Spec.Actions = newList
 }
 
+// updateActionsInSpec - updates the actions in spec without losing the status, the update returns the status that is stored in the cluster
+func (r *NVMeshReconciler) updateActionsInSpec(cr *nvmeshv1.NVMesh) error {
+	status := cr.Status.DeepCopy()
+	err := r.updateNVMeshClusterObject(cr)
+	cr.Status = *status
+	return err
+}
+
 func (r *NVMeshReconciler) removeFinishedActionStatuses(cr *nvmeshv1.NVMesh) ctrl.Result {
 	// remove ActionStatus of actions that were finished and deleted
 	var found bool
@@ -90,9 +468,14 @@ func (r *NVMeshReconciler) removeFinishedActionStatuses(cr *nvmeshv1.NVMesh) ctr
 
 	if cr.Status.ActionsStatus != nil {
 		for actionName := range cr.Status.ActionsStatus {
+			if actionName == mgmtUpgradeStatusName {
+				// started by the operator, kept until the next upgrade
+				continue
+			}
+
 			found = false
 			for _, action := range cr.Spec.Actions {
-				if action.Name == actionName {
+				if actionStatusKey(action) == actionName {
 					found = true
 				}
 			}
@@ -129,10 +512,10 @@ func (r *NVMeshReconciler) getTaskStatus(cr *nvmeshv1.NVMesh, action nvmeshv1.Cl
 		cr.Status.ActionsStatus = make(map[string]nvmeshv1.ActionStatus)
 	}
 
-	actionStatus, ok := cr.Status.ActionsStatus[action.Name]
+	actionStatus, ok := cr.Status.ActionsStatus[actionStatusKey(action)]
 	if !ok {
-		cr.Status.ActionsStatus[action.Name] = make(map[string]string)
-		actionStatus = cr.Status.ActionsStatus[action.Name]
+		cr.Status.ActionsStatus[actionStatusKey(action)] = make(map[string]string)
+		actionStatus = cr.Status.ActionsStatus[actionStatusKey(action)]
 	}
 
 	return actionStatus[key]
@@ -143,11 +526,11 @@ func (r *NVMeshReconciler) setTaskStatus(cr *nvmeshv1.NVMesh, action nvmeshv1.Cl
 		cr.Status.ActionsStatus = make(map[string]nvmeshv1.ActionStatus)
 	}
 
-	if _, ok := cr.Status.ActionsStatus[action.Name]; !ok {
-		cr.Status.ActionsStatus[action.Name] = make(map[string]string)
+	if _, ok := cr.Status.ActionsStatus[actionStatusKey(action)]; !ok {
+		cr.Status.ActionsStatus[actionStatusKey(action)] = make(map[string]string)
 	}
 
-	cr.Status.ActionsStatus[action.Name][key] = value
+	cr.Status.ActionsStatus[actionStatusKey(action)][key] = value
 }
 
 func (r *NVMeshReconciler) isTaskFinished(cr *nvmeshv1.NVMesh, action nvmeshv1.ClusterAction, key string) bool {
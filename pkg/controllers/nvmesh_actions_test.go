@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"strings"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// waitAction - an action for testing that waits until done is set
+type waitAction struct {
+	actionOptions
+	done *bool
+}
+
+func (w waitAction) Stages(r *NVMeshReconciler, a nvmeshv1.ClusterAction) []Task {
+	return []Task{{"wait", func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+		if !*w.done {
+			return Requeue(time.Second), nil
+		}
+
+		return DoNotRequeue(), nil
+	}}}
+}
+
+// failingAction - an action for testing that fails with a transient error, or with an action failure when fatal is set
+type failingAction struct {
+	actionOptions
+	fatal    bool
+	reverted *int
+}
+
+func (f failingAction) Stages(r *NVMeshReconciler, a nvmeshv1.ClusterAction) []Task {
+	return []Task{{"fail", func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+		if f.fatal {
+			return DoNotRequeue(), newActionFailure("the drive is in use")
+		}
+
+		return DoNotRequeue(), goerrors.New("Management is not available")
+	}}}
+}
+
+func (f failingAction) Revert(r *NVMeshReconciler, cr *nvmeshv1.NVMesh, a nvmeshv1.ClusterAction) error {
+	*f.reverted++
+	return nil
+}
+
+var _ = Describe("Actions", func() {
+	var (
+		ctx      = context.TODO()
+		cr       *nvmeshv1.NVMesh
+		r        *NVMeshReconciler
+		done     bool
+		reverted int
+	)
+
+	getActions := func() []nvmeshv1.ClusterAction {
+		stored := &nvmeshv1.NVMesh{}
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(cr), stored)).To(Succeed())
+		return stored.Spec.Actions
+	}
+
+	// handleActions - runs the actions and continues with the actions stored in the cluster, as the next reconcile does
+	handleActions := func() ctrl.Result {
+		result, err := r.handleActions(cr)
+		Expect(err).NotTo(HaveOccurred())
+		cr.Spec.Actions = getActions()
+		return result
+	}
+
+	isStarted := func(a nvmeshv1.ClusterAction) bool {
+		_, started := r.getActionStartTime(cr, a)
+		return started
+	}
+
+	BeforeEach(func() {
+		done = false
+		reverted = 0
+		registeredActions["test-wait"] = waitAction{actionOptions{timeout: time.Minute}, &done}
+		registeredActions["test-other"] = waitAction{actionOptions{timeout: time.Minute}, &done}
+		registeredActions["test-exclusive"] = waitAction{actionOptions{timeout: time.Minute, exclusive: true}, &done}
+		registeredActions["test-fail"] = failingAction{actionOptions{timeout: time.Minute}, false, &reverted}
+		registeredActions["test-fatal"] = failingAction{actionOptions{timeout: time.Minute}, true, &reverted}
+
+		cr = newTestCluster()
+	})
+
+	AfterEach(func() {
+		for _, name := range []nvmeshv1.ActionName{"test-wait", "test-other", "test-exclusive", "test-fail", "test-fatal"} {
+			delete(registeredActions, name)
+		}
+	})
+
+	It("saves a unique ID for each action before they start", func() {
+		cr.Spec.Actions = []nvmeshv1.ClusterAction{{Name: "test-wait"}, {Name: "test-wait", ID: "same"}, {Name: "test-wait", ID: "same"}}
+		r = newFakeReconciler(cr)
+
+		result := handleActions()
+		Expect(result.Requeue).To(BeTrue())
+		Expect(cr.Spec.Actions[0].ID).To(HavePrefix("test-wait-"))
+		Expect(cr.Spec.Actions[1].ID).To(Equal("same"))
+		Expect(cr.Spec.Actions[2].ID).To(HavePrefix("test-wait-"))
+		Expect(cr.Spec.Actions[2].ID).NotTo(Equal(cr.Spec.Actions[0].ID))
+		Expect(cr.Status.ActionsStatus).To(BeEmpty())
+	})
+
+	It("runs actions of different types together and records unknown actions as failed", func() {
+		cr.Spec.Actions = []nvmeshv1.ClusterAction{{Name: "test-wait", ID: "wait-1"}, {Name: "no-such-action", ID: "unknown-1"}, {Name: "test-wait", ID: "wait-2"}, {Name: "test-other", ID: "other-1"}}
+		r = newFakeReconciler(cr)
+
+		result := handleActions()
+		Expect(result.RequeueAfter).To(Equal(time.Second))
+		Expect(isStarted(cr.Spec.Actions[0])).To(BeTrue())
+		Expect(isStarted(cr.Spec.Actions[1])).To(BeFalse())
+		Expect(isStarted(cr.Spec.Actions[2])).To(BeTrue())
+		Expect(cr.Status.ActionHistory).To(HaveLen(1))
+		Expect(cr.Status.ActionHistory[0].Name).To(Equal("no-such-action"))
+		Expect(cr.Status.ActionHistory[0].Result).To(Equal(nvmeshv1.ActionFailed))
+
+		By("starting the second action of a type once the first finished")
+		done = true
+		handleActions()
+		Expect(cr.Spec.Actions).To(HaveLen(1))
+		Expect(cr.Spec.Actions[0].ID).To(Equal("wait-2"))
+
+		handleActions()
+		Expect(cr.Spec.Actions).To(BeEmpty())
+		history := cr.Status.ActionHistory
+		Expect(history).To(HaveLen(4))
+		Expect(history[3].ID).To(Equal("wait-2"))
+		Expect(history[3].Result).To(Equal(nvmeshv1.ActionSucceeded))
+		Expect(history[3].StartTime.IsZero()).To(BeFalse())
+	})
+
+	It("starts an exclusive action only when no other action started", func() {
+		cr.Spec.Actions = []nvmeshv1.ClusterAction{{Name: "test-wait", ID: "wait-1"}, {Name: "test-exclusive", ID: "exclusive-1"}, {Name: "test-other", ID: "other-1"}}
+		r = newFakeReconciler(cr)
+
+		handleActions()
+		Expect(isStarted(cr.Spec.Actions[0])).To(BeTrue())
+		Expect(isStarted(cr.Spec.Actions[1])).To(BeFalse())
+
+		By("not waiting for an earlier action that did not start")
+		Expect(isStarted(cr.Spec.Actions[2])).To(BeTrue())
+
+		By("starting the exclusive action after the started actions finished")
+		done = true
+		handleActions()
+		Expect(cr.Spec.Actions).To(HaveLen(1))
+		Expect(isStarted(cr.Spec.Actions[0])).To(BeFalse())
+
+		done = false
+		handleActions()
+		Expect(isStarted(cr.Spec.Actions[0])).To(BeTrue())
+	})
+
+	It("keeps running a started action when an exclusive action is added before it", func() {
+		cr.Spec.Actions = []nvmeshv1.ClusterAction{{Name: "test-wait", ID: "wait-1"}}
+		r = newFakeReconciler(cr)
+		handleActions()
+		startTime, _ := r.getActionStartTime(cr, cr.Spec.Actions[0])
+
+		stored := &nvmeshv1.NVMesh{}
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(cr), stored)).To(Succeed())
+		stored.Spec.Actions = append([]nvmeshv1.ClusterAction{{Name: "test-exclusive", ID: "exclusive-1"}}, stored.Spec.Actions...)
+		Expect(r.Client.Update(ctx, stored)).To(Succeed())
+		cr.SetResourceVersion(stored.GetResourceVersion())
+		cr.Spec.Actions = stored.Spec.Actions
+		handleActions()
+		Expect(isStarted(cr.Spec.Actions[0])).To(BeFalse())
+		Expect(r.getTaskStatus(cr, cr.Spec.Actions[1], "wait")).To(Equal(taskStarted))
+
+		done = true
+		handleActions()
+		Expect(cr.Spec.Actions).To(HaveLen(1))
+		Expect(cr.Status.ActionHistory[0].StartTime.Time.Unix()).To(Equal(startTime.Unix()))
+	})
+
+	It("retries a failing stage until the action times out with the last error", func() {
+		r = newFakeReconciler(cr)
+		failing := nvmeshv1.ClusterAction{Name: "test-fail", ID: "test-fail-1", TimeoutSeconds: 60}
+
+		finished, result, err := r.handleAction(failing, cr)
+		Expect(finished).To(BeFalse())
+		Expect(err).To(HaveOccurred())
+		Expect(result.Requeue).To(BeTrue())
+		Expect(reverted).To(Equal(0))
+
+		r.setTaskStatus(cr, failing, actionStartTimeKey, time.Now().Add(-2*time.Minute).Format(time.RFC3339))
+		finished, _, err = r.handleAction(failing, cr)
+		Expect(finished).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("last error: Management is not available")))
+		Expect(cr.Status.ActionHistory[0].Result).To(Equal(nvmeshv1.ActionTimedOut))
+		Expect(reverted).To(Equal(1))
+	})
+
+	It("reverts an action that fails", func() {
+		r = newFakeReconciler(cr)
+
+		finished, _, err := r.handleAction(nvmeshv1.ClusterAction{Name: "test-fatal", ID: "test-fatal-1"}, cr)
+		Expect(finished).To(BeTrue())
+		Expect(err).To(MatchError("the drive is in use"))
+		Expect(cr.Status.ActionHistory[0].Result).To(Equal(nvmeshv1.ActionFailed))
+		Expect(reverted).To(Equal(1))
+	})
+
+	It("keeps only the last results", func() {
+		r = newFakeReconciler(cr)
+		for i := 0; i <= actionHistoryLimit; i++ {
+			r.finishAction(cr, nvmeshv1.ClusterAction{Name: "test-wait", ID: strings.Repeat("x", i+1)}, nvmeshv1.ActionSucceeded, "")
+		}
+
+		Expect(cr.Status.ActionHistory).To(HaveLen(actionHistoryLimit))
+		Expect(cr.Status.ActionHistory[0].ID).To(Equal("xx"))
+	})
+})
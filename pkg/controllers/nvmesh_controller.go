@@ -3,16 +3,23 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/dynamic"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -31,7 +38,7 @@ var (
 		"SecurityContextConstraints",
 	}
 
-	reconcileCycles = 0
+	reconcileCycles int64 = 0
 )
 
 const (
@@ -49,6 +56,9 @@ type NVMeshBaseReconciler struct {
 	Manager       ctrl.Manager
 	EventManager  *EventManager
 	Options       OperatorOptions
+
+	// DynamicWatches - watches objects created with the dynamic client and enqueues the NVMesh cluster that owns them
+	DynamicWatches *DynamicWatchRegistry
 }
 
 // NVMeshReconciler - Reconciles an NVMesh CR
@@ -65,6 +75,8 @@ type NVMeshComponent interface {
 
 // +kubebuilder:rbac:groups=nvmesh.excelero.com,resources=nvmeshes,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=nvmesh.excelero.com,resources=nvmeshes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
 // +kubebuilder:subresource:status
 
 // Reconcile - Reconciles an NVMesh CR
@@ -72,7 +84,7 @@ func (r *NVMeshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	_ = context.Background()
 	_ = r.Log.WithValues("nvmesh", req.NamespacedName)
 
-	reconcileCycles = reconcileCycles + 1
+	atomic.AddInt64(&reconcileCycles, 1)
 
 	// Fetch the NVMesh instance
 	cr := &nvmeshv1.NVMesh{}
@@ -97,7 +109,10 @@ func (r *NVMeshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return r.ManageError(cr, err, RequeueWithDefaultBackOff())
 	}
 
-	r.stopAllUnstructuredWatchers()
+	secretsResult, err := r.reconcileSecrets(cr)
+	if err != nil {
+		return r.ManageError(cr, err, RequeueWithDefaultBackOff())
+	}
 
 	if err := r.makeSureServiceAccountExists(cr); err != nil {
 		return r.ManageError(cr, err, RequeueWithDefaultBackOff())
@@ -113,37 +128,62 @@ func (r *NVMeshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return r.ManageSuccess(cr, finResult)
 	}
 
-	// Reconcile
-	result, err := r.reconcileAllcomponents(cr)
+	// Uninstall nodes that had their NVMesh role labels removed
+	nodesResult, err := r.reconcileNodes(cr)
+	if err != nil {
+		return r.ManageError(cr, err, RequeueWithDefaultBackOff())
+	}
 
-	if result.Requeue {
-		if err != nil {
-			_, err = r.ManageError(cr, err, result)
-		}
+	// A component that waits or fails (i.e. for MongoDB or for the preflight checks) does not hold the rest of the cycle, so that started actions keep running.
+	// The shortest requeue requested by any of the steps and the first error are kept
+	results := newErrorCollector()
+	results.add(secretsResult, nil)
+	results.add(nodesResult, nil)
 
-		// drop the result returned by ManageError
-		return result, err
+	// Reconcile
+	componentsResult, err := r.reconcileAllcomponents(cr)
+	results.add(componentsResult, err)
+
+	// Create the CSI Management user once Management is deployed
+	credentialsResult, err := r.reconcileCSICredentials(cr)
+	results.add(credentialsResult, err)
+
+	// Block drains of target nodes that hold the last healthy copy of a volume
+	drainGuardResult, err := r.reconcileDrainGuard(cr)
+	results.add(drainGuardResult, err)
+
+	// Create NVMeshActions for the schedules that are due
+	scheduleResult, err := r.reconcileSchedules(cr)
+	results.add(scheduleResult, err)
+
+	// Handle Stale Action Statuses, the actions run on the next cycle
+	if staleResult := r.removeFinishedActionStatuses(cr); staleResult.Requeue {
+		results.add(staleResult, nil)
+	} else if r.hasActions(cr) {
+		// Handle Actions
+		results.add(r.handleActions(cr))
 	}
 
-	// Handle Stale Action Statuses
-	result = r.removeFinishedActionStatuses(cr)
-	if result.Requeue {
-		return r.ManageSuccess(cr, result)
+	result, err := results.Result()
+	if err != nil {
+		if !result.Requeue {
+			result = RequeueWithDefaultBackOff()
+		}
+
+		return r.ManageError(cr, err, result)
 	}
 
-	// Handle Actions
-	if r.hasActions(cr) {
-		actionResult, err := r.handleActions(cr)
-		if err != nil {
-			return r.ManageError(cr, err, actionResult)
+	if componentsResult.Requeue {
+		// the cluster is not Ready while a component waits
+		if err := r.UpdateStatus(cr); err != nil {
+			// the status is updated again on the next cycle
+			r.Log.Info(fmt.Sprintf("Failed to update status. %s", err))
 		}
 
-		if actionResult.Requeue {
-			return r.ManageSuccess(cr, actionResult)
-		}
+		return result, nil
 	}
 
-	return r.ManageSuccess(cr, DoNotRequeue())
+	return r.ManageSuccess(cr, result)
 }
 
 func (r *NVMeshReconciler) reconcileAllcomponents(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
@@ -151,35 +191,36 @@ func (r *NVMeshReconciler) reconcileAllcomponents(cr *nvmeshv1.NVMesh) (ctrl.Res
 	core := NVMeshCoreReconciler(*r)
 	csi := NVMeshCSIReconciler(*r)
 	components := []NVMeshComponent{&mgmt, &core, &csi}
-	var errorList []error
-	var errToReturn error
-	resultWithMinimalRequeue := DoNotRequeue()
-	for _, component := range components {
-		result, err := component.Reconcile(cr, r)
 
-		// We collect errors and keep on Reconciling other components
-		// We then requeue another reconcile cycle with the shortest reconcile requested
-		if err != nil {
-			errorList = append(errorList, err)
-		}
+	// Components are reconciled concurrently so that a slow component (i.e. waiting for MongoDB) will not block the others
+	// We collect errors and keep on Reconciling other components
+	// We then requeue another reconcile cycle with the shortest reconcile requested
+	collector := newErrorCollector()
+	funcs := make([]func(), 0, len(components))
 
-		if result.Requeue {
-			resultWithMinimalRequeue.Requeue = true
-			if result.RequeueAfter < resultWithMinimalRequeue.RequeueAfter {
-				resultWithMinimalRequeue.RequeueAfter = result.RequeueAfter
-			}
-		}
+	// Each component works on its own copy of the CR, the status changes of the components are merged after they finish
+	statusBefore := cr.Status.DeepCopy()
+	copies := make([]*nvmeshv1.NVMesh, 0, len(components))
+	for _, component := range components {
+		comp := component
+		crCopy := cr.DeepCopy()
+		copies = append(copies, crCopy)
+		funcs = append(funcs, func() {
+			collector.add(comp.Reconcile(crCopy, r))
+		})
 	}
 
-	if len(errorList) > 0 {
-		for _, e := range errorList {
-			r.Log.Error(e, "Error from ReconcileComponent")
-		}
+	runConcurrently(maxConcurrentComponents, funcs)
 
-		errToReturn = errorList[0]
+	for _, crCopy := range copies {
+		mergeComponentStatus(&cr.Status, statusBefore, &crCopy.Status)
 	}
 
-	return resultWithMinimalRequeue, errToReturn
+	for _, e := range collector.Errors() {
+		r.Log.Error(e, "Error from ReconcileComponent")
+	}
+
+	return collector.Result()
 }
 
 func (r *NVMeshReconciler) getManagementGUIURL(cr *nvmeshv1.NVMesh) string {
@@ -207,26 +248,51 @@ func (r *NVMeshReconciler) getManagementGUIURL(cr *nvmeshv1.NVMesh) string {
 //SetupWithManager - adds this reconciler to a manager
 func (r *NVMeshReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
-	// Reconcile only if generation field changed - this is to prevent cycle loop after status updates
-	generationChangePredicate := predicate.GenerationChangedPredicate{}
+	// Reconcile the NVMesh CR only if generation field changed - this is to prevent cycle loop after status updates
+	generationChanged := builder.WithPredicates(predicate.GenerationChangedPredicate{})
+
+	// Reconcile when pods of owned workloads become ready or unavailable so status and actions progress on events
+	workloadStatusChanged := builder.WithPredicates(workloadStatusChangedPredicate())
 
 	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
-		For(&nvmeshv1.NVMesh{}).
-		WithEventFilter(generationChangePredicate).
-		Owns(&appsv1.StatefulSet{}).
-		Owns(&appsv1.Deployment{}).
-		Owns(&appsv1.DaemonSet{}).
-		Owns(&corev1.Service{}).
-		Owns(&corev1.ServiceAccount{}).
-		Owns(&corev1.ConfigMap{}).
-		Owns(&corev1.Secret{}).
-		Owns(&corev1.Service{}).
-		Owns(&rbac.ClusterRole{}).
-		Owns(&rbac.ClusterRoleBinding{}).
-		Owns(&rbac.Role{}).
-		Owns(&rbac.RoleBinding{}).
-		Owns(&storagev1.CSIDriver{}).
-		Owns(&storagev1.StorageClass{})
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.Options.MaxConcurrentReconciles}).
+		For(&nvmeshv1.NVMesh{}, generationChanged).
+		Owns(&appsv1.StatefulSet{}, workloadStatusChanged).
+		Owns(&appsv1.Deployment{}, workloadStatusChanged).
+		Owns(&appsv1.DaemonSet{}, workloadStatusChanged).
+		Owns(&corev1.Service{}, generationChanged).
+		Owns(&corev1.ServiceAccount{}, generationChanged).
+		Owns(&corev1.ConfigMap{}, generationChanged).
+		Owns(&corev1.Secret{}, generationChanged).
+		Owns(&rbac.ClusterRole{}, generationChanged).
+		Owns(&rbac.ClusterRoleBinding{}, generationChanged).
+		Owns(&rbac.Role{}, generationChanged).
+		Owns(&rbac.RoleBinding{}, generationChanged).
+		Owns(&storagev1.CSIDriver{}, generationChanged).
+		Owns(&storagev1.StorageClass{}, generationChanged).
+		Owns(&policyv1.PodDisruptionBudget{}, generationChanged).
+		Owns(&nvmeshv1.NVMeshNode{}, generationChanged).
+		Owns(&nvmeshv1.NVMeshAction{}, builder.WithPredicates(nvmeshActionFinishedPredicate())).
+		// Jobs created by getNewJob are not owned by the CR, they are mapped to the cluster using the cluster-name label
+		Watches(&source.Kind{Type: &batchv1.Job{}},
+			handler.EnqueueRequestsFromMapFunc(r.mapObjectToCluster),
+			builder.WithPredicates(jobStatusChangedPredicate())).
+		Watches(&source.Kind{Type: &corev1.Node{}},
+			handler.EnqueueRequestsFromMapFunc(r.mapNodeToClusters),
+			builder.WithPredicates(nodeChangedPredicate())).
+		// Secrets provided by the user are not owned by the CR, they are mapped to the clusters that need them. only labelled Secrets are cached, see SecretsCacheSelector
+		Watches(&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.mapSecretToClusters))
+
+	if r.DynamicWatches != nil {
+		// Objects created using the dynamic client (i.e. the MongoDB CustomResource) are watched by the DynamicWatchRegistry
+		controllerBuilder = controllerBuilder.Watches(&source.Channel{Source: r.DynamicWatches.Events()}, &handler.EnqueueRequestForObject{})
+
+		if err := mgr.Add(r.DynamicWatches); err != nil {
+			return err
+		}
+	}
+
 	return controllerBuilder.Complete(r)
 }
 
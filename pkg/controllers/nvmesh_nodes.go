@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	errors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	controllerutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	componentLabelKey = "nvmesh.excelero.com/component"
+
+	driverContainerName = "driver-container"
+	mcsContainerName    = "mcs"
+	agentContainerName  = "agent"
+
+	nvmeshVersionEnvVar = "NVMESH_VERSION"
+
+	nicProtocolTCP  = "tcp"
+	nicProtocolRDMA = "rdma"
+)
+
+// +kubebuilder:rbac:groups=nvmesh.excelero.com,resources=nvmeshnodes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=nvmesh.excelero.com,resources=nvmeshnodes/status,verbs=get;update;patch
+
+// syncNVMeshNodes - makes sure there is an NVMeshNode for each node in cr.Status.Nodes and updates its status from the node's NVMesh pods
+func (r *NVMeshReconciler) syncNVMeshNodes(cr *nvmeshv1.NVMesh) error {
+	pods, err := r.getNVMeshPodsByNode(cr)
+	if err != nil {
+		return err
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := r.Client.List(context.TODO(), nodeList); err != nil {
+		return errors.Wrap(err, "Failed to list nodes")
+	}
+
+	nodeLabels := make(map[string]map[string]string)
+	for _, node := range nodeList.Items {
+		nodeLabels[node.GetName()] = node.GetLabels()
+	}
+
+	discoveries, err := r.getNVMeshNodeStatuses(cr)
+	if err != nil {
+		return err
+	}
+
+	for nodeName, nodeStatus := range cr.Status.Nodes {
+		var discovery *nvmeshv1.NodeDiscovery
+		if status, ok := discoveries[nodeName]; ok {
+			discovery = status.Discovery
+		}
+
+		nodeConfig, err := getNodeCoreConfig(cr, nodeName, nodeLabels[nodeName], discovery)
+		if err != nil {
+			return err
+		}
+
+		status := r.getNVMeshNodeStatus(cr, nodeStatus, nodeConfig, discovery, pods[nodeName])
+		if err := r.updateNVMeshNode(cr, nodeName, func(nvmeshNode *nvmeshv1.NVMeshNode) {
+			// fields that are reported by other flows (i.e. collect-logs, discovery or preflight) are kept
+			status.LastCollectLogs = nvmeshNode.Status.LastCollectLogs
+			status.Discovery = nvmeshNode.Status.Discovery
+			status.LastDiscovery = nvmeshNode.Status.LastDiscovery
+			status.LastPreflight = nvmeshNode.Status.LastPreflight
+			if status.LastUninstall == nil {
+				status.LastUninstall = nvmeshNode.Status.LastUninstall
+			}
+
+			nvmeshNode.Status = status
+		}); err != nil {
+			return err
+		}
+	}
+
+	// remove NVMeshNodes of nodes that are no longer tracked
+	nvmeshNodeList := &nvmeshv1.NVMeshNodeList{}
+	err = r.Client.List(context.TODO(), nvmeshNodeList, client.InNamespace(cr.GetNamespace()), client.MatchingLabels{nvmeshClusterNameLabelKey: cr.GetName()})
+	if err != nil {
+		return errors.Wrap(err, "Failed to list NVMeshNodes")
+	}
+
+	for i := range nvmeshNodeList.Items {
+		nvmeshNode := &nvmeshNodeList.Items[i]
+		if _, ok := cr.Status.Nodes[nvmeshNode.Spec.NodeName]; ok {
+			continue
+		}
+
+		r.Log.Info(fmt.Sprintf("Deleting NVMeshNode %s", nvmeshNode.GetName()))
+		if err := r.Client.Delete(context.TODO(), nvmeshNode); err != nil && !k8serrors.IsNotFound(err) {
+			return errors.Wrap(err, fmt.Sprintf("Failed to delete NVMeshNode %s", nvmeshNode.GetName()))
+		}
+	}
+
+	return nil
+}
+
+// getNVMeshNodeName - NVMeshNodes are named after the cluster and the node, so clusters in the same namespace do not share NVMeshNodes
+func getNVMeshNodeName(cr *nvmeshv1.NVMesh, nodeName string) string {
+	return fmt.Sprintf("%s-%s", cr.GetName(), nodeName)
+}
+
+// updateNVMeshNode - creates the NVMeshNode for the node if it does not exist, and updates its status using the mutate func
+func (r *NVMeshBaseReconciler) updateNVMeshNode(cr *nvmeshv1.NVMesh, nodeName string, mutate func(*nvmeshv1.NVMeshNode)) error {
+	key := client.ObjectKey{Name: getNVMeshNodeName(cr, nodeName), Namespace: cr.GetNamespace()}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		nvmeshNode := &nvmeshv1.NVMeshNode{}
+		err := r.Client.Get(context.TODO(), key, nvmeshNode)
+		if k8serrors.IsNotFound(err) {
+			nvmeshNode = r.newNVMeshNode(cr, nodeName)
+			if err := r.Client.Create(context.TODO(), nvmeshNode); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("Failed to create NVMeshNode %s", key.Name))
+			}
+		} else if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Failed to get NVMeshNode %s", key.Name))
+		}
+
+		before := nvmeshNode.Status.DeepCopy()
+		mutate(nvmeshNode)
+		if reflect.DeepEqual(*before, nvmeshNode.Status) {
+			return nil
+		}
+
+		return r.Client.Status().Update(context.TODO(), nvmeshNode)
+	})
+}
+
+func (r *NVMeshBaseReconciler) newNVMeshNode(cr *nvmeshv1.NVMesh, nodeName string) *nvmeshv1.NVMeshNode {
+	nvmeshNode := &nvmeshv1.NVMeshNode{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getNVMeshNodeName(cr, nodeName),
+			Namespace: cr.GetNamespace(),
+			Labels:    r.getOperatorLabels(cr),
+		},
+		Spec: nvmeshv1.NVMeshNodeSpec{
+			NodeName: nodeName,
+			Cluster:  cr.GetName(),
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(cr, nvmeshNode, r.Scheme); err != nil {
+		r.Log.Error(err, "Error running SetControllerReference")
+	}
+
+	return nvmeshNode
+}
+
+// recordNodeTaskResult - saves the result of a task that ran on a node (i.e. collect-logs) on the node's NVMeshNode
+func (r *NVMeshBaseReconciler) recordNodeTaskResult(cr *nvmeshv1.NVMesh, nodeName string, setResult func(*nvmeshv1.NVMeshNodeStatus, *nvmeshv1.NodeTaskResult), taskErr error) {
+	result := &nvmeshv1.NodeTaskResult{
+		Result: nvmeshv1.NodeTaskSucceeded,
+		Time:   metav1.Now(),
+	}
+
+	if taskErr != nil {
+		result.Result = nvmeshv1.NodeTaskFailed
+		result.Message = taskErr.Error()
+	}
+
+	err := r.updateNVMeshNode(cr, nodeName, func(nvmeshNode *nvmeshv1.NVMeshNode) {
+		setResult(&nvmeshNode.Status, result)
+	})
+
+	if err != nil {
+		r.Log.Info(fmt.Sprintf("Warning: Failed to update NVMeshNode %s. Error: %s", nodeName, err))
+	}
+}
+
+func setLastCollectLogs(status *nvmeshv1.NVMeshNodeStatus, result *nvmeshv1.NodeTaskResult) {
+	status.LastCollectLogs = result
+}
+
+func (r *NVMeshReconciler) getNVMeshNodeStatus(cr *nvmeshv1.NVMesh, nodeStatus nvmeshv1.NodeStatus, nodeConfig nodeCoreConfig, discovery *nvmeshv1.NodeDiscovery, pods []corev1.Pod) nvmeshv1.NVMeshNodeStatus {
+	status := nvmeshv1.NVMeshNodeStatus{
+		Roles:          nodeStatus.Roles,
+		State:          nodeStatus.State,
+		MCSState:       nvmeshv1.ComponentNotInstalled,
+		AgentState:     nvmeshv1.ComponentNotInstalled,
+		NICs:           getConfiguredNICs(cr, nodeConfig),
+		Drives:         getDiscoveredDrives(discovery, nodeConfig),
+		ExcludedDrives: getExcludedDrives(nodeConfig),
+	}
+
+	switch nodeStatus.State {
+	case nvmeshv1.NodeUninstalled:
+		status.LastUninstall = &nvmeshv1.NodeTaskResult{Result: nvmeshv1.NodeTaskSucceeded, Message: nodeStatus.Message, Time: nodeStatus.LastTransitionTime}
+	case nvmeshv1.NodeUninstallFailed:
+		status.LastUninstall = &nvmeshv1.NodeTaskResult{Result: nvmeshv1.NodeTaskFailed, Message: nodeStatus.Message, Time: nodeStatus.LastTransitionTime}
+	}
+
+	if !nodeHasCoreRole(nodeStatus.Roles) || nodeStatus.State != nvmeshv1.NodeActive {
+		status.NICs = nil
+		status.Drives = nil
+		status.ExcludedDrives = nil
+		return status
+	}
+
+	status.MCSState = nvmeshv1.ComponentNotRunning
+	status.AgentState = nvmeshv1.ComponentNotRunning
+
+	for _, pod := range pods {
+		switch pod.GetLabels()[componentLabelKey] {
+		case "mcs-agent":
+			status.MCSState = getContainerState(&pod, mcsContainerName)
+			status.AgentState = getContainerState(&pod, agentContainerName)
+		case "client", "target":
+			if getContainerState(&pod, driverContainerName) == nvmeshv1.ComponentConnected {
+				status.DriverVersion = getContainerEnvValue(&pod, driverContainerName, nvmeshVersionEnvVar)
+			}
+		}
+	}
+
+	return status
+}
+
+// getNVMeshPodsByNode - returns the NVMesh Core pods of the cluster mapped by the node they are running on
+func (r *NVMeshReconciler) getNVMeshPodsByNode(cr *nvmeshv1.NVMesh) (map[string][]corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	err := r.Client.List(context.TODO(), podList, client.InNamespace(cr.GetNamespace()), client.HasLabels{componentLabelKey})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to list NVMesh pods")
+	}
+
+	pods := make(map[string][]corev1.Pod)
+	for _, pod := range podList.Items {
+		nodeName := pod.Spec.NodeName
+		if nodeName != "" {
+			pods[nodeName] = append(pods[nodeName], pod)
+		}
+	}
+
+	return pods, nil
+}
+
+func getContainerState(pod *corev1.Pod, containerName string) string {
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.Name != containerName {
+			continue
+		}
+
+		if s.Ready {
+			return nvmeshv1.ComponentConnected
+		} else if s.State.Running != nil {
+			return nvmeshv1.ComponentNotReady
+		}
+
+		return nvmeshv1.ComponentNotRunning
+	}
+
+	return nvmeshv1.ComponentNotRunning
+}
+
+func getContainerEnvValue(pod *corev1.Pod, containerName string, envName string) string {
+	for _, c := range pod.Spec.Containers {
+		if c.Name != containerName {
+			continue
+		}
+
+		for _, env := range c.Env {
+			if env.Name == envName {
+				return env.Value
+			}
+		}
+	}
+
+	return ""
+}
+
+// getConfiguredNICs - returns the NICs configured for the node in Spec.Core.ConfiguredNICs or in its node overrides, or chosen from its discovery
+func getConfiguredNICs(cr *nvmeshv1.NVMesh, nodeConfig nodeCoreConfig) []nvmeshv1.NVMeshNodeNIC {
+	protocol := nicProtocolRDMA
+	if nodeConfig.TCPOnly {
+		protocol = nicProtocolTCP
+	}
+
+	nics := make([]nvmeshv1.NVMeshNodeNIC, 0)
+	for _, name := range strings.Split(nodeConfig.ConfiguredNICs, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			nics = append(nics, nvmeshv1.NVMeshNodeNIC{Name: name, Protocol: protocol})
+		}
+	}
+
+	if len(nics) == 0 {
+		return nil
+	}
+
+	return nics
+}
+
+// getDiscoveredDrives - returns the NVMe drives found on the node by its discovery, marking the drives excluded by serial number or device path
+func getDiscoveredDrives(discovery *nvmeshv1.NodeDiscovery, nodeConfig nodeCoreConfig) []nvmeshv1.NVMeshNodeDrive {
+	if discovery == nil || len(discovery.Drives) == 0 {
+		return nil
+	}
+
+	var excludedSerials, excludedPaths []string
+	if nodeConfig.ExcludeDrives != nil {
+		excludedSerials = nodeConfig.ExcludeDrives.SerialNumbers
+		excludedPaths = nodeConfig.ExcludeDrives.DevicePaths
+	}
+
+	drives := make([]nvmeshv1.NVMeshNodeDrive, 0, len(discovery.Drives))
+	for _, d := range discovery.Drives {
+		drives = append(drives, nvmeshv1.NVMeshNodeDrive{
+			SerialNumber: d.SerialNumber,
+			DevicePath:   d.DevicePath,
+			Excluded:     (d.SerialNumber != "" && stringInSlice(d.SerialNumber, excludedSerials)) || stringInSlice(d.DevicePath, excludedPaths),
+		})
+	}
+
+	return drives
+}
+
+func getExcludedDrives(nodeConfig nodeCoreConfig) []string {
+	exclude := nodeConfig.ExcludeDrives
+	if exclude == nil || len(exclude.SerialNumbers)+len(exclude.DevicePaths) == 0 {
+		return nil
+	}
+
+	drives := make([]string, 0)
+	drives = append(drives, exclude.SerialNumbers...)
+	drives = append(drives, exclude.DevicePaths...)
+	return drives
+}
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"context"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("NVMeshNodes", func() {
+	It("reflects the roles, NICs and component states of the node", func() {
+		cr := newTestCluster()
+		cr.Spec.Core.ConfiguredNICs = "ib0, ib1"
+
+		node := newTestNode("worker-1", map[string]string{nvmeshClientLabelKey: "", nvmeshMgmtLabelKey: ""})
+
+		mcsPod := newTestPod("nvmesh-mcs-agent-abcde", "worker-1", map[string]string{componentLabelKey: "mcs-agent"})
+		mcsPod.Status.ContainerStatuses = []corev1.ContainerStatus{
+			{Name: mcsContainerName, Ready: true},
+			{Name: agentContainerName, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+		}
+
+		r := newFakeReconciler(cr, node, mcsPod)
+		ctx := context.TODO()
+		key := client.ObjectKey{Name: getNVMeshNodeName(cr, "worker-1"), Namespace: TestingNamespace}
+
+		_, err := r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+
+		nvmeshNode := &nvmeshv1.NVMeshNode{}
+		Expect(r.Client.Get(ctx, key, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Spec.Cluster).To(Equal("cluster1"))
+		Expect(nvmeshNode.GetLabels()[nvmeshClusterNameLabelKey]).To(Equal("cluster1"))
+		Expect(nvmeshNode.Status.Roles).To(Equal([]string{nodeRoleClient, nodeRoleManagement}))
+		Expect(nvmeshNode.Status.State).To(Equal(nvmeshv1.NodeActive))
+		Expect(nvmeshNode.Status.MCSState).To(Equal(nvmeshv1.ComponentConnected))
+		Expect(nvmeshNode.Status.AgentState).To(Equal(nvmeshv1.ComponentNotReady))
+		Expect(nvmeshNode.Status.NICs).To(Equal([]nvmeshv1.NVMeshNodeNIC{{Name: "ib0", Protocol: nicProtocolRDMA}, {Name: "ib1", Protocol: nicProtocolRDMA}}))
+
+		r.recordCollectLogsResult(cr, "worker-1", nil)
+		Expect(r.Client.Get(ctx, key, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Status.LastCollectLogs.Result).To(Equal(nvmeshv1.NodeTaskSucceeded))
+
+		// the collect-logs result is kept when the status is refreshed
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(r.Client.Get(ctx, key, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Status.LastCollectLogs).NotTo(BeNil())
+
+		Expect(r.Client.Delete(ctx, node)).To(BeNil())
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(k8serrors.IsNotFound(r.Client.Get(ctx, key, nvmeshNode))).To(BeTrue())
+	})
+
+	It("keeps separate NVMeshNodes for clusters in the same namespace", func() {
+		ctx := context.TODO()
+
+		node := newTestNode("worker-1", map[string]string{nvmeshClientLabelKey: ""})
+
+		cluster1 := newTestCluster()
+
+		cluster2 := cluster1.DeepCopy()
+		cluster2.SetName("cluster2")
+
+		r := newFakeReconciler(cluster1, cluster2, node)
+		for _, cr := range []*nvmeshv1.NVMesh{cluster1, cluster2} {
+			_, err := r.reconcileNodes(cr)
+			Expect(err).To(BeNil())
+		}
+
+		nvmeshNode := &nvmeshv1.NVMeshNode{}
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: "cluster1-worker-1", Namespace: TestingNamespace}, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Spec.Cluster).To(Equal("cluster1"))
+
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: "cluster2-worker-1", Namespace: TestingNamespace}, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Spec.Cluster).To(Equal("cluster2"))
+	})
+})
@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	conditions "excelero.com/nvmesh-k8s-operator/pkg/conditions"
+	errors "github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// nvmeshActionPendingRequeue - how often a pending NVMeshAction checks if it can start
+const nvmeshActionPendingRequeue = 15 * time.Second
+
+// NVMeshActionReconciler - runs NVMeshActions on the NVMesh cluster they reference, using the same actions as spec.actions of the NVMesh
+type NVMeshActionReconciler struct {
+	NVMeshBaseReconciler
+}
+
+// +kubebuilder:rbac:groups=nvmesh.excelero.com,resources=nvmeshactions,verbs=get;list;watch;update;patch;delete
+// +kubebuilder:rbac:groups=nvmesh.excelero.com,resources=nvmeshactions/status,verbs=get;update;patch
+
+// Reconcile - runs the next stages of an NVMeshAction
+func (r *NVMeshActionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	action := &nvmeshv1.NVMeshAction{}
+	if err := r.Client.Get(ctx, req.NamespacedName, action); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return DoNotRequeue(), nil
+		}
+
+		return DoNotRequeue(), errors.Wrap(err, "Failed to get NVMeshAction")
+	}
+
+	if isNVMeshActionFinished(action) {
+		return r.deleteExpiredAction(action)
+	}
+
+	cr := &nvmeshv1.NVMesh{}
+	err := r.Client.Get(ctx, client.ObjectKey{Namespace: action.GetNamespace(), Name: action.Spec.Cluster}, cr)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return r.setActionPending(action, fmt.Sprintf("NVMesh cluster %s was not found", action.Spec.Cluster))
+		}
+
+		return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to get NVMesh cluster %s", action.Spec.Cluster))
+	}
+
+	if cr.GetDeletionTimestamp() != nil {
+		return r.finishNVMeshAction(action, nvmeshv1.ActionFailed, fmt.Sprintf("NVMesh cluster %s is being deleted", cr.GetName()))
+	}
+
+	if !isOwnedBy(action, cr) {
+		// the action is garbage collected with its cluster
+		if err := controllerutil.SetOwnerReference(cr, action, r.Scheme); err != nil {
+			return DoNotRequeue(), errors.Wrap(err, "Failed to set the owner of the NVMeshAction")
+		}
+
+		if err := r.Client.Update(ctx, action); err != nil {
+			return DoNotRequeue(), errors.Wrap(err, "Failed to update the owner of the NVMeshAction")
+		}
+	}
+
+	a := getNVMeshActionClusterAction(action)
+	started, err := r.getCurrentStartedActions(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	claim := nvmeshActionClaimPrefix + action.GetName()
+	if _, ok := started[claim]; !ok {
+		if action.Status.Phase != nvmeshv1.ActionRunning && !canRunAction(startedActionsList(started), a) {
+			return r.setActionPending(action, fmt.Sprintf("waiting for the started actions on cluster %s", cr.GetName()))
+		}
+
+		// the action starts only if the NVMesh did not change since it was read, i.e. by an action of spec.actions that started
+		started[claim] = a.Name
+		setStartedActions(cr, started)
+		if err := r.Client.Update(ctx, cr); err != nil {
+			return DoNotRequeue(), errors.Wrap(err, fmt.Sprintf("Failed to start the action on NVMesh cluster %s", cr.GetName()))
+		}
+	}
+
+	// the stages run on a copy of the cluster so their status is kept in the NVMeshAction and not in the NVMesh
+	nvmeshr := NVMeshReconciler(*r)
+	clusterCopy := cr.DeepCopy()
+	clusterCopy.Status.ActionsStatus = map[string]nvmeshv1.ActionStatus{a.ID: copyActionStatus(action.Status.Stages)}
+
+	result, reason, requeue, err := nvmeshr.runActionStages(clusterCopy, a)
+	action.Status.Stages = clusterCopy.Status.ActionsStatus[a.ID]
+	if startTime, ok := nvmeshr.getActionStartTime(clusterCopy, a); ok && action.Status.StartTime == nil {
+		start := metav1.NewTime(startTime)
+		action.Status.StartTime = &start
+	}
+
+	if result != "" {
+		return r.finishNVMeshAction(action, result, reason)
+	}
+
+	action.Status.Phase = nvmeshv1.ActionRunning
+	action.Status.Reason = ""
+	if updateErr := r.Client.Status().Update(ctx, action); updateErr != nil {
+		return DoNotRequeue(), errors.Wrap(updateErr, "Failed to update NVMeshAction status")
+	}
+
+	if err != nil {
+		r.Log.Info(fmt.Sprintf("NVMeshAction %s will be retried. %s", action.GetName(), err))
+	}
+
+	return requeue, err
+}
+
+// getNVMeshActionClusterAction - the action to run, its ID is the name of the NVMeshAction
+func getNVMeshActionClusterAction(action *nvmeshv1.NVMeshAction) nvmeshv1.ClusterAction {
+	return nvmeshv1.ClusterAction{
+		Name:           action.Spec.Name,
+		ID:             action.GetName(),
+		Args:           action.Spec.Args,
+		TimeoutSeconds: action.Spec.TimeoutSeconds,
+	}
+}
+
+func copyActionStatus(status nvmeshv1.ActionStatus) nvmeshv1.ActionStatus {
+	c := make(nvmeshv1.ActionStatus)
+	for k, v := range status {
+		c[k] = v
+	}
+
+	return c
+}
+
+func isNVMeshActionFinished(action *nvmeshv1.NVMeshAction) bool {
+	switch action.Status.Phase {
+	case nvmeshv1.ActionSucceeded, nvmeshv1.ActionFailed, nvmeshv1.ActionTimedOut:
+		return true
+	}
+
+	return false
+}
+
+func isOwnedBy(obj metav1.Object, owner metav1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == owner.GetUID() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getClusterNVMeshActions - returns the unfinished NVMeshActions of the cluster ordered by creation time
+func (r *NVMeshBaseReconciler) getClusterNVMeshActions(cr *nvmeshv1.NVMesh) ([]nvmeshv1.NVMeshAction, error) {
+	actionList := &nvmeshv1.NVMeshActionList{}
+	if err := r.Client.List(context.TODO(), actionList, client.InNamespace(cr.GetNamespace())); err != nil {
+		return nil, errors.Wrap(err, "Failed to list NVMeshActions")
+	}
+
+	actions := make([]nvmeshv1.NVMeshAction, 0)
+	for _, action := range actionList.Items {
+		if action.Spec.Cluster == cr.GetName() && !isNVMeshActionFinished(&action) {
+			actions = append(actions, action)
+		}
+	}
+
+	sort.SliceStable(actions, func(i, j int) bool {
+		ti, tj := actions[i].GetCreationTimestamp(), actions[j].GetCreationTimestamp()
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+
+		return actions[i].GetName() < actions[j].GetName()
+	})
+
+	return actions, nil
+}
+
+// releaseStartedAction - removes a finished NVMeshAction from the started actions of its cluster
+func (r *NVMeshActionReconciler) releaseStartedAction(action *nvmeshv1.NVMeshAction) error {
+	cr := &nvmeshv1.NVMesh{}
+	if err := r.Client.Get(context.TODO(), client.ObjectKey{Namespace: action.GetNamespace(), Name: action.Spec.Cluster}, cr); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	started := getStartedActions(cr)
+	if _, ok := started[nvmeshActionClaimPrefix+action.GetName()]; !ok {
+		return nil
+	}
+
+	delete(started, nvmeshActionClaimPrefix+action.GetName())
+	setStartedActions(cr, started)
+	return r.Client.Update(context.TODO(), cr)
+}
+
+func (r *NVMeshActionReconciler) setActionPending(action *nvmeshv1.NVMeshAction, reason string) (ctrl.Result, error) {
+	if action.Status.Phase != nvmeshv1.ActionPending || action.Status.Reason != reason {
+		action.Status.Phase = nvmeshv1.ActionPending
+		action.Status.Reason = reason
+		if err := r.Client.Status().Update(context.TODO(), action); err != nil {
+			return DoNotRequeue(), errors.Wrap(err, "Failed to update NVMeshAction status")
+		}
+	}
+
+	return Requeue(nvmeshActionPendingRequeue), nil
+}
+
+// finishNVMeshAction - sets the result and the Complete or Failed condition of the NVMeshAction
+func (r *NVMeshActionReconciler) finishNVMeshAction(action *nvmeshv1.NVMeshAction, result string, reason string) (ctrl.Result, error) {
+	now := metav1.Now()
+	action.Status.Phase = result
+	action.Status.Reason = reason
+	action.Status.CompletionTime = &now
+	if action.Status.StartTime == nil {
+		action.Status.StartTime = &now
+	}
+
+	condition := nvmeshv1.ClusterCondition{Type: nvmeshv1.ActionCompleteCondition, Status: nvmeshv1.ConditionTrue, Reason: result}
+	if result != nvmeshv1.ActionSucceeded {
+		condition = nvmeshv1.ClusterCondition{Type: nvmeshv1.ActionFailedCondition, Status: nvmeshv1.ConditionTrue, Reason: result, Message: reason}
+	}
+
+	conditions.SetStatusCondition(&action.Status.Conditions, &condition)
+
+	if err := r.Client.Status().Update(context.TODO(), action); err != nil {
+		return DoNotRequeue(), errors.Wrap(err, "Failed to update NVMeshAction status")
+	}
+
+	if err := r.releaseStartedAction(action); err != nil {
+		// removed by the next reconcile of the cluster or of another NVMeshAction
+		r.Log.Info(fmt.Sprintf("Failed to remove NVMeshAction %s from the started actions of cluster %s. %s", action.GetName(), action.Spec.Cluster, err))
+	}
+
+	if result == nvmeshv1.ActionSucceeded {
+		r.EventManager.NormalOnObject(action, "ActionSucceeded", fmt.Sprintf("%s finished on cluster %s", action.Spec.Name, action.Spec.Cluster))
+	} else {
+		r.EventManager.WarningOnObject(action, "ActionFailed", fmt.Sprintf("%s %s: %s", action.Spec.Name, result, reason))
+	}
+
+	return r.deleteExpiredAction(action)
+}
+
+// deleteExpiredAction - deletes a finished NVMeshAction after spec.ttlSecondsAfterFinished
+func (r *NVMeshActionReconciler) deleteExpiredAction(action *nvmeshv1.NVMeshAction) (ctrl.Result, error) {
+	ttl := action.Spec.TTLSecondsAfterFinished
+	if ttl == nil || action.Status.CompletionTime == nil {
+		return DoNotRequeue(), nil
+	}
+
+	expiry := action.Status.CompletionTime.Add(time.Duration(*ttl) * time.Second)
+	if remaining := time.Until(expiry); remaining > 0 {
+		return Requeue(remaining), nil
+	}
+
+	if err := r.Client.Delete(context.TODO(), action); err != nil && !k8serrors.IsNotFound(err) {
+		return DoNotRequeue(), errors.Wrap(err, "Failed to delete expired NVMeshAction")
+	}
+
+	return DoNotRequeue(), nil
+}
+
+// SetupWithManager - adds this reconciler to a manager
+func (r *NVMeshActionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// the stages requeue while they run, status updates should not trigger another reconcile
+	generationChanged := builder.WithPredicates(predicate.GenerationChangedPredicate{})
+
+	return ctrl.NewControllerManagedBy(mgr).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.Options.MaxConcurrentReconciles}).
+		For(&nvmeshv1.NVMeshAction{}, generationChanged).
+		Complete(r)
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	conditions "excelero.com/nvmesh-k8s-operator/pkg/conditions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	errors "github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("NVMeshActions", func() {
+	var (
+		ctx      = context.TODO()
+		cr       *nvmeshv1.NVMesh
+		nvmeshr  *NVMeshReconciler
+		r        *NVMeshActionReconciler
+		recorder *record.FakeRecorder
+		done     bool
+	)
+
+	reconcile := func(a *nvmeshv1.NVMeshAction) ctrl.Result {
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(a)})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	get := func(a *nvmeshv1.NVMeshAction) *nvmeshv1.NVMeshAction {
+		stored := &nvmeshv1.NVMeshAction{}
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(a), stored)).To(Succeed())
+		return stored
+	}
+
+	getCluster := func() *nvmeshv1.NVMesh {
+		stored := &nvmeshv1.NVMesh{}
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(cr), stored)).To(Succeed())
+		return stored
+	}
+
+	newReconcilers := func(objects ...client.Object) {
+		nvmeshr = newFakeReconciler(objects...)
+		r = &NVMeshActionReconciler{NVMeshBaseReconciler: nvmeshr.NVMeshBaseReconciler}
+		recorder = r.EventManager.recorder.(*record.FakeRecorder)
+	}
+
+	BeforeEach(func() {
+		done = false
+		registeredActions["test-wait"] = waitAction{actionOptions{timeout: time.Minute}, &done}
+		registeredActions["test-exclusive"] = waitAction{actionOptions{timeout: time.Minute, exclusive: true}, &done}
+
+		cr = newTestCluster()
+	})
+
+	AfterEach(func() {
+		delete(registeredActions, "test-wait")
+		delete(registeredActions, "test-exclusive")
+	})
+
+	It("waits for the cluster to be created", func() {
+		action := newTestNVMeshAction("wait-1", "test-wait")
+		newReconcilers(action)
+
+		result := reconcile(action)
+		Expect(result.RequeueAfter).To(Equal(nvmeshActionPendingRequeue))
+		Expect(get(action).Status.Phase).To(Equal(nvmeshv1.ActionPending))
+		Expect(get(action).Status.Reason).To(ContainSubstring("cluster1 was not found"))
+	})
+
+	It("runs the stages without changing the spec or the status of the cluster", func() {
+		action := newTestNVMeshAction("wait-1", "test-wait")
+		newReconcilers(cr, action)
+		generation := getCluster().GetGeneration()
+
+		result := reconcile(action)
+		Expect(result.RequeueAfter).To(Equal(time.Second))
+		running := get(action)
+		Expect(running.Status.Phase).To(Equal(nvmeshv1.ActionRunning))
+		Expect(running.Status.StartTime).NotTo(BeNil())
+		Expect(running.Status.Stages).To(HaveKeyWithValue("wait", taskStarted))
+		Expect(running.GetOwnerReferences()).To(HaveLen(1))
+
+		stored := getCluster()
+		Expect(stored.GetGeneration()).To(Equal(generation))
+		Expect(stored.Spec.Actions).To(BeEmpty())
+		Expect(stored.Status.ActionsStatus).To(BeEmpty())
+		Expect(getStartedActions(stored)).To(Equal(map[string]nvmeshv1.ActionName{"nvmeshaction/wait-1": "test-wait"}))
+	})
+
+	It("does not start while an exclusive action of spec.actions started", func() {
+		cr.Spec.Actions = []nvmeshv1.ClusterAction{{Name: "test-exclusive", ID: "exclusive-1"}}
+		action := newTestNVMeshAction("wait-1", "test-wait")
+		newReconcilers(cr, action)
+
+		_, err := nvmeshr.handleActions(cr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getStartedActions(getCluster())).To(HaveKey("exclusive-1"))
+
+		reconcile(action)
+		Expect(get(action).Status.Phase).To(Equal(nvmeshv1.ActionPending))
+		Expect(get(action).Status.Reason).To(ContainSubstring("waiting for the started actions"))
+	})
+
+	It("holds spec.actions of the same type while it runs", func() {
+		action := newTestNVMeshAction("wait-1", "test-wait")
+		newReconcilers(cr, action)
+		reconcile(action)
+
+		stored := getCluster()
+		stored.Spec.Actions = []nvmeshv1.ClusterAction{{Name: "test-wait", ID: "wait-2"}}
+		_, err := nvmeshr.handleActions(stored)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.Status.ActionsStatus["wait-2"]).NotTo(HaveKey(actionStartTimeKey))
+	})
+
+	It("does not start when the cluster changed since it was read", func() {
+		action := newTestNVMeshAction("wait-1", "test-wait")
+		newReconcilers(cr, action)
+
+		stale := getCluster()
+		reconcile(action)
+
+		By("starting a spec action from the stale cluster")
+		stale.Spec.Actions = []nvmeshv1.ClusterAction{{Name: "test-exclusive", ID: "exclusive-1"}}
+		_, err := nvmeshr.handleActions(stale)
+		Expect(k8serrors.IsConflict(errors.Cause(err))).To(BeTrue())
+		Expect(stale.Status.ActionsStatus["exclusive-1"]).NotTo(HaveKey(actionStartTimeKey))
+	})
+
+	It("releases the cluster and is deleted after its TTL once it succeeded", func() {
+		ttl := int32(0)
+		action := newTestNVMeshAction("wait-1", "test-wait")
+		action.Spec.TTLSecondsAfterFinished = &ttl
+		newReconcilers(cr, action)
+		reconcile(action)
+
+		done = true
+		reconcile(action)
+		Expect(recorder.Events).To(Receive(ContainSubstring("ActionSucceeded")))
+		err := r.Client.Get(ctx, client.ObjectKeyFromObject(action), &nvmeshv1.NVMeshAction{})
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		Expect(getCluster().GetAnnotations()).NotTo(HaveKey(startedActionsAnnotation))
+	})
+
+	It("fails an unknown action and keeps it without a TTL", func() {
+		failing := newTestNVMeshAction("unknown-1", "no-such-action")
+		newReconcilers(cr, failing)
+
+		reconcile(failing)
+		failed := get(failing)
+		Expect(failed.Status.Phase).To(Equal(nvmeshv1.ActionFailed))
+		Expect(failed.Status.CompletionTime).NotTo(BeNil())
+		condition := conditions.FindStatusCondition(failed.Status.Conditions, nvmeshv1.ActionFailedCondition)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Message).To(ContainSubstring("Unknown Action no-such-action"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("ActionFailed")))
+
+		By("not running it again")
+		Expect(reconcile(failing)).To(Equal(DoNotRequeue()))
+		Expect(get(failing).Status.Phase).To(Equal(nvmeshv1.ActionFailed))
+	})
+
+	It("drops the started NVMeshActions that were deleted", func() {
+		action := newTestNVMeshAction("wait-1", "test-wait")
+		newReconcilers(cr, action)
+		reconcile(action)
+		Expect(r.Client.Delete(ctx, get(action))).To(Succeed())
+
+		stored := getCluster()
+		stored.Spec.Actions = []nvmeshv1.ClusterAction{{Name: "test-exclusive", ID: "exclusive-1"}}
+		_, err := nvmeshr.handleActions(stored)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getStartedActions(getCluster())).To(Equal(map[string]nvmeshv1.ActionName{"exclusive-1": "test-exclusive"}))
+	})
+})
@@ -5,4 +5,7 @@ type OperatorOptions struct {
 	IsOpenShift         bool
 	DefaultCoreImageTag string
 	Development         bool
+
+	// MaxConcurrentReconciles - the number of NVMesh clusters that can be reconciled at the same time
+	MaxConcurrentReconciles int
 }
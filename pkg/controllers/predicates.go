@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var nvmeshNodeLabelKeys = []string{
+	nvmeshClientLabelKey,
+	nvmeshTargetLabelKey,
+	nvmeshMgmtLabelKey,
+}
+
+// workloadStatusChangedPredicate - passes updates on DaemonSets, StatefulSets and Deployments when the spec was changed or when pods became ready or unavailable
+func workloadStatusChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+
+			if e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() {
+				return true
+			}
+
+			return !reflect.DeepEqual(getWorkloadReadiness(e.ObjectOld), getWorkloadReadiness(e.ObjectNew))
+		},
+	}
+}
+
+// getWorkloadReadiness - returns only the status fields that are relevant for the readiness of a workload
+func getWorkloadReadiness(obj client.Object) []interface{} {
+	switch o := obj.(type) {
+	case *appsv1.DaemonSet:
+		s := o.Status
+		return []interface{}{s.ObservedGeneration, s.DesiredNumberScheduled, s.NumberReady, s.NumberAvailable, s.NumberUnavailable, s.UpdatedNumberScheduled}
+	case *appsv1.StatefulSet:
+		s := o.Status
+		return []interface{}{s.ObservedGeneration, s.Replicas, s.ReadyReplicas, s.UpdatedReplicas, s.CurrentRevision, s.UpdateRevision}
+	case *appsv1.Deployment:
+		s := o.Status
+		return []interface{}{s.ObservedGeneration, s.Replicas, s.ReadyReplicas, s.AvailableReplicas, s.UnavailableReplicas, s.UpdatedReplicas}
+	}
+
+	return nil
+}
+
+// jobStatusChangedPredicate - passes updates on Jobs when pods of the job started, succeeded or failed
+func jobStatusChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldJob, okOld := e.ObjectOld.(*batchv1.Job)
+			newJob, okNew := e.ObjectNew.(*batchv1.Job)
+			if !okOld || !okNew {
+				return false
+			}
+
+			o := oldJob.Status
+			n := newJob.Status
+			return o.Active != n.Active || o.Succeeded != n.Succeeded || o.Failed != n.Failed || len(o.Conditions) != len(n.Conditions)
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			// Jobs are created by the operator, we only care about their progress
+			return false
+		},
+	}
+}
+
+// nvmeshActionFinishedPredicate - passes updates on NVMeshActions when they finished, a schedule may start a run that waited for the previous run
+func nvmeshActionFinishedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldAction, okOld := e.ObjectOld.(*nvmeshv1.NVMeshAction)
+			newAction, okNew := e.ObjectNew.(*nvmeshv1.NVMeshAction)
+			if !okOld || !okNew {
+				return false
+			}
+
+			return !isNVMeshActionFinished(oldAction) && isNVMeshActionFinished(newAction)
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			// scheduled NVMeshActions are created by the operator
+			return false
+		},
+	}
+}
+
+// nodeChangedPredicate - passes events on Nodes that have or had one of the NVMesh role labels,
+// new nodes and label changes are passed as well because they might match the node selectors in the CR.
+// a node that was rebooted or got a new kernel is passed so that it is discovered again
+func nodeChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return true
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return hasAnyNVMeshLabel(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+
+			if !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) {
+				return true
+			}
+
+			oldNode, okOld := e.ObjectOld.(*corev1.Node)
+			newNode, okNew := e.ObjectNew.(*corev1.Node)
+			if !okOld || !okNew {
+				return false
+			}
+
+			oldInfo := oldNode.Status.NodeInfo
+			newInfo := newNode.Status.NodeInfo
+			return oldInfo.BootID != newInfo.BootID || oldInfo.KernelVersion != newInfo.KernelVersion
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+func hasAnyNVMeshLabel(obj client.Object) bool {
+	if obj == nil {
+		return false
+	}
+
+	labels := obj.GetLabels()
+	for _, key := range nvmeshNodeLabelKeys {
+		if _, ok := labels[key]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mapObjectToCluster - maps objects created by the operator without an owner reference (i.e. Jobs) to the NVMesh cluster using the cluster-name label
+func (r *NVMeshReconciler) mapObjectToCluster(obj client.Object) []reconcile.Request {
+	clusterName, ok := obj.GetLabels()[nvmeshClusterNameLabelKey]
+	if !ok || clusterName == "" {
+		return nil
+	}
+
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: clusterName, Namespace: obj.GetNamespace()}},
+	}
+}
+
+// mapNodeToClusters - a node can be used by any of the NVMesh clusters, so all clusters are enqueued
+func (r *NVMeshReconciler) mapNodeToClusters(obj client.Object) []reconcile.Request {
+	clusterList := &nvmeshv1.NVMeshList{}
+	if err := r.getCachedReader().List(context.TODO(), clusterList); err != nil {
+		r.Log.Error(err, "Failed to list NVMesh clusters for node event")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(clusterList.Items))
+	for _, cluster := range clusterList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: cluster.GetName(), Namespace: cluster.GetNamespace()},
+		})
+	}
+
+	return requests
+}
@@ -0,0 +1,498 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	conditions "excelero.com/nvmesh-k8s-operator/pkg/conditions"
+	errors "github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	preflightJobNamePrefix     = "nvmesh-preflight-"
+	preflightNodeLabelKey      = "nvmesh.excelero.com/preflight-node"
+	preflightVersionAnnotation = "nvmesh.excelero.com/preflight-version"
+
+	defaultPreflightMinAvailableMemoryMiB = 1024
+
+	preflightAction = "preflight"
+
+	startPreflightStage   = "StartPreflight"
+	waitForPreflightStage = "WaitForPreflight"
+)
+
+// preflightScript - checks that the node can run the NVMesh drivers and reports one name|result|message line per check to the termination message of the container.
+// the binaries of each NVMesh version are listed on the file server under <address>/<version>/ by kernel version
+const preflightScript = `report=/dev/termination-log
+: > $report
+check() { echo "$1|$2|$3" >> $report; }
+
+kernel=$(uname -r)
+insecure=""
+if [ "$NO_CHECK_CERTIFICATE" = "true" ]; then insecure="-k"; fi
+index_url="$KMOD_SERVER_ADDRESS/$NVMESH_VERSION/"
+if [ -n "$KMOD_SERVER_USERNAME" ]; then
+	code=$(curl -sS --max-time 20 $insecure --user "$KMOD_SERVER_USERNAME:$KMOD_SERVER_PASSWORD" -o /tmp/index -w '%{http_code}' "$index_url" 2>/tmp/curl.err)
+else
+	code=$(curl -sS --max-time 20 $insecure -o /tmp/index -w '%{http_code}' "$index_url" 2>/tmp/curl.err)
+fi
+case "$code" in
+	2*|3*)
+		check file-server Passed "$KMOD_SERVER_ADDRESS is reachable"
+		if grep -q -F "$kernel" /tmp/index; then
+			check kernel Passed "Kernel $kernel is supported by NVMesh $NVMESH_VERSION"
+		else
+			check kernel Failed "No binaries were found for kernel $kernel and NVMesh $NVMESH_VERSION on the file server"
+		fi;;
+	401|403)
+		check file-server Failed "The file server rejected the credentials in the nvmesh-file-server-cred secret (HTTP $code)"
+		check kernel Failed "Could not read the binaries index of NVMesh $NVMESH_VERSION";;
+	000|"")
+		check file-server Failed "$KMOD_SERVER_ADDRESS is not reachable. $(head -c 200 /tmp/curl.err | tr '\n' ' ')"
+		check kernel Failed "Could not read the binaries index of NVMesh $NVMESH_VERSION";;
+	*)
+		check file-server Failed "HTTP $code from $index_url"
+		check kernel Failed "Could not read the binaries index of NVMesh $NVMESH_VERSION";;
+esac
+
+available=$(awk '/^MemAvailable:/ {print int($2/1024)}' /proc/meminfo)
+if [ "$available" -ge "$MIN_AVAILABLE_MEMORY_MIB" ]; then
+	check memory Passed "${available}MiB available"
+else
+	check memory Failed "${available}MiB available, at least ${MIN_AVAILABLE_MEMORY_MIB}MiB are required"
+fi
+
+if [ "$MIN_FREE_HUGEPAGES" -gt 0 ]; then
+	hugepages=$(awk '/^HugePages_Free:/ {print $2}' /proc/meminfo)
+	if [ "${hugepages:-0}" -ge "$MIN_FREE_HUGEPAGES" ]; then
+		check hugepages Passed "$hugepages hugepages are free"
+	else
+		check hugepages Failed "${hugepages:-0} hugepages are free, at least $MIN_FREE_HUGEPAGES are required"
+	fi
+fi
+
+if [ "$REQUIRE_RDMA" = "true" ]; then
+	if ls /dev/infiniband/uverbs* > /dev/null 2>&1 && [ -e /dev/infiniband/rdma_cm ]; then
+		check rdma-devices Passed "Found /dev/infiniband/uverbs* and /dev/infiniband/rdma_cm"
+	else
+		check rdma-devices Failed "Missing /dev/infiniband/uverbs* or /dev/infiniband/rdma_cm. Install the RDMA drivers or use TCP on this node"
+	fi
+fi
+
+if [ "$IS_TARGET" = "true" ]; then
+	if ls /dev/nvme*n* > /dev/null 2>&1; then
+		check nvme-devices Passed "Found NVMe drives"
+	else
+		check nvme-devices Warning "No NVMe drives were found on a target node"
+	fi
+fi
+
+secure_boot=$(od -An -t u1 /host/sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c 2>/dev/null | awk '{print $NF}')
+if [ "$secure_boot" = "1" ]; then
+	check secure-boot Warning "Secure Boot is enabled, the NVMesh kernel modules will not load unless they are signed with an enrolled key"
+else
+	check secure-boot Passed "Secure Boot is disabled"
+fi
+`
+
+func getPreflightJobName(nodeName string) string {
+	return preflightJobNamePrefix + sanitizeString(nodeName)
+}
+
+// getDeployedCoreVersion - returns the NVMesh version of the deployed driver DaemonSets, deployed is false if NVMesh Core was not deployed yet
+func (r *NVMeshBaseReconciler) getDeployedCoreVersion(cr *nvmeshv1.NVMesh) (version string, deployed bool, err error) {
+	for _, dsName := range []string{clientDriverDaemonSetName, targetDriverDaemonSetName} {
+		ds := &appsv1.DaemonSet{}
+		err := r.Client.Get(context.TODO(), client.ObjectKey{Name: dsName, Namespace: cr.GetNamespace()}, ds)
+		if k8serrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return "", false, errors.Wrap(err, fmt.Sprintf("Failed to get DaemonSet %s", dsName))
+		}
+
+		for _, c := range ds.Spec.Template.Spec.Containers {
+			for _, env := range c.Env {
+				if env.Name == envVarNVMeshVersion {
+					return env.Value, true, nil
+				}
+			}
+		}
+
+		return "", true, nil
+	}
+
+	return "", false, nil
+}
+
+// isPreflightRequired - the preflight checks run before NVMesh Core is first deployed and before each upgrade, which is when the deployed drivers are not of spec.core.version
+func (r *NVMeshBaseReconciler) isPreflightRequired(cr *nvmeshv1.NVMesh) (bool, error) {
+	if cr.Spec.Core.Disabled || cr.Spec.Core.Preflight.Skip {
+		return false, nil
+	}
+
+	version, deployed, err := r.getDeployedCoreVersion(cr)
+	if err != nil {
+		return false, err
+	}
+
+	return !deployed || (version != "" && version != cr.Spec.Core.Version), nil
+}
+
+// reconcilePreflight - records the results of finished preflight jobs, starts the checks on nodes that were not checked for spec.core.version when they are required, and reports failed nodes
+func (r *NVMeshReconciler) reconcilePreflight(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	statuses, err := r.getNVMeshNodeStatuses(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	jobs, err := r.getNodeJobs(cr, preflightNodeLabelKey)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	inProgress := false
+	results := newErrorCollector()
+	for nodeName, job := range jobs {
+		if !isActiveCoreNode(cr, nodeName) {
+			if err := r.deleteJob(cr.GetNamespace(), job.GetName()); err != nil {
+				return DoNotRequeue(), err
+			}
+
+			continue
+		}
+
+		// the job was already handled and is being deleted
+		if job.GetDeletionTimestamp() != nil {
+			inProgress = true
+			continue
+		}
+
+		completed, jobErr := r.isSingleJobCompleted(&job)
+		if !completed {
+			inProgress = true
+			continue
+		}
+
+		result := &nvmeshv1.NodePreflightResult{Version: job.GetAnnotations()[preflightVersionAnnotation]}
+		if jobErr == nil {
+			var report string
+			report, jobErr = r.getJobTerminationMessage(cr, job.GetName())
+			result.Checks = parsePreflightReport(report)
+		}
+
+		setPreflightResult(result, jobErr)
+		var previous *nvmeshv1.NodePreflightResult
+		if status := statuses[nodeName]; status != nil && status.LastPreflight != nil && status.LastPreflight.Version == result.Version {
+			previous = status.LastPreflight
+		}
+
+		countPreflightFailures(previous, result)
+
+		if err := r.updateNVMeshNode(cr, nodeName, func(nvmeshNode *nvmeshv1.NVMeshNode) {
+			nvmeshNode.Status.LastPreflight = result
+		}); err != nil {
+			return DoNotRequeue(), err
+		}
+
+		if statuses[nodeName] == nil {
+			statuses[nodeName] = &nvmeshv1.NVMeshNodeStatus{}
+		}
+
+		statuses[nodeName].LastPreflight = result
+		if err := r.deleteJob(cr.GetNamespace(), job.GetName()); err != nil {
+			return DoNotRequeue(), err
+		}
+	}
+
+	required, err := r.isPreflightRequired(cr)
+	if err != nil {
+		return DoNotRequeue(), err
+	}
+
+	if required {
+		for nodeName := range cr.Status.Nodes {
+			if !isActiveCoreNode(cr, nodeName) {
+				continue
+			}
+
+			if _, ok := jobs[nodeName]; ok {
+				continue
+			}
+
+			if status, ok := statuses[nodeName]; ok && status.LastPreflight != nil && status.LastPreflight.Version == cr.Spec.Core.Version {
+				if status.LastPreflight.Result != nvmeshv1.NodeTaskFailed {
+					continue
+				}
+
+				// a node that failed the checks is checked again after a delay that grows with each failure, i.e. after the missing binaries were uploaded to the file server
+				if delay := getNodeTaskRetryDelay(status.LastPreflight.Failures, status.LastPreflight.Time); delay > 0 {
+					results.add(Requeue(delay), nil)
+					continue
+				}
+			}
+
+			if err := r.createPreflightJob(cr, nodeName); err != nil {
+				return DoNotRequeue(), err
+			}
+
+			inProgress = true
+		}
+	}
+
+	r.reportPreflightFailures(cr, statuses)
+
+	if inProgress {
+		results.add(Requeue(jobProgressSafetyRequeue), nil)
+	}
+
+	return results.Result()
+}
+
+// reportPreflightFailures - sets the PreflightFailed condition when active nodes failed the checks of spec.core.version
+func (r *NVMeshReconciler) reportPreflightFailures(cr *nvmeshv1.NVMesh, statuses map[string]*nvmeshv1.NVMeshNodeStatus) {
+	failures := make([]string, 0)
+	for nodeName, status := range statuses {
+		if !isActiveCoreNode(cr, nodeName) || status.LastPreflight == nil {
+			continue
+		}
+
+		result := status.LastPreflight
+		if result.Version == cr.Spec.Core.Version && result.Result == nvmeshv1.NodeTaskFailed {
+			failures = append(failures, fmt.Sprintf("%s: %s", nodeName, result.Message))
+		}
+	}
+
+	existing := conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.PreflightFailed)
+	if len(failures) == 0 {
+		if existing != nil {
+			r.EventManager.Normal(cr, "PreflightPassed", fmt.Sprintf("All nodes passed the preflight checks for NVMesh %s", cr.Spec.Core.Version))
+		}
+
+		conditions.RemoveStatusCondition(&cr.Status.Conditions, nvmeshv1.PreflightFailed)
+		return
+	}
+
+	sort.Strings(failures)
+	msg := fmt.Sprintf("Nodes failed the preflight checks for NVMesh %s. %s", cr.Spec.Core.Version, strings.Join(failures, "; "))
+	if existing == nil || existing.Message != msg {
+		r.EventManager.Warning(cr, "PreflightFailed", msg+". The failed nodes are checked again periodically, run the preflight action to check them right after fixing them, or set spec.core.preflight.skip")
+	}
+
+	conditions.SetStatusCondition(&cr.Status.Conditions, &nvmeshv1.ClusterCondition{
+		Type:    nvmeshv1.PreflightFailed,
+		Status:  nvmeshv1.ConditionTrue,
+		Reason:  "ChecksFailed",
+		Message: msg,
+	})
+}
+
+// isPreflightPassed - returns true when NVMesh Core can be deployed, that is when the preflight checks are not required or all active nodes passed them for spec.core.version
+func (r *NVMeshBaseReconciler) isPreflightPassed(cr *nvmeshv1.NVMesh) (bool, error) {
+	required, err := r.isPreflightRequired(cr)
+	if err != nil || !required {
+		return !required, err
+	}
+
+	statuses, err := r.getNVMeshNodeStatuses(cr)
+	if err != nil {
+		return false, err
+	}
+
+	for nodeName := range cr.Status.Nodes {
+		if !isActiveCoreNode(cr, nodeName) {
+			continue
+		}
+
+		status, ok := statuses[nodeName]
+		if !ok || status.LastPreflight == nil || status.LastPreflight.Version != cr.Spec.Core.Version || status.LastPreflight.Result != nvmeshv1.NodeTaskSucceeded {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// getCoreVersionToDeploy - returns the NVMesh version to deploy, which is spec.core.version once the preflight checks passed and the deployed version until then.
+// deploy is false when NVMesh Core was not deployed yet and the checks did not pass
+func (r *NVMeshBaseReconciler) getCoreVersionToDeploy(cr *nvmeshv1.NVMesh) (version string, deploy bool, err error) {
+	passed, err := r.isPreflightPassed(cr)
+	if err != nil || passed {
+		return cr.Spec.Core.Version, passed, err
+	}
+
+	return r.getDeployedCoreVersion(cr)
+}
+
+func (r *NVMeshBaseReconciler) createPreflightJob(cr *nvmeshv1.NVMesh, nodeName string) error {
+	node := &corev1.Node{}
+	if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: nodeName}, node); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Failed to get node %s", nodeName))
+	}
+
+	statuses, err := r.getNVMeshNodeStatuses(cr)
+	if err != nil {
+		return err
+	}
+
+	var discovery *nvmeshv1.NodeDiscovery
+	if status, ok := statuses[nodeName]; ok {
+		discovery = status.Discovery
+	}
+
+	nodeConfig, err := getNodeCoreConfig(cr, nodeName, node.GetLabels(), discovery)
+	if err != nil {
+		return err
+	}
+
+	isTarget := false
+	for _, role := range cr.Status.Nodes[nodeName].Roles {
+		if role == nodeRoleTarget {
+			isTarget = true
+		}
+	}
+
+	minAvailableMemory := cr.Spec.Core.Preflight.MinAvailableMemoryMiB
+	if minAvailableMemory == 0 {
+		minAvailableMemory = defaultPreflightMinAvailableMemoryMiB
+	}
+
+	jobName := getPreflightJobName(nodeName)
+	job := r.getNewJob(cr, jobName, r.getCoreFullImageName(cr, driverContainerImageName))
+	job.ObjectMeta.Labels[preflightNodeLabelKey] = nodeName
+	job.ObjectMeta.Annotations = map[string]string{preflightVersionAnnotation: cr.Spec.Core.Version}
+
+	podSpec := &job.Spec.Template.Spec
+	podSpec.HostNetwork = true
+	podSpec.NodeSelector = matchNode(nodeName)
+
+	container := &podSpec.Containers[0]
+	container.Command = []string{"/bin/sh"}
+	container.Args = []string{"-c", preflightScript}
+	container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	setContainerAsPrivileged(container)
+
+	// the core ConfigMap is not deployed yet on the first deploy, so the file server options are passed directly
+	fileServerOptions := getFileServerOptions(cr)
+	optional := true
+	container.Env = []corev1.EnvVar{
+		{Name: envVarNVMeshVersion, Value: cr.Spec.Core.Version},
+		{Name: "KMOD_SERVER_ADDRESS", Value: fileServerOptions.Address},
+		{Name: "NO_CHECK_CERTIFICATE", Value: strconv.FormatBool(fileServerOptions.SkipCheckCertificate)},
+		{Name: "KMOD_SERVER_USERNAME", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: getFileServerSecretName(cr)}, Key: "username", Optional: &optional}}},
+		{Name: "KMOD_SERVER_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: getFileServerSecretName(cr)}, Key: "password", Optional: &optional}}},
+		{Name: "MIN_AVAILABLE_MEMORY_MIB", Value: strconv.Itoa(minAvailableMemory)},
+		{Name: "MIN_FREE_HUGEPAGES", Value: strconv.Itoa(cr.Spec.Core.Preflight.MinFreeHugePages)},
+		{Name: "REQUIRE_RDMA", Value: strconv.FormatBool(!nodeConfig.TCPOnly)},
+		{Name: "IS_TARGET", Value: strconv.FormatBool(isTarget)},
+	}
+
+	// efivars is a separate mount on the host, it is used to check if Secure Boot is enabled
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name:         "sys-firmware",
+		VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/sys/firmware"}},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "sys-firmware", MountPath: "/host/sys/firmware", ReadOnly: true})
+
+	err = r.Client.Create(context.TODO(), job)
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return errors.Wrap(err, fmt.Sprintf("Failed to create %s on node %s", jobName, nodeName))
+	}
+
+	r.Log.Info(fmt.Sprintf("Created preflight job for node %s", nodeName))
+	return nil
+}
+
+// parsePreflightReport - parses the name|result|message lines written by preflightScript
+func parsePreflightReport(report string) []nvmeshv1.PreflightCheck {
+	checks := make([]nvmeshv1.PreflightCheck, 0)
+	for _, line := range strings.Split(report, "\n") {
+		parts := strings.SplitN(line, "|", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		checks = append(checks, nvmeshv1.PreflightCheck{Name: parts[0], Result: parts[1], Message: strings.TrimSpace(parts[2])})
+	}
+
+	return checks
+}
+
+// setPreflightResult - sets the result of the node from its checks, the node fails if any check failed or if the job failed
+func setPreflightResult(result *nvmeshv1.NodePreflightResult, jobErr error) {
+	result.Time = metav1.Now()
+
+	if jobErr != nil {
+		result.Result = nvmeshv1.NodeTaskFailed
+		result.Message = jobErr.Error()
+		return
+	}
+
+	failed := make([]string, 0)
+	for _, check := range result.Checks {
+		if check.Result == nvmeshv1.PreflightCheckFailed {
+			failed = append(failed, fmt.Sprintf("%s - %s", check.Name, check.Message))
+		}
+	}
+
+	if len(result.Checks) == 0 {
+		failed = append(failed, "the preflight job did not report any check")
+	}
+
+	if len(failed) > 0 {
+		result.Result = nvmeshv1.NodeTaskFailed
+		result.Message = strings.Join(failed, ", ")
+		return
+	}
+
+	result.Result = nvmeshv1.NodeTaskSucceeded
+}
+
+// countPreflightFailures - sets the number of consecutive failures on a failed result, counting the failures of the previous result of the same version
+func countPreflightFailures(previous *nvmeshv1.NodePreflightResult, result *nvmeshv1.NodePreflightResult) {
+	if result.Result != nvmeshv1.NodeTaskFailed {
+		return
+	}
+
+	result.Failures = 1
+	if previous != nil && previous.Result == nvmeshv1.NodeTaskFailed {
+		result.Failures = previous.Failures + 1
+	}
+}
+
+// preflight - runs the checks again on all nodes, the results are recorded by reconcilePreflight
+type preflight struct {
+	actionOptions
+}
+
+func (preflight) Stages(r *NVMeshReconciler, a nvmeshv1.ClusterAction) []Task {
+	return []Task{
+		{startPreflightStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			for nodeName := range cr.Status.Nodes {
+				if !isActiveCoreNode(cr, nodeName) {
+					continue
+				}
+
+				if err := r.createPreflightJob(cr, nodeName); err != nil {
+					return DoNotRequeue(), err
+				}
+			}
+
+			return DoNotRequeue(), nil
+		}},
+		{waitForPreflightStage, func(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+			return r.waitForNodeJobs(cr, preflightNodeLabelKey)
+		}},
+	}
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	conditions "excelero.com/nvmesh-k8s-operator/pkg/conditions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	failedPreflightReport = "file-server|Passed|https://repo is reachable\n" +
+		"kernel|Failed|No binaries were found for kernel 5.14.0 and NVMesh 2.5.0 on the file server\n" +
+		"memory|Passed|8000MiB available\n" +
+		"secure-boot|Warning|Secure Boot is enabled\n"
+
+	passedPreflightReport = "file-server|Passed|https://repo is reachable\n" +
+		"kernel|Passed|Kernel 5.4.0 is supported by NVMesh 2.5.0\n" +
+		"memory|Passed|8000MiB available\n"
+)
+
+var _ = Describe("Preflight checks", func() {
+	It("blocks the deploy and the upgrade until the nodes pass the checks", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Spec.Core.Version = "2.5.0"
+
+		node := newTestNode("worker-1", map[string]string{nvmeshTargetLabelKey: ""})
+
+		r := newFakeReconciler(cr, node)
+		jobName := getPreflightJobName("worker-1")
+		getLastPreflight := func() *nvmeshv1.NodePreflightResult {
+			nvmeshNode := &nvmeshv1.NVMeshNode{}
+			Expect(r.Client.Get(ctx, client.ObjectKey{Name: getNVMeshNodeName(cr, "worker-1"), Namespace: TestingNamespace}, nvmeshNode)).To(BeNil())
+			return nvmeshNode.Status.LastPreflight
+		}
+
+		By("running the checks before the first deploy")
+		result, err := r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(result.Requeue).To(BeTrue())
+
+		job := &batchv1.Job{}
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: jobName, Namespace: TestingNamespace}, job)).To(BeNil())
+		Expect(job.GetAnnotations()[preflightVersionAnnotation]).To(Equal("2.5.0"))
+		Expect(job.Spec.Template.Spec.NodeSelector).To(Equal(matchNode("worker-1")))
+		Expect(job.Spec.Template.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "IS_TARGET", Value: "true"}))
+
+		passed, err := r.isPreflightPassed(cr)
+		Expect(err).To(BeNil())
+		Expect(passed).To(BeFalse())
+
+		By("blocking the deploy and reporting a node that failed")
+		Expect(completeJobWithReport(r, jobName, failedPreflightReport)).To(Succeed())
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+
+		lastPreflight := getLastPreflight()
+		Expect(lastPreflight.Result).To(Equal(nvmeshv1.NodeTaskFailed))
+		Expect(lastPreflight.Version).To(Equal("2.5.0"))
+		Expect(lastPreflight.Checks).To(HaveLen(4))
+		Expect(lastPreflight.Message).To(ContainSubstring("kernel - No binaries"))
+
+		condition := conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.PreflightFailed)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Message).To(ContainSubstring("worker-1"))
+
+		passed, err = r.isPreflightPassed(cr)
+		Expect(err).To(BeNil())
+		Expect(passed).To(BeFalse())
+
+		By("not checking the failed node again before the retry delay passed")
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: jobName, Namespace: TestingNamespace}, &batchv1.Job{})).NotTo(BeNil())
+
+		By("running the checks again with the preflight action")
+		action := nvmeshv1.ClusterAction{Name: preflightAction, ID: "preflight-1"}
+		done, result, err := r.handleAction(action, cr)
+		Expect(err).To(BeNil())
+		Expect(done).To(BeFalse())
+		Expect(result.Requeue).To(BeTrue())
+
+		Expect(completeJobWithReport(r, jobName, passedPreflightReport)).To(Succeed())
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+
+		done, _, err = r.handleAction(action, cr)
+		Expect(err).To(BeNil())
+		Expect(done).To(BeTrue())
+
+		Expect(getLastPreflight().Result).To(Equal(nvmeshv1.NodeTaskSucceeded))
+		Expect(conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.PreflightFailed)).To(BeNil())
+
+		passed, err = r.isPreflightPassed(cr)
+		Expect(err).To(BeNil())
+		Expect(passed).To(BeTrue())
+
+		By("running the checks again before an upgrade")
+		ds := newTestTargetDaemonSet(false)
+		ds.Spec.Template.Spec.Containers = []corev1.Container{{Name: driverContainerName, Env: []corev1.EnvVar{{Name: envVarNVMeshVersion, Value: "2.5.0"}}}}
+		Expect(r.Client.Create(ctx, ds)).To(BeNil())
+
+		required, err := r.isPreflightRequired(cr)
+		Expect(err).To(BeNil())
+		Expect(required).To(BeFalse())
+
+		cr.Spec.Core.Version = "2.6.0"
+		passed, err = r.isPreflightPassed(cr)
+		Expect(err).To(BeNil())
+		Expect(passed).To(BeFalse())
+
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: jobName, Namespace: TestingNamespace}, job)).To(BeNil())
+		Expect(job.GetAnnotations()[preflightVersionAnnotation]).To(Equal("2.6.0"))
+
+		By("not blocking when the checks are skipped")
+		cr.Spec.Core.Preflight.Skip = true
+		passed, err = r.isPreflightPassed(cr)
+		Expect(err).To(BeNil())
+		Expect(passed).To(BeTrue())
+	})
+
+	It("retries a failed check and only blocks the upgrade", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Spec.Core.Version = "2.6.0"
+
+		node := newTestNode("worker-1", map[string]string{nvmeshClientLabelKey: "", nvmeshTargetLabelKey: ""})
+
+		// NVMesh 2.5.0 is deployed
+		ds := newTestTargetDaemonSet(false)
+		ds.Spec.Template.Spec.Containers = []corev1.Container{{Name: driverContainerName, Env: []corev1.EnvVar{{Name: envVarNVMeshVersion, Value: "2.5.0"}}}}
+
+		r := newFakeReconciler(cr, node, ds)
+		jobKey := client.ObjectKey{Name: getPreflightJobName("worker-1"), Namespace: TestingNamespace}
+		nvmeshNodeKey := client.ObjectKey{Name: getNVMeshNodeName(cr, "worker-1"), Namespace: TestingNamespace}
+		failChecks := func() {
+			_, err := r.reconcileNodes(cr)
+			Expect(err).To(BeNil())
+			Expect(completeJobWithReport(r, jobKey.Name, failedPreflightReport)).To(Succeed())
+			_, err = r.reconcileNodes(cr)
+			Expect(err).To(BeNil())
+		}
+
+		failChecks()
+		nvmeshNode := &nvmeshv1.NVMeshNode{}
+		Expect(r.Client.Get(ctx, nvmeshNodeKey, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Status.LastPreflight.Failures).To(Equal(1))
+
+		By("deploying the other changes with the deployed version while the upgrade waits for the checks")
+		version, deploy, err := r.getCoreVersionToDeploy(cr)
+		Expect(err).To(BeNil())
+		Expect(deploy).To(BeTrue())
+		Expect(version).To(Equal("2.5.0"))
+
+		By("checking the failed node again once the retry delay passed")
+		_, err = r.reconcileNodes(cr)
+		Expect(err).To(BeNil())
+		Expect(r.Client.Get(ctx, jobKey, &batchv1.Job{})).NotTo(BeNil())
+
+		nvmeshNode.Status.LastPreflight.Time = metav1.NewTime(time.Now().Add(-nodeTaskRetryBackoff))
+		Expect(r.Client.Status().Update(ctx, nvmeshNode)).To(BeNil())
+		failChecks()
+		Expect(r.Client.Get(ctx, nvmeshNodeKey, nvmeshNode)).To(BeNil())
+		Expect(nvmeshNode.Status.LastPreflight.Failures).To(Equal(2))
+	})
+})
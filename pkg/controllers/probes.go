@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// probesHashAnnotation - set on the pod templates the operator adds probes to, so that a change in the probe timings rolls the pods
+const probesHashAnnotation = "nvmesh.excelero.com/probes-hash"
+
+var (
+	// Management starts listening only after it connected to MongoDB and loaded its configuration
+	mgmtReadinessDefaults = nvmeshv1.ProbeTimings{InitialDelaySeconds: 20, PeriodSeconds: 10, TimeoutSeconds: 5, FailureThreshold: 3}
+	mgmtLivenessDefaults  = nvmeshv1.ProbeTimings{InitialDelaySeconds: 120, PeriodSeconds: 20, TimeoutSeconds: 5, FailureThreshold: 6}
+
+	mongoReadinessDefaults = nvmeshv1.ProbeTimings{InitialDelaySeconds: 5, PeriodSeconds: 10, TimeoutSeconds: 5, FailureThreshold: 3}
+	mongoLivenessDefaults  = nvmeshv1.ProbeTimings{InitialDelaySeconds: 30, PeriodSeconds: 20, TimeoutSeconds: 5, FailureThreshold: 6}
+
+	// the driver container may have to download or compile the kernel modules before they are loaded
+	driverReadinessDefaults = nvmeshv1.ProbeTimings{InitialDelaySeconds: 10, PeriodSeconds: 10, TimeoutSeconds: 5, FailureThreshold: 3}
+
+	csiReadinessDefaults = nvmeshv1.ProbeTimings{InitialDelaySeconds: 5, PeriodSeconds: 10, TimeoutSeconds: 5, FailureThreshold: 3}
+	csiLivenessDefaults  = nvmeshv1.ProbeTimings{InitialDelaySeconds: 30, PeriodSeconds: 20, TimeoutSeconds: 5, FailureThreshold: 6}
+)
+
+// newProbe - returns a probe with the given handler, using the timings from the spec and falling back to the defaults for fields that are not set
+func newProbe(handler corev1.Handler, timings nvmeshv1.ProbeTimings, defaults nvmeshv1.ProbeTimings) *corev1.Probe {
+	valueOrDefault := func(value int32, defaultValue int32) int32 {
+		if value > 0 {
+			return value
+		}
+
+		return defaultValue
+	}
+
+	return &corev1.Probe{
+		Handler:             handler,
+		InitialDelaySeconds: valueOrDefault(timings.InitialDelaySeconds, defaults.InitialDelaySeconds),
+		PeriodSeconds:       valueOrDefault(timings.PeriodSeconds, defaults.PeriodSeconds),
+		TimeoutSeconds:      valueOrDefault(timings.TimeoutSeconds, defaults.TimeoutSeconds),
+		FailureThreshold:    valueOrDefault(timings.FailureThreshold, defaults.FailureThreshold),
+	}
+}
+
+func getProbesHash(probes nvmeshv1.ProbesSpec) string {
+	data, _ := json.Marshal(probes)
+	return fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+}
+
+func setProbesHashAnnotation(probes nvmeshv1.ProbesSpec, template *corev1.PodTemplateSpec) {
+	annotations := template.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	annotations[probesHashAnnotation] = getProbesHash(probes)
+	template.SetAnnotations(annotations)
+}
+
+func probesHashChanged(expected *corev1.PodTemplateSpec, found *corev1.PodTemplateSpec) bool {
+	return expected.GetAnnotations()[probesHashAnnotation] != found.GetAnnotations()[probesHashAnnotation]
+}
+
+// addMgmtProbes - Management is ready when the GUI answers over HTTP(S), and alive as long as the websocket port accepts connections
+func addMgmtProbes(cr *nvmeshv1.NVMesh, template *corev1.PodTemplateSpec, container *corev1.Container) {
+	probes := cr.Spec.Management.Probes
+	setProbesHashAnnotation(probes, template)
+	if probes.Disabled {
+		container.ReadinessProbe = nil
+		container.LivenessProbe = nil
+		return
+	}
+
+	scheme := corev1.URISchemeHTTPS
+	if cr.Spec.Management.NoSSL {
+		scheme = corev1.URISchemeHTTP
+	}
+
+	// the kubelet does not verify the certificate of HTTPS probes, so a self signed certificate is fine
+	container.ReadinessProbe = newProbe(corev1.Handler{
+		HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromString("gui"), Scheme: scheme},
+	}, probes.Readiness, mgmtReadinessDefaults)
+
+	container.LivenessProbe = newProbe(corev1.Handler{
+		TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("ws")},
+	}, probes.Liveness, mgmtLivenessDefaults)
+}
+
+// addMongoProbes - pings the MongoDB deployed by the operator over the local connection. ping does not require authentication
+func addMongoProbes(cr *nvmeshv1.NVMesh, template *corev1.PodTemplateSpec, container *corev1.Container) {
+	probes := cr.Spec.Management.MongoDB.Probes
+	setProbesHashAnnotation(probes, template)
+	if probes.Disabled {
+		container.ReadinessProbe = nil
+		container.LivenessProbe = nil
+		return
+	}
+
+	command := append([]string{"mongo"}, getMongoLocalFlags(cr)...)
+	command = append(command, "--quiet", "--eval", "db.adminCommand('ping')")
+	handler := corev1.Handler{Exec: &corev1.ExecAction{Command: command}}
+
+	container.ReadinessProbe = newProbe(handler, probes.Readiness, mongoReadinessDefaults)
+	container.LivenessProbe = newProbe(handler, probes.Liveness, mongoLivenessDefaults)
+}
+
+// addDriverReadinessProbe - the driver container is ready once its kernel module is loaded.
+// there is no liveness probe, restarting the driver container unloads the modules and disconnects the volumes on the node
+func addDriverReadinessProbe(cr *nvmeshv1.NVMesh, daemonSetName string, template *corev1.PodTemplateSpec, container *corev1.Container) {
+	probes := cr.Spec.Core.Probes
+	setProbesHashAnnotation(probes, template)
+	if probes.Disabled {
+		container.ReadinessProbe = nil
+		return
+	}
+
+	module := "nvmeibc"
+	if daemonSetName == targetDriverDaemonSetName {
+		module = "nvmeibs"
+	}
+
+	container.ReadinessProbe = newProbe(corev1.Handler{
+		Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "-c", fmt.Sprintf("grep -q '^%s ' /proc/modules", module)}},
+	}, probes.Readiness, driverReadinessDefaults)
+}
+
+// driverProbesHashChanged - a driver DaemonSet deployed before the probes hash was added is compared as if it had the default probes,
+// so that upgrading the operator does not restart the drivers on every node
+func driverProbesHashChanged(expected *corev1.PodTemplateSpec, found *corev1.PodTemplateSpec) bool {
+	expectedHash, ok := expected.GetAnnotations()[probesHashAnnotation]
+	if !ok {
+		// not a driver DaemonSet
+		return false
+	}
+
+	foundHash, ok := found.GetAnnotations()[probesHashAnnotation]
+	if !ok {
+		foundHash = getProbesHash(nvmeshv1.ProbesSpec{})
+	}
+
+	return expectedHash != foundHash
+}
+
+// addCSIProbes - the CSI driver is ready and alive as long as it answers the CSI Probe call over its gRPC socket, which is checked by the liveness-probe container
+func addCSIProbes(cr *nvmeshv1.NVMesh, template *corev1.PodTemplateSpec, container *corev1.Container) {
+	probes := cr.Spec.CSI.Probes
+	setProbesHashAnnotation(probes, template)
+	if probes.Disabled {
+		container.ReadinessProbe = nil
+		container.LivenessProbe = nil
+		return
+	}
+
+	handler := corev1.Handler{HTTPGet: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromString("healthz")}}
+	container.ReadinessProbe = newProbe(handler, probes.Readiness, csiReadinessDefaults)
+	container.LivenessProbe = newProbe(handler, probes.Liveness, csiLivenessDefaults)
+}
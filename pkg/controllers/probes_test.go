@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var _ = Describe("Probes", func() {
+	It("injects the probes with tunable timings", func() {
+		cr := newTestCluster()
+		cr.Spec.Management.Version = "2.5.0"
+		cr.Spec.CSI.Version = "v1.1.2"
+		cr.Spec.Management.MongoDB.TLS = &nvmeshv1.MongoDBTLSSpec{CASecretName: "mongo-ca", ServerCertSecretName: "mongo-server"}
+
+		r := newFakeReconciler(cr)
+		mgmtr := NVMeshMgmtReconciler(*r)
+		corer := NVMeshCoreReconciler(*r)
+		csir := NVMeshCSIReconciler(*r)
+
+		newMgmtStatefulSet := func() *appsv1.StatefulSet {
+			ss := &appsv1.StatefulSet{}
+			ss.SetName(mgmtStatefulSetName)
+			ss.Spec.Template.Spec.Containers = []corev1.Container{{Name: "nvmesh-management"}}
+			ss.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{{}}
+			Expect(mgmtr.InitObject(cr, ss)).To(Succeed())
+			return ss
+		}
+
+		By("checking the Management GUI over HTTPS")
+		mgmt := newMgmtStatefulSet()
+		readiness := mgmt.Spec.Template.Spec.Containers[0].ReadinessProbe
+		Expect(readiness.HTTPGet.Scheme).To(Equal(corev1.URISchemeHTTPS))
+		Expect(readiness.HTTPGet.Port.String()).To(Equal("gui"))
+		Expect(readiness.InitialDelaySeconds).To(Equal(mgmtReadinessDefaults.InitialDelaySeconds))
+		Expect(mgmt.Spec.Template.Spec.Containers[0].LivenessProbe.TCPSocket.Port.String()).To(Equal("ws"))
+
+		By("rolling Management when the timings change")
+		cr.Spec.Management.NoSSL = true
+		cr.Spec.Management.Probes.Readiness.PeriodSeconds = 3
+		updated := newMgmtStatefulSet()
+		readiness = updated.Spec.Template.Spec.Containers[0].ReadinessProbe
+		Expect(readiness.HTTPGet.Scheme).To(Equal(corev1.URISchemeHTTP))
+		Expect(readiness.PeriodSeconds).To(Equal(int32(3)))
+		Expect(readiness.FailureThreshold).To(Equal(mgmtReadinessDefaults.FailureThreshold))
+		Expect(mgmtr.ShouldUpdateObject(cr, updated, mgmt)).To(BeTrue())
+		Expect(mgmtr.ShouldUpdateObject(cr, updated, updated.DeepCopy())).To(BeFalse())
+
+		By("pinging MongoDB over the local TLS connection")
+		mongo := &appsv1.StatefulSet{}
+		mongo.SetName("mongo")
+		mongo.Spec.Template.Spec.Containers = []corev1.Container{{Name: "mongod"}}
+		mongo.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{{}}
+		Expect(mgmtr.InitObject(cr, mongo)).To(Succeed())
+		command := mongo.Spec.Template.Spec.Containers[0].LivenessProbe.Exec.Command
+		Expect(command).To(ContainElements("mongo", "--tls", "--tlsAllowInvalidHostnames", "db.adminCommand('ping')"))
+
+		By("checking that the kernel module of the driver is loaded, without a liveness probe")
+		target := &appsv1.DaemonSet{}
+		target.SetName(targetDriverDaemonSetName)
+		target.Spec.Template.Spec.Containers = []corev1.Container{{Name: driverContainerName}}
+		Expect(corer.initDaemonSets(cr, target)).To(Succeed())
+		driver := target.Spec.Template.Spec.Containers[0]
+		Expect(driver.ReadinessProbe.Exec.Command[2]).To(Equal("grep -q '^nvmeibs ' /proc/modules"))
+		Expect(driver.LivenessProbe).To(BeNil())
+
+		By("rolling the drivers when the probes change, but not when an older DaemonSet has no probes hash")
+		older := target.DeepCopy()
+		delete(older.Spec.Template.Annotations, probesHashAnnotation)
+		Expect(corer.shouldUpdateDaemonSet(cr, target, older)).To(BeFalse())
+
+		cr.Spec.Core.Probes.Readiness.PeriodSeconds = 30
+		changed := &appsv1.DaemonSet{}
+		changed.SetName(targetDriverDaemonSetName)
+		changed.Spec.Template.Spec.Containers = []corev1.Container{{Name: driverContainerName}}
+		Expect(corer.initDaemonSets(cr, changed)).To(Succeed())
+		Expect(corer.shouldUpdateDaemonSet(cr, changed, target)).To(BeTrue())
+		Expect(corer.shouldUpdateDaemonSet(cr, changed, older)).To(BeTrue())
+
+		By("checking the CSI gRPC health through the liveness-probe container")
+		csiDS := &appsv1.DaemonSet{}
+		csiDS.SetName("nvmesh-csi-node-driver")
+		csiDS.Spec.Template.Spec.Containers = []corev1.Container{{Name: "nvmesh-csi-driver"}, {Name: "liveness-probe"}}
+		Expect(csir.InitObject(cr, csiDS)).To(Succeed())
+		Expect(csiDS.Spec.Template.Spec.Containers[0].ReadinessProbe.HTTPGet).To(Equal(&corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromString("healthz")}))
+		Expect(csiDS.Spec.Template.Spec.Containers[0].LivenessProbe.HTTPGet.Path).To(Equal("/healthz"))
+
+		withoutSidecar := csiDS.DeepCopy()
+		withoutSidecar.Spec.Template.Spec.Containers = withoutSidecar.Spec.Template.Spec.Containers[:1]
+		Expect(csir.ShouldUpdateObject(cr, csiDS, withoutSidecar)).To(BeTrue())
+
+		By("removing the probes when they are disabled")
+		cr.Spec.CSI.Probes.Disabled = true
+		disabled := csiDS.DeepCopy()
+		Expect(csir.InitObject(cr, disabled)).To(Succeed())
+		Expect(disabled.Spec.Template.Spec.Containers[0].ReadinessProbe).To(BeNil())
+		Expect(csir.ShouldUpdateObject(cr, disabled, csiDS)).To(BeTrue())
+	})
+})
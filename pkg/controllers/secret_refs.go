@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"reflect"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getImagePullSecrets - returns the image pull secrets from spec.operator.secrets or the default registry secret
+func getImagePullSecrets(cr *nvmeshv1.NVMesh) []corev1.LocalObjectReference {
+	if len(cr.Spec.Operator.Secrets.ImagePullSecrets) > 0 {
+		return append([]corev1.LocalObjectReference{}, cr.Spec.Operator.Secrets.ImagePullSecrets...)
+	}
+
+	return []corev1.LocalObjectReference{{Name: exceleroRegistrySecretName}}
+}
+
+func getFileServerSecretName(cr *nvmeshv1.NVMesh) string {
+	return secretNameOrDefault(cr.Spec.Operator.Secrets.FileServer, fileServerSecretName)
+}
+
+func getCSIManagementSecretName(cr *nvmeshv1.NVMesh) string {
+	return secretNameOrDefault(cr.Spec.Operator.Secrets.CSIManagement, csiCredentialsSecretName)
+}
+
+func getS3SecretName(cr *nvmeshv1.NVMesh) string {
+	return secretNameOrDefault(cr.Spec.Operator.Secrets.S3, s3bucketSecretName)
+}
+
+func secretNameOrDefault(name string, defaultName string) string {
+	if name != "" {
+		return name
+	}
+
+	return defaultName
+}
+
+// getPodSpecOfObject - returns the pod template spec of workload objects, nil for other objects
+func getPodSpecOfObject(obj client.Object) *corev1.PodSpec {
+	switch o := obj.(type) {
+	case *appsv1.DaemonSet:
+		return &o.Spec.Template.Spec
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template.Spec
+	case *appsv1.Deployment:
+		return &o.Spec.Template.Spec
+	case *batchv1.Job:
+		return &o.Spec.Template.Spec
+	}
+
+	return nil
+}
+
+// applySecretReferences - sets the image pull secrets and replaces the default secret names in workloads and ServiceAccounts with the ones configured in spec.operator.secrets.
+// the yaml files and the job builders reference the default names, so this is the single place where the configured names are applied
+func applySecretReferences(cr *nvmeshv1.NVMesh, obj client.Object) {
+	if sa, ok := obj.(*corev1.ServiceAccount); ok {
+		sa.ImagePullSecrets = getImagePullSecrets(cr)
+		return
+	}
+
+	podSpec := getPodSpecOfObject(obj)
+	if podSpec == nil {
+		return
+	}
+
+	podSpec.ImagePullSecrets = getImagePullSecrets(cr)
+
+	names := map[string]string{
+		fileServerSecretName:     getFileServerSecretName(cr),
+		csiCredentialsSecretName: getCSIManagementSecretName(cr),
+		s3bucketSecretName:       getS3SecretName(cr),
+	}
+
+	rename := func(name *string) {
+		if newName, ok := names[*name]; ok {
+			*name = newName
+		}
+	}
+
+	forEachSecretReference(podSpec, rename)
+}
+
+// forEachSecretReference - calls fn with a pointer to each secret name referenced by the env, envFrom and volumes of the pod spec
+func forEachSecretReference(podSpec *corev1.PodSpec, fn func(name *string)) {
+	containers := make([]*corev1.Container, 0, len(podSpec.InitContainers)+len(podSpec.Containers))
+	for i := range podSpec.InitContainers {
+		containers = append(containers, &podSpec.InitContainers[i])
+	}
+
+	for i := range podSpec.Containers {
+		containers = append(containers, &podSpec.Containers[i])
+	}
+
+	for _, container := range containers {
+		for i := range container.Env {
+			if container.Env[i].ValueFrom != nil && container.Env[i].ValueFrom.SecretKeyRef != nil {
+				fn(&container.Env[i].ValueFrom.SecretKeyRef.Name)
+			}
+		}
+
+		for i := range container.EnvFrom {
+			if container.EnvFrom[i].SecretRef != nil {
+				fn(&container.EnvFrom[i].SecretRef.Name)
+			}
+		}
+	}
+
+	for i := range podSpec.Volumes {
+		if podSpec.Volumes[i].Secret != nil {
+			fn(&podSpec.Volumes[i].Secret.SecretName)
+		}
+	}
+}
+
+// getSecretReferences - returns the image pull secrets and the secret names referenced by a workload or a ServiceAccount
+func getSecretReferences(obj client.Object) []string {
+	refs := make([]string, 0)
+	if sa, ok := obj.(*corev1.ServiceAccount); ok {
+		for _, ref := range sa.ImagePullSecrets {
+			refs = append(refs, ref.Name)
+		}
+
+		return refs
+	}
+
+	podSpec := getPodSpecOfObject(obj)
+	if podSpec == nil {
+		return refs
+	}
+
+	for _, ref := range podSpec.ImagePullSecrets {
+		refs = append(refs, ref.Name)
+	}
+
+	forEachSecretReference(podSpec, func(name *string) {
+		refs = append(refs, *name)
+	})
+
+	return refs
+}
+
+// secretReferencesChanged - returns true if spec.operator.secrets was changed after the object was created
+func secretReferencesChanged(expected client.Object, found client.Object) bool {
+	return !reflect.DeepEqual(getSecretReferences(expected), getSecretReferences(found))
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	conditions "excelero.com/nvmesh-k8s-operator/pkg/conditions"
+	errors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// default secret name, can be changed in spec.operator.secrets
+	csiCredentialsSecretName = "nvmesh-csi-credentials"
+	s3UploadActionArg        = "upload-to-s3"
+
+	// secretsMissingRequeue - how often the Secrets are checked while some of them are missing or invalid
+	secretsMissingRequeue = time.Minute
+)
+
+// SecretsCacheSelector - only the Secrets labelled with the cluster name are cached and watched, which are the Secrets the operator deploys and the Secrets the user labelled
+// with nvmesh.excelero.com/cluster-name. This avoids keeping every Secret of the cluster in the memory of the operator, other Secrets are read with getSecret
+func SecretsCacheSelector() labels.Selector {
+	selector := labels.NewSelector()
+	requirement, _ := labels.NewRequirement(nvmeshClusterNameLabelKey, selection.Exists, nil)
+	return selector.Add(*requirement)
+}
+
+// getSecret - reads a Secret directly from the api-server, since Secrets the user did not label are not in the cache
+func (r *NVMeshBaseReconciler) getSecret(namespace string, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := r.getAPIReader().Get(context.TODO(), client.ObjectKey{Name: name, Namespace: namespace}, secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// requiredSecret - a Secret that is referenced by the objects the operator deploys
+type requiredSecret struct {
+	Name string
+
+	// Keys - the keys the Secret must contain
+	Keys []string
+
+	// DockerConfig - the Secret is used as an image pull secret and must be of type dockerconfigjson or dockercfg
+	DockerConfig bool
+
+	// Optional - the Secret is deployed by the operator, so only its keys are checked if it exists
+	Optional bool
+
+	// UsedBy - what needs the Secret, used in messages
+	UsedBy string
+}
+
+// getRequiredSecrets - returns the Secrets that are needed by the enabled components and the pending actions
+func getRequiredSecrets(cr *nvmeshv1.NVMesh) []requiredSecret {
+	secrets := make([]requiredSecret, 0)
+	for _, ref := range getImagePullSecrets(cr) {
+		secrets = append(secrets, requiredSecret{Name: ref.Name, DockerConfig: true, UsedBy: "pulling the NVMesh images"})
+	}
+
+	if !cr.Spec.Core.Disabled {
+		secrets = append(secrets, requiredSecret{Name: getFileServerSecretName(cr), Keys: []string{"username", "password"}, UsedBy: "downloading the NVMesh binaries"})
+	} else if !cr.Spec.Management.Disabled {
+		secrets = append(secrets, requiredSecret{Name: getFileServerSecretName(cr), Keys: []string{"username"}, UsedBy: "the NVMesh Management customer ID"})
+	}
+
+	if !cr.Spec.CSI.Disabled {
+		// the generated CSI credentials are deployed by the operator, otherwise the Secret must be created by the user
+		secrets = append(secrets, requiredSecret{Name: getCSIManagementSecretName(cr), Keys: []string{"username", "password"}, Optional: isCSICredentialsGenerated(cr), UsedBy: "the CSI driver connection to NVMesh Management"})
+	}
+
+	if isCSICredentialsGenerated(cr) {
+		secrets = append(secrets, requiredSecret{Name: getMgmtAdminSecretName(cr), Keys: []string{"username", "password"}, UsedBy: "creating the CSI user in NVMesh Management"})
+	}
+
+	secrets = append(secrets, getMongoSecuritySecrets(cr)...)
+
+	for _, action := range cr.Spec.Actions {
+		if _, ok := getActionArg(action, s3UploadActionArg); ok {
+			secrets = append(secrets, requiredSecret{Name: getS3SecretName(cr), Keys: []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"}, UsedBy: fmt.Sprintf("uploading to S3 in the %s action", action.Name)})
+			break
+		}
+	}
+
+	return secrets
+}
+
+// verifySecret - returns a description of each problem found with the Secret, nil if the Secret is valid
+func (r *NVMeshBaseReconciler) verifySecret(cr *nvmeshv1.NVMesh, required requiredSecret) ([]string, error) {
+	secret, err := r.getSecret(cr.GetNamespace(), required.Name)
+	if k8serrors.IsNotFound(err) {
+		if required.Optional {
+			return nil, nil
+		}
+
+		return []string{fmt.Sprintf("Secret %s is missing, it is needed for %s", required.Name, required.UsedBy)}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Failed to get secret %s", required.Name))
+	}
+
+	problems := make([]string, 0)
+	for _, key := range required.Keys {
+		if len(secret.Data[key]) == 0 {
+			problems = append(problems, fmt.Sprintf("Secret %s is missing the key %s, it is needed for %s", required.Name, key, required.UsedBy))
+		}
+	}
+
+	if required.DockerConfig {
+		if err := verifyDockerConfigSecret(secret); err != nil {
+			problems = append(problems, fmt.Sprintf("Secret %s is not a valid image pull secret, %s", required.Name, err))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil, nil
+	}
+
+	return problems, nil
+}
+
+// verifyDockerConfigSecret - checks that the Secret has the type and content of an image pull secret
+func verifyDockerConfigSecret(secret *corev1.Secret) error {
+	var key string
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		key = corev1.DockerConfigJsonKey
+	case corev1.SecretTypeDockercfg:
+		key = corev1.DockerConfigKey
+	default:
+		return fmt.Errorf("expected type %s but found %q", corev1.SecretTypeDockerConfigJson, secret.Type)
+	}
+
+	content, ok := secret.Data[key]
+	if !ok || len(content) == 0 {
+		return fmt.Errorf("the key %s is missing", key)
+	}
+
+	// .dockercfg holds the auths map directly, .dockerconfigjson wraps it in an auths field
+	auths := make(map[string]json.RawMessage)
+	if key == corev1.DockerConfigJsonKey {
+		config := struct {
+			Auths map[string]json.RawMessage `json:"auths"`
+		}{}
+
+		if err := json.Unmarshal(content, &config); err != nil {
+			return fmt.Errorf("the key %s is not valid JSON. %s", key, err)
+		}
+
+		auths = config.Auths
+	} else if err := json.Unmarshal(content, &auths); err != nil {
+		return fmt.Errorf("the key %s is not valid JSON. %s", key, err)
+	}
+
+	if len(auths) == 0 {
+		return fmt.Errorf("the key %s has no registry credentials", key)
+	}
+
+	return nil
+}
+
+// reconcileSecrets - verifies the Secrets needed by the cluster and reports problems with the SecretsMissing condition and events.
+// the rollout is not blocked, images may already exist on the nodes and the pods report the missing Secrets as well.
+// Secrets that are not labelled with the cluster name are not watched, so the Secrets are checked again periodically until they are fixed
+func (r *NVMeshReconciler) reconcileSecrets(cr *nvmeshv1.NVMesh) (ctrl.Result, error) {
+	problems := make([]string, 0)
+	for _, required := range getRequiredSecrets(cr) {
+		secretProblems, err := r.verifySecret(cr, required)
+		if err != nil {
+			return DoNotRequeue(), err
+		}
+
+		problems = append(problems, secretProblems...)
+	}
+
+	existing := conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.SecretsMissing)
+	if len(problems) == 0 {
+		if existing != nil {
+			r.EventManager.Normal(cr, "SecretsFound", "All required secrets were found")
+		}
+
+		conditions.RemoveStatusCondition(&cr.Status.Conditions, nvmeshv1.SecretsMissing)
+		return DoNotRequeue(), nil
+	}
+
+	sort.Strings(problems)
+	msg := strings.Join(problems, ". ")
+
+	// an event is created for each new problem
+	if existing == nil || existing.Message != msg {
+		for _, problem := range problems {
+			if existing == nil || !strings.Contains(existing.Message, problem) {
+				r.EventManager.Warning(cr, "SecretsMissing", problem)
+			}
+		}
+	}
+
+	conditions.SetStatusCondition(&cr.Status.Conditions, &nvmeshv1.ClusterCondition{
+		Type:    nvmeshv1.SecretsMissing,
+		Status:  nvmeshv1.ConditionTrue,
+		Reason:  "InvalidSecrets",
+		Message: msg,
+	})
+
+	return Requeue(secretsMissingRequeue), nil
+}
+
+// mapSecretToClusters - enqueues the NVMesh clusters in the namespace of the Secret that need it, so that creating or fixing a labelled Secret clears the SecretsMissing condition right away
+func (r *NVMeshReconciler) mapSecretToClusters(obj client.Object) []reconcile.Request {
+	clusterList := &nvmeshv1.NVMeshList{}
+	if err := r.getCachedReader().List(context.TODO(), clusterList, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.Log.Error(err, "Failed to list NVMesh clusters for secret event")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0)
+	for i := range clusterList.Items {
+		cluster := &clusterList.Items[i]
+		for _, required := range getRequiredSecrets(cluster) {
+			if required.Name == obj.GetName() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: cluster.GetName(), Namespace: cluster.GetNamespace()},
+				})
+				break
+			}
+		}
+	}
+
+	return requests
+}
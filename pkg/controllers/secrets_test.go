@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+
+	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
+	conditions "excelero.com/nvmesh-k8s-operator/pkg/conditions"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Secrets", func() {
+	It("verifies the required secrets and reports the missing ones", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Spec.Actions = []nvmeshv1.ClusterAction{{Name: "collect-logs", Args: map[string]string{s3UploadActionArg: "my-bucket"}}}
+
+		r := newFakeReconciler(cr)
+		recorder := r.EventManager.recorder.(*record.FakeRecorder)
+		getCondition := func() *nvmeshv1.ClusterCondition {
+			result, err := r.reconcileSecrets(cr)
+			Expect(err).To(BeNil())
+			condition := conditions.FindStatusCondition(cr.Status.Conditions, nvmeshv1.SecretsMissing)
+
+			// Secrets that are not labelled are not watched, so they are checked again while some are missing
+			if condition != nil {
+				Expect(result).To(Equal(Requeue(secretsMissingRequeue)))
+			} else {
+				Expect(result).To(Equal(DoNotRequeue()))
+			}
+
+			return condition
+		}
+
+		By("reporting missing secrets, the operator deployed CSI credentials are not required")
+		condition := getCondition()
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Message).To(ContainSubstring("Secret excelero-registry-cred is missing"))
+		Expect(condition.Message).To(ContainSubstring("Secret nvmesh-file-server-cred is missing"))
+		Expect(condition.Message).To(ContainSubstring("Secret s3-bucket-secrets is missing"))
+		Expect(condition.Message).To(ContainSubstring("Secret nvmesh-mgmt-admin-cred is missing"))
+		Expect(condition.Message).NotTo(ContainSubstring(csiCredentialsSecretName))
+		Expect(recorder.Events).To(HaveLen(4))
+		for len(recorder.Events) > 0 {
+			<-recorder.Events
+		}
+
+		By("naming the missing keys and validating the pull secret format")
+		registry := newTestSecret(exceleroRegistrySecretName, nil)
+		Expect(r.Client.Create(ctx, registry)).To(Succeed())
+		Expect(r.Client.Create(ctx, newTestSecret(fileServerSecretName, map[string]string{"username": "customer"}))).To(Succeed())
+		Expect(r.Client.Create(ctx, newTestSecret(s3bucketSecretName, map[string]string{"AWS_ACCESS_KEY_ID": "a", "AWS_SECRET_ACCESS_KEY": "b"}))).To(Succeed())
+		Expect(r.Client.Create(ctx, newTestSecret(csiCredentialsSecretName, map[string]string{"username": "csi"}))).To(Succeed())
+
+		condition = getCondition()
+		Expect(condition.Message).To(ContainSubstring("Secret excelero-registry-cred is not a valid image pull secret, expected type kubernetes.io/dockerconfigjson"))
+		Expect(condition.Message).To(ContainSubstring("Secret nvmesh-file-server-cred is missing the key password"))
+		Expect(condition.Message).To(ContainSubstring("Secret nvmesh-csi-credentials is missing the key password"))
+		Expect(condition.Message).NotTo(ContainSubstring("s3-bucket-secrets"))
+		Expect(<-recorder.Events).To(ContainSubstring("SecretsMissing"))
+
+		invalidConfigs := map[string]string{
+			"not json":       "{",
+			"no credentials": `{"auths": {}}`,
+		}
+
+		for name, config := range invalidConfigs {
+			registry.Type = corev1.SecretTypeDockerConfigJson
+			registry.Data = map[string][]byte{corev1.DockerConfigJsonKey: []byte(config)}
+			Expect(verifyDockerConfigSecret(registry)).NotTo(Succeed(), name)
+		}
+
+		registry.Data = map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {"registry.excelero.com": {"auth": "dXNlcjpwYXNz"}}}`)}
+		Expect(r.Client.Update(ctx, registry)).To(Succeed())
+
+		By("clearing the condition when the secrets are fixed")
+		for _, name := range []string{fileServerSecretName, csiCredentialsSecretName} {
+			Expect(r.Client.Update(ctx, newTestSecret(name, map[string]string{"username": "u", "password": "p"}))).To(Succeed())
+		}
+
+		Expect(r.Client.Create(ctx, newTestSecret(mgmtAdminSecretName, map[string]string{"username": "u", "password": "p"}))).To(Succeed())
+
+		Expect(getCondition()).To(BeNil())
+
+		By("mapping secret events to the clusters that need them")
+		Expect(r.mapSecretToClusters(registry)).To(HaveLen(1))
+		Expect(r.mapSecretToClusters(newTestSecret("other", nil))).To(BeEmpty())
+
+		By("caching only the Secrets labelled with the cluster name")
+		owned := newTestSecret(mongoConnectionSecretName, nil)
+		r.addOperatorLabels(cr, owned)
+		Expect(SecretsCacheSelector().Matches(labels.Set(owned.GetLabels()))).To(BeTrue())
+		Expect(SecretsCacheSelector().Matches(labels.Set(registry.GetLabels()))).To(BeFalse())
+
+		By("looking up the requested secret name")
+		Expect(r.verifySecretExists(fileServerSecretName, TestingNamespace)).To(Succeed())
+		Expect(r.verifySecretExists("other", TestingNamespace)).NotTo(Succeed())
+	})
+
+	It("propagates the configured secret names to the deployed objects", func() {
+		ctx := context.TODO()
+
+		cr := newTestCluster()
+		cr.Spec.Operator.Secrets = nvmeshv1.OperatorSecretsSpec{
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "mirror-cred"}, {Name: "quay-cred"}},
+			FileServer:       "my-file-server",
+			CSIManagement:    "my-csi-user",
+		}
+
+		r := newFakeReconciler(cr)
+
+		newDaemonSet := func() *appsv1.DaemonSet {
+			ds := &appsv1.DaemonSet{TypeMeta: metav1.TypeMeta{Kind: "DaemonSet", APIVersion: "apps/v1"}}
+			ds.SetName("nvmesh-client")
+			ds.Spec.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: exceleroRegistrySecretName}}
+			ds.Spec.Template.Spec.Containers = []corev1.Container{{
+				Name: "client",
+				Env: []corev1.EnvVar{
+					{Name: "FILE_SERVER_USERNAME", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: fileServerSecretName}, Key: "username"}}},
+					{Name: "MANAGEMENT_USERNAME", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: csiCredentialsSecretName}, Key: "username"}}},
+					{Name: "OTHER", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "other"}, Key: "key"}}},
+				},
+			}}
+
+			return ds
+		}
+
+		By("replacing the default secret names in workloads")
+		Expect(r.makeSureObjectExists(cr, newDaemonSet(), nil)).To(Succeed())
+		ds := &appsv1.DaemonSet{}
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: "nvmesh-client", Namespace: TestingNamespace}, ds)).To(Succeed())
+		Expect(getSecretReferences(ds)).To(Equal([]string{"mirror-cred", "quay-cred", "my-file-server", "my-csi-user", "other"}))
+
+		By("setting the image pull secrets on the ServiceAccount and the jobs")
+		Expect(r.makeSureServiceAccountExists(cr)).To(Succeed())
+		sa := &corev1.ServiceAccount{}
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: clusterServiceAccountName, Namespace: TestingNamespace}, sa)).To(Succeed())
+		Expect(sa.ImagePullSecrets).To(Equal(cr.Spec.Operator.Secrets.ImagePullSecrets))
+		Expect(r.getNewJob(cr, "job1", "image").Spec.Template.Spec.ImagePullSecrets).To(Equal(cr.Spec.Operator.Secrets.ImagePullSecrets))
+
+		By("verifying the configured secrets, a configured CSI secret is not optional")
+		names := make([]string, 0)
+		for _, required := range getRequiredSecrets(cr) {
+			names = append(names, required.Name)
+			if required.Name == "my-csi-user" {
+				Expect(required.Optional).To(BeFalse())
+			}
+		}
+
+		Expect(names).To(Equal([]string{"mirror-cred", "quay-cred", "my-file-server", "my-csi-user"}))
+
+		By("updating existing objects when the references change")
+		cr.Spec.Operator.Secrets = nvmeshv1.OperatorSecretsSpec{S3: "my-s3"}
+		Expect(r.makeSureObjectExists(cr, newDaemonSet(), nil)).To(Succeed())
+		Expect(r.Client.Get(ctx, client.ObjectKey{Name: "nvmesh-client", Namespace: TestingNamespace}, ds)).To(Succeed())
+		Expect(getSecretReferences(ds)).To(Equal([]string{exceleroRegistrySecretName, fileServerSecretName, csiCredentialsSecretName, "other"}))
+	})
+})
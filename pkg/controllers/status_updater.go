@@ -3,6 +3,8 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sync/atomic"
 	"time"
 
 	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
@@ -45,7 +47,7 @@ func (r *NVMeshReconciler) ManageSuccess(cr *nvmeshv1.NVMesh, result ctrl.Result
 		}
 	}
 
-	fmt.Printf("Reconcile Success. Cycle #: %d, Generation: %d\n", reconcileCycles, generation)
+	fmt.Printf("Reconcile Success. Cycle #: %d, Generation: %d\n", atomic.LoadInt64(&reconcileCycles), generation)
 	return result, nil
 }
 
@@ -115,3 +117,50 @@ func (r *NVMeshReconciler) UpdateStatus(cr *nvmeshv1.NVMesh) error {
 
 	return err
 }
+
+// mergeComponentStatus - applies the changes a component made to its copy of the status, before is the status the components started with.
+// actionsStatus and conditions are merged by key since more than one component may change them, other fields are replaced when changed
+func mergeComponentStatus(status *nvmeshv1.NVMeshStatus, before *nvmeshv1.NVMeshStatus, after *nvmeshv1.NVMeshStatus) {
+	for key, actionStatus := range after.ActionsStatus {
+		if !reflect.DeepEqual(actionStatus, before.ActionsStatus[key]) {
+			if status.ActionsStatus == nil {
+				status.ActionsStatus = make(map[string]nvmeshv1.ActionStatus)
+			}
+
+			status.ActionsStatus[key] = actionStatus
+		}
+	}
+
+	for key := range before.ActionsStatus {
+		if _, ok := after.ActionsStatus[key]; !ok {
+			delete(status.ActionsStatus, key)
+		}
+	}
+
+	for i := range after.Conditions {
+		condition := after.Conditions[i]
+		if existing := conditions.FindStatusCondition(before.Conditions, condition.Type); existing == nil || !reflect.DeepEqual(*existing, condition) {
+			conditions.SetStatusCondition(&status.Conditions, &condition)
+		}
+	}
+
+	for _, condition := range before.Conditions {
+		if conditions.FindStatusCondition(after.Conditions, condition.Type) == nil {
+			conditions.RemoveStatusCondition(&status.Conditions, condition.Type)
+		}
+	}
+
+	statusValue := reflect.ValueOf(status).Elem()
+	beforeValue := reflect.ValueOf(before).Elem()
+	afterValue := reflect.ValueOf(after).Elem()
+	for i := 0; i < statusValue.NumField(); i++ {
+		switch statusValue.Type().Field(i).Name {
+		case "ActionsStatus", "Conditions":
+			continue
+		}
+
+		if !reflect.DeepEqual(beforeValue.Field(i).Interface(), afterValue.Field(i).Interface()) {
+			statusValue.Field(i).Set(afterValue.Field(i))
+		}
+	}
+}
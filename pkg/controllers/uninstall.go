@@ -227,7 +227,7 @@ func (r *NVMeshReconciler) getUninstallJob(cr *nvmeshv1.NVMesh, nodeName string)
 	return job
 }
 
-func (r *NVMeshReconciler) getNodesWithLabel(key string, value string) (*corev1.NodeList, error) {
+func (r *NVMeshBaseReconciler) getNodesWithLabel(key string, value string) (*corev1.NodeList, error) {
 	nodeSelector := client.MatchingLabels{
 		key: value,
 	}
@@ -249,7 +249,7 @@ func (r *NVMeshReconciler) getAllMgmtLabelledNodes(cr *nvmeshv1.NVMesh) (*corev1
 	return nodes, nil
 }
 
-func (r *NVMeshReconciler) getAllNVMeshClusterNodes(cr *nvmeshv1.NVMesh) (map[string]corev1.Node, error) {
+func (r *NVMeshBaseReconciler) getAllNVMeshClusterNodes(cr *nvmeshv1.NVMesh) (map[string]corev1.Node, error) {
 
 	clients, err := r.getNodesWithLabel(nvmeshClientLabelKey, "")
 	if err != nil {
@@ -308,6 +308,10 @@ func (r *NVMeshReconciler) deleteUninstallJobs(cr *nvmeshv1.NVMesh, nodeList []c
 }
 
 func (r *NVMeshReconciler) runClearDbJob(cr *nvmeshv1.NVMesh) error {
+	if _, err := r.reconcileMongoConnection(cr); err != nil {
+		return err
+	}
+
 	mongoImage := r.getCoreFullImageName(cr, mongoInstanceImageName)
 	job := r.getNewJob(cr, clearDbJobName, mongoImage)
 	backoffLimit := int32(1)
@@ -315,10 +319,10 @@ func (r *NVMeshReconciler) runClearDbJob(cr *nvmeshv1.NVMesh) error {
 	container := &job.Spec.Template.Spec.Containers[0]
 
 	container.Command = []string{"mongo"}
-	mongoConnString := getMongoConnectionString(cr) + "/management"
-	container.Args = []string{mongoConnString, "--eval", "db.dropDatabase()"}
+	container.Args = []string{"--eval", "db.dropDatabase()"}
+	setMongoShellJobConnection(cr, &job.Spec.Template.Spec, container)
 
-	job.Spec.Template.Spec.ImagePullSecrets = r.getExceleroRegistryPullSecrets()
+	job.Spec.Template.Spec.ImagePullSecrets = getImagePullSecrets(cr)
 
 	err := r.Client.Create(context.TODO(), job)
 	if err != nil && !k8serrors.IsAlreadyExists(err) {
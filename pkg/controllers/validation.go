@@ -1,14 +1,11 @@
 package controllers
 
 import (
-	"context"
 	goerrors "errors"
 	"fmt"
 
 	nvmeshv1 "excelero.com/nvmesh-k8s-operator/pkg/api/v1"
-	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func validationError(cr *nvmeshv1.NVMesh, errorMessage string, additionlaDetails string) error {
@@ -18,22 +15,44 @@ func validationError(cr *nvmeshv1.NVMesh, errorMessage string, additionlaDetails
 func (r *NVMeshBaseReconciler) isValid(cr *nvmeshv1.NVMesh) error {
 	// NOTE: it is best to apply most of the validation using the OpenAPI with kubebuilder annotations on the NVMesh type
 
-	// If External mongo is deployed, mongo connection address is expected
-	if cr.Spec.Management.MongoDB.External && cr.Spec.Management.MongoDB.Address == "" {
+	// If External mongo is deployed, mongo connection address or connection string is expected
+	if cr.Spec.Management.MongoDB.External && cr.Spec.Management.MongoDB.Address == "" && !hasMongoConnectionString(cr) {
 		return validationError(cr,
 			"Missing MongoDB address for externally deployed MongoDB cluster.",
-			"spec.management.mongoDB.external=true but spec.management.mongoDB.address is not specified. When MongoDB is deployed manually the mongoConnection address must be specified in MongoDB.Address. i.e: \"mongo-svc.default.svc.cluster.local:27017\".",
+			"spec.management.mongoDB.external=true but spec.management.mongoDB.address is not specified. When MongoDB is deployed manually the mongoConnection address must be specified in MongoDB.Address. i.e: \"mongo-svc.default.svc.cluster.local:27017\", or a full connection string in MongoDB.ConnectionString.",
 		)
 	}
 
+	if err := validateMongoConnection(cr); err != nil {
+		return err
+	}
+
+	if err := validateMongoSecurity(cr); err != nil {
+		return err
+	}
+
+	if err := validateExtraConfig(cr, "spec.core.extraConfig", cr.Spec.Core.ExtraConfig); err != nil {
+		return err
+	}
+
+	if err := validateKernelModules(cr); err != nil {
+		return err
+	}
+
+	if err := validateNodeOverrides(cr); err != nil {
+		return err
+	}
+
+	if err := validateSchedules(cr); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (r *NVMeshBaseReconciler) verifySecretExists(secretName string, ns string) error {
 	// check if a secret exist
-	secret := &corev1.Secret{}
-	secretKey := client.ObjectKey{Name: exceleroRegistrySecretName, Namespace: ns}
-	err := r.Client.Get(context.TODO(), secretKey, secret)
+	_, err := r.getSecret(ns, secretName)
 
 	if err != nil {
 		if k8serrors.IsNotFound(err) {
@@ -41,11 +60,13 @@ func (r *NVMeshBaseReconciler) verifySecretExists(secretName string, ns string)
 		} else {
 			r.Log.Info(fmt.Sprintf("DEBUG: Error while trying to get secret: %s in namespace %s. error: %s", secretName, ns, err))
 		}
+
+		return err
 	}
 
 	r.Log.Info(fmt.Sprintf("DEBUG: Secret: %s found", secretName))
 
-	return err
+	return nil
 }
 
 func (r *NVMeshBaseReconciler) verifyNVMeshSecretsExist(namespace string) {
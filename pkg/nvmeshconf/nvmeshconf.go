@@ -0,0 +1,353 @@
+// Package nvmeshconf reads and writes the shell style nvmesh.conf file.
+// Parsing is lossless - comments, blank lines, order and quoting of lines that were not changed are written back as they were read.
+package nvmeshconf
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseError - Error while trying to parse nvmesh.conf
+type ParseError struct {
+	Message string
+	Line    int
+}
+
+func (e *ParseError) Error() string { return fmt.Sprintf("nvmesh.conf line %d: %s", e.Line, e.Message) }
+
+// entry - a logical line of the file. an assignment with a quoted value that contains a newline spans more than one physical line
+type entry struct {
+	// raw - the text of the entry as it was read, or as it was rendered after a change
+	raw string
+
+	isAssignment bool
+	// prefix - indentation and export keyword before the key
+	prefix string
+	key    string
+	value  string
+	// suffix - whitespace and comment after the value
+	suffix string
+}
+
+func (e *entry) render() {
+	e.raw = e.prefix + e.key + "=" + Quote(e.value) + e.suffix
+}
+
+// Config - a parsed nvmesh.conf
+type Config struct {
+	entries []*entry
+}
+
+// New - returns an empty Config
+func New() *Config {
+	return &Config{}
+}
+
+// Parse - parses the content of nvmesh.conf
+func Parse(content string) (*Config, error) {
+	p := &parser{input: content, line: 1}
+	c := &Config{}
+
+	for {
+		e, err := p.parseEntry()
+		if err != nil {
+			return nil, err
+		}
+
+		c.entries = append(c.entries, e)
+
+		if p.pos >= len(p.input) {
+			break
+		}
+
+		// skip the newline that ends the entry
+		p.pos++
+		p.line++
+	}
+
+	return c, nil
+}
+
+// MustParse - like Parse but panics on error, for content that is known to be valid
+func MustParse(content string) *Config {
+	c, err := Parse(content)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// String - returns the content of the file
+func (c *Config) String() string {
+	lines := make([]string, 0, len(c.entries))
+	for _, e := range c.entries {
+		lines = append(lines, e.raw)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Keys - returns the assigned keys in the order of their first appearance
+func (c *Config) Keys() []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	for _, e := range c.entries {
+		if e.isAssignment && !seen[e.key] {
+			seen[e.key] = true
+			keys = append(keys, e.key)
+		}
+	}
+
+	return keys
+}
+
+// Map - returns the effective value of each key
+func (c *Config) Map() map[string]string {
+	values := make(map[string]string)
+	for _, e := range c.entries {
+		if e.isAssignment {
+			values[e.key] = e.value
+		}
+	}
+
+	return values
+}
+
+func (c *Config) lastAssignment(key string) *entry {
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if c.entries[i].isAssignment && c.entries[i].key == key {
+			return c.entries[i]
+		}
+	}
+
+	return nil
+}
+
+// Get - returns the unquoted value of key. when a key is assigned more than once the last assignment wins, as it does when the file is sourced
+func (c *Config) Get(key string) (string, bool) {
+	e := c.lastAssignment(key)
+	if e == nil {
+		return "", false
+	}
+
+	return e.value, true
+}
+
+// Set - sets the value of key. the last assignment of the key is changed in place and earlier assignments are removed, a new key is appended at the end of the file
+func (c *Config) Set(key string, value string) {
+	last := c.lastAssignment(key)
+	if last == nil {
+		e := &entry{isAssignment: true, key: key, value: value}
+		e.render()
+		c.appendEntry(e)
+		return
+	}
+
+	c.removeAssignments(key, last)
+	if last.value != value {
+		last.value = value
+		last.render()
+	}
+}
+
+// Delete - removes all assignments of key, returns true if the key was assigned
+func (c *Config) Delete(key string) bool {
+	return c.removeAssignments(key, nil)
+}
+
+func (c *Config) removeAssignments(key string, keep *entry) bool {
+	removed := false
+	entries := make([]*entry, 0, len(c.entries))
+	for _, e := range c.entries {
+		if e.isAssignment && e.key == key && e != keep {
+			removed = true
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	c.entries = entries
+	return removed
+}
+
+func (c *Config) appendEntry(e *entry) {
+	// an empty file is parsed as a single empty line
+	n := len(c.entries)
+	if n == 1 && c.entries[0].raw == "" {
+		c.entries[0] = e
+		return
+	}
+
+	// keep a trailing newline at the end of the file
+	if n > 0 && c.entries[n-1].raw == "" && !c.entries[n-1].isAssignment {
+		c.entries = append(c.entries[:n-1], e, c.entries[n-1])
+		return
+	}
+
+	// a backslash at the end of the file would continue into the new line
+	if n > 0 && endsWithLineContinuation(c.entries[n-1].raw) {
+		if c.entries[n-1].isAssignment {
+			c.entries[n-1].render()
+		} else {
+			c.entries = append(c.entries, &entry{})
+		}
+	}
+
+	c.entries = append(c.entries, e)
+}
+
+func endsWithLineContinuation(raw string) bool {
+	backslashes := len(raw) - len(strings.TrimRight(raw, "\\"))
+	return backslashes%2 == 1
+}
+
+// GetBool - returns the value of key as a boolean. True/Yes/1 and False/No/0 are accepted in any case
+func (c *Config) GetBool(key string) (bool, bool, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return false, false, nil
+	}
+
+	switch strings.ToLower(value) {
+	case "true", "yes", "1":
+		return true, true, nil
+	case "false", "no", "0":
+		return false, true, nil
+	}
+
+	return false, true, fmt.Errorf("%s has a non boolean value %q", key, value)
+}
+
+// SetBool - sets a boolean value, keeping the Yes/No style if the key already uses it, otherwise True/False is used
+func (c *Config) SetBool(key string, value bool) {
+	current, _ := c.Get(key)
+	switch strings.ToLower(current) {
+	case "yes", "no":
+		if value {
+			c.Set(key, "Yes")
+		} else {
+			c.Set(key, "No")
+		}
+	default:
+		if value {
+			c.Set(key, "True")
+		} else {
+			c.Set(key, "False")
+		}
+	}
+}
+
+// GetInt - returns the value of key as an integer
+func (c *Config) GetInt(key string) (int, bool, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return 0, false, nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, true, fmt.Errorf("%s has a non integer value %q", key, value)
+	}
+
+	return n, true, nil
+}
+
+// SetInt - sets an integer value
+func (c *Config) SetInt(key string, value int) {
+	c.Set(key, strconv.Itoa(value))
+}
+
+// GetList - returns the value of key as a comma seperated list, empty items are omitted
+func (c *Config) GetList(key string) ([]string, bool) {
+	value, ok := c.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items, true
+}
+
+// SetList - sets a comma seperated list value
+func (c *Config) SetList(key string, items []string) {
+	c.Set(key, strings.Join(items, ","))
+}
+
+// ChangeType - the type of a Change
+type ChangeType string
+
+const (
+	// Added - the key exists only in the new config
+	Added ChangeType = "Added"
+	// Removed - the key exists only in the old config
+	Removed ChangeType = "Removed"
+	// Modified - the key has a different value in the new config
+	Modified ChangeType = "Modified"
+)
+
+// Change - a difference in the value of a single key
+type Change struct {
+	Type     ChangeType
+	Key      string
+	OldValue string
+	NewValue string
+}
+
+func (c Change) String() string {
+	switch c.Type {
+	case Added:
+		return fmt.Sprintf("%s added with %q", c.Key, c.NewValue)
+	case Removed:
+		return fmt.Sprintf("%s removed (was %q)", c.Key, c.OldValue)
+	}
+
+	return fmt.Sprintf("%s changed from %q to %q", c.Key, c.OldValue, c.NewValue)
+}
+
+// Diff - returns the changes in the effective values from old to new, sorted by key. comments, order, duplicate assignments and quoting are ignored
+func Diff(old *Config, new *Config) []Change {
+	oldValues := old.Map()
+	newValues := new.Map()
+	changes := make([]Change, 0)
+
+	for key, oldValue := range oldValues {
+		newValue, ok := newValues[key]
+		if !ok {
+			changes = append(changes, Change{Type: Removed, Key: key, OldValue: oldValue})
+		} else if newValue != oldValue {
+			changes = append(changes, Change{Type: Modified, Key: key, OldValue: oldValue, NewValue: newValue})
+		}
+	}
+
+	for key, newValue := range newValues {
+		if _, ok := oldValues[key]; !ok {
+			changes = append(changes, Change{Type: Added, Key: key, NewValue: newValue})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// Equal - returns true if other has the same effective values
+func (c *Config) Equal(other *Config) bool {
+	return len(Diff(c, other)) == 0
+}
+
+var doubleQuoteSpecialChars = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+
+// Quote - wraps a value with double quotes, escaping the characters that are special inside double quotes
+func Quote(value string) string {
+	return "\"" + doubleQuoteSpecialChars.Replace(value) + "\""
+}
@@ -0,0 +1,49 @@
+//go:build go1.18
+// +build go1.18
+
+package nvmeshconf
+
+import (
+	"testing"
+)
+
+func FuzzParse(f *testing.F) {
+	f.Add("A=1\nB=\"two\"\n")
+	f.Add("# comment\nexport A='x y'  # trailing\n\n")
+	f.Add("A=\"multi\nline\"\nB=a\\\nb\n")
+	f.Add("if true; then A=1; fi")
+
+	f.Fuzz(func(t *testing.T, content string) {
+		conf, err := Parse(content)
+		if err != nil {
+			return
+		}
+
+		if conf.String() != content {
+			t.Fatalf("parsing is not lossless. input %q output %q", content, conf.String())
+		}
+
+		// values that are set are read back unchanged, and other keys are not affected
+		before := conf.Map()
+		conf.Set("FUZZ_KEY", content)
+		reparsed, err := Parse(conf.String())
+		if err != nil {
+			t.Fatalf("failed to parse rendered config %q: %s", conf.String(), err)
+		}
+
+		value, _ := reparsed.Get("FUZZ_KEY")
+		if value != content {
+			t.Fatalf("value changed after rendering. set %q got %q", content, value)
+		}
+
+		for key, v := range before {
+			if key == "FUZZ_KEY" {
+				continue
+			}
+
+			if got, _ := reparsed.Get(key); got != v {
+				t.Fatalf("key %s changed from %q to %q", key, v, got)
+			}
+		}
+	})
+}
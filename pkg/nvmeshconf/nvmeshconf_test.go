@@ -0,0 +1,149 @@
+package nvmeshconf
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+const samplesDir = "../../test/samples/nvmeshconf/"
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+func readSample(t *testing.T, name string) string {
+	content, err := ioutil.ReadFile(samplesDir + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+func TestParseIsLossless(t *testing.T) {
+	g := NewWithT(t)
+
+	content := readSample(t, "nvmesh.conf")
+	conf, err := Parse(content)
+	g.Expect(err).To(BeNil())
+	g.Expect(conf.String()).To(Equal(content))
+}
+
+func TestParseValues(t *testing.T) {
+	g := NewWithT(t)
+
+	conf, err := Parse(readSample(t, "nvmesh.conf"))
+	g.Expect(err).To(BeNil())
+
+	expected := map[string]string{
+		"K8S_ENV":                   "True",
+		"MANAGEMENT_PROTOCOL":       "https",
+		"MANAGEMENT_SERVERS":        "mgmt-0:4001",
+		"MCS_LOGGING_LEVEL":         "VERBOSE",
+		"MCS_LOGGING_VERBOSE_TYPES": "MGMT>*,*>MGMT",
+		"AGENT_LOGGING_LEVEL":       "DEBUG",
+		"CONFIGURED_NICS":           "eth1, eth2",
+		"EXCLUDE_DEVICE_PATHS":      "/dev/nvme0n1,/dev/nvme1n1",
+		"BANNER":                    "line one\nline two with \"quotes\" and $dollar",
+		"EMPTY":                     "",
+		"MIXED":                     "abc d",
+	}
+	g.Expect(conf.Map()).To(Equal(expected))
+	g.Expect(conf.Keys()[0]).To(Equal("K8S_ENV"))
+
+	nics, ok := conf.GetList("CONFIGURED_NICS")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(nics).To(Equal([]string{"eth1", "eth2"}))
+
+	k8sEnv, ok, err := conf.GetBool("K8S_ENV")
+	g.Expect(err).To(BeNil())
+	g.Expect(ok && k8sEnv).To(BeTrue())
+
+	_, _, err = conf.GetBool("MANAGEMENT_PROTOCOL")
+	g.Expect(err).NotTo(BeNil())
+
+	_, ok, err = conf.GetInt("MISSING")
+	g.Expect(ok).To(BeFalse())
+	g.Expect(err).To(BeNil())
+}
+
+func TestEditMatchesGolden(t *testing.T) {
+	g := NewWithT(t)
+
+	conf, err := Parse(readSample(t, "nvmesh.conf"))
+	g.Expect(err).To(BeNil())
+
+	conf.Set("MANAGEMENT_SERVERS", "mgmt-0:4001,mgmt-1:4001")
+	conf.Set("MCS_LOGGING_LEVEL", "INFO")
+	conf.SetList("CONFIGURED_NICS", []string{"ib0", "ib1"})
+	conf.Set("K8S_ENV", "True")
+	conf.Delete("BANNER")
+	conf.SetBool("TCP_ENABLED", true)
+	conf.SetInt("CONFIG_PROFILE_VERSION", 2)
+	conf.Set("MY_KEY", "a \"b\" $c `d` \\e")
+
+	golden := samplesDir + "nvmesh.conf.golden"
+	if *updateGolden {
+		g.Expect(ioutil.WriteFile(golden, []byte(conf.String()), 0644)).To(Succeed())
+	}
+
+	g.Expect(conf.String()).To(Equal(readSample(t, "nvmesh.conf.golden")))
+
+	// the rendered values are read back as they were set
+	reparsed, err := Parse(conf.String())
+	g.Expect(err).To(BeNil())
+	g.Expect(Diff(conf, reparsed)).To(BeEmpty())
+	value, _ := reparsed.Get("MY_KEY")
+	g.Expect(value).To(Equal("a \"b\" $c `d` \\e"))
+}
+
+func TestDiffIgnoresFormatting(t *testing.T) {
+	g := NewWithT(t)
+
+	a := MustParse("# comment\nA=\"1\"\nB='two'\nC=3")
+	b := MustParse("export C=3\nB=two   # same value\nA=1\n")
+	g.Expect(a.Equal(b)).To(BeTrue())
+
+	b.Set("A", "2")
+	b.Delete("B")
+	b.Set("D", "4")
+	g.Expect(Diff(a, b)).To(Equal([]Change{
+		{Type: Modified, Key: "A", OldValue: "1", NewValue: "2"},
+		{Type: Removed, Key: "B", OldValue: "two"},
+		{Type: Added, Key: "D", NewValue: "4"},
+	}))
+}
+
+func TestSetOnEmptyConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	conf := MustParse("")
+	conf.Set("A", "1")
+	conf.SetBool("B", false)
+	g.Expect(conf.String()).To(Equal("A=\"1\"\nB=\"False\""))
+
+	conf = New()
+	conf.Set("A", "1")
+	g.Expect(conf.String()).To(Equal("A=\"1\""))
+
+	conf = MustParse("B=\"No\"\n")
+	conf.SetBool("B", true)
+	conf.Set("A", "1")
+	g.Expect(conf.String()).To(Equal("B=\"Yes\"\nA=\"1\"\n"))
+}
+
+func TestParseErrors(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := Parse("A=1\nB=\"unterminated\nC=3")
+	g.Expect(err).To(MatchError(ContainSubstring("line 2")))
+
+	_, err = Parse("A='unterminated")
+	g.Expect(err).NotTo(BeNil())
+
+	// commands and other lines that are not simple assignments are kept as they are
+	conf, err := Parse("A=1; B=2\nC=3 echo\nD=4 # ok")
+	g.Expect(err).To(BeNil())
+	g.Expect(conf.Map()).To(Equal(map[string]string{"D": "4"}))
+}
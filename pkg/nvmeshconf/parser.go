@@ -0,0 +1,194 @@
+package nvmeshconf
+
+import (
+	"strings"
+)
+
+type parser struct {
+	input string
+	pos   int
+	line  int
+}
+
+// parseEntry - parses an entry starting at the current position and leaves the position on the newline that ends it
+func (p *parser) parseEntry() (*entry, error) {
+	start := p.pos
+	startLine := p.line
+
+	e, err := p.parseAssignment()
+	if err != nil {
+		return nil, err
+	}
+
+	if e != nil {
+		e.raw = p.input[start:p.pos]
+		return e, nil
+	}
+
+	// comments, blank lines and anything that is not a simple assignment are kept as they are
+	p.pos = start
+	p.line = startLine
+	if end := strings.IndexByte(p.input[start:], '\n'); end >= 0 {
+		p.pos = start + end
+	} else {
+		p.pos = len(p.input)
+	}
+
+	return &entry{raw: p.input[start:p.pos]}, nil
+}
+
+// parseAssignment - parses [export] KEY=VALUE [# comment]. returns nil if the line is not a simple assignment
+func (p *parser) parseAssignment() (*entry, error) {
+	start := p.pos
+	p.skipBlanks()
+
+	if strings.HasPrefix(p.input[p.pos:], "export") && p.pos+len("export") < len(p.input) && isBlank(p.input[p.pos+len("export")]) {
+		p.pos += len("export")
+		p.skipBlanks()
+	}
+
+	prefix := p.input[start:p.pos]
+
+	keyStart := p.pos
+	for p.pos < len(p.input) && isKeyChar(p.input[p.pos], p.pos == keyStart) {
+		p.pos++
+	}
+
+	key := p.input[keyStart:p.pos]
+	if key == "" || p.pos >= len(p.input) || p.input[p.pos] != '=' {
+		return nil, nil
+	}
+
+	p.pos++
+
+	value, ok, err := p.parseValue()
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	suffixStart := p.pos
+	p.skipBlanks()
+	if p.pos < len(p.input) && p.input[p.pos] == '#' {
+		for p.pos < len(p.input) && p.input[p.pos] != '\n' {
+			p.pos++
+		}
+	}
+
+	if p.pos < len(p.input) && p.input[p.pos] != '\n' {
+		// more words after the value, i.e. a command
+		return nil, nil
+	}
+
+	return &entry{
+		isAssignment: true,
+		prefix:       prefix,
+		key:          key,
+		value:        value,
+		suffix:       p.input[suffixStart:p.pos],
+	}, nil
+}
+
+// parseValue - parses a shell word made of unquoted, single quoted and double quoted parts
+func (p *parser) parseValue() (string, bool, error) {
+	var value strings.Builder
+
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch {
+		case isBlank(c) || c == '\n':
+			return value.String(), true, nil
+		case strings.IndexByte(";&|<>()", c) >= 0:
+			return "", false, nil
+		case c == '\'':
+			end := strings.IndexByte(p.input[p.pos+1:], '\'')
+			if end < 0 {
+				return "", false, &ParseError{Message: "unterminated single quoted value", Line: p.line}
+			}
+
+			quoted := p.input[p.pos+1 : p.pos+1+end]
+			p.line += strings.Count(quoted, "\n")
+			value.WriteString(quoted)
+			p.pos += end + 2
+		case c == '"':
+			if err := p.parseDoubleQuoted(&value); err != nil {
+				return "", false, err
+			}
+		case c == '\\':
+			p.pos++
+			if p.pos >= len(p.input) {
+				value.WriteByte('\\')
+			} else if p.input[p.pos] == '\n' {
+				// line continuation
+				p.line++
+				p.pos++
+			} else {
+				value.WriteByte(p.input[p.pos])
+				p.pos++
+			}
+		default:
+			value.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return value.String(), true, nil
+}
+
+func (p *parser) parseDoubleQuoted(value *strings.Builder) error {
+	startLine := p.line
+	p.pos++
+
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return nil
+		case '\\':
+			if p.pos+1 < len(p.input) {
+				next := p.input[p.pos+1]
+				switch next {
+				case '\n':
+					// line continuation
+					p.line++
+					p.pos += 2
+					continue
+				case '\\', '"', '$', '`':
+					value.WriteByte(next)
+					p.pos += 2
+					continue
+				}
+			}
+
+			value.WriteByte(c)
+			p.pos++
+		case '\n':
+			p.line++
+			value.WriteByte(c)
+			p.pos++
+		default:
+			value.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	return &ParseError{Message: "unterminated double quoted value", Line: startLine}
+}
+
+func (p *parser) skipBlanks() {
+	for p.pos < len(p.input) && isBlank(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+func isKeyChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+
+	return !first && c >= '0' && c <= '9'
+}